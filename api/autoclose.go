@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AutoCloseConfigTopicTemplate is the HA discovery topic for a device's auto-close number
+	AutoCloseConfigTopicTemplate = "homeassistant/number/%s_auto_close/config"
+	// AutoCloseStateTopicTemplate carries the auto-close delay in minutes (0 when off)
+	AutoCloseStateTopicTemplate = "%s/%s/auto_close"
+	// AutoCloseCommandTopicTemplate receives a new auto-close delay in minutes
+	AutoCloseCommandTopicTemplate = "%s/%s/set_auto_close"
+
+	// AutoCloseTriggerConfigTopicTemplate is the HA discovery topic for an auto-close device trigger, keyed by deviceID_payload
+	AutoCloseTriggerConfigTopicTemplate = "homeassistant/device_automation/%s_auto_close_%s/config"
+	// AutoCloseTriggerTopicTemplate receives AutoCloseWarning before, and AutoCloseClosing as, a door is closed automatically
+	AutoCloseTriggerTopicTemplate = "%s/%s/auto_close/event"
+)
+
+// Payloads published on AutoCloseTriggerTopicTemplate
+const (
+	AutoCloseWarning = "warning"
+	AutoCloseClosing = "closing"
+)
+
+// MaxAutoCloseMinutes is the longest auto-close delay accepted.
+const MaxAutoCloseMinutes = 240
+
+// ErrInvalidAutoClose is returned for an auto-close delay that isn't 0 to MaxAutoCloseMinutes.
+var ErrInvalidAutoClose = fmt.Errorf("auto-close must be 0-%d minutes", MaxAutoCloseMinutes)
+
+// ParseAutoCloseMinutes parses a set_auto_close payload, rounding fractional minutes.
+func ParseAutoCloseMinutes(payload string) (int, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAutoClose, payload)
+	}
+	minutes := int(math.Round(value))
+	if minutes < 0 || minutes > MaxAutoCloseMinutes {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidAutoClose, minutes)
+	}
+	return minutes, nil
+}
+
+// ConfigureAutoClose publishes Home Assistant discovery for a number entity setting how
+// many minutes the door may stay open before it's closed automatically (0 turns it off),
+// and for device triggers fired when it's about to be and is closed.
+func (h *MQTTHandler) ConfigureAutoClose(mqttPrefix string, device DoorStatusDevice) error {
+	identifiers := []string{fmt.Sprintf("garage_door_%s", device.ID)}
+	configPayload := map[string]interface{}{
+		"name":                  fmt.Sprintf("%s Auto Close", device.Name),
+		"command_topic":         fmt.Sprintf(AutoCloseCommandTopicTemplate, mqttPrefix, device.ID),
+		"state_topic":           fmt.Sprintf(AutoCloseStateTopicTemplate, mqttPrefix, device.ID),
+		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"min":                   0,
+		"max":                   MaxAutoCloseMinutes,
+		"step":                  1,
+		"mode":                  "box",
+		"unit_of_measurement":   "min",
+		"entity_category":       "config",
+		"unique_id":             fmt.Sprintf("auto_close_%s", device.ID),
+		"device": map[string]interface{}{
+			"identifiers": identifiers,
+		},
+		"icon": "mdi:timer-lock-outline",
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	if err := h.publishToMQTT(fmt.Sprintf(AutoCloseConfigTopicTemplate, device.ID), 0, true, bytes); err != nil {
+		return err
+	}
+
+	for _, payload := range []string{AutoCloseWarning, AutoCloseClosing} {
+		triggerPayload := map[string]interface{}{
+			"automation_type": "trigger",
+			"topic":           fmt.Sprintf(AutoCloseTriggerTopicTemplate, mqttPrefix, device.ID),
+			"payload":         payload,
+			"type":            "auto_close_" + payload,
+			"subtype":         "auto_close",
+			"device": map[string]interface{}{
+				"identifiers": identifiers,
+			},
+		}
+		bytes, err := json.Marshal(triggerPayload)
+		if err != nil {
+			return err
+		}
+		if err := h.publishToMQTT(fmt.Sprintf(AutoCloseTriggerConfigTopicTemplate, device.ID, payload), 0, true, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishAutoClose publishes a device's auto-close delay (retained).
+func (h *MQTTHandler) PublishAutoClose(prefix, deviceID string, after time.Duration) error {
+	minutes := strconv.Itoa(int(after / time.Minute))
+	return h.publishToMQTT(fmt.Sprintf(AutoCloseStateTopicTemplate, prefix, deviceID), 0, true, minutes)
+}
+
+// PublishAutoCloseEvent fires an auto-close device trigger, AutoCloseWarning or AutoCloseClosing.
+func (h *MQTTHandler) PublishAutoCloseEvent(prefix, deviceID, event string) error {
+	return h.publishToMQTT(fmt.Sprintf(AutoCloseTriggerTopicTemplate, prefix, deviceID), 0, false, event)
+}
+
+// AutoClose returns how long the door may stay open before it's closed, or 0 if it isn't.
+func (d *DeviceFSM) AutoClose() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.autoClose
+}
+
+// SetAutoClose sets how long the door may stay open before it's closed automatically; 0
+// turns auto-close off. The new delay is published, and a door already open is timed
+// afresh from now.
+func (d *DeviceFSM) SetAutoClose(after time.Duration) {
+	d.mu.Lock()
+	d.autoClose = after
+	d.mu.Unlock()
+
+	d.disarmAutoClose()
+	switch d.Current() {
+	case "open", "partially_open", "stopped":
+		d.armAutoClose()
+	}
+	if err := d.mqttHandler.PublishAutoClose(d.MQTTPrefix, d.ID, after); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to publish auto-close")
+	}
+}
+
+// SetAutoCloseWarning sets AutoCloseWarning while the device may be running. It applies
+// from the next time an open door is timed.
+func (d *DeviceFSM) SetAutoCloseWarning(warning time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.AutoCloseWarning = warning
+}
+
+// armAutoClose starts timing how long the door has been open, unless it's already being
+// timed. With AutoCloseWarning set, a warning is published that long before closing.
+func (d *DeviceFSM) armAutoClose() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.autoClose <= 0 || d.autoCloseTimer != nil {
+		return
+	}
+	gen, warning := d.autoCloseGen, d.AutoCloseWarning
+	if warning > 0 && warning < d.autoClose {
+		d.autoCloseTimer = time.AfterFunc(d.autoClose-warning, func() { d.warnAutoClose(gen, warning) })
+		return
+	}
+	d.autoCloseTimer = time.AfterFunc(d.autoClose, func() { d.runAutoClose(gen) })
+}
+
+// disarmAutoClose stops timing the open door. Timers already firing see the generation
+// change and do nothing.
+func (d *DeviceFSM) disarmAutoClose() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.autoCloseGen++
+	if d.autoCloseTimer != nil {
+		d.autoCloseTimer.Stop()
+		d.autoCloseTimer = nil
+	}
+}
+
+// warnAutoClose publishes that the door will be closed in closeIn, and times it.
+func (d *DeviceFSM) warnAutoClose(gen int, closeIn time.Duration) {
+	d.mu.Lock()
+	if d.autoCloseGen != gen {
+		d.mu.Unlock()
+		return
+	}
+	d.autoCloseTimer = time.AfterFunc(closeIn, func() { d.runAutoClose(gen) })
+	d.mu.Unlock()
+
+	d.mqttHandler.log().WithField("deviceID", d.ID).WithField("in", closeIn).Info("Door will be closed automatically")
+	if err := d.mqttHandler.PublishAutoCloseEvent(d.MQTTPrefix, d.ID, AutoCloseWarning); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to publish auto-close warning")
+	}
+}
+
+// runAutoClose closes a door that's still open once its auto-close delay is up.
+func (d *DeviceFSM) runAutoClose(gen int) {
+	d.mu.Lock()
+	if d.autoCloseGen != gen {
+		d.mu.Unlock()
+		return
+	}
+	d.autoCloseGen++
+	d.autoCloseTimer = nil
+	d.mu.Unlock()
+
+	switch d.Current() {
+	case "closed", "closing", "offline":
+		return
+	}
+	d.mqttHandler.log().WithField("deviceID", d.ID).Info("Door open too long; closing automatically")
+	if err := d.mqttHandler.PublishAutoCloseEvent(d.MQTTPrefix, d.ID, AutoCloseClosing); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to publish auto-close event")
+	}
+	d.queue.Submit(QueuedCommand{Action: QueueClose, Source: SourceTimer})
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseAutoCloseMinutes(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    int
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"15", 15, false},
+		{" 10.0 ", 10, false},
+		{"2.6", 3, false},
+		{"240", 240, false},
+		{"241", 0, true},
+		{"-1", 0, true},
+		{"soon", 0, true},
+		{"NaN", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAutoCloseMinutes(tt.payload)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAutoCloseMinutes(%q) = %d, %v, want %d, error %v", tt.payload, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidAutoClose) {
+			t.Errorf("ParseAutoCloseMinutes(%q) error = %v, want %v", tt.payload, err, ErrInvalidAutoClose)
+		}
+	}
+}
+
+func TestConfigureAutoClose(t *testing.T) {
+	handler, client := newTestHandler()
+	if err := handler.ConfigureAutoClose("dd-door", DoorStatusDevice{ID: "door1", Name: "Garage"}); err != nil {
+		t.Fatalf("ConfigureAutoClose() error = %v", err)
+	}
+
+	p, ok := client.last("homeassistant/number/door1_auto_close/config")
+	if !ok {
+		t.Fatalf("no auto-close number discovery published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("decode discovery payload: %v", err)
+	}
+	for key, want := range map[string]interface{}{
+		"command_topic":       "dd-door/door1/set_auto_close",
+		"state_topic":         "dd-door/door1/auto_close",
+		"unit_of_measurement": "min",
+		"max":                 float64(MaxAutoCloseMinutes),
+	} {
+		if config[key] != want {
+			t.Errorf("config[%q] = %v, want %v", key, config[key], want)
+		}
+	}
+	for _, payload := range []string{AutoCloseWarning, AutoCloseClosing} {
+		if _, ok := client.last("homeassistant/device_automation/door1_auto_close_" + payload + "/config"); !ok {
+			t.Errorf("no %s trigger discovery published", payload)
+		}
+	}
+}
+
+// newAutoCloseFSM returns an online device whose queued commands are sent to the
+// returned channel rather than the hub.
+func newAutoCloseFSM(t *testing.T) (*DeviceFSM, <-chan QueuedCommand, *mockClient) {
+	t.Helper()
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.FSM.SetState("online")
+	executed := make(chan QueuedCommand, 4)
+	df.Queue().execute = func(cmd QueuedCommand) { executed <- cmd }
+	return df, executed, client
+}
+
+func TestDeviceFSM_AutoClose(t *testing.T) {
+	df, executed, client := newAutoCloseFSM(t)
+	df.AutoCloseWarning = 20 * time.Millisecond
+	df.SetAutoClose(40 * time.Millisecond)
+
+	if p, ok := client.last("dd-door/door1/auto_close"); !ok || payloadString(p.Payload) != "0" || !p.Retained {
+		t.Errorf("auto_close state = %v, want retained 0 (whole minutes)", p)
+	}
+
+	if err := df.Trigger(context.Background(), "go_opened"); err != nil {
+		t.Fatalf("Trigger(go_opened) error = %v", err)
+	}
+	select {
+	case cmd := <-executed:
+		if cmd.Action != QueueClose {
+			t.Errorf("auto-close queued %v, want close", cmd)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("door not closed automatically")
+	}
+
+	var events []string
+	for _, p := range client.publishes("dd-door/door1/auto_close/event") {
+		events = append(events, payloadString(p.Payload))
+	}
+	if len(events) != 2 || events[0] != AutoCloseWarning || events[1] != AutoCloseClosing {
+		t.Errorf("auto-close events = %v, want [warning closing]", events)
+	}
+}
+
+func TestDeviceFSM_AutoCloseCancelledByClosing(t *testing.T) {
+	df, executed, _ := newAutoCloseFSM(t)
+	df.SetAutoClose(30 * time.Millisecond)
+
+	if err := df.Trigger(context.Background(), "go_opened"); err != nil {
+		t.Fatalf("Trigger(go_opened) error = %v", err)
+	}
+	if err := df.Trigger(context.Background(), "go_closed"); err != nil {
+		t.Fatalf("Trigger(go_closed) error = %v", err)
+	}
+	select {
+	case cmd := <-executed:
+		t.Errorf("closed door auto-closed with %v", cmd)
+	case <-time.After(80 * time.Millisecond):
+	}
+}
+
+func TestDeviceFSM_SetAutoCloseOff(t *testing.T) {
+	df, executed, _ := newAutoCloseFSM(t)
+	df.SetAutoClose(30 * time.Millisecond)
+	if err := df.Trigger(context.Background(), "go_opened"); err != nil {
+		t.Fatalf("Trigger(go_opened) error = %v", err)
+	}
+	df.SetAutoClose(0)
+	select {
+	case cmd := <-executed:
+		t.Errorf("auto-close turned off but queued %v", cmd)
+	case <-time.After(80 * time.Millisecond):
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// AuxConfigTopicTemplate is the HA discovery topic for a door's aux relay switch
+	AuxConfigTopicTemplate = "homeassistant/switch/%s/config"
+	// AuxStateTopicTemplate carries the aux relay state (ON/OFF)
+	AuxStateTopicTemplate = "%s/%s/aux"
+	// AuxCommandTopicTemplate receives ON/OFF commands for the aux relay
+	AuxCommandTopicTemplate = "%s/%s/set_aux"
+)
+
+// ConfigureAux publishes Home Assistant discovery for the door's aux relay as a switch.
+func (h *MQTTHandler) ConfigureAux(mqttPrefix string, device DoorStatusDevice) error {
+	configPayload := map[string]interface{}{
+		"name":                  fmt.Sprintf("%s Aux", device.Name),
+		"command_topic":         fmt.Sprintf(AuxCommandTopicTemplate, mqttPrefix, device.ID),
+		"state_topic":           fmt.Sprintf(AuxStateTopicTemplate, mqttPrefix, device.ID),
+		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"payload_on":            PayloadOn,
+		"payload_off":           PayloadOff,
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"unique_id":             fmt.Sprintf("aux_%s", device.ID),
+		"device": map[string]interface{}{
+			"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+		},
+		"icon": "mdi:electric-switch",
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(AuxConfigTopicTemplate, device.ID), 0, true, bytes)
+}
+
+// PublishAuxState publishes the aux relay state for a device (retained).
+func (h *MQTTHandler) PublishAuxState(prefix, deviceID string, on bool) error {
+	return h.publishOnOff(fmt.Sprintf(AuxStateTopicTemplate, prefix, deviceID), on)
+}
+
+// AuxCommand maps an ON/OFF payload to the aux command code.
+func AuxCommand(payload string) (int, error) {
+	return onOffCommand(payload, AvailableCommands.AuxOn, AvailableCommands.AuxOff)
+}
+
+// AuxState reports whether the device's aux relay is on; ok is false if the device
+// has no aux button.
+func (d DoorStatusDevice) AuxState() (on bool, ok bool) {
+	return d.toggleState(AvailableCommands.AuxOn, AvailableCommands.AuxOff)
+}
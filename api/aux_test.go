@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigureAux(t *testing.T) {
+	handler, client := newTestHandler()
+	device := DoorStatusDevice{ID: "door1", Name: "Garage"}
+
+	if err := handler.ConfigureAux("dd-door", device); err != nil {
+		t.Fatalf("ConfigureAux() error = %v", err)
+	}
+
+	p, ok := client.last("homeassistant/switch/door1/config")
+	if !ok {
+		t.Fatalf("no aux switch discovery published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("decode discovery payload: %v", err)
+	}
+	for key, want := range map[string]string{
+		"command_topic": "dd-door/door1/set_aux",
+		"state_topic":   "dd-door/door1/aux",
+		"unique_id":     "aux_door1",
+	} {
+		if config[key] != want {
+			t.Errorf("config[%q] = %v, want %q", key, config[key], want)
+		}
+	}
+}
+
+func TestAuxCommand(t *testing.T) {
+	if got, err := AuxCommand(PayloadOn); err != nil || got != AvailableCommands.AuxOn {
+		t.Errorf("AuxCommand(ON) = %d, %v, want %d", got, err, AvailableCommands.AuxOn)
+	}
+	if got, err := AuxCommand(PayloadOff); err != nil || got != AvailableCommands.AuxOff {
+		t.Errorf("AuxCommand(OFF) = %d, %v, want %d", got, err, AvailableCommands.AuxOff)
+	}
+	if _, err := AuxCommand("on"); err == nil {
+		t.Errorf("AuxCommand(on) error = nil, want error")
+	}
+}
+
+func TestDoorStatusDevice_AuxState(t *testing.T) {
+	device := DoorStatusDevice{Aux: []DoorStatusButton{testButton(0, 0, AvailableCommands.AuxOff)}}
+	if on, ok := device.AuxState(); !on || !ok {
+		t.Errorf("AuxState() = %v, %v, want true, true", on, ok)
+	}
+	if _, ok := (DoorStatusDevice{}).AuxState(); ok {
+		t.Errorf("AuxState() with no aux button ok = true, want false")
+	}
+}
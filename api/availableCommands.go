@@ -2,7 +2,9 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 // AvailableCommands contains all SmartDoor device command codes.
@@ -151,3 +153,59 @@ func ParseCommand(command string) (int, error) {
 	}
 	return 0, errors.New("command not found")
 }
+
+// normalizeCommandName lowercases name and replaces spaces with underscores, matching
+// the style of AvailableCommandsMap keys.
+func normalizeCommandName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+}
+
+// ParseButtonCommands maps the device's button and aux titles (normalized, e.g.
+// "Pet Open" becomes "pet_open") to the commands they send.
+func ParseButtonCommands(device DoorStatusDevice) map[string]int {
+	out := make(map[string]int)
+	for _, buttons := range [][]DoorStatusButton{device.Buttons, device.Aux} {
+		for _, b := range buttons {
+			if b.Title == "" {
+				continue
+			}
+			out[normalizeCommandName(b.Title)] = b.Action.Command
+		}
+	}
+	return out
+}
+
+// ParseCommandsFromButtons builds a per-device command registry from a status: the
+// static AvailableCommandsMap merged with each device's own buttons. Device button
+// names take precedence over static names.
+func ParseCommandsFromButtons(status *DoorStatus) map[string]map[string]int {
+	registry := make(map[string]map[string]int, len(status.Devices))
+	for _, device := range status.Devices {
+		commands := make(map[string]int, len(AvailableCommandsMap))
+		for name, cmd := range AvailableCommandsMap {
+			commands[name] = cmd
+		}
+		for name, cmd := range ParseButtonCommands(device) {
+			commands[name] = cmd
+		}
+		registry[device.ID] = commands
+	}
+	return registry
+}
+
+// GetCommandForDevice resolves commandName for a device using a registry built by
+// ParseCommandsFromButtons. Integer strings are accepted as raw command codes.
+func GetCommandForDevice(deviceID string, commandName string, registry map[string]map[string]int) (int, error) {
+	if value, err := strconv.Atoi(commandName); err == nil {
+		return value, nil
+	}
+
+	commands, ok := registry[deviceID]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceID)
+	}
+	if value, ok := commands[normalizeCommandName(commandName)]; ok {
+		return value, nil
+	}
+	return 0, fmt.Errorf("command not found for device %s: %s", deviceID, commandName)
+}
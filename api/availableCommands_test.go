@@ -106,3 +106,46 @@ func TestAvailableCommandsMap_Consistency(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCommandForDevice(t *testing.T) {
+	vent := DoorStatusButton{Title: "Vent"}
+	vent.Action.Command = AvailableCommands.OpenPercent10
+	petOpen := DoorStatusButton{Title: "Pet Open"}
+	petOpen.Action.Command = AvailableCommands.PartOpen1
+
+	status := &DoorStatus{
+		Devices: []DoorStatusDevice{
+			{ID: "door1", Buttons: []DoorStatusButton{vent}, Aux: []DoorStatusButton{petOpen}},
+			{ID: "door2"},
+		},
+	}
+	registry := ParseCommandsFromButtons(status)
+
+	tests := []struct {
+		name     string
+		deviceID string
+		command  string
+		want     int
+		wantErr  bool
+	}{
+		{"Device button", "door1", "Vent", AvailableCommands.OpenPercent10, false},
+		{"Device button normalized", "door1", "vent", AvailableCommands.OpenPercent10, false},
+		{"Device aux with spaces", "door1", "Pet Open", AvailableCommands.PartOpen1, false},
+		{"Static command", "door1", "close", AvailableCommands.Close, false},
+		{"Integer command", "door2", "16", AvailableCommands.LightOn, false},
+		{"Button on other device", "door2", "Vent", 0, true},
+		{"Unknown device", "door3", "open", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetCommandForDevice(tt.deviceID, tt.command, registry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetCommandForDevice(%q, %q) error = %v, wantErr %v", tt.deviceID, tt.command, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetCommandForDevice(%q, %q) = %d, want %d", tt.deviceID, tt.command, got, tt.want)
+			}
+		})
+	}
+}
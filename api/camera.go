@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// AudioAlarmConfigTopicTemplate is the HA discovery topic for a camera's audio alarm switch
+	AudioAlarmConfigTopicTemplate = "homeassistant/switch/%s_audio_alarm/config"
+	// AudioAlarmStateTopicTemplate carries the audio alarm state (ON/OFF)
+	AudioAlarmStateTopicTemplate = "%s/%s/audio_alarm"
+	// AudioAlarmCommandTopicTemplate receives ON/OFF commands for the audio alarm
+	AudioAlarmCommandTopicTemplate = "%s/%s/set_audio_alarm"
+
+	// MotionAlarmConfigTopicTemplate is the HA discovery topic for a camera's motion alarm switch
+	MotionAlarmConfigTopicTemplate = "homeassistant/switch/%s_motion_alarm/config"
+	// MotionAlarmStateTopicTemplate carries the motion alarm state (ON/OFF)
+	MotionAlarmStateTopicTemplate = "%s/%s/motion_alarm"
+	// MotionAlarmCommandTopicTemplate receives ON/OFF commands for the motion alarm
+	MotionAlarmCommandTopicTemplate = "%s/%s/set_motion_alarm"
+)
+
+// AudioAlarmState reports whether the device's camera audio alarm is on; ok is false if
+// the device has no audio alarm button.
+func (d DoorStatusDevice) AudioAlarmState() (on bool, ok bool) {
+	return d.toggleState(AvailableCommands.CameraAudioAlarmEnable, AvailableCommands.CameraAudioAlarmDisable)
+}
+
+// MotionAlarmState reports whether the device's camera motion alarm is on; ok is false if
+// the device has no motion alarm button.
+func (d DoorStatusDevice) MotionAlarmState() (on bool, ok bool) {
+	return d.toggleState(AvailableCommands.CameraMotionAlarmEnable, AvailableCommands.CameraMotionAlarmDisable)
+}
+
+// HasCamera reports whether the device has camera alarm controls.
+func (d DoorStatusDevice) HasCamera() bool {
+	_, audio := d.AudioAlarmState()
+	_, motion := d.MotionAlarmState()
+	return audio || motion
+}
+
+// AudioAlarmCommand maps an ON/OFF payload to the camera audio alarm command code.
+func AudioAlarmCommand(payload string) (int, error) {
+	return onOffCommand(payload, AvailableCommands.CameraAudioAlarmEnable, AvailableCommands.CameraAudioAlarmDisable)
+}
+
+// MotionAlarmCommand maps an ON/OFF payload to the camera motion alarm command code.
+func MotionAlarmCommand(payload string) (int, error) {
+	return onOffCommand(payload, AvailableCommands.CameraMotionAlarmEnable, AvailableCommands.CameraMotionAlarmDisable)
+}
+
+// ConfigureCameraAlarms publishes Home Assistant discovery for switches controlling the
+// camera alarms the device has. Devices without a camera get none.
+func (h *MQTTHandler) ConfigureCameraAlarms(mqttPrefix string, device DoorStatusDevice) error {
+	if _, ok := device.AudioAlarmState(); ok {
+		err := h.configureSwitch(mqttPrefix, device, "Audio Alarm", "audio_alarm", "mdi:volume-high",
+			AudioAlarmConfigTopicTemplate, AudioAlarmStateTopicTemplate, AudioAlarmCommandTopicTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := device.MotionAlarmState(); ok {
+		return h.configureSwitch(mqttPrefix, device, "Motion Alarm", "motion_alarm", "mdi:motion-sensor",
+			MotionAlarmConfigTopicTemplate, MotionAlarmStateTopicTemplate, MotionAlarmCommandTopicTemplate)
+	}
+	return nil
+}
+
+// configureSwitch publishes discovery for one of a device's on/off switches, identified by
+// id in its unique ID.
+func (h *MQTTHandler) configureSwitch(mqttPrefix string, device DoorStatusDevice, name, id, icon, configTemplate, stateTemplate, commandTemplate string) error {
+	configPayload := map[string]interface{}{
+		"name":                  fmt.Sprintf("%s %s", device.Name, name),
+		"command_topic":         fmt.Sprintf(commandTemplate, mqttPrefix, device.ID),
+		"state_topic":           fmt.Sprintf(stateTemplate, mqttPrefix, device.ID),
+		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"payload_on":            PayloadOn,
+		"payload_off":           PayloadOff,
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"unique_id":             fmt.Sprintf("%s_%s", id, device.ID),
+		"device": map[string]interface{}{
+			"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+		},
+		"icon": icon,
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(configTemplate, device.ID), 0, true, bytes)
+}
+
+// PublishAudioAlarmState publishes the camera audio alarm state for a device (retained).
+func (h *MQTTHandler) PublishAudioAlarmState(prefix, deviceID string, on bool) error {
+	return h.publishOnOff(fmt.Sprintf(AudioAlarmStateTopicTemplate, prefix, deviceID), on)
+}
+
+// PublishMotionAlarmState publishes the camera motion alarm state for a device (retained).
+func (h *MQTTHandler) PublishMotionAlarmState(prefix, deviceID string, on bool) error {
+	return h.publishOnOff(fmt.Sprintf(MotionAlarmStateTopicTemplate, prefix, deviceID), on)
+}
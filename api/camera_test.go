@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigureCameraAlarms(t *testing.T) {
+	handler, client := newTestHandler()
+	device := DoorStatusDevice{ID: "door1", Name: "Garage", Aux: []DoorStatusButton{
+		testButton(0, 0, AvailableCommands.CameraAudioAlarmDisable),
+		testButton(0, 0, AvailableCommands.CameraMotionAlarmEnable),
+	}}
+
+	if err := handler.ConfigureCameraAlarms("dd-door", device); err != nil {
+		t.Fatalf("ConfigureCameraAlarms() error = %v", err)
+	}
+
+	for topic, want := range map[string]map[string]string{
+		"homeassistant/switch/door1_audio_alarm/config": {
+			"command_topic": "dd-door/door1/set_audio_alarm",
+			"state_topic":   "dd-door/door1/audio_alarm",
+			"unique_id":     "audio_alarm_door1",
+		},
+		"homeassistant/switch/door1_motion_alarm/config": {
+			"command_topic": "dd-door/door1/set_motion_alarm",
+			"state_topic":   "dd-door/door1/motion_alarm",
+			"unique_id":     "motion_alarm_door1",
+		},
+	} {
+		p, ok := client.last(topic)
+		if !ok {
+			t.Errorf("no discovery published on %s", topic)
+			continue
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+			t.Fatalf("decode discovery payload: %v", err)
+		}
+		for key, value := range want {
+			if config[key] != value {
+				t.Errorf("%s: config[%q] = %v, want %q", topic, key, config[key], value)
+			}
+		}
+	}
+}
+
+func TestConfigureCameraAlarms_NoCamera(t *testing.T) {
+	handler, client := newTestHandler()
+	if err := handler.ConfigureCameraAlarms("dd-door", DoorStatusDevice{ID: "door1"}); err != nil {
+		t.Fatalf("ConfigureCameraAlarms() error = %v", err)
+	}
+	if _, ok := client.last("homeassistant/switch/door1_audio_alarm/config"); ok {
+		t.Errorf("audio alarm discovery published for device without camera")
+	}
+	if _, ok := client.last("homeassistant/switch/door1_motion_alarm/config"); ok {
+		t.Errorf("motion alarm discovery published for device without camera")
+	}
+}
+
+func TestCameraAlarmCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		command func(string) (int, error)
+		payload string
+		want    int
+	}{
+		{"audio on", AudioAlarmCommand, PayloadOn, AvailableCommands.CameraAudioAlarmEnable},
+		{"audio off", AudioAlarmCommand, PayloadOff, AvailableCommands.CameraAudioAlarmDisable},
+		{"motion on", MotionAlarmCommand, PayloadOn, AvailableCommands.CameraMotionAlarmEnable},
+		{"motion off", MotionAlarmCommand, PayloadOff, AvailableCommands.CameraMotionAlarmDisable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.command(tt.payload); err != nil || got != tt.want {
+				t.Errorf("command(%s) = %d, %v, want %d", tt.payload, got, err, tt.want)
+			}
+		})
+	}
+	if _, err := MotionAlarmCommand("on"); err == nil {
+		t.Errorf("MotionAlarmCommand(on) error = nil, want error")
+	}
+}
+
+func TestDoorStatusDevice_CameraAlarmState(t *testing.T) {
+	device := DoorStatusDevice{Aux: []DoorStatusButton{testButton(0, 0, AvailableCommands.CameraMotionAlarmDisable)}}
+	if on, ok := device.MotionAlarmState(); !on || !ok {
+		t.Errorf("MotionAlarmState() = %v, %v, want true, true", on, ok)
+	}
+	if _, ok := device.AudioAlarmState(); ok {
+		t.Errorf("AudioAlarmState() with no audio alarm button ok = true, want false")
+	}
+	if !device.HasCamera() {
+		t.Errorf("HasCamera() = false, want true")
+	}
+	if (DoorStatusDevice{}).HasCamera() {
+		t.Errorf("HasCamera() with no camera buttons = true, want false")
+	}
+}
@@ -1,6 +1,13 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gravypower/dd"
 	"github.com/sirupsen/logrus"
 )
@@ -16,27 +23,134 @@ type CommandOutput struct {
 	Value string `json:"value"`
 }
 
+// accepted reports whether the hub accepted the command. It answers with an empty value,
+// "ok" or a zero status code; anything else is treated as a rejection.
+func (o CommandOutput) accepted() bool {
+	value := strings.TrimSpace(o.Value)
+	if value == "" || strings.EqualFold(value, "ok") {
+		return true
+	}
+	code, err := strconv.Atoi(value)
+	return err == nil && code == 0
+}
+
+// CommandRejectedError is returned when the hub answers a command with an error (for
+// example because the device is locked out) or, with CommandOptions.Verify, with a
+// non-zero value. Err is the underlying *dd.RPCError, if any, so errors.Is still matches
+// sentinels like dd.ErrAccessRestricted.
+type CommandRejectedError struct {
+	DeviceID string
+	Command  int
+	Value    string // CommandOutput.Value, when rejected by verification
+	Err      error
+}
+
+func (e *CommandRejectedError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("command %d rejected by device %s: %v", e.Command, e.DeviceID, e.Err)
+	}
+	return fmt.Sprintf("command %d rejected by device %s: value %q", e.Command, e.DeviceID, e.Value)
+}
+
+func (e *CommandRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Defaults for CommandOptions
+const (
+	DefaultCommandBackoff    = time.Second
+	DefaultCommandMaxBackoff = 30 * time.Second
+)
+
+// CommandOptions controls how SendCommand verifies and retries a command.
+// Zero values use the defaults, which send once without verification.
+type CommandOptions struct {
+	Verify     bool          // check the hub's CommandOutput for a rejection
+	Retries    int           // extra attempts after a failure to reach the hub
+	Backoff    time.Duration // delay before the first retry, doubled for each after and jittered
+	MaxBackoff time.Duration // cap on the delay between retries
+}
+
 // SafeCommand sends a command to a device and returns an error if it fails.
 // This function no longer calls Fatal() to allow graceful error handling.
 func SafeCommand(conn *dd.Conn, deviceID string, command int) error {
+	return SafeCommandContext(context.Background(), conn, deviceID, command)
+}
+
+// SafeCommandContext is SafeCommand with a context bounding the request.
+func SafeCommandContext(ctx context.Context, conn *dd.Conn, deviceID string, command int) error {
+	_, err := SendCommand(ctx, conn, deviceID, command, CommandOptions{})
+	return err
+}
+
+// SendCommand sends a command to a device, retrying as options allow when the request never
+// got to the hub (dd.ErrUnreachable: its address couldn't be resolved or connected to).
+// Other failures aren't retried, since the hub may have acted on the command: after
+// dd.ErrNoResponse or dd.ErrTimeout a door could already be moving, and sending a toggle
+// again would reverse it. A hub rejection is returned as a *CommandRejectedError.
+// In dry-run mode (see EnableDryRun) nothing is sent.
+func SendCommand(ctx context.Context, conn *dd.Conn, deviceID string, command int, options CommandOptions) (CommandOutput, error) {
+	if options.Backoff == 0 {
+		options.Backoff = DefaultCommandBackoff
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = DefaultCommandMaxBackoff
+	}
 
-	logger.WithField("deviceID", deviceID).
-		WithField("command", command).
-		Info("sending command")
+	if holdCommand(ctx, deviceID, command) {
+		return CommandOutput{}, nil
+	}
+
+	log := logger.WithField("deviceID", deviceID).WithField("command", command)
+	log.Info("sending command")
 
 	var commandInput CommandInput
 	commandInput.DeviceId = deviceID
 	commandInput.Action.Command = command
-	err := conn.RPC(dd.RPC{
-		Path:  "/app/res/action",
-		Input: commandInput,
-	})
-	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"commandInput": commandInput,
-			"error":        err,
-		}).Error("Could not perform RPC action")
-		return err
+
+	backoff := dd.Backoff{Base: options.Backoff, Max: options.MaxBackoff, Jitter: dd.DefaultBackoffJitter}
+	for attempt := 0; ; attempt++ {
+		var output CommandOutput
+		rpc := dd.RPC{Path: "/app/res/action", Input: commandInput}
+		if options.Verify {
+			rpc.Output = &output
+		}
+		// Ahead of polls waiting for the hub's next access slot
+		err := timedRPCContext(dd.WithPriority(ctx, dd.PriorityCommand), conn, rpc)
+		var rpcErr *dd.RPCError
+		switch {
+		case errors.As(err, &rpcErr):
+			err = &CommandRejectedError{DeviceID: deviceID, Command: command, Err: err}
+		case err == nil && options.Verify && !output.accepted():
+			err = &CommandRejectedError{DeviceID: deviceID, Command: command, Value: output.Value}
+		}
+		recordCommand(ctx, deviceID, command, err)
+		if err == nil {
+			return output, nil
+		}
+
+		if !retryCommand(err) || ctx.Err() != nil || attempt >= options.Retries {
+			log.WithFields(logrus.Fields{
+				"commandInput": commandInput,
+				"error":        err,
+			}).Error("Could not perform RPC action")
+			return output, err
+		}
+
+		delay := backoff.Next()
+		log.WithError(err).WithField("retryIn", delay).Warn("Command failed, retrying")
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return output, ctx.Err()
+		}
 	}
-	return nil
+}
+
+// retryCommand reports whether a command that failed with err can safely be sent again,
+// because the hub never got it.
+func retryCommand(err error) bool {
+	return errors.Is(err, dd.ErrUnreachable)
 }
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gravypower/dd"
+	"github.com/gravypower/dd/ddtest"
+)
+
+func TestCommandOutput_accepted(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", true},
+		{"ok", true},
+		{"OK", true},
+		{"0", true},
+		{"3", false},
+		{"locked out", false},
+	}
+	for _, tt := range tests {
+		if got := (CommandOutput{Value: tt.value}).accepted(); got != tt.want {
+			t.Errorf("CommandOutput{%q}.accepted() = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSendCommand_Rejected(t *testing.T) {
+	server, conn := connectTestServer(t)
+	ctx := context.Background()
+
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		return nil, &dd.RPCError{Code: 5, Description: "restricted by lockout"}
+	})
+	_, err := SendCommand(ctx, conn, "door1", AvailableCommands.Open, CommandOptions{Retries: 3})
+	var rejected *CommandRejectedError
+	if !errors.As(err, &rejected) || rejected.DeviceID != "door1" || rejected.Command != AvailableCommands.Open {
+		t.Fatalf("SendCommand() error = %v, want *CommandRejectedError for door1", err)
+	}
+	if !errors.Is(err, dd.ErrAccessRestricted) {
+		t.Errorf("SendCommand() error = %v, want it to match dd.ErrAccessRestricted", err)
+	}
+	if n := server.Requests("/app/res/action"); n != 1 {
+		t.Errorf("sent %d commands, want 1 (rejections aren't retried)", n)
+	}
+
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		return CommandOutput{Value: "3"}, nil
+	})
+	if _, err := SendCommand(ctx, conn, "door1", AvailableCommands.Open, CommandOptions{}); err != nil {
+		t.Errorf("SendCommand() without Verify error = %v, want nil", err)
+	}
+	_, err = SendCommand(ctx, conn, "door1", AvailableCommands.Open, CommandOptions{Verify: true})
+	if !errors.As(err, &rejected) || rejected.Value != "3" {
+		t.Errorf("SendCommand() with Verify error = %v, want *CommandRejectedError with value 3", err)
+	}
+}
+
+func TestRetryCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unreachable", fmt.Errorf("%w: do request: connection refused", dd.ErrUnreachable), true},
+		{"no response", fmt.Errorf("%w: do request: EOF", dd.ErrNoResponse), false},
+		{"timeout", dd.ErrTimeout, false},
+		{"wrapped timeout", fmt.Errorf("rpc: %w", dd.ErrTimeout), false},
+		{"HTTP status", errors.New("non-2xx status code: 503 Service Unavailable"), false},
+		{"rejected", &CommandRejectedError{DeviceID: "door1", Err: &dd.RPCError{Code: 5}}, false},
+		{"canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryCommand(tt.err); got != tt.want {
+				t.Errorf("retryCommand(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendCommand_RetriesTransientFailures(t *testing.T) {
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+	// Fail the dial for the first command, so it never leaves
+	var refuse atomic.Bool
+	conn := server.Conn()
+	conn.Transport = &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if refuse.CompareAndSwap(true, false) {
+				addr = "127.0.0.1:1" // nothing listens here
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		return CommandOutput{Value: "ok"}, nil
+	})
+	refuse.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := SendCommand(ctx, conn, "door1", AvailableCommands.Close, CommandOptions{
+		Verify:  true,
+		Retries: 2,
+		Backoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("SendCommand() error = %v", err)
+	}
+	if refuse.Load() || out.Value != "ok" {
+		t.Errorf("SendCommand() = %+v, want ok after a failed dial", out)
+	}
+	if n := server.Requests("/app/res/action"); n != 1 {
+		t.Errorf("hub got %d commands, want 1", n)
+	}
+}
+
+func TestSendCommand_DeliveredFailuresNotRetried(t *testing.T) {
+	// In each case the hub has read the command, so it may have acted on it
+	for _, tt := range []struct {
+		name string
+		fail func(t *testing.T, w http.ResponseWriter)
+		want error
+	}{
+		{"status", func(t *testing.T, w http.ResponseWriter) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}, nil},
+		{"reset", func(t *testing.T, w http.ResponseWriter) {
+			c, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack() error = %v", err)
+				return
+			}
+			c.Close()
+		}, dd.ErrNoResponse},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := ddtest.NewUnstartedServer()
+			var delivered atomic.Int32
+			handler := server.Config.Handler
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/app/res/action") {
+					io.ReadAll(r.Body)
+					delivered.Add(1)
+					tt.fail(t, w)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			})
+			server.StartTLS()
+			t.Cleanup(server.Close)
+			conn := server.Conn()
+			t.Cleanup(conn.Close)
+			if err := conn.Connect(server.Credential); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+
+			_, err := SendCommand(context.Background(), conn, "door1", AvailableCommands.Open, CommandOptions{
+				Retries: 2,
+				Backoff: 10 * time.Millisecond,
+			})
+			if err == nil || errors.Is(err, dd.ErrUnreachable) || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Fatalf("SendCommand() error = %v, want a failure after delivery", err)
+			}
+			if n := delivered.Load(); n != 1 {
+				t.Errorf("hub got %d commands, want 1", n)
+			}
+		})
+	}
+}
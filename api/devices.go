@@ -1,9 +1,19 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/gravypower/dd"
 )
 
+// ErrDeviceNotFound is returned when a device ID isn't present in the hub's status.
+var ErrDeviceNotFound = errors.New("device not found")
+
 // Door command constants - these map to SmartDoor device command codes
 const (
 	// CMD_OPEN fully opens the door (position 100)
@@ -29,14 +39,31 @@ type DoorStatusDevice struct {
 
 	Device struct {
 		Position int `json:"position"` // 0-100
+
+		// Diagnostics, for devices whose hub reports them
+		Battery  *int `json:"battery,omitempty"`  // percent
+		RFSignal *int `json:"rfSignal,omitempty"` // dBm
 	} `json:"device"`
 
-	Log struct {
-		ID    int64  `json:"logId"`
-		Alert int    `json:"alert"`
-		Text  string `json:"text"`
-		Time  int64  `json:"time"`
-	} `json:"log"`
+	Log DoorStatusLog `json:"log"`
+}
+
+// DoorStatusLog is an entry in a device's event log.
+type DoorStatusLog struct {
+	ID    int64  `json:"logId"`
+	Alert int    `json:"alert"` // command code or alert that caused the entry
+	Text  string `json:"text"`
+	Time  int64  `json:"time"` // epoch milliseconds
+}
+
+// Equal reports whether other describes the same device state, comparing the
+// position, latest log entry, status hash and diagnostics.
+func (d DoorStatusDevice) Equal(other DoorStatusDevice) bool {
+	return d.Device.Position == other.Device.Position &&
+		d.Log.ID == other.Log.ID &&
+		d.Hash == other.Hash &&
+		equalReading(d.Device.Battery, other.Device.Battery) &&
+		equalReading(d.Device.RFSignal, other.Device.RFSignal)
 }
 
 // DoorStatusButton represents a button displayed in the UI.
@@ -96,6 +123,16 @@ func CommandForRatio(position int) int {
 	}
 }
 
+// ParsePosition parses a set_position payload, rounding fractional values and
+// clamping the result to 0-100.
+func ParsePosition(payload string) (int, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid position %q", payload)
+	}
+	return int(math.Round(max(PositionClosed, min(PositionOpen, value)))), nil
+}
+
 // GetCommandForPosition maps a position percentage (0-100) to the appropriate device command.
 // Uses granular percentage commands (5% increments) when available.
 func GetCommandForPosition(position int) int {
@@ -153,11 +190,31 @@ func GetCommandForPosition(position int) int {
 	}
 }
 
+// PositionForCommand returns the position command leaves the door at, reporting false
+// for commands without an exact one, such as stop or the pet and parcel opens.
+func PositionForCommand(command int) (int, bool) {
+	switch {
+	case command == AvailableCommands.Open:
+		return PositionOpen, true
+	case command == AvailableCommands.Close:
+		return PositionClosed, true
+	case command >= AvailableCommands.OpenPercent05 && command <= AvailableCommands.OpenPercent95:
+		return (command - AvailableCommands.OpenPercent05 + 1) * 5, true
+	default:
+		return 0, false
+	}
+}
+
 // SafeFetchStatus fetches the door status and returns an error if it fails.
 // This function no longer calls Fatal() to allow graceful error handling.
 func SafeFetchStatus(conn *dd.Conn) (*DoorStatus, error) {
+	return SafeFetchStatusContext(context.Background(), conn)
+}
+
+// SafeFetchStatusContext is SafeFetchStatus with a context bounding the request.
+func SafeFetchStatusContext(ctx context.Context, conn *dd.Conn) (*DoorStatus, error) {
 	var status DoorStatus
-	err := conn.RPC(dd.RPC{
+	err := timedRPCContext(ctx, conn, dd.RPC{
 		Path:   "/app/res/devices/fetch",
 		Output: &status,
 	})
@@ -165,5 +222,81 @@ func SafeFetchStatus(conn *dd.Conn) (*DoorStatus, error) {
 		logger.WithField("error", err).Error("Could not fetch door status")
 		return nil, err
 	}
+	RecordDoorStatus(ctx, &status)
 	return &status, nil
 }
+
+// FetchDeviceByID fetches the door status and returns the device with the given ID,
+// or ErrDeviceNotFound if the hub doesn't report it.
+func FetchDeviceByID(conn *dd.Conn, deviceID string) (*DoorStatusDevice, error) {
+	status, err := SafeFetchStatus(conn)
+	if err != nil {
+		return nil, err
+	}
+	return status.deviceByID(deviceID)
+}
+
+// ListDevices fetches the hub's status and returns its devices in the hub's DeviceOrder.
+// Devices missing from DeviceOrder follow in the order they were sent.
+func ListDevices(conn *dd.Conn) ([]DoorStatusDevice, error) {
+	status, err := SafeFetchStatus(conn)
+	if err != nil {
+		return nil, err
+	}
+	return status.ordered(), nil
+}
+
+// FindDeviceByName returns the device named name (ignoring case), or ErrDeviceNotFound.
+func FindDeviceByName(devices []DoorStatusDevice, name string) (*DoorStatusDevice, error) {
+	for i := range devices {
+		if strings.EqualFold(devices[i].Name, name) {
+			return &devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, name)
+}
+
+// ordered returns Devices sorted by DeviceOrder.
+func (ds *DoorStatus) ordered() []DoorStatusDevice {
+	devices := make([]DoorStatusDevice, 0, len(ds.Devices))
+	seen := make(map[string]bool, len(ds.DeviceOrder))
+	for _, id := range ds.DeviceOrder {
+		if device := ds.Get(id); device != nil && !seen[id] {
+			devices = append(devices, *device)
+			seen[id] = true
+		}
+	}
+	for _, device := range ds.Devices {
+		if !seen[device.ID] {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// renameRequest is the input to /app/res/devices/rename.
+type renameRequest struct {
+	DeviceID string `json:"deviceId"`
+	Name     string `json:"name"`
+}
+
+// RenameDevice changes the name the hub shows for a device. Requires admin credentials.
+func RenameDevice(conn *dd.Conn, deviceID, name string) error {
+	err := timedRPC(conn, dd.RPC{
+		Path:  "/app/res/devices/rename",
+		Input: renameRequest{DeviceID: deviceID, Name: name},
+	})
+	if err != nil {
+		logger.WithError(err).WithField("deviceID", deviceID).Error("Could not rename device")
+	}
+	return err
+}
+
+// deviceByID is Get, returning ErrDeviceNotFound instead of nil.
+func (ds *DoorStatus) deviceByID(deviceID string) (*DoorStatusDevice, error) {
+	device := ds.Get(deviceID)
+	if device == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceID)
+	}
+	return device, nil
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -172,3 +174,137 @@ func TestGetCommandForPosition_AllPercentages(t *testing.T) {
 		}
 	}
 }
+
+func TestPositionForCommand(t *testing.T) {
+	for pos := 0; pos <= 100; pos += 5 {
+		got, ok := PositionForCommand(GetCommandForPosition(pos))
+		if !ok || got != pos {
+			t.Errorf("PositionForCommand(GetCommandForPosition(%d)) = %d, %v, want %d", pos, got, ok, pos)
+		}
+	}
+	for _, command := range []int{AvailableCommands.Stop, AvailableCommands.LightOn, CMD_PET_OPEN, CMD_PARCEL_OPEN} {
+		if got, ok := PositionForCommand(command); ok {
+			t.Errorf("PositionForCommand(%d) = %d, want no position", command, got)
+		}
+	}
+}
+
+func TestDoorStatusDevice_Equal(t *testing.T) {
+	base := DoorStatusDevice{ID: "door1", Name: "Garage", Hash: 42}
+	base.Device.Position = 50
+	base.Log.ID = 7
+
+	same := base
+	same.Name = "Renamed" // not part of the comparison
+	if !base.Equal(same) {
+		t.Errorf("Equal() = false for identical device state")
+	}
+
+	moved := base
+	moved.Device.Position = 60
+	if base.Equal(moved) {
+		t.Errorf("Equal() = true when position differs")
+	}
+
+	logged := base
+	logged.Log.ID = 8
+	if base.Equal(logged) {
+		t.Errorf("Equal() = true when log ID differs")
+	}
+
+	rehashed := base
+	rehashed.Hash = 43
+	if base.Equal(rehashed) {
+		t.Errorf("Equal() = true when hash differs")
+	}
+}
+
+func TestDoorStatus_deviceByID(t *testing.T) {
+	status := DoorStatus{
+		Devices: []DoorStatusDevice{
+			{ID: "door1", Name: "Garage"},
+			{ID: "door2", Name: "Gate"},
+		},
+	}
+
+	device, err := status.deviceByID("door2")
+	if err != nil {
+		t.Fatalf("deviceByID(door2) error = %v", err)
+	}
+	if device.Name != "Gate" {
+		t.Errorf("deviceByID(door2).Name = %q, want %q", device.Name, "Gate")
+	}
+
+	_, err = status.deviceByID("missing")
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("deviceByID(missing) error = %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    int
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"42", 42, false},
+		{" 100\n", 100, false},
+		{"37.6", 38, false},
+		{"-5", 0, false},
+		{"150", 100, false},
+		{"", 0, true},
+		{"open", 0, true},
+		{"NaN", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePosition(tt.payload)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePosition(%q) error = %v, wantErr %v", tt.payload, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePosition(%q) = %d, want %d", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestDoorStatus_ordered(t *testing.T) {
+	status := DoorStatus{
+		DeviceOrder: []string{"door3", "missing", "door1", "door3"},
+		Devices:     []DoorStatusDevice{{ID: "door1"}, {ID: "door2"}, {ID: "door3"}},
+	}
+
+	var got []string
+	for _, d := range status.ordered() {
+		got = append(got, d.ID)
+	}
+	if want := []string{"door3", "door1", "door2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ordered() = %v, want %v", got, want)
+	}
+}
+
+func TestFindDeviceByName(t *testing.T) {
+	devices := []DoorStatusDevice{{ID: "door1", Name: "Garage"}, {ID: "door2", Name: "Side Gate"}}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		{"Side Gate", "door2", nil},
+		{"garage", "door1", nil},
+		{"Front", "", ErrDeviceNotFound},
+	}
+	for _, tt := range tests {
+		got, err := FindDeviceByName(devices, tt.name)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("FindDeviceByName(%q) error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.ID != tt.want {
+			t.Errorf("FindDeviceByName(%q) = %s, want %s", tt.name, got.ID, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DiagnosticsConfigTopicTemplate is the HA discovery topic for a diagnostic sensor,
+	// by entity ID (e.g. {deviceID}_battery)
+	DiagnosticsConfigTopicTemplate = "homeassistant/sensor/%s/config"
+	// DiagnosticsTopicTemplate carries a device's diagnostics as JSON
+	DiagnosticsTopicTemplate = "%s/%s/diagnostics"
+	// HubDiagnosticsTopicTemplate carries the hub's diagnostics as JSON
+	HubDiagnosticsTopicTemplate = "%s/bridge/diagnostics"
+	// HubConnectivityConfigTopicTemplate is the HA discovery topic for the hub's connectivity
+	// sensor, by base station ID
+	HubConnectivityConfigTopicTemplate = "homeassistant/binary_sensor/hub_%s_connectivity/config"
+)
+
+// DeviceDiagnostics are a device's health readings. Nil readings weren't reported.
+type DeviceDiagnostics struct {
+	Battery  *int `json:"battery,omitempty"`  // percent
+	RFSignal *int `json:"rfSignal,omitempty"` // dBm
+}
+
+// Diagnostics returns the device's health readings.
+func (d DoorStatusDevice) Diagnostics() DeviceDiagnostics {
+	return DeviceDiagnostics{Battery: d.Device.Battery, RFSignal: d.Device.RFSignal}
+}
+
+// HubDiagnostics are the hub's health readings. Nil readings weren't reported.
+type HubDiagnostics struct {
+	WiFiRSSI *int `json:"wifiRssi,omitempty"` // dBm
+
+	// From health checks; see dd.Conn.Ping
+	Latency   *int  `json:"latency,omitempty"`   // ms
+	ClockSkew *int  `json:"clockSkew,omitempty"` // ms, hub clock minus ours
+	Healthy   *bool `json:"healthy,omitempty"`
+}
+
+// Diagnostics returns the hub's health readings.
+func (i BasicInfo) Diagnostics() HubDiagnostics {
+	return HubDiagnostics{WiFiRSSI: i.WiFiRSSI}
+}
+
+// diagnosticSensor describes one HA sensor reading a field from a diagnostics topic.
+type diagnosticSensor struct {
+	suffix      string // appended to the entity ID and name
+	name        string
+	field       string // JSON field in the diagnostics payload
+	deviceClass string
+	unit        string
+}
+
+var (
+	batterySensor   = diagnosticSensor{"battery", "Battery", "battery", "battery", "%"}
+	rfSignalSensor  = diagnosticSensor{"rf_signal", "RF Signal", "rfSignal", "signal_strength", "dBm"}
+	wifiSensor      = diagnosticSensor{"wifi_rssi", "Wi-Fi Signal", "wifiRssi", "signal_strength", "dBm"}
+	latencySensor   = diagnosticSensor{"latency", "Latency", "latency", "duration", "ms"}
+	clockSkewSensor = diagnosticSensor{"clock_skew", "Clock Skew", "clockSkew", "duration", "ms"}
+)
+
+// ConfigureDiagnostics publishes Home Assistant discovery for the diagnostic sensors the
+// device reports readings for.
+func (h *MQTTHandler) ConfigureDiagnostics(mqttPrefix string, device DoorStatusDevice) error {
+	diagnostics := device.Diagnostics()
+	identifiers := []string{fmt.Sprintf("garage_door_%s", device.ID)}
+	stateTopic := fmt.Sprintf(DiagnosticsTopicTemplate, mqttPrefix, device.ID)
+	availabilityTopic := fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID)
+	for _, sensor := range []struct {
+		diagnosticSensor
+		reported bool
+	}{
+		{batterySensor, diagnostics.Battery != nil},
+		{rfSignalSensor, diagnostics.RFSignal != nil},
+	} {
+		if !sensor.reported {
+			continue
+		}
+		err := h.configureDiagnosticSensor(device.ID, device.Name, identifiers, stateTopic, availabilityTopic, sensor.diagnosticSensor)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigureHubDiagnostics publishes Home Assistant discovery for the hub's diagnostic
+// sensors, on a device of its own, if it reports any readings.
+func (h *MQTTHandler) ConfigureHubDiagnostics(mqttPrefix string, info BasicInfo) error {
+	if info.WiFiRSSI == nil {
+		return nil
+	}
+	identifiers := []string{fmt.Sprintf("dd_hub_%s", info.BaseStation)}
+	stateTopic := fmt.Sprintf(HubDiagnosticsTopicTemplate, mqttPrefix)
+	return h.configureDiagnosticSensor("hub_"+info.BaseStation, info.Name, identifiers, stateTopic, "", wifiSensor)
+}
+
+// ConfigureHubHealth publishes Home Assistant discovery for the hub's health check
+// readings: latency and clock skew sensors and a connectivity binary sensor, on the same
+// device as ConfigureHubDiagnostics.
+func (h *MQTTHandler) ConfigureHubHealth(mqttPrefix string, info BasicInfo) error {
+	id := "hub_" + info.BaseStation
+	identifiers := []string{fmt.Sprintf("dd_hub_%s", info.BaseStation)}
+	stateTopic := fmt.Sprintf(HubDiagnosticsTopicTemplate, mqttPrefix)
+	for _, sensor := range []diagnosticSensor{latencySensor, clockSkewSensor} {
+		if err := h.configureDiagnosticSensor(id, info.Name, identifiers, stateTopic, "", sensor); err != nil {
+			return err
+		}
+	}
+
+	configPayload := map[string]interface{}{
+		"name":            fmt.Sprintf("%s Connectivity", info.Name),
+		"state_topic":     stateTopic,
+		"value_template":  "{{ 'ON' if value_json.healthy else 'OFF' }}",
+		"device_class":    "connectivity",
+		"entity_category": "diagnostic",
+		"unique_id":       id + "_connectivity",
+		"device": map[string]interface{}{
+			"identifiers": identifiers,
+			"name":        info.Name,
+		},
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(HubConnectivityConfigTopicTemplate, info.BaseStation), 0, true, bytes)
+}
+
+func (h *MQTTHandler) configureDiagnosticSensor(id, name string, identifiers []string, stateTopic, availabilityTopic string, sensor diagnosticSensor) error {
+	entityID := fmt.Sprintf("%s_%s", id, sensor.suffix)
+	configPayload := map[string]interface{}{
+		"name":                fmt.Sprintf("%s %s", name, sensor.name),
+		"state_topic":         stateTopic,
+		"value_template":      fmt.Sprintf("{{ value_json.%s }}", sensor.field),
+		"device_class":        sensor.deviceClass,
+		"unit_of_measurement": sensor.unit,
+		"state_class":         "measurement",
+		"entity_category":     "diagnostic",
+		"unique_id":           entityID,
+		"device": map[string]interface{}{
+			"identifiers": identifiers,
+			"name":        name,
+		},
+	}
+	if availabilityTopic != "" {
+		configPayload["availability_topic"] = availabilityTopic
+		configPayload["payload_available"] = "online"
+		configPayload["payload_not_available"] = "offline"
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(DiagnosticsConfigTopicTemplate, entityID), 0, true, bytes)
+}
+
+// PublishDiagnostics publishes a device's diagnostics as retained JSON, if it reports any.
+func (h *MQTTHandler) PublishDiagnostics(prefix, deviceID string, diagnostics DeviceDiagnostics) error {
+	if diagnostics == (DeviceDiagnostics{}) {
+		return nil
+	}
+	return h.publishJSON(fmt.Sprintf(DiagnosticsTopicTemplate, prefix, deviceID), diagnostics)
+}
+
+// PublishHubDiagnostics publishes the hub's diagnostics as retained JSON, if it reports any.
+func (h *MQTTHandler) PublishHubDiagnostics(prefix string, diagnostics HubDiagnostics) error {
+	if diagnostics == (HubDiagnostics{}) {
+		return nil
+	}
+	return h.publishJSON(fmt.Sprintf(HubDiagnosticsTopicTemplate, prefix), diagnostics)
+}
+
+func (h *MQTTHandler) publishJSON(topic string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", topic, err)
+	}
+	return h.publishToMQTT(topic, 0, true, payload)
+}
+
+// equalReading reports whether two optional readings are both unreported or equal.
+func equalReading(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestDoorStatusDevice_Diagnostics(t *testing.T) {
+	var device DoorStatusDevice
+	if err := json.Unmarshal([]byte(`{"deviceId":"door1","device":{"position":0,"battery":87,"rfSignal":-71}}`), &device); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	d := device.Diagnostics()
+	if d.Battery == nil || *d.Battery != 87 || d.RFSignal == nil || *d.RFSignal != -71 {
+		t.Errorf("Diagnostics() = %+v, want battery 87 and rfSignal -71", d)
+	}
+
+	// A changed reading is a changed device, so the new reading gets published
+	other := device
+	battery := 86
+	other.Device.Battery = &battery
+	if device.Equal(other) {
+		t.Errorf("Equal() = true for different battery readings")
+	}
+	other.Device.Battery = nil
+	if device.Equal(other) {
+		t.Errorf("Equal() = true for a battery reading that disappeared")
+	}
+}
+
+func TestMQTTHandler_ConfigureDiagnostics(t *testing.T) {
+	handler, client := newTestHandler()
+	battery := 87
+	device := DoorStatusDevice{ID: "door1", Name: "Garage"}
+	device.Device.Battery = &battery
+
+	if err := handler.ConfigureDiagnostics("dd-door", device); err != nil {
+		t.Fatalf("ConfigureDiagnostics() error = %v", err)
+	}
+	p, ok := client.last(fmt.Sprintf(DiagnosticsConfigTopicTemplate, "door1_battery"))
+	if !ok {
+		t.Fatalf("no battery sensor discovery published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("discovery config is not valid JSON: %v", err)
+	}
+	if config["entity_category"] != "diagnostic" || config["device_class"] != "battery" {
+		t.Errorf("battery sensor config = %v, want diagnostic battery sensor", config)
+	}
+	if _, ok := client.last(fmt.Sprintf(DiagnosticsConfigTopicTemplate, "door1_rf_signal")); ok {
+		t.Errorf("RF signal sensor configured without a reading")
+	}
+
+	if err := handler.PublishDiagnostics("dd-door", "door1", device.Diagnostics()); err != nil {
+		t.Fatalf("PublishDiagnostics() error = %v", err)
+	}
+	if p, _ := client.last("dd-door/door1/diagnostics"); payloadString(p.Payload) != `{"battery":87}` {
+		t.Errorf("diagnostics = %s, want {\"battery\":87}", payloadString(p.Payload))
+	}
+}
+
+func TestMQTTHandler_HubDiagnostics(t *testing.T) {
+	handler, client := newTestHandler()
+
+	// Hubs that don't report readings get no sensors
+	if err := handler.ConfigureHubDiagnostics("dd-door", BasicInfo{BaseStation: "bs1"}); err != nil {
+		t.Fatalf("ConfigureHubDiagnostics() error = %v", err)
+	}
+	if err := handler.PublishHubDiagnostics("dd-door", HubDiagnostics{}); err != nil {
+		t.Fatalf("PublishHubDiagnostics() error = %v", err)
+	}
+	if len(client.published) != 0 {
+		t.Errorf("published %d messages for a hub without readings, want 0", len(client.published))
+	}
+
+	rssi := -60
+	info := BasicInfo{BaseStation: "bs1", Name: "Home", WiFiRSSI: &rssi}
+	if err := handler.ConfigureHubDiagnostics("dd-door", info); err != nil {
+		t.Fatalf("ConfigureHubDiagnostics() error = %v", err)
+	}
+	if _, ok := client.last(fmt.Sprintf(DiagnosticsConfigTopicTemplate, "hub_bs1_wifi_rssi")); !ok {
+		t.Errorf("no Wi-Fi sensor discovery published")
+	}
+	if err := handler.PublishHubDiagnostics("dd-door", info.Diagnostics()); err != nil {
+		t.Fatalf("PublishHubDiagnostics() error = %v", err)
+	}
+	if p, _ := client.last("dd-door/bridge/diagnostics"); payloadString(p.Payload) != `{"wifiRssi":-60}` {
+		t.Errorf("hub diagnostics = %s, want {\"wifiRssi\":-60}", payloadString(p.Payload))
+	}
+}
+
+func TestMQTTHandler_ConfigureHubHealth(t *testing.T) {
+	handler, client := newTestHandler()
+	if err := handler.ConfigureHubHealth("dd-door", BasicInfo{BaseStation: "bs1", Name: "Home"}); err != nil {
+		t.Fatalf("ConfigureHubHealth() error = %v", err)
+	}
+	for _, id := range []string{"hub_bs1_latency", "hub_bs1_clock_skew"} {
+		if _, ok := client.last(fmt.Sprintf(DiagnosticsConfigTopicTemplate, id)); !ok {
+			t.Errorf("no %s sensor discovery published", id)
+		}
+	}
+	p, ok := client.last(fmt.Sprintf(HubConnectivityConfigTopicTemplate, "bs1"))
+	if !ok {
+		t.Fatalf("no connectivity sensor discovery published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("discovery config is not valid JSON: %v", err)
+	}
+	if config["device_class"] != "connectivity" || config["state_topic"] != "dd-door/bridge/diagnostics" {
+		t.Errorf("connectivity sensor config = %v, want connectivity sensor on the hub diagnostics topic", config)
+	}
+
+	latency, healthy := 42, true
+	rssi := -60
+	diagnostics := HubDiagnostics{WiFiRSSI: &rssi, Latency: &latency, Healthy: &healthy}
+	if err := handler.PublishHubDiagnostics("dd-door", diagnostics); err != nil {
+		t.Fatalf("PublishHubDiagnostics() error = %v", err)
+	}
+	want := `{"wifiRssi":-60,"latency":42,"healthy":true}`
+	if p, _ := client.last("dd-door/bridge/diagnostics"); payloadString(p.Payload) != want {
+		t.Errorf("hub diagnostics = %s, want %s", payloadString(p.Payload), want)
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gravypower/dd"
+)
+
+// ErrNoLight is returned by Door.ToggleLight for a device without a light button.
+var ErrNoLight = errors.New("device has no light")
+
+// ErrNoTargetPosition is returned by Door.CommandAndWait for a command that doesn't leave
+// the door at a known position.
+var ErrNoTargetPosition = errors.New("command has no target position")
+
+// DoorWaitTimeout bounds OpenAndWait and CloseAndWait when ctx has no deadline.
+var DoorWaitTimeout = 2 * time.Minute
+
+// Door controls a single device on a connected hub, hiding the command and RPC
+// plumbing behind SendCommand and SafeFetchStatus.
+type Door struct {
+	conn     *dd.Conn
+	deviceID string
+	options  CommandOptions
+}
+
+// NewDoor returns a Door for deviceID on conn, which must already be connected.
+func NewDoor(conn *dd.Conn, deviceID string) *Door {
+	return &Door{conn: conn, deviceID: deviceID}
+}
+
+// SetCommandOptions sets how the door's commands are verified and retried; see SendCommand.
+func (d *Door) SetCommandOptions(options CommandOptions) {
+	d.options = options
+}
+
+// ID returns the door's device ID.
+func (d *Door) ID() string {
+	return d.deviceID
+}
+
+// Open fully opens the door.
+func (d *Door) Open(ctx context.Context) error {
+	return d.command(ctx, AvailableCommands.Open)
+}
+
+// Close fully closes the door.
+func (d *Door) Close(ctx context.Context) error {
+	return d.command(ctx, AvailableCommands.Close)
+}
+
+// Stop halts the door where it is.
+func (d *Door) Stop(ctx context.Context) error {
+	return d.command(ctx, AvailableCommands.Stop)
+}
+
+// SetPosition moves the door to pct percent open (0-100), to the nearest 5%.
+func (d *Door) SetPosition(ctx context.Context, pct int) error {
+	if pct < PositionClosed || pct > PositionOpen {
+		return fmt.Errorf("invalid position %d", pct)
+	}
+	return d.command(ctx, GetCommandForPosition(pct))
+}
+
+// ToggleLight switches the door's courtesy light, returning ErrNoLight if it has none.
+func (d *Door) ToggleLight(ctx context.Context) error {
+	device, err := d.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	on, ok := device.LightState()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoLight, d.deviceID)
+	}
+	if on {
+		return d.command(ctx, AvailableCommands.LightOff)
+	}
+	return d.command(ctx, AvailableCommands.LightOn)
+}
+
+// Position returns how far open the door is, 0-100.
+func (d *Door) Position(ctx context.Context) (int, error) {
+	device, err := d.fetch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return device.Device.Position, nil
+}
+
+// OpenAndWait opens the door and blocks until it reports fully open, returning the last
+// position seen. It watches the Conn's status messages, so shouldn't be used while
+// something else (such as a dd.Runner) is subscribed to the same Conn.
+func (d *Door) OpenAndWait(ctx context.Context) (int, error) {
+	return d.commandAndWait(ctx, AvailableCommands.Open, PositionOpen)
+}
+
+// CloseAndWait closes the door and blocks until it reports closed; see OpenAndWait.
+func (d *Door) CloseAndWait(ctx context.Context) (int, error) {
+	return d.commandAndWait(ctx, AvailableCommands.Close, PositionClosed)
+}
+
+// CommandAndWait sends command and blocks until the door reaches the position it moves
+// to, as given by PositionForCommand; see OpenAndWait. Commands without one, such as
+// stop, return ErrNoTargetPosition without being sent.
+func (d *Door) CommandAndWait(ctx context.Context, command int) (int, error) {
+	target, ok := PositionForCommand(command)
+	if !ok {
+		return 0, fmt.Errorf("%w: %d", ErrNoTargetPosition, command)
+	}
+	return d.commandAndWait(ctx, command, target)
+}
+
+// commandAndWait sends command and waits for a status putting the door at target. If
+// ctx is done or DoorWaitTimeout passes first, the last position seen is returned along
+// with the context's error.
+func (d *Door) commandAndWait(ctx context.Context, command, target int) (int, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DoorWaitTimeout)
+		defer cancel()
+	}
+
+	position, err := d.Position(ctx)
+	if err != nil || position == target {
+		return position, err
+	}
+
+	// Subscribe before sending so the first status update isn't missed.
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	messages, err := d.conn.Subscribe(subCtx)
+	if err != nil {
+		return position, err
+	}
+	if err := d.command(ctx, command); err != nil {
+		return position, err
+	}
+
+	for m := range messages {
+		var status DoorStatus
+		if err := m.Decode(&status); err != nil {
+			continue
+		}
+		device := status.Get(d.deviceID)
+		if device == nil {
+			continue
+		}
+		position = device.Device.Position
+		if position == target {
+			return position, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return position, err
+	}
+	return position, dd.ErrConnectionLost
+}
+
+func (d *Door) command(ctx context.Context, command int) error {
+	_, err := SendCommand(ctx, d.conn, d.deviceID, command, d.options)
+	return err
+}
+
+// fetch returns the door's current status, or ErrDeviceNotFound.
+func (d *Door) fetch(ctx context.Context) (*DoorStatusDevice, error) {
+	status, err := SafeFetchStatusContext(ctx, d.conn)
+	if err != nil {
+		return nil, err
+	}
+	return status.deviceByID(d.deviceID)
+}
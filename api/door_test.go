@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDoor_Commands(t *testing.T) {
+	server, conn := connectTestServer(t)
+	var got CommandInput
+	server.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		return nil, json.Unmarshal(body, &got)
+	})
+	door := NewDoor(conn, "door1")
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want int
+	}{
+		{"open", func() error { return door.Open(ctx) }, AvailableCommands.Open},
+		{"close", func() error { return door.Close(ctx) }, AvailableCommands.Close},
+		{"stop", func() error { return door.Stop(ctx) }, AvailableCommands.Stop},
+		{"set position", func() error { return door.SetPosition(ctx, 42) }, AvailableCommands.OpenPercent45},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = CommandInput{}
+			if err := tt.call(); err != nil {
+				t.Fatalf("%s error = %v", tt.name, err)
+			}
+			if got.DeviceId != "door1" || got.Action.Command != tt.want {
+				t.Errorf("%s sent %+v, want command %d to door1", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	if err := door.SetPosition(ctx, 101); err == nil {
+		t.Errorf("SetPosition(101) error = nil, want invalid position")
+	}
+}
+
+func TestDoor_ToggleLightAndPosition(t *testing.T) {
+	server, conn := connectTestServer(t)
+	lightOff := DoorStatusButton{Title: "Light off"}
+	lightOff.Action.Command = AvailableCommands.LightOff
+	lit := statusDevice("door1", 30, 0, 0)
+	lit.Buttons = []DoorStatusButton{lightOff}
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{Devices: []DoorStatusDevice{lit, statusDevice("door2", 0, 0, 0)}}, nil
+	})
+	var sent int
+	server.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		var in CommandInput
+		err := json.Unmarshal(body, &in)
+		sent = in.Action.Command
+		return nil, err
+	})
+	ctx := context.Background()
+
+	door := NewDoor(conn, "door1")
+	if pos, err := door.Position(ctx); err != nil || pos != 30 {
+		t.Errorf("Position() = %d, %v, want 30", pos, err)
+	}
+	if err := door.ToggleLight(ctx); err != nil {
+		t.Fatalf("ToggleLight() error = %v", err)
+	}
+	if sent != AvailableCommands.LightOff {
+		t.Errorf("ToggleLight() sent %d, want LightOff (%d)", sent, AvailableCommands.LightOff)
+	}
+
+	if err := NewDoor(conn, "door2").ToggleLight(ctx); !errors.Is(err, ErrNoLight) {
+		t.Errorf("ToggleLight() without light error = %v, want ErrNoLight", err)
+	}
+	if _, err := NewDoor(conn, "door3").Position(ctx); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Position() of unknown door error = %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestDoor_OpenAndWait(t *testing.T) {
+	server, conn := connectTestServer(t)
+	position := 0
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", position, 0, 0)}}, nil
+	})
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		for _, p := range []int{40, 80, 100} {
+			if err := server.Push(DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", p, 0, 0)}}); err != nil {
+				return nil, err
+			}
+		}
+		position = 100
+		return nil, nil
+	})
+	door := NewDoor(conn, "door1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if pos, err := door.OpenAndWait(ctx); err != nil || pos != PositionOpen {
+		t.Fatalf("OpenAndWait() = %d, %v, want %d", pos, err, PositionOpen)
+	}
+	// Already open: returns without sending another command.
+	if pos, err := door.OpenAndWait(ctx); err != nil || pos != PositionOpen {
+		t.Errorf("OpenAndWait() when open = %d, %v, want %d", pos, err, PositionOpen)
+	}
+	if n := server.Requests("/app/res/action"); n != 1 {
+		t.Errorf("sent %d commands, want 1", n)
+	}
+}
+
+func TestDoor_CloseAndWait_Timeout(t *testing.T) {
+	server, conn := connectTestServer(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 100, 0, 0)}}, nil
+	})
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		// The door jams part way.
+		return nil, server.Push(DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 60, 0, 0)}})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pos, err := NewDoor(conn, "door1").CloseAndWait(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseAndWait() error = %v, want context.DeadlineExceeded", err)
+	}
+	if pos != 60 {
+		t.Errorf("CloseAndWait() = %d, want last seen position 60", pos)
+	}
+}
+
+func TestDoor_CommandAndWait(t *testing.T) {
+	server, conn := connectTestServer(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 0, 0, 0)}}, nil
+	})
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		return nil, server.Push(DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 50, 0, 0)}})
+	})
+	door := NewDoor(conn, "door1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if pos, err := door.CommandAndWait(ctx, AvailableCommands.OpenPercent50); err != nil || pos != 50 {
+		t.Fatalf("CommandAndWait(50%%) = %d, %v, want 50", pos, err)
+	}
+	if _, err := door.CommandAndWait(ctx, AvailableCommands.Stop); !errors.Is(err, ErrNoTargetPosition) {
+		t.Errorf("CommandAndWait(stop) error = %v, want ErrNoTargetPosition", err)
+	}
+	if n := server.Requests("/app/res/action"); n != 1 {
+		t.Errorf("sent %d commands, want 1", n)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DryRunTopicTemplate is where each command held back by dry-run mode is published.
+const DryRunTopicTemplate = "%s/%s/dry_run"
+
+// DryRunCommand is a command SendCommand didn't send because dry-run mode is on.
+type DryRunCommand struct {
+	DeviceID string    `json:"deviceId"`
+	Command  int       `json:"command"`
+	Source   string    `json:"source,omitempty"` // set with WithSource, if the caller did
+	Time     time.Time `json:"time"`
+}
+
+// dryRunMode holds the dry-run notify func; a nil pointer means commands are sent.
+var dryRunMode atomic.Pointer[func(DryRunCommand)]
+
+// EnableDryRun stops SendCommand, and everything built on it, sending door commands to the
+// hub: each is logged and passed to notify (if not nil) instead, for testing automations
+// and configs against live hardware. Status is still fetched and followed as usual.
+func EnableDryRun(notify func(DryRunCommand)) {
+	if notify == nil {
+		notify = func(DryRunCommand) {}
+	}
+	dryRunMode.Store(&notify)
+}
+
+// DisableDryRun makes SendCommand send commands again.
+func DisableDryRun() {
+	dryRunMode.Store(nil)
+}
+
+// DryRun reports whether dry-run mode is on.
+func DryRun() bool {
+	return dryRunMode.Load() != nil
+}
+
+// holdCommand reports whether dry-run mode is on, in which case it has logged the command
+// and passed it to the notify func rather than it being sent.
+func holdCommand(ctx context.Context, deviceID string, command int) bool {
+	notify := dryRunMode.Load()
+	if notify == nil {
+		return false
+	}
+	c := DryRunCommand{DeviceID: deviceID, Command: command, Source: sourceFrom(ctx), Time: time.Now()}
+	logger.WithField("deviceID", deviceID).WithField("command", command).Warn("Dry run: not sending command")
+	(*notify)(c)
+	return true
+}
+
+// PublishDryRun publishes a command held back by dry-run mode as JSON. It isn't retained,
+// being a record of something that happened rather than a state.
+func (h *MQTTHandler) PublishDryRun(prefix string, c DryRunCommand) error {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode dry run command: %w", err)
+	}
+	return h.publishToMQTT(fmt.Sprintf(DryRunTopicTemplate, prefix, c.DeviceID), 0, false, payload)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestSendCommand_DryRun(t *testing.T) {
+	server, conn := connectTestServer(t)
+	var held []DryRunCommand
+	EnableDryRun(func(c DryRunCommand) { held = append(held, c) })
+	t.Cleanup(DisableDryRun)
+
+	ctx := WithSource(context.Background(), SourceSchedule)
+	if _, err := SendCommand(ctx, conn, "door1", AvailableCommands.Open, CommandOptions{}); err != nil {
+		t.Fatalf("SendCommand() in dry-run mode error = %v", err)
+	}
+	if n := server.Requests("/app/res/action"); n != 0 {
+		t.Errorf("dry run sent %d commands to the hub, want 0", n)
+	}
+	if len(held) != 1 || held[0].DeviceID != "door1" || held[0].Command != AvailableCommands.Open ||
+		held[0].Source != SourceSchedule || held[0].Time.IsZero() {
+		t.Errorf("held commands = %+v, want door1 open from the schedule", held)
+	}
+
+	DisableDryRun()
+	if DryRun() {
+		t.Error("DryRun() after DisableDryRun() = true")
+	}
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) { return CommandOutput{}, nil })
+	if err := SafeCommand(conn, "door1", AvailableCommands.Open); err != nil {
+		t.Fatalf("SafeCommand() error = %v", err)
+	}
+	if n := server.Requests("/app/res/action"); n != 1 || len(held) != 1 {
+		t.Errorf("after DisableDryRun sent %d commands and held %d, want 1 sent", n, len(held))
+	}
+}
+
+func TestPublishDryRun(t *testing.T) {
+	handler, client := newTestHandler()
+
+	c := DryRunCommand{DeviceID: "door1", Command: AvailableCommands.Close, Source: SourceMQTT}
+	if err := handler.PublishDryRun("dd-door", c); err != nil {
+		t.Fatalf("PublishDryRun() error = %v", err)
+	}
+	p, ok := client.last(fmt.Sprintf(DryRunTopicTemplate, "dd-door", "door1"))
+	if !ok {
+		t.Fatal("nothing published to the dry run topic")
+	}
+	if p.Retained {
+		t.Error("dry run command was retained")
+	}
+	var got DryRunCommand
+	if err := json.Unmarshal(p.Payload.([]byte), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.DeviceID != "door1" || got.Command != AvailableCommands.Close || got.Source != SourceMQTT {
+		t.Errorf("published %+v, want %+v", got, c)
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/gravypower/dd"
+)
+
+// EventKind identifies the type of an Event.
+type EventKind int
+
+const (
+	// EventPositionChanged is a PositionChanged event
+	EventPositionChanged EventKind = iota
+	// EventButtonPressed is a ButtonPressed event
+	EventButtonPressed
+	// EventLogEntry is a LogEntry event
+	EventLogEntry
+	// EventUsersChanged is a UsersChanged event
+	EventUsersChanged
+	// EventConnectivity is a Connectivity event
+	EventConnectivity
+	// EventObstruction is an Obstruction event
+	EventObstruction
+)
+
+// String returns the kind's name, e.g. "position_changed".
+func (k EventKind) String() string {
+	switch k {
+	case EventPositionChanged:
+		return "position_changed"
+	case EventButtonPressed:
+		return "button_pressed"
+	case EventLogEntry:
+		return "log_entry"
+	case EventUsersChanged:
+		return "users_changed"
+	case EventConnectivity:
+		return "connectivity"
+	case EventObstruction:
+		return "obstruction"
+	}
+	return "unknown"
+}
+
+// Event is something that happened on the hub, derived from its status messages.
+// Switch on the concrete type, or on Kind.
+type Event interface {
+	Kind() EventKind
+}
+
+// PositionChanged reports a door's new position. The first status seen for a device
+// produces one with Initial set and Previous equal to Position.
+type PositionChanged struct {
+	DeviceID string
+	Position int
+	Previous int
+	Initial  bool
+}
+
+// ButtonPressed reports that a device's button was used, recognised by a new log entry
+// matching the button's command.
+type ButtonPressed struct {
+	DeviceID string
+	Button   DoorStatusButton
+}
+
+// LogEntry reports a new entry in a device's event log.
+type LogEntry struct {
+	DeviceID string
+	Entry    DoorStatusLog
+}
+
+// UsersChanged reports the hub's user list when it differs from the last one seen.
+// Only admin credentials receive users.
+type UsersChanged struct {
+	Users []DoorStatusUsers
+}
+
+// Connectivity reports that the hub started or stopped reporting the base station online.
+type Connectivity struct {
+	Online bool
+}
+
+func (PositionChanged) Kind() EventKind { return EventPositionChanged }
+func (ButtonPressed) Kind() EventKind   { return EventButtonPressed }
+func (LogEntry) Kind() EventKind        { return EventLogEntry }
+func (UsersChanged) Kind() EventKind    { return EventUsersChanged }
+func (Connectivity) Kind() EventKind    { return EventConnectivity }
+
+// ErrEventStreamStarted is returned when Run is called on an EventStream that has already been started.
+var ErrEventStreamStarted = errors.New("event stream already started")
+
+// EventStream classifies a connected Conn's status messages into Events. It may only be Run once.
+type EventStream struct {
+	conn    *dd.Conn
+	events  chan Event
+	tracker eventTracker
+
+	mu      sync.Mutex
+	started bool
+}
+
+// NewEventStream creates an EventStream for an already connected Conn.
+func NewEventStream(conn *dd.Conn) *EventStream {
+	return &EventStream{
+		conn:   conn,
+		events: make(chan Event),
+	}
+}
+
+// Events returns the channel events are delivered on. It is closed when Run returns.
+func (s *EventStream) Events() <-chan Event {
+	return s.events
+}
+
+// Run fetches the current status, then delivers events for it and every status update
+// until ctx is done or the connection is lost, returning the reason as dd.Runner does.
+func (s *EventStream) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return ErrEventStreamStarted
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	defer close(s.events)
+
+	s.tracker.online = s.conn.IsBasestationOnline()
+	if status, err := SafeFetchStatus(s.conn); err == nil {
+		if !s.send(ctx, s.tracker.diff(*status, s.conn.IsBasestationOnline())) {
+			return ctx.Err()
+		}
+	}
+
+	runner := dd.NewRunner(s.conn, 0)
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	for m := range runner.Status() {
+		var status DoorStatus
+		if err := m.Decode(&status); err != nil {
+			logger.WithError(err).Debug("Ignoring message that is not a door status")
+			continue
+		}
+		s.send(ctx, s.tracker.diff(status, s.conn.IsBasestationOnline()))
+	}
+	return <-done
+}
+
+// send delivers events, returning false if ctx is done first.
+func (s *EventStream) send(ctx context.Context, events []Event) bool {
+	for _, e := range events {
+		select {
+		case s.events <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// eventTracker remembers the last status seen so that each new one can be turned into events.
+type eventTracker struct {
+	devices      map[string]DoorStatusDevice
+	users        []DoorStatusUsers
+	online       bool
+	obstructions ObstructionDetector
+}
+
+// diff returns the events between the last status seen and status, and remembers status.
+func (t *eventTracker) diff(status DoorStatus, online bool) []Event {
+	if t.devices == nil {
+		t.devices = make(map[string]DoorStatusDevice)
+	}
+
+	var events []Event
+	if online != t.online {
+		t.online = online
+		events = append(events, Connectivity{Online: online})
+	}
+
+	for _, device := range status.Devices {
+		prev, seen := t.devices[device.ID]
+		t.devices[device.ID] = device
+		obstruction := t.obstructions.Observe(device)
+
+		if !seen {
+			events = append(events, PositionChanged{
+				DeviceID: device.ID,
+				Position: device.Device.Position,
+				Previous: device.Device.Position,
+				Initial:  true,
+			})
+			continue
+		}
+		if device.Device.Position != prev.Device.Position {
+			events = append(events, PositionChanged{
+				DeviceID: device.ID,
+				Position: device.Device.Position,
+				Previous: prev.Device.Position,
+			})
+		}
+		if device.Log.ID != prev.Log.ID && device.Log.ID != 0 {
+			events = append(events, LogEntry{DeviceID: device.ID, Entry: device.Log})
+		}
+		if button, ok := PressedButton(prev, device); ok {
+			events = append(events, ButtonPressed{DeviceID: device.ID, Button: *button})
+		}
+		if obstruction != nil {
+			events = append(events, *obstruction)
+		}
+	}
+
+	// Only admin payloads carry users; a payload without them says nothing about users
+	if status.Users != nil && !slices.Equal(status.Users, t.users) {
+		t.users = status.Users
+		events = append(events, UsersChanged{Users: status.Users})
+	}
+	return events
+}
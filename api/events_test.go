@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// statusDevice returns a device at position with the given latest log entry.
+func statusDevice(id string, position int, logID int64, alert int) DoorStatusDevice {
+	d := DoorStatusDevice{ID: id}
+	d.Device.Position = position
+	d.Log.ID = logID
+	d.Log.Alert = alert
+	return d
+}
+
+func TestEventTracker_Diff(t *testing.T) {
+	open := DoorStatusButton{Title: "Open"}
+	open.Action.Command = AvailableCommands.Open
+
+	first := statusDevice("door1", 0, 1, 0)
+	first.Buttons = []DoorStatusButton{open}
+	moved := statusDevice("door1", 40, 2, AvailableCommands.Open)
+	moved.Buttons = []DoorStatusButton{open}
+	users := []DoorStatusUsers{{Enabled: true, Username: "alice"}}
+
+	tests := []struct {
+		name   string
+		status DoorStatus
+		online bool
+		want   []Event
+	}{
+		{
+			name:   "first sighting",
+			status: DoorStatus{Devices: []DoorStatusDevice{first}},
+			online: true,
+			want: []Event{
+				Connectivity{Online: true},
+				PositionChanged{DeviceID: "door1", Position: 0, Previous: 0, Initial: true},
+			},
+		},
+		{
+			name:   "unchanged",
+			status: DoorStatus{Devices: []DoorStatusDevice{first}},
+			online: true,
+		},
+		{
+			name:   "button opens door",
+			status: DoorStatus{Devices: []DoorStatusDevice{moved}},
+			online: true,
+			want: []Event{
+				PositionChanged{DeviceID: "door1", Position: 40, Previous: 0},
+				LogEntry{DeviceID: "door1", Entry: moved.Log},
+				ButtonPressed{DeviceID: "door1", Button: open},
+			},
+		},
+		{
+			name:   "door closes partway",
+			status: DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 20, 2, AvailableCommands.Open)}},
+			online: true,
+			want:   []Event{PositionChanged{DeviceID: "door1", Position: 20, Previous: 40}},
+		},
+		{
+			name:   "door reverses",
+			status: DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 30, 2, AvailableCommands.Open)}},
+			online: true,
+			want: []Event{
+				PositionChanged{DeviceID: "door1", Position: 30, Previous: 20},
+				Obstruction{DeviceID: "door1", Reason: ObstructionReversed, Position: 30},
+			},
+		},
+		{
+			name:   "users appear",
+			status: DoorStatus{Users: users},
+			online: true,
+			want:   []Event{UsersChanged{Users: users}},
+		},
+		{
+			name:   "base station goes offline",
+			status: DoorStatus{Users: users},
+			online: false,
+			want:   []Event{Connectivity{Online: false}},
+		},
+	}
+
+	var tracker eventTracker
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tracker.diff(tt.status, tt.online)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventStream_Run(t *testing.T) {
+	server, conn := connectTestServer(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 0, 0, 0)}}, nil
+	})
+
+	if err := server.Push(DoorStatus{Devices: []DoorStatusDevice{statusDevice("door1", 100, 0, 0)}}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	stream := NewEventStream(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- stream.Run(ctx) }()
+
+	want := []Event{
+		PositionChanged{DeviceID: "door1", Position: 0, Previous: 0, Initial: true},
+		PositionChanged{DeviceID: "door1", Position: 100, Previous: 0},
+	}
+	for _, w := range want {
+		select {
+		case got := <-stream.Events():
+			if !reflect.DeepEqual(got, w) {
+				t.Errorf("event = %+v, want %+v", got, w)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+
+	if err := stream.Run(ctx); !errors.Is(err, ErrEventStreamStarted) {
+		t.Errorf("second Run() error = %v, want ErrEventStreamStarted", err)
+	}
+
+	cancel()
+	for range stream.Events() {
+		// drain until closed
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestEventKind_String(t *testing.T) {
+	if got := (ButtonPressed{}).Kind().String(); got != "button_pressed" {
+		t.Errorf("ButtonPressed kind = %q, want %q", got, "button_pressed")
+	}
+	if got := EventKind(99).String(); got != "unknown" {
+		t.Errorf("EventKind(99) = %q, want %q", got, "unknown")
+	}
+}
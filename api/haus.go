@@ -3,14 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/looplab/fsm"
 	"github.com/gravypower/dd"
+	"github.com/looplab/fsm"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,7 +22,9 @@ const (
 	PositionTopicTemplate                          = "%s/%s/position"
 	SetPositionTopicTemplate                       = "%s/%s/set_position"
 	AvailabilityTopicTemplate                      = "%s/%s/availability"
+	BridgeStatusTopicTemplate                      = "%s/bridge/status"
 	HomeAssistantConfigTopicTemplate               = "homeassistant/cover/%s/config"
+	HomeAssistantStatusTopic                       = "homeassistant/status"
 	publishTimeout                   time.Duration = 10 * time.Second
 )
 
@@ -31,10 +35,12 @@ const (
 )
 
 var (
-	DeviceFSMs = make(map[string]*DeviceFSM)
-	// deviceFSMsMutex protects concurrent access to DeviceFSMs map
-	deviceFSMsMutex sync.RWMutex
-	logger          = logrus.New()
+	// configRetryBaseDelay is the delay before the first config publish retry, doubled for each after
+	configRetryBaseDelay = 5 * time.Second
+	// configRetryMaxDelay caps the delay between config publish retries
+	configRetryMaxDelay = time.Minute
+
+	logger = logrus.New()
 )
 
 func init() {
@@ -44,40 +50,74 @@ func init() {
 		ForceColors:   true,
 	})
 	logger.SetLevel(logrus.InfoLevel)
+	logger.AddHook(dd.RedactHook{})
 }
 
-// GetDeviceFSM safely retrieves a device FSM by ID
-func GetDeviceFSM(deviceID string) (*DeviceFSM, bool) {
-	deviceFSMsMutex.RLock()
-	defer deviceFSMsMutex.RUnlock()
-	fsm, exists := DeviceFSMs[deviceID]
-	return fsm, exists
+// SetLogger replaces the package logger used by package-level functions and by
+// handlers without their own Logger.
+func SetLogger(l *logrus.Logger) {
+	if l != nil {
+		logger = l
+	}
 }
 
-// SetDeviceFSM safely sets a device FSM
-func SetDeviceFSM(deviceID string, fsm *DeviceFSM) {
-	deviceFSMsMutex.Lock()
-	defer deviceFSMsMutex.Unlock()
-	DeviceFSMs[deviceID] = fsm
+// PublishOptions is the QoS level and retain flag a class of topics is published with.
+type PublishOptions struct {
+	QoS    byte
+	Retain bool
 }
 
-// GetAllDeviceFSMs safely returns all device FSMs (used for shutdown)
-func GetAllDeviceFSMs() map[string]*DeviceFSM {
-	deviceFSMsMutex.RLock()
-	defer deviceFSMsMutex.RUnlock()
-	// Return a copy to avoid holding the lock
-	devices := make(map[string]*DeviceFSM, len(DeviceFSMs))
-	for k, v := range DeviceFSMs {
-		devices[k] = v
+// MQTTOptions sets how each class of device topic is published. Brokers that drop QoS 0
+// messages under load, or Home Assistant setups that restart often, may want QoS 1 and
+// retained state.
+type MQTTOptions struct {
+	State        PublishOptions // open, closed, opening, ...
+	Position     PublishOptions // 0-100
+	Availability PublishOptions // online or offline
+}
+
+// DefaultMQTTOptions returns the options NewMQTTHandler starts with. State isn't retained,
+// since a replayed "opening" would outlive the motion; position is an absolute reading
+// that's always valid as the last known value, so it's retained for new subscribers.
+func DefaultMQTTOptions() MQTTOptions {
+	return MQTTOptions{
+		State:        PublishOptions{QoS: 0, Retain: false},
+		Position:     PublishOptions{QoS: 1, Retain: true},
+		Availability: PublishOptions{QoS: 0, Retain: true},
 	}
-	return devices
+}
+
+// ErrInvalidQoS is returned by MQTTOptions.Validate for a QoS level other than 0, 1 or 2.
+var ErrInvalidQoS = errors.New("invalid MQTT QoS level")
+
+// Validate checks every QoS level is one MQTT supports.
+func (o MQTTOptions) Validate() error {
+	classes := []struct {
+		name    string
+		options PublishOptions
+	}{
+		{"state", o.State},
+		{"position", o.Position},
+		{"availability", o.Availability},
+	}
+	for _, c := range classes {
+		if c.options.QoS > 2 {
+			return fmt.Errorf("%w for %s: %d", ErrInvalidQoS, c.name, c.options.QoS)
+		}
+	}
+	return nil
 }
 
 // MQTTHandler centralizes MQTT operations
 type MQTTHandler struct {
 	Client mqtt.Client
 	Mutex  sync.Mutex
-	Logger *logrus.Logger
+	Logger *logrus.Logger // optional, defaults to the package logger
+
+	// Options sets the QoS and retain flag of state, position and availability publishes.
+	Options MQTTOptions
+
+	bridgeStatusTopic string // last topic written by PublishBridgeStatus, cleared on Close
 }
 
 // DeviceFSM encapsulates a state machine for a device
@@ -89,13 +129,57 @@ type DeviceFSM struct {
 	mqttHandler *MQTTHandler
 	State       string
 	mu          sync.Mutex
+
+	// StopTimeout is how long the device may sit in "stopping" or "stopped" without a
+	// position update before the status is fetched once to resolve it to open, closed or
+	// partially_open. Zero disables the timeout.
+	StopTimeout time.Duration
+	stopTimer   *time.Timer
+
+	fetchStatus func() (*DoorStatus, error) // defaults to SafeFetchStatus on Conn
+
+	// EstimateInterval is how often an estimated position is published while the door
+	// moves, once its travel time has been learned. Zero disables estimates.
+	EstimateInterval time.Duration
+	travel           travelTracker
+
+	queue *CommandQueue
+
+	// AutoCloseWarning is how long before closing an open door automatically a warning
+	// is published. Zero closes without warning. The delay itself is set by SetAutoClose.
+	AutoCloseWarning time.Duration
+	autoClose        time.Duration
+	autoCloseTimer   *time.Timer
+	autoCloseGen     int // bumped to cancel timers that have already fired
+
+	// States, if set, saves the device's resting state and position so they can be
+	// published on the next startup.
+	States *StateStore
+
+	// HistorySize is how many transitions History keeps. Zero uses DefaultHistorySize.
+	HistorySize int
+	history     []Transition
+	// OnTransition, if set, is called with each transition as it's recorded, e.g. for an
+	// audit log.
+	OnTransition func(Transition)
+
+	stats       DeviceStats
+	statsKnown  bool // whether the door's state has been seen, so changes from it count
+	statsLoaded bool // whether stats have been restored from States
+}
+
+// Queue returns the device's command queue.
+func (d *DeviceFSM) Queue() *CommandQueue {
+	return d.queue
 }
 
 // Trigger triggers an event on the device FSM.
 // Note: Do not hold d.mu while invoking FSM.Event, as callbacks (e.g., enter_state)
 // also acquire d.mu and would deadlock. The FSM itself handles its internal concurrency.
 func (d *DeviceFSM) Trigger(ctx context.Context, event string) error {
-	return d.FSM.Event(ctx, event)
+	err := traceDoorEvent(ctx, d, event)
+	recordDoorEvent(ctx, d.ID, event, err)
+	return err
 }
 
 // Current returns the current state in a thread-safe way
@@ -108,14 +192,105 @@ func (d *DeviceFSM) Current() string {
 	return d.FSM.Current()
 }
 
+// armStopTimeout starts the StopTimeout timer, replacing any pending one.
+func (d *DeviceFSM) armStopTimeout() {
+	if d.StopTimeout <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopTimer != nil {
+		d.stopTimer.Stop()
+	}
+	d.stopTimer = time.AfterFunc(d.StopTimeout, d.resolveStopped)
+}
+
+// disarmStopTimeout cancels a pending StopTimeout timer.
+func (d *DeviceFSM) disarmStopTimeout() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopTimer != nil {
+		d.stopTimer.Stop()
+		d.stopTimer = nil
+	}
+}
+
+// CompleteStop moves a device in "stopping" to "stopped", for a status update reporting
+// where the door came to rest after a stop command. It does nothing in any other state.
+func (d *DeviceFSM) CompleteStop(ctx context.Context) error {
+	if d.Current() != "stopping" {
+		return nil
+	}
+	return d.Trigger(ctx, "go_stopped")
+}
+
+// resolveStopped fetches the status once and moves a device still in "stopping" or
+// "stopped" to open, closed or partially_open based on the reported position.
+func (d *DeviceFSM) resolveStopped() {
+	ctx := WithSource(context.Background(), SourceTimer)
+	if d.Current() == "stopping" {
+		d.mqttHandler.log().WithField("deviceID", d.ID).Info("No status update after stop command; assuming the door stopped")
+		if err := d.Trigger(ctx, "go_stopped"); err != nil {
+			d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to complete stop")
+			return
+		}
+	}
+	if d.Current() != "stopped" {
+		return
+	}
+
+	d.mqttHandler.log().WithField("deviceID", d.ID).Info("No position update after stop; fetching status")
+	status, err := d.fetchStatus()
+	if err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to fetch status after stop timeout")
+		return
+	}
+	device := status.Get(d.ID)
+	if device == nil {
+		d.mqttHandler.log().WithField("deviceID", d.ID).Warn("Device missing from status after stop timeout")
+		return
+	}
+	d.ObservePosition(device.Device.Position)
+
+	var event string
+	switch device.Device.Position {
+	case PositionOpen:
+		event = "go_opened"
+	case PositionClosed:
+		event = "go_closed"
+	default:
+		d.mqttHandler.log().WithFields(logrus.Fields{
+			"deviceID": d.ID,
+			"position": device.Device.Position,
+		}).Debug("Device stopped at intermediate position")
+		event = "go_partially_opened"
+		if err := d.mqttHandler.PublishPosition(d.MQTTPrefix, d.ID, device.Device.Position); err != nil {
+			d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Error publishing stopped position")
+		}
+	}
+
+	if err := d.Trigger(ctx, event); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to resolve stopped state")
+	}
+}
+
 // NewMQTTHandler creates a new MQTTHandler instance
 func NewMQTTHandler(client mqtt.Client, logger *logrus.Logger) *MQTTHandler {
 	return &MQTTHandler{
-		Client: client,
-		Logger: logger,
+		Client:  client,
+		Logger:  logger,
+		Options: DefaultMQTTOptions(),
 	}
 }
 
+// log returns the handler's logger, falling back to the package logger.
+func (h *MQTTHandler) log() *logrus.Logger {
+	if h == nil || h.Logger == nil {
+		return logger
+	}
+	return h.Logger
+}
+
 // publishToMQTT is a helper method to centralize MQTT publish logic
 func (h *MQTTHandler) publishToMQTT(topic string, qos byte, retained bool, payload interface{}) error {
 	h.Mutex.Lock()
@@ -123,55 +298,104 @@ func (h *MQTTHandler) publishToMQTT(topic string, qos byte, retained bool, paylo
 
 	if !h.Client.IsConnected() {
 		err := fmt.Errorf("mqtt not connected; cannot publish to %s", topic)
-		h.Logger.WithFields(logrus.Fields{
+		h.log().WithFields(logrus.Fields{
 			"topic":   topic,
 			"payload": payload,
 			"error":   err,
 		}).Error("Publish skipped: not connected")
+		recordPublishFailure(context.Background(), "not_connected")
 		return err
 	}
 
 	tok := h.Client.Publish(topic, qos, retained, payload)
 	if ok := tok.WaitTimeout(publishTimeout); !ok {
 		err := fmt.Errorf("mqtt publish to %s timed out after %s", topic, publishTimeout)
-		h.Logger.WithFields(logrus.Fields{
+		h.log().WithFields(logrus.Fields{
 			"topic":   topic,
 			"payload": payload,
 			"error":   err,
 		}).Error("Publish timed out")
+		recordPublishFailure(context.Background(), "timeout")
 		return err
 	}
 	if err := tok.Error(); err != nil {
-		h.Logger.WithFields(logrus.Fields{
+		h.log().WithFields(logrus.Fields{
 			"topic":   topic,
 			"payload": payload,
 			"error":   err,
 		}).Error("Failed to publish")
+		recordPublishFailure(context.Background(), "error")
 		return err
 	}
-	h.Logger.WithFields(logrus.Fields{
+	h.log().WithFields(logrus.Fields{
 		"topic":   topic,
 		"payload": payload,
 	}).Debug("Message published successfully")
 	return nil
 }
 
+// publishWith publishes payload to topic with the given options.
+func (h *MQTTHandler) publishWith(topic string, options PublishOptions, payload interface{}) error {
+	return h.publishToMQTT(topic, options.QoS, options.Retain, payload)
+}
+
 // PublishStatus publishes a device's status to the appropriate topic
 func (h *MQTTHandler) PublishStatus(prefix, deviceID, status string) error {
 	topic := fmt.Sprintf(StateTopicTemplate, prefix, deviceID)
-	return h.publishToMQTT(topic, 0, false, status)
+	return h.publishWith(topic, h.Options.State, status)
 }
 
 // PublishAvailability publishes a device's availability to the appropriate topic
 func (h *MQTTHandler) PublishAvailability(prefix, deviceID, availability string) error {
 	topic := fmt.Sprintf(AvailabilityTopicTemplate, prefix, deviceID)
-	return h.publishToMQTT(topic, 0, true, availability)
+	return h.publishWith(topic, h.Options.Availability, availability)
 }
 
 // PublishPosition publishes a device's current position (0-100) to the appropriate topic
 func (h *MQTTHandler) PublishPosition(prefix, deviceID string, position int) error {
 	topic := fmt.Sprintf(PositionTopicTemplate, prefix, deviceID)
-	return h.publishToMQTT(topic, 0, false, fmt.Sprintf("%d", position))
+	return h.publishWith(topic, h.Options.Position, fmt.Sprintf("%d", position))
+}
+
+// PublishRetainedPosition publishes a device's position with QoS 1 and the retain
+// flag set, whatever the handler's Options.
+//
+// Deprecated: use PublishPosition, which retains by default.
+func (h *MQTTHandler) PublishRetainedPosition(prefix, deviceID string, position int) error {
+	topic := fmt.Sprintf(PositionTopicTemplate, prefix, deviceID)
+	return h.publishToMQTT(topic, 1, true, fmt.Sprintf("%d", position))
+}
+
+// PublishBridgeStatus publishes the full DoorStatus as retained JSON, so new
+// subscribers get the current state without waiting for the next poll.
+func (h *MQTTHandler) PublishBridgeStatus(prefix string, status DoorStatus) error {
+	topic := fmt.Sprintf(BridgeStatusTopicTemplate, prefix)
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("encode bridge status: %w", err)
+	}
+	if err := h.publishToMQTT(topic, 0, true, payload); err != nil {
+		return err
+	}
+	h.Mutex.Lock()
+	h.bridgeStatusTopic = topic
+	h.Mutex.Unlock()
+	return nil
+}
+
+// Close clears the retained bridge status (if any) and disconnects the client.
+func (h *MQTTHandler) Close() {
+	h.Mutex.Lock()
+	topic := h.bridgeStatusTopic
+	h.bridgeStatusTopic = ""
+	h.Mutex.Unlock()
+
+	if topic != "" {
+		if err := h.publishToMQTT(topic, 0, true, ""); err != nil {
+			h.log().WithError(err).Warn("Failed to clear bridge status")
+		}
+	}
+	h.Client.Disconnect(250)
 }
 
 // RemoveEntity removes the Home Assistant entity for the device
@@ -179,26 +403,80 @@ func (h *MQTTHandler) RemoveEntity(deviceID string) error {
 	discoveryTopic := fmt.Sprintf(HomeAssistantConfigTopicTemplate, deviceID)
 	err := h.publishToMQTT(discoveryTopic, 0, true, "")
 	if err != nil {
-		h.Logger.WithFields(logrus.Fields{
+		h.log().WithFields(logrus.Fields{
 			"deviceID": deviceID,
 			"error":    err,
 		}).Error("Failed to remove entity for device")
 		return err
 	}
-	h.Logger.WithField("deviceID", deviceID).Info("Removed entity for device")
+	h.log().WithField("deviceID", deviceID).Info("Removed entity for device")
 	return nil
 }
 
-// ConfigureDevice publishes the Home Assistant MQTT cover configuration
-func ConfigureDevice(handler *MQTTHandler, conn *dd.Conn, mqttPrefix string, device DoorStatusDevice, basicInfo BasicInfo) *DeviceFSM {
-	configTopic := fmt.Sprintf(HomeAssistantConfigTopicTemplate, device.ID)
+// Defaults for DeviceOptions, in seconds
+const (
+	DefaultExpireAfter  = 60
+	DefaultScanInterval = 10
+)
+
+// DefaultDeviceClass is the Home Assistant cover device class used unless overridden.
+const DefaultDeviceClass = "garage"
+
+// ErrInvalidDeviceClass is returned by DeviceOptions.Validate for a device class Home
+// Assistant covers don't support.
+var ErrInvalidDeviceClass = errors.New("invalid cover device class")
+
+// coverDeviceClasses are the device classes Home Assistant accepts for a cover.
+var coverDeviceClasses = []string{
+	"awning", "blind", "curtain", "damper", "door", "garage", "gate", "shade", "shutter", "window",
+}
+
+// DeviceOptions customizes the Home Assistant discovery config for a device.
+// Zero values use the defaults.
+type DeviceOptions struct {
+	ExpireAfter  int // seconds before HA marks the state stale
+	ScanInterval int // seconds between HA polls
+
+	Name        string // entity name, defaults to the device's name on the hub
+	ObjectID    string // HA object_id, used for the entity ID; HA derives one from the name if unset
+	DeviceClass string // HA cover device class, e.g. gate or shutter; defaults to garage
+	Icon        string // e.g. mdi:gate; defaults to mdi:garage for garages and HA's class icon otherwise
+}
+
+// Validate checks DeviceClass, if set, is one Home Assistant covers support.
+func (o DeviceOptions) Validate() error {
+	if o.DeviceClass != "" && !slices.Contains(coverDeviceClasses, o.DeviceClass) {
+		return fmt.Errorf("%w: %q", ErrInvalidDeviceClass, o.DeviceClass)
+	}
+	return nil
+}
+
+// deviceConfig returns the Home Assistant MQTT cover configuration for a device.
+func deviceConfig(mqttPrefix string, device DoorStatusDevice, basicInfo BasicInfo, options DeviceOptions) ([]byte, error) {
+	if options.ExpireAfter == 0 {
+		options.ExpireAfter = DefaultExpireAfter
+	}
+	if options.ScanInterval == 0 {
+		options.ScanInterval = DefaultScanInterval
+	}
+	if options.Name == "" {
+		options.Name = device.Name
+	}
+	if options.DeviceClass == "" {
+		options.DeviceClass = DefaultDeviceClass
+	}
+	if options.Icon == "" && options.DeviceClass == DefaultDeviceClass {
+		options.Icon = "mdi:garage"
+	}
+
 	configPayload := map[string]interface{}{
-		"name":                  device.Name,
+		"name":                  options.Name,
 		"command_topic":         fmt.Sprintf(CommandTopicTemplate, mqttPrefix, device.ID),
 		"state_topic":           fmt.Sprintf(StateTopicTemplate, mqttPrefix, device.ID),
 		"position_topic":        fmt.Sprintf(PositionTopicTemplate, mqttPrefix, device.ID),
 		"set_position_topic":    fmt.Sprintf(SetPositionTopicTemplate, mqttPrefix, device.ID),
 		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"json_attributes_topic": fmt.Sprintf(AttributesTopicTemplate, mqttPrefix, device.ID),
 		"availability_mode":     "latest",
 		"payload_open":          "go_open",
 		"payload_close":         "go_close",
@@ -213,45 +491,110 @@ func ConfigureDevice(handler *MQTTHandler, conn *dd.Conn, mqttPrefix string, dev
 		"position_closed":       0,
 		"optimistic":            false,
 		"retain":                false,
-		"device_class":          "garage",
-		"expire_after":          60,
+		"device_class":          options.DeviceClass,
+		"expire_after":          options.ExpireAfter,
 		"unique_id":             fmt.Sprintf("cover_%s", device.ID),
-		"scan_interval":         10,
+		"scan_interval":         options.ScanInterval,
 		"device": map[string]interface{}{
 			"identifiers":  []string{fmt.Sprintf("garage_door_%s", device.ID)},
 			"name":         basicInfo.Name,
 			"manufacturer": "dd",
 		},
-		"icon": "mdi:garage",
 	}
+	if options.Icon != "" {
+		configPayload["icon"] = options.Icon
+	}
+	if options.ObjectID != "" {
+		configPayload["object_id"] = options.ObjectID
+	}
+	return json.Marshal(configPayload)
+}
+
+// PublishDeviceConfig publishes a device's Home Assistant MQTT cover configuration, as
+// ConfigureDevice does, without creating its FSM.
+func (h *MQTTHandler) PublishDeviceConfig(mqttPrefix string, device DoorStatusDevice, basicInfo BasicInfo, options DeviceOptions) error {
+	bytes, err := deviceConfig(mqttPrefix, device, basicInfo, options)
+	if err != nil {
+		return fmt.Errorf("encode config payload: %w", err)
+	}
+	return h.publishToMQTT(fmt.Sprintf(HomeAssistantConfigTopicTemplate, device.ID), 0, true, bytes)
+}
 
-	bytes, err := json.Marshal(configPayload)
+// ConfigureDevice publishes the Home Assistant MQTT cover configuration and registers the
+// device's FSM in the default registry.
+//
+// Deprecated: Use DeviceRegistry.ConfigureDevice.
+func ConfigureDevice(handler *MQTTHandler, conn *dd.Conn, mqttPrefix string, device DoorStatusDevice, basicInfo BasicInfo, options DeviceOptions) *DeviceFSM {
+	return defaultRegistry.ConfigureDevice(handler, conn, mqttPrefix, device, basicInfo, options)
+}
+
+// ConfigureDevice publishes the Home Assistant MQTT cover configuration and registers the
+// device's FSM in r.
+func (r *DeviceRegistry) ConfigureDevice(handler *MQTTHandler, conn *dd.Conn, mqttPrefix string, device DoorStatusDevice, basicInfo BasicInfo, options DeviceOptions) *DeviceFSM {
+	configTopic := fmt.Sprintf(HomeAssistantConfigTopicTemplate, device.ID)
+	bytes, err := deviceConfig(mqttPrefix, device, basicInfo, options)
 	if err != nil {
-		logger.WithField("err", err).Error("Couldn't encode config payload")
+		handler.log().WithField("err", err).Error("Couldn't encode config payload")
 		return nil
 	}
 
 	if err := handler.publishToMQTT(configTopic, 0, true, bytes); err != nil {
-		logger.WithField("err", err).Error("Couldn't publish config; will retry in background")
+		handler.log().WithField("err", err).Error("Couldn't publish config; will retry in background")
 		// Retry in background without killing the process, as broker/network may be slow on startup
+		// Jittered, so devices that failed together don't retry in lockstep
 		go func() {
+			backoff := dd.Backoff{Base: configRetryBaseDelay, Max: configRetryMaxDelay, Jitter: dd.DefaultBackoffJitter}
 			for attempt := 1; attempt <= 5; attempt++ {
-				delay := time.Duration(attempt) * 5 * time.Second
-				time.Sleep(delay)
+				time.Sleep(backoff.Next())
 				if err := handler.publishToMQTT(configTopic, 0, true, bytes); err == nil {
-					logger.WithFields(logrus.Fields{"attempt": attempt}).Info("Published config successfully after retry")
+					handler.log().WithFields(logrus.Fields{"attempt": attempt}).Info("Published config successfully after retry")
 					return
 				}
-				logger.WithFields(logrus.Fields{"attempt": attempt}).Warn("Retry to publish config failed; will retry again if attempts remain")
+				handler.log().WithFields(logrus.Fields{"attempt": attempt}).Warn("Retry to publish config failed; will retry again if attempts remain")
 			}
 		}()
 	}
 
 	deviceFSM := NewDeviceFSM(device.ID, mqttPrefix, conn, handler)
-	SetDeviceFSM(device.ID, deviceFSM)
+	r.Set(device.ID, deviceFSM)
 	return deviceFSM
 }
 
+// publishedStates maps FSM states to the state topic payload published on entering them.
+var publishedStates = map[string]string{
+	"opening":        "opening",
+	"closing":        "closing",
+	"stopping":       "stopping",
+	"stopped":        "open", // at rest part way; HA shows it with the position
+	"open":           "open",
+	"closed":         "closed",
+	"partially_open": "open",
+}
+
+// Republish publishes the device's availability, auto-close delay and current state again, e.g. for a Home
+// Assistant that restarted and lost them. A device that was never brought online has
+// nothing to publish.
+func (d *DeviceFSM) Republish() error {
+	state := d.Current()
+	if state == "initial" {
+		return nil
+	}
+	availability := "online"
+	if state == "offline" {
+		availability = "offline"
+	}
+	if err := d.mqttHandler.PublishAvailability(d.MQTTPrefix, d.ID, availability); err != nil {
+		return err
+	}
+	if err := d.mqttHandler.PublishAutoClose(d.MQTTPrefix, d.ID, d.AutoClose()); err != nil {
+		return err
+	}
+	if status, ok := publishedStates[state]; ok {
+		return d.mqttHandler.PublishStatus(d.MQTTPrefix, d.ID, status)
+	}
+	return nil
+}
+
 // NewDeviceFSM initializes the FSM for a specific device
 func NewDeviceFSM(deviceID string, mqttPrefix string, conn *dd.Conn, mqttHandler *MQTTHandler) *DeviceFSM {
 	df := &DeviceFSM{
@@ -260,118 +603,179 @@ func NewDeviceFSM(deviceID string, mqttPrefix string, conn *dd.Conn, mqttHandler
 		Conn:        conn,
 		mqttHandler: mqttHandler,
 	}
+	df.fetchStatus = func() (*DoorStatus, error) {
+		return SafeFetchStatus(df.Conn)
+	}
+	df.queue = newCommandQueue(df)
 
 	f := fsm.NewFSM(
 		"initial",
 		fsm.Events{
 			{Name: "go_online", Src: []string{"offline", "initial"}, Dst: "online"},
-			{Name: "go_offline", Src: []string{"online", "opening", "closing", "open", "closed", "stopping", "stopped"}, Dst: "offline"},
-			{Name: "go_open", Src: []string{"online", "closed", "stopped"}, Dst: "opening"},
-			{Name: "go_close", Src: []string{"online", "open", "stopped"}, Dst: "closing"},
-			{Name: "go_opened", Src: []string{"online", "opening", "open", "closing", "closed", "stopping", "stopped"}, Dst: "open"},
-			{Name: "go_closed", Src: []string{"online", "opening", "open", "closing", "closed", "stopping", "stopped"}, Dst: "closed"},
-			{Name: "go_stop", Src: []string{"online", "opening", "open", "closing", "closed"}, Dst: "stopping"},
+			{Name: "go_offline", Src: []string{"online", "opening", "closing", "open", "closed", "stopping", "stopped", "partially_open"}, Dst: "offline"},
+			{Name: "go_open", Src: []string{"online", "closed", "stopped", "partially_open"}, Dst: "opening"},
+			{Name: "go_close", Src: []string{"online", "open", "stopped", "partially_open"}, Dst: "closing"},
+			{Name: "go_opened", Src: []string{"online", "opening", "open", "closing", "closed", "stopping", "stopped", "partially_open"}, Dst: "open"},
+			{Name: "go_closed", Src: []string{"online", "opening", "open", "closing", "closed", "stopping", "stopped", "partially_open"}, Dst: "closed"},
+			{Name: "go_partially_opened", Src: []string{"online", "open", "closed", "stopped"}, Dst: "partially_open"},
+			{Name: "go_stop", Src: []string{"online", "opening", "open", "closing", "closed", "partially_open"}, Dst: "stopping"},
 			{Name: "go_stopped", Src: []string{"stopping"}, Dst: "stopped"},
 		},
 		fsm.Callbacks{
 			"enter_online": func(ctx context.Context, e *fsm.Event) {
+				recordAvailability(ctx, deviceID, true)
 				err := mqttHandler.PublishAvailability(mqttPrefix, deviceID, "online")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device online")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device online")
 					return
 				}
-				logger.WithField("deviceID", deviceID).Info("Device is online")
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is online")
 			},
 			"enter_offline": func(ctx context.Context, e *fsm.Event) {
+				df.endTravel(-1)
+				df.disarmAutoClose()
+				recordAvailability(ctx, deviceID, false)
 				err := mqttHandler.PublishAvailability(mqttPrefix, deviceID, "offline")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device offline")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device offline")
 					return
 				}
-				logger.WithField("deviceID", deviceID).Info("Device is offline")
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is offline")
 			},
 			"enter_opening": func(ctx context.Context, e *fsm.Event) {
+				df.beginTravel(QueueOpen)
 				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "opening")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to opening")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to opening")
 					return
 				}
 				err = SafeCommand(conn, deviceID, AvailableCommands.Open)
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error sending open command")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error sending open command")
 					return
 				}
-				logger.WithField("deviceID", deviceID).Info("Device is Opening")
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is Opening")
 			},
 			"enter_closing": func(ctx context.Context, e *fsm.Event) {
+				df.beginTravel(QueueClose)
+				df.disarmAutoClose()
 				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "closing")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to closing")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to closing")
 					return
 				}
 				err = SafeCommand(conn, deviceID, AvailableCommands.Close)
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error sending close command")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error sending close command")
 					return
 				}
-				logger.WithField("deviceID", deviceID).Info("Device is Closing")
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is Closing")
 			},
 			"enter_stopping": func(ctx context.Context, e *fsm.Event) {
-				logger.WithField("deviceID", deviceID).Info("Device is Stopping")
+				// Where the door was estimated to be is the best guess until the hub reports it
+				if position, ok := df.travel.estimate(time.Now()); ok {
+					df.travel.observe(position)
+				}
+				df.endTravel(-1)
+				df.armStopTimeout()
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is Stopping")
 				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "stopping")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to stopping")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to stopping")
 					return
 				}
 				err = SafeCommand(conn, deviceID, AvailableCommands.Stop)
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error sending stop command")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error sending stop command")
+					return
+				}
+			},
+			"leave_stopping": func(ctx context.Context, e *fsm.Event) {
+				df.disarmStopTimeout()
+			},
+			"enter_stopped": func(ctx context.Context, e *fsm.Event) {
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is Stopped")
+				df.armStopTimeout()
+				df.armAutoClose()
+				// HA covers have no stopped state; like partially_open, it's "open" at the position
+				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "open")
+				if err != nil {
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to stopped")
 					return
 				}
+				err = mqttHandler.PublishPosition(mqttPrefix, deviceID, df.travel.lastPosition())
+				if err != nil {
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error publishing stopped position")
+				}
+			},
+			"leave_stopped": func(ctx context.Context, e *fsm.Event) {
+				df.disarmStopTimeout()
 			},
 			"enter_open": func(ctx context.Context, e *fsm.Event) {
+				df.endTravel(PositionOpen)
+				df.armAutoClose()
 				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "open")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to opened")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to opened")
 					return
 				}
 				// Publish position as 100 (fully open)
 				err = mqttHandler.PublishPosition(mqttPrefix, deviceID, PositionOpen)
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error publishing open position")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error publishing open position")
 				}
-				logger.WithField("deviceID", deviceID).Info("Device is fully Opened")
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is fully Opened")
 			},
 			"enter_closed": func(ctx context.Context, e *fsm.Event) {
+				df.endTravel(PositionClosed)
+				df.disarmAutoClose()
 				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "closed")
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to closed")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to closed")
 					return
 				}
 				// Publish position as 0 (fully closed)
 				err = mqttHandler.PublishPosition(mqttPrefix, deviceID, PositionClosed)
 				if err != nil {
-					logger.WithError(err).WithField("deviceID", deviceID).Error("Error publishing closed position")
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error publishing closed position")
+				}
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is fully Closed")
+			},
+			"enter_partially_open": func(ctx context.Context, e *fsm.Event) {
+				df.endTravel(-1)
+				df.armAutoClose()
+				// HA covers have no partial state; "open" plus the published position is how HA shows it
+				err := mqttHandler.PublishStatus(mqttPrefix, deviceID, "open")
+				if err != nil {
+					mqttHandler.log().WithError(err).WithField("deviceID", deviceID).Error("Error setting Device to partially open")
+					return
 				}
-				logger.WithField("deviceID", deviceID).Info("Device is fully Closed")
+				mqttHandler.log().WithField("deviceID", deviceID).Info("Device is partially Open")
 			},
 			"enter_state": func(ctx context.Context, e *fsm.Event) {
 				// keep an internal copy of the current state
 				df.mu.Lock()
 				df.State = e.Dst
 				df.mu.Unlock()
+				df.observeStats(e.Dst)
+				df.saveState(e.Dst)
 			},
 			"after_event": func(ctx context.Context, e *fsm.Event) {
-				logger.WithFields(logrus.Fields{
+				source := sourceFrom(ctx)
+				mqttHandler.log().WithFields(logrus.Fields{
 					"deviceID": deviceID,
 					"event":    e.Event,
 					"src":      e.Src,
 					"dst":      e.Dst,
+					"source":   source,
 				}).Debug("FSM transition complete")
+				if e.Src != e.Dst {
+					df.recordTransition(Transition{Time: time.Now(), Src: e.Src, Dst: e.Dst, Event: e.Event, Source: source})
+				}
 			},
 			"error": func(ctx context.Context, e *fsm.Event) {
 				// log and ignore invalid transitions
-				logger.WithFields(logrus.Fields{
+				mqttHandler.log().WithFields(logrus.Fields{
 					"deviceID": deviceID,
 					"event":    e.Event,
 					"src":      e.Src,
@@ -0,0 +1,687 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gravypower/dd/ddtest"
+	"github.com/sirupsen/logrus"
+)
+
+// mockToken is a completed mqtt.Token carrying an optional error.
+type mockToken struct {
+	err error
+}
+
+func (t *mockToken) Wait() bool                     { return true }
+func (t *mockToken) WaitTimeout(time.Duration) bool { return true }
+func (t *mockToken) Error() error                   { return t.err }
+func (t *mockToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// mockPublish records a single call to Publish.
+type mockPublish struct {
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  interface{}
+	Time     time.Time
+}
+
+// mockClient is an in-memory mqtt.Client that records publishes and subscriptions.
+type mockClient struct {
+	mu            sync.Mutex
+	connected     bool
+	published     []mockPublish
+	subscriptions map[string]mqtt.MessageHandler
+	// failFirst makes the first N publishes to each topic fail
+	failFirst int
+	attempts  map[string]int
+}
+
+func newMockClient() *mockClient {
+	return &mockClient{
+		connected:     true,
+		subscriptions: make(map[string]mqtt.MessageHandler),
+		attempts:      make(map[string]int),
+	}
+}
+
+func (c *mockClient) IsConnected() bool      { return c.connected }
+func (c *mockClient) IsConnectionOpen() bool { return c.connected }
+func (c *mockClient) Connect() mqtt.Token    { return &mockToken{} }
+func (c *mockClient) Disconnect(uint)        { c.connected = false }
+
+func (c *mockClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts[topic]++
+	if c.attempts[topic] <= c.failFirst {
+		return &mockToken{err: errors.New("mock publish failure")}
+	}
+	c.published = append(c.published, mockPublish{
+		Topic:    topic,
+		QoS:      qos,
+		Retained: retained,
+		Payload:  payload,
+		Time:     time.Now(),
+	})
+	return &mockToken{}
+}
+
+func (c *mockClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions[topic] = callback
+	return &mockToken{}
+}
+
+func (c *mockClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	for topic := range filters {
+		c.Subscribe(topic, 0, callback)
+	}
+	return &mockToken{}
+}
+
+func (c *mockClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+	}
+	return &mockToken{}
+}
+
+func (c *mockClient) AddRoute(string, mqtt.MessageHandler) {}
+
+func (c *mockClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewClient(mqtt.NewClientOptions()).OptionsReader()
+}
+
+// publishes returns all successful publishes to topic, in order.
+func (c *mockClient) publishes(topic string) []mockPublish {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []mockPublish
+	for _, p := range c.published {
+		if p.Topic == topic {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// last returns the most recent publish to topic.
+func (c *mockClient) last(topic string) (mockPublish, bool) {
+	p := c.publishes(topic)
+	if len(p) == 0 {
+		return mockPublish{}, false
+	}
+	return p[len(p)-1], true
+}
+
+// payloadString converts a recorded payload to a string.
+func payloadString(payload interface{}) string {
+	switch p := payload.(type) {
+	case string:
+		return p
+	case []byte:
+		return string(p)
+	default:
+		return fmt.Sprint(p)
+	}
+}
+
+func newTestHandler() (*MQTTHandler, *mockClient) {
+	client := newMockClient()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return NewMQTTHandler(client, l), client
+}
+
+func TestMQTTHandler_PublishBridgeStatus(t *testing.T) {
+	handler, client := newTestHandler()
+
+	status := DoorStatus{
+		DeviceOrder: []string{"door1", "door2"},
+		Devices: []DoorStatusDevice{
+			{ID: "door1", Name: "Garage"},
+			{ID: "door2", Name: "Gate"},
+		},
+	}
+
+	if err := handler.PublishBridgeStatus("dd-door", status); err != nil {
+		t.Fatalf("PublishBridgeStatus() error = %v", err)
+	}
+
+	topic := fmt.Sprintf(BridgeStatusTopicTemplate, "dd-door")
+	p, ok := client.last(topic)
+	if !ok {
+		t.Fatalf("nothing published to %s", topic)
+	}
+	if !p.Retained {
+		t.Errorf("bridge status should be retained")
+	}
+
+	var got DoorStatus
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &got); err != nil {
+		t.Fatalf("bridge status is not valid JSON: %v", err)
+	}
+	if len(got.Devices) != 2 {
+		t.Fatalf("bridge status device count = %d, want 2", len(got.Devices))
+	}
+	for i, id := range []string{"door1", "door2"} {
+		if got.Devices[i].ID != id {
+			t.Errorf("bridge status device[%d].ID = %q, want %q", i, got.Devices[i].ID, id)
+		}
+	}
+}
+
+func TestMQTTHandler_CloseClearsBridgeStatus(t *testing.T) {
+	handler, client := newTestHandler()
+
+	if err := handler.PublishBridgeStatus("dd-door", DoorStatus{DeviceOrder: []string{"door1"}}); err != nil {
+		t.Fatalf("PublishBridgeStatus() error = %v", err)
+	}
+	handler.Close()
+
+	topic := fmt.Sprintf(BridgeStatusTopicTemplate, "dd-door")
+	p, ok := client.last(topic)
+	if !ok {
+		t.Fatalf("nothing published to %s", topic)
+	}
+	if payloadString(p.Payload) != "" || !p.Retained {
+		t.Errorf("Close() should publish an empty retained message, got %q retained=%v", payloadString(p.Payload), p.Retained)
+	}
+	if client.IsConnected() {
+		t.Errorf("Close() should disconnect the client")
+	}
+}
+
+func TestDeviceFSM_StopTimeoutResolvesState(t *testing.T) {
+	tests := []struct {
+		name     string
+		position int
+		want     string
+	}{
+		{"Stopped fully open", PositionOpen, "open"},
+		{"Stopped fully closed", PositionClosed, "closed"},
+		{"Stopped part way", 40, "partially_open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _ := newTestHandler()
+			df := NewDeviceFSM("door1", "dd-door", nil, handler)
+			df.StopTimeout = 10 * time.Millisecond
+
+			var fetches int
+			var mu sync.Mutex
+			df.fetchStatus = func() (*DoorStatus, error) {
+				mu.Lock()
+				fetches++
+				mu.Unlock()
+				status := &DoorStatus{Devices: []DoorStatusDevice{{ID: "door1"}}}
+				status.Devices[0].Device.Position = tt.position
+				return status, nil
+			}
+
+			// Enter "stopped" without a follow-up position update
+			df.FSM.SetState("stopping")
+			if err := df.Trigger(context.Background(), "go_stopped"); err != nil {
+				t.Fatalf("go_stopped error = %v", err)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for df.Current() != tt.want && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if got := df.Current(); got != tt.want {
+				t.Fatalf("state after stop timeout = %q, want %q", got, tt.want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if fetches != 1 {
+				t.Errorf("status fetched %d times, want 1", fetches)
+			}
+		})
+	}
+}
+
+func TestDeviceFSM_StopTimeoutCancelledByUpdate(t *testing.T) {
+	handler, _ := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.StopTimeout = 20 * time.Millisecond
+
+	fetched := make(chan struct{}, 1)
+	df.fetchStatus = func() (*DoorStatus, error) {
+		fetched <- struct{}{}
+		return &DoorStatus{}, nil
+	}
+
+	df.FSM.SetState("stopping")
+	if err := df.Trigger(context.Background(), "go_stopped"); err != nil {
+		t.Fatalf("go_stopped error = %v", err)
+	}
+	// A position update arrives before the timeout
+	if err := df.Trigger(context.Background(), "go_closed"); err != nil {
+		t.Fatalf("go_closed error = %v", err)
+	}
+
+	select {
+	case <-fetched:
+		t.Errorf("status should not be fetched after a position update")
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+// newStoppableFSM returns a device FSM in "opening" at position 40, whose commands go to a
+// test hub that accepts them.
+func newStoppableFSM(t *testing.T) (*DeviceFSM, *mockClient) {
+	t.Helper()
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+	conn := server.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		return CommandOutput{Value: "ok"}, nil
+	})
+
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", conn, handler)
+	df.FSM.SetState("opening")
+	df.ObservePosition(40)
+	return df, client
+}
+
+func TestDeviceFSM_CompleteStop(t *testing.T) {
+	df, client := newStoppableFSM(t)
+	ctx := context.Background()
+
+	if err := df.CompleteStop(ctx); err != nil || df.Current() != "opening" {
+		t.Errorf("CompleteStop() while opening = %v, state %q, want no change", err, df.Current())
+	}
+	if err := df.Trigger(ctx, "go_stop"); err != nil {
+		t.Fatalf("go_stop error = %v", err)
+	}
+	if err := df.CompleteStop(ctx); err != nil {
+		t.Fatalf("CompleteStop() error = %v", err)
+	}
+	if got := df.Current(); got != "stopped" {
+		t.Fatalf("state after CompleteStop() = %q, want stopped", got)
+	}
+	for topic, want := range map[string]string{
+		"dd-door/door1/state":    "open",
+		"dd-door/door1/position": "40",
+	} {
+		if p, ok := client.last(topic); !ok || payloadString(p.Payload) != want {
+			t.Errorf("%s = %v, want %q", topic, p.Payload, want)
+		}
+	}
+}
+
+func TestDeviceFSM_StopTimeoutFromStopping(t *testing.T) {
+	df, _ := newStoppableFSM(t)
+	df.StopTimeout = 10 * time.Millisecond
+	df.fetchStatus = func() (*DoorStatus, error) {
+		status := &DoorStatus{Devices: []DoorStatusDevice{{ID: "door1"}}}
+		status.Devices[0].Device.Position = 55
+		return status, nil
+	}
+
+	if err := df.Trigger(context.Background(), "go_stop"); err != nil {
+		t.Fatalf("go_stop error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for df.Current() != "partially_open" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := df.Current(); got != "partially_open" {
+		t.Fatalf("state after stop timeout = %q, want partially_open", got)
+	}
+	if got := df.travel.lastPosition(); got != 55 {
+		t.Errorf("position after stop timeout = %d, want 55", got)
+	}
+}
+
+func TestConfigureDevice_RetriesAreJittered(t *testing.T) {
+	prev := configRetryBaseDelay
+	configRetryBaseDelay = 50 * time.Millisecond
+	defer func() { configRetryBaseDelay = prev }()
+
+	handler, client := newTestHandler()
+	client.failFirst = 1
+
+	registry := NewDeviceRegistry()
+	const devices = 10
+	var wg sync.WaitGroup
+	for i := 0; i < devices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			registry.ConfigureDevice(handler, nil, "dd-door", DoorStatusDevice{ID: fmt.Sprintf("jitter%d", i)}, BasicInfo{}, DeviceOptions{})
+		}(i)
+	}
+	wg.Wait()
+
+	var times []time.Time
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		times = times[:0]
+		for i := 0; i < devices; i++ {
+			if p, ok := client.last(fmt.Sprintf(HomeAssistantConfigTopicTemplate, fmt.Sprintf("jitter%d", i))); ok {
+				times = append(times, p.Time)
+			}
+		}
+		if len(times) == devices {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(times) != devices {
+		t.Fatalf("only %d of %d configs published after retry", len(times), devices)
+	}
+
+	earliest, latest := times[0], times[0]
+	for _, ts := range times {
+		if ts.Before(earliest) {
+			earliest = ts
+		}
+		if ts.After(latest) {
+			latest = ts
+		}
+	}
+	// ±20% of 50ms spreads retries across up to 20ms
+	if spread := latest.Sub(earliest); spread < time.Millisecond {
+		t.Errorf("retries spread over %v, expected jitter to desynchronize them", spread)
+	}
+}
+
+func TestConfigureDevice_Options(t *testing.T) {
+	tests := []struct {
+		name             string
+		options          DeviceOptions
+		wantExpireAfter  float64
+		wantScanInterval float64
+	}{
+		{"Defaults", DeviceOptions{}, DefaultExpireAfter, DefaultScanInterval},
+		{"Overrides", DeviceOptions{ExpireAfter: 120, ScanInterval: 20}, 120, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, client := newTestHandler()
+			NewDeviceRegistry().ConfigureDevice(handler, nil, "dd-door", DoorStatusDevice{ID: "options1"}, BasicInfo{}, tt.options)
+
+			p, ok := client.last(fmt.Sprintf(HomeAssistantConfigTopicTemplate, "options1"))
+			if !ok {
+				t.Fatalf("no discovery config published")
+			}
+			var config map[string]interface{}
+			if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+				t.Fatalf("discovery config is not valid JSON: %v", err)
+			}
+			if config["expire_after"] != tt.wantExpireAfter {
+				t.Errorf("expire_after = %v, want %v", config["expire_after"], tt.wantExpireAfter)
+			}
+			if config["scan_interval"] != tt.wantScanInterval {
+				t.Errorf("scan_interval = %v, want %v", config["scan_interval"], tt.wantScanInterval)
+			}
+		})
+	}
+}
+
+func TestConfigureDevice_EntityOverrides(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      DeviceOptions
+		wantName     string
+		wantClass    string
+		wantIcon     interface{}
+		wantObjectID interface{}
+	}{
+		{"Defaults", DeviceOptions{}, "Front", "garage", "mdi:garage", nil},
+		{"Gate", DeviceOptions{Name: "Side Gate", ObjectID: "side_gate", DeviceClass: "gate"}, "Side Gate", "gate", nil, "side_gate"},
+		{"Icon", DeviceOptions{DeviceClass: "shutter", Icon: "mdi:window-shutter"}, "Front", "shutter", "mdi:window-shutter", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, client := newTestHandler()
+			if err := handler.PublishDeviceConfig("dd-door", DoorStatusDevice{ID: "entity1", Name: "Front"}, BasicInfo{}, tt.options); err != nil {
+				t.Fatalf("PublishDeviceConfig() error = %v", err)
+			}
+
+			p, _ := client.last(fmt.Sprintf(HomeAssistantConfigTopicTemplate, "entity1"))
+			var config map[string]interface{}
+			if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+				t.Fatalf("discovery config is not valid JSON: %v", err)
+			}
+			if config["name"] != tt.wantName {
+				t.Errorf("name = %v, want %v", config["name"], tt.wantName)
+			}
+			if config["device_class"] != tt.wantClass {
+				t.Errorf("device_class = %v, want %v", config["device_class"], tt.wantClass)
+			}
+			if config["icon"] != tt.wantIcon {
+				t.Errorf("icon = %v, want %v", config["icon"], tt.wantIcon)
+			}
+			if config["object_id"] != tt.wantObjectID {
+				t.Errorf("object_id = %v, want %v", config["object_id"], tt.wantObjectID)
+			}
+		})
+	}
+}
+
+func TestDeviceOptions_Validate(t *testing.T) {
+	tests := []struct {
+		class   string
+		wantErr error
+	}{
+		{"", nil},
+		{"gate", nil},
+		{"shutter", nil},
+		{"portcullis", ErrInvalidDeviceClass},
+	}
+	for _, tt := range tests {
+		if err := (DeviceOptions{DeviceClass: tt.class}).Validate(); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.class, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMQTTHandler_PublishRetainedPosition(t *testing.T) {
+	handler, client := newTestHandler()
+
+	if err := handler.PublishRetainedPosition("dd-door", "door1", 40); err != nil {
+		t.Fatalf("PublishRetainedPosition() error = %v", err)
+	}
+
+	p, ok := client.last(fmt.Sprintf(PositionTopicTemplate, "dd-door", "door1"))
+	if !ok {
+		t.Fatalf("nothing published to position topic")
+	}
+	if !p.Retained {
+		t.Errorf("position should be retained")
+	}
+	if p.QoS != 1 {
+		t.Errorf("position QoS = %d, want 1", p.QoS)
+	}
+	if payloadString(p.Payload) != "40" {
+		t.Errorf("position payload = %q, want %q", payloadString(p.Payload), "40")
+	}
+}
+
+func TestMQTTHandler_Options(t *testing.T) {
+	handler, client := newTestHandler()
+	handler.Options = MQTTOptions{
+		State:        PublishOptions{QoS: 1, Retain: true},
+		Position:     PublishOptions{QoS: 2, Retain: false},
+		Availability: PublishOptions{QoS: 1, Retain: false},
+	}
+
+	if err := handler.PublishStatus("dd-door", "door1", "open"); err != nil {
+		t.Fatalf("PublishStatus() error = %v", err)
+	}
+	if err := handler.PublishPosition("dd-door", "door1", 100); err != nil {
+		t.Fatalf("PublishPosition() error = %v", err)
+	}
+	if err := handler.PublishAvailability("dd-door", "door1", "online"); err != nil {
+		t.Fatalf("PublishAvailability() error = %v", err)
+	}
+
+	tests := []struct {
+		topic string
+		want  PublishOptions
+	}{
+		{fmt.Sprintf(StateTopicTemplate, "dd-door", "door1"), handler.Options.State},
+		{fmt.Sprintf(PositionTopicTemplate, "dd-door", "door1"), handler.Options.Position},
+		{fmt.Sprintf(AvailabilityTopicTemplate, "dd-door", "door1"), handler.Options.Availability},
+	}
+	for _, tt := range tests {
+		p, ok := client.last(tt.topic)
+		if !ok {
+			t.Errorf("nothing published to %s", tt.topic)
+			continue
+		}
+		if got := (PublishOptions{QoS: p.QoS, Retain: p.Retained}); got != tt.want {
+			t.Errorf("%s published with %+v, want %+v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTOptions_Validate(t *testing.T) {
+	if err := DefaultMQTTOptions().Validate(); err != nil {
+		t.Errorf("DefaultMQTTOptions().Validate() = %v, want nil", err)
+	}
+	options := DefaultMQTTOptions()
+	options.Availability.QoS = 3
+	if err := options.Validate(); !errors.Is(err, ErrInvalidQoS) {
+		t.Errorf("Validate() = %v, want %v", err, ErrInvalidQoS)
+	}
+}
+
+func TestMQTTHandler_Logger(t *testing.T) {
+	client := newMockClient()
+	client.connected = false
+
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	handler := NewMQTTHandler(client, l)
+
+	if err := handler.PublishStatus("dd-door", "dev1", "open"); err == nil {
+		t.Fatalf("PublishStatus() error = nil, want not connected error")
+	}
+	if !strings.Contains(buf.String(), "Publish skipped") {
+		t.Errorf("handler logger output = %q, want publish skipped entry", buf.String())
+	}
+
+	// A handler without a logger falls back to the package logger
+	nilHandler := NewMQTTHandler(client, nil)
+	if err := nilHandler.PublishStatus("dd-door", "dev1", "open"); err == nil {
+		t.Errorf("PublishStatus() error = nil, want not connected error")
+	}
+}
+
+func TestDeviceFSM_PartiallyOpen(t *testing.T) {
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	ctx := context.Background()
+
+	df.Trigger(ctx, "go_online")
+	df.Trigger(ctx, "go_closed")
+	if err := df.Trigger(ctx, "go_partially_opened"); err != nil {
+		t.Fatalf("go_partially_opened from closed error = %v", err)
+	}
+	if got := df.Current(); got != "partially_open" {
+		t.Fatalf("state = %q, want partially_open", got)
+	}
+	p, _ := client.last("dd-door/door1/state")
+	if got := payloadString(p.Payload); got != "open" {
+		t.Errorf("published state = %q, want open", got)
+	}
+
+	// Moving doors report intermediate positions without becoming partially open
+	df.FSM.SetState("closing")
+	if err := df.Trigger(ctx, "go_partially_opened"); err == nil {
+		t.Errorf("go_partially_opened from closing error = nil, want invalid transition")
+	}
+
+	df.FSM.SetState("partially_open")
+	if err := df.Trigger(ctx, "go_closed"); err != nil {
+		t.Errorf("go_closed from partially_open error = %v", err)
+	}
+}
+
+func TestDeviceFSM_Republish(t *testing.T) {
+	tests := []struct {
+		state            string
+		wantAvailability string
+		wantState        string
+	}{
+		{"initial", "", ""},
+		{"online", "online", ""},
+		{"offline", "offline", ""},
+		{"closed", "online", "closed"},
+		{"partially_open", "online", "open"},
+		{"stopped", "online", "open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			handler, client := newTestHandler()
+			df := NewDeviceFSM("door1", "dd-door", nil, handler)
+			df.FSM.SetState(tt.state)
+
+			if err := df.Republish(); err != nil {
+				t.Fatalf("Republish() error = %v", err)
+			}
+			for topic, want := range map[string]string{
+				"dd-door/door1/availability": tt.wantAvailability,
+				"dd-door/door1/state":        tt.wantState,
+			} {
+				got := ""
+				if p, ok := client.last(topic); ok {
+					got = payloadString(p.Payload)
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", topic, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMQTTHandler_PublishDeviceConfig(t *testing.T) {
+	handler, client := newTestHandler()
+	device := DoorStatusDevice{ID: "door1", Name: "Garage"}
+
+	if err := handler.PublishDeviceConfig("dd-door", device, BasicInfo{}, DeviceOptions{}); err != nil {
+		t.Fatalf("PublishDeviceConfig() error = %v", err)
+	}
+	p, ok := client.last(fmt.Sprintf(HomeAssistantConfigTopicTemplate, "door1"))
+	if !ok {
+		t.Fatalf("no discovery config published")
+	}
+	if !p.Retained {
+		t.Errorf("discovery config should be retained")
+	}
+	if _, exists := GetDeviceFSM("door1"); exists {
+		t.Errorf("PublishDeviceConfig() registered an FSM")
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// HistoryTopicTemplate carries a device's recent state transitions as JSON, for debugging
+const HistoryTopicTemplate = "%s/%s/history"
+
+// DefaultHistorySize is how many transitions a DeviceFSM keeps when HistorySize is zero.
+const DefaultHistorySize = 50
+
+// Sources of a state transition, recorded in the device's history
+const (
+	SourceMQTT     = "mqtt"     // a command received over MQTT
+	SourceStatus   = "status"   // a status update from the hub
+	SourceSchedule = "schedule" // a scheduled action
+	SourceTimer    = "timer"    // the stop timeout or auto-close timer
+	SourceBridge   = "bridge"   // the bridge itself, e.g. on shutdown or losing the hub
+)
+
+// Transition is a change in a device's FSM state.
+type Transition struct {
+	Time   time.Time `json:"time"`
+	Src    string    `json:"src"`
+	Dst    string    `json:"dst"`
+	Event  string    `json:"event"`
+	Source string    `json:"source,omitempty"` // one of the Source constants, if known
+}
+
+type sourceKey struct{}
+
+// WithSource returns a context that records source as the cause of any transitions
+// triggered with it.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// sourceFrom returns the source set on ctx by WithSource, or "".
+func sourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// History returns the device's recent transitions, oldest first.
+func (d *DeviceFSM) History() []Transition {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.history)
+}
+
+// recordTransition adds t to the history, dropping the oldest beyond HistorySize, passes
+// it to OnTransition and publishes the history.
+func (d *DeviceFSM) recordTransition(t Transition) {
+	size := d.HistorySize
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	d.mu.Lock()
+	d.history = append(d.history, t)
+	if len(d.history) > size {
+		d.history = slices.Clone(d.history[len(d.history)-size:])
+	}
+	history := slices.Clone(d.history)
+	d.mu.Unlock()
+
+	if d.OnTransition != nil {
+		d.OnTransition(t)
+	}
+	if d.mqttHandler == nil {
+		return
+	}
+	if err := d.mqttHandler.PublishHistory(d.MQTTPrefix, d.ID, history); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Debug("Failed to publish history")
+	}
+}
+
+// PublishHistory publishes a device's recent transitions as a retained JSON array.
+func (h *MQTTHandler) PublishHistory(prefix, deviceID string, history []Transition) error {
+	payload, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("encode history: %w", err)
+	}
+	return h.publishToMQTT(fmt.Sprintf(HistoryTopicTemplate, prefix, deviceID), 0, true, payload)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestDeviceFSM_History(t *testing.T) {
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	var observed []Transition
+	df.OnTransition = func(t Transition) { observed = append(observed, t) }
+
+	df.Trigger(WithSource(context.Background(), SourceStatus), "go_online")
+	df.Trigger(WithSource(context.Background(), SourceStatus), "go_closed")
+	df.Trigger(WithSource(context.Background(), SourceStatus), "go_closed") // no change
+	df.Trigger(WithSource(context.Background(), SourceMQTT), "go_offline")
+	df.Trigger(context.Background(), "go_stopped") // invalid from offline
+
+	want := []Transition{
+		{Src: "initial", Dst: "online", Event: "go_online", Source: SourceStatus},
+		{Src: "online", Dst: "closed", Event: "go_closed", Source: SourceStatus},
+		{Src: "closed", Dst: "offline", Event: "go_offline", Source: SourceMQTT},
+	}
+	history := df.History()
+	if len(history) != len(want) {
+		t.Fatalf("History() = %+v, want %d transitions", history, len(want))
+	}
+	for i, got := range history {
+		if got.Time.IsZero() {
+			t.Errorf("transition %d has no time", i)
+		}
+		got.Time = want[i].Time
+		if got != want[i] {
+			t.Errorf("transition %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if len(observed) != len(want) || observed[2].Dst != "offline" {
+		t.Errorf("OnTransition got %+v, want the same as History()", observed)
+	}
+
+	p, ok := client.last(fmt.Sprintf(HistoryTopicTemplate, "dd-door", "door1"))
+	if !ok || !p.Retained {
+		t.Fatalf("history not published retained")
+	}
+	var published []Transition
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &published); err != nil {
+		t.Fatalf("history is not valid JSON: %v", err)
+	}
+	if len(published) != len(want) || published[2].Source != SourceMQTT {
+		t.Errorf("published history = %+v, want the same as History()", published)
+	}
+}
+
+func TestDeviceFSM_HistoryIsBounded(t *testing.T) {
+	handler, _ := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.HistorySize = 3
+
+	ctx := context.Background()
+	df.Trigger(ctx, "go_online")
+	for i := 0; i < 3; i++ {
+		df.Trigger(ctx, "go_closed")
+		df.Trigger(ctx, "go_opened")
+	}
+
+	history := df.History()
+	if len(history) != 3 {
+		t.Fatalf("History() has %d transitions, want 3", len(history))
+	}
+	if last := history[2]; last.Dst != "open" {
+		t.Errorf("newest transition = %+v, want to open", last)
+	}
+}
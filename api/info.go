@@ -8,11 +8,18 @@ type BasicInfo struct {
 	Clock       int64  `json:"clock"`
 	Name        string `json:"name"`
 	Version     int    `json:"version"`
+	WiFiRSSI    *int   `json:"wifiRssi,omitempty"` // dBm, if the hub reports it
 }
 
 // FetchBasicInfo fetches basic device information and returns an error if it fails.
 // This function no longer calls Fatal() to allow graceful error handling.
+// Through the cloud the SDK endpoint isn't reachable, so only the base station and hub
+// version from the connect response are filled in.
 func FetchBasicInfo(conn *dd.Conn) (*BasicInfo, error) {
+	if conn.IsCloud() {
+		return &BasicInfo{BaseStation: conn.BaseStation(), Version: conn.HubVersion()}, nil
+	}
+
 	var info BasicInfo
 	err := conn.SimpleRequest(dd.SimpleRequest{
 		Path:   "/sdk/info",
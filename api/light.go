@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// LightConfigTopicTemplate is the HA discovery topic for a door's courtesy light
+	LightConfigTopicTemplate = "homeassistant/light/%s/config"
+	// LightStateTopicTemplate carries the light state (ON/OFF)
+	LightStateTopicTemplate = "%s/%s/light"
+	// LightCommandTopicTemplate receives ON/OFF commands for the light
+	LightCommandTopicTemplate = "%s/%s/set_light"
+
+	// PayloadOn and PayloadOff are the state and command payloads for on/off entities
+	PayloadOn  = "ON"
+	PayloadOff = "OFF"
+)
+
+// ConfigureLight publishes Home Assistant discovery for the door's courtesy light.
+func (h *MQTTHandler) ConfigureLight(mqttPrefix string, device DoorStatusDevice) error {
+	configPayload := map[string]interface{}{
+		"name":                  fmt.Sprintf("%s Light", device.Name),
+		"command_topic":         fmt.Sprintf(LightCommandTopicTemplate, mqttPrefix, device.ID),
+		"state_topic":           fmt.Sprintf(LightStateTopicTemplate, mqttPrefix, device.ID),
+		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"payload_on":            PayloadOn,
+		"payload_off":           PayloadOff,
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"unique_id":             fmt.Sprintf("light_%s", device.ID),
+		"device": map[string]interface{}{
+			"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+		},
+		"icon": "mdi:lightbulb",
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(LightConfigTopicTemplate, device.ID), 0, true, bytes)
+}
+
+// PublishLightState publishes the light state for a device (retained, so HA picks it up on restart).
+func (h *MQTTHandler) PublishLightState(prefix, deviceID string, on bool) error {
+	return h.publishOnOff(fmt.Sprintf(LightStateTopicTemplate, prefix, deviceID), on)
+}
+
+// LightCommand maps an ON/OFF payload to the light command code.
+func LightCommand(payload string) (int, error) {
+	return onOffCommand(payload, AvailableCommands.LightOn, AvailableCommands.LightOff)
+}
+
+// publishOnOff publishes a retained ON/OFF state.
+func (h *MQTTHandler) publishOnOff(topic string, on bool) error {
+	payload := PayloadOff
+	if on {
+		payload = PayloadOn
+	}
+	return h.publishToMQTT(topic, 0, true, payload)
+}
+
+// onOffCommand maps an ON/OFF payload to onCommand or offCommand.
+func onOffCommand(payload string, onCommand, offCommand int) (int, error) {
+	switch payload {
+	case PayloadOn:
+		return onCommand, nil
+	case PayloadOff:
+		return offCommand, nil
+	}
+	return 0, fmt.Errorf("invalid on/off payload %q", payload)
+}
+
+// LightState reports whether the device's light is on; ok is false if the device
+// has no light button.
+func (d DoorStatusDevice) LightState() (on bool, ok bool) {
+	return d.toggleState(AvailableCommands.LightOn, AvailableCommands.LightOff)
+}
+
+// toggleState infers an on/off state from the device's buttons. The hub doesn't send
+// these states directly, but its buttons toggle: a button offering offCommand means
+// the output is on, and one offering onCommand means it's off.
+func (d DoorStatusDevice) toggleState(onCommand, offCommand int) (on bool, ok bool) {
+	for _, buttons := range [][]DoorStatusButton{d.Buttons, d.Aux} {
+		for _, button := range buttons {
+			switch button.Action.Command {
+			case offCommand:
+				return true, true
+			case onCommand:
+				return false, true
+			}
+		}
+	}
+	return false, false
+}
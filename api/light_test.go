@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigureLight(t *testing.T) {
+	handler, client := newTestHandler()
+	device := DoorStatusDevice{ID: "door1", Name: "Garage"}
+
+	if err := handler.ConfigureLight("dd-door", device); err != nil {
+		t.Fatalf("ConfigureLight() error = %v", err)
+	}
+
+	p, ok := client.last("homeassistant/light/door1/config")
+	if !ok {
+		t.Fatalf("no light discovery published")
+	}
+	if !p.Retained {
+		t.Errorf("light discovery should be retained")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("decode discovery payload: %v", err)
+	}
+	for key, want := range map[string]string{
+		"command_topic": "dd-door/door1/set_light",
+		"state_topic":   "dd-door/door1/light",
+		"unique_id":     "light_door1",
+		"name":          "Garage Light",
+	} {
+		if config[key] != want {
+			t.Errorf("config[%q] = %v, want %q", key, config[key], want)
+		}
+	}
+}
+
+func TestPublishLightState(t *testing.T) {
+	handler, client := newTestHandler()
+
+	if err := handler.PublishLightState("dd-door", "door1", true); err != nil {
+		t.Fatalf("PublishLightState() error = %v", err)
+	}
+	p, _ := client.last("dd-door/door1/light")
+	if got := payloadString(p.Payload); got != PayloadOn || !p.Retained {
+		t.Errorf("light state = %q (retained %v), want %q retained", got, p.Retained, PayloadOn)
+	}
+}
+
+func TestLightCommand(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    int
+		wantErr bool
+	}{
+		{PayloadOn, AvailableCommands.LightOn, false},
+		{PayloadOff, AvailableCommands.LightOff, false},
+		{"TOGGLE", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := LightCommand(tt.payload)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("LightCommand(%q) = %d, %v, want %d, wantErr %v", tt.payload, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDoorStatusDevice_LightState(t *testing.T) {
+	tests := []struct {
+		name   string
+		device DoorStatusDevice
+		wantOn bool
+		wantOK bool
+	}{
+		{"no light button", DoorStatusDevice{Buttons: []DoorStatusButton{testButton(0, 0, AvailableCommands.Open)}}, false, false},
+		{"offers light on", DoorStatusDevice{Buttons: []DoorStatusButton{testButton(0, 0, AvailableCommands.LightOn)}}, false, true},
+		{"offers light off", DoorStatusDevice{Buttons: []DoorStatusButton{testButton(0, 0, AvailableCommands.LightOff)}}, true, true},
+		{"aux light button", DoorStatusDevice{Aux: []DoorStatusButton{testButton(0, 0, AvailableCommands.LightOff)}}, true, true},
+	}
+
+	for _, tt := range tests {
+		on, ok := tt.device.LightState()
+		if on != tt.wantOn || ok != tt.wantOK {
+			t.Errorf("%s: LightState() = %v, %v, want %v, %v", tt.name, on, ok, tt.wantOn, tt.wantOK)
+		}
+	}
+}
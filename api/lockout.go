@@ -0,0 +1,69 @@
+package api
+
+import "fmt"
+
+const (
+	// PhoneLockoutConfigTopicTemplate is the HA discovery topic for a device's phone lockout switch
+	PhoneLockoutConfigTopicTemplate = "homeassistant/switch/%s_phone_lockout/config"
+	// PhoneLockoutStateTopicTemplate carries the phone lockout state (ON/OFF)
+	PhoneLockoutStateTopicTemplate = "%s/%s/phone_lockout"
+	// PhoneLockoutCommandTopicTemplate receives ON/OFF commands for the phone lockout
+	PhoneLockoutCommandTopicTemplate = "%s/%s/set_phone_lockout"
+
+	// RemoteLockoutConfigTopicTemplate is the HA discovery topic for a device's remote control lockout switch
+	RemoteLockoutConfigTopicTemplate = "homeassistant/switch/%s_remote_lockout/config"
+	// RemoteLockoutStateTopicTemplate carries the remote control lockout state (ON/OFF)
+	RemoteLockoutStateTopicTemplate = "%s/%s/remote_lockout"
+	// RemoteLockoutCommandTopicTemplate receives ON/OFF commands for the remote control lockout
+	RemoteLockoutCommandTopicTemplate = "%s/%s/set_remote_lockout"
+)
+
+// PhoneLockoutState reports whether the device ignores the phone app; ok is false if the
+// device has no phone lockout button.
+func (d DoorStatusDevice) PhoneLockoutState() (on bool, ok bool) {
+	return d.toggleState(AvailableCommands.PhoneLockoutOn, AvailableCommands.PhoneLockoutOff)
+}
+
+// RemoteLockoutState reports whether the device ignores its remote controls; ok is false
+// if the device has no remote lockout button.
+func (d DoorStatusDevice) RemoteLockoutState() (on bool, ok bool) {
+	return d.toggleState(AvailableCommands.RemoteControlLockoutOn, AvailableCommands.RemoteControlLockoutOff)
+}
+
+// PhoneLockoutCommand maps an ON/OFF payload to the phone lockout command code.
+func PhoneLockoutCommand(payload string) (int, error) {
+	return onOffCommand(payload, AvailableCommands.PhoneLockoutOn, AvailableCommands.PhoneLockoutOff)
+}
+
+// RemoteLockoutCommand maps an ON/OFF payload to the remote control lockout command code.
+func RemoteLockoutCommand(payload string) (int, error) {
+	return onOffCommand(payload, AvailableCommands.RemoteControlLockoutOn, AvailableCommands.RemoteControlLockoutOff)
+}
+
+// ConfigureLockouts publishes Home Assistant discovery for switches controlling the
+// lockouts the device has, e.g. for a vacation mode that locks out everything but the
+// bridge.
+func (h *MQTTHandler) ConfigureLockouts(mqttPrefix string, device DoorStatusDevice) error {
+	if _, ok := device.PhoneLockoutState(); ok {
+		err := h.configureSwitch(mqttPrefix, device, "Phone Lockout", "phone_lockout", "mdi:cellphone-lock",
+			PhoneLockoutConfigTopicTemplate, PhoneLockoutStateTopicTemplate, PhoneLockoutCommandTopicTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := device.RemoteLockoutState(); ok {
+		return h.configureSwitch(mqttPrefix, device, "Remote Lockout", "remote_lockout", "mdi:remote-off",
+			RemoteLockoutConfigTopicTemplate, RemoteLockoutStateTopicTemplate, RemoteLockoutCommandTopicTemplate)
+	}
+	return nil
+}
+
+// PublishPhoneLockoutState publishes the phone lockout state for a device (retained).
+func (h *MQTTHandler) PublishPhoneLockoutState(prefix, deviceID string, on bool) error {
+	return h.publishOnOff(fmt.Sprintf(PhoneLockoutStateTopicTemplate, prefix, deviceID), on)
+}
+
+// PublishRemoteLockoutState publishes the remote control lockout state for a device (retained).
+func (h *MQTTHandler) PublishRemoteLockoutState(prefix, deviceID string, on bool) error {
+	return h.publishOnOff(fmt.Sprintf(RemoteLockoutStateTopicTemplate, prefix, deviceID), on)
+}
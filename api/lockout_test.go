@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigureLockouts(t *testing.T) {
+	handler, client := newTestHandler()
+	device := DoorStatusDevice{ID: "door1", Name: "Garage", Aux: []DoorStatusButton{
+		testButton(0, 0, AvailableCommands.PhoneLockoutOn),
+	}}
+
+	if err := handler.ConfigureLockouts("dd-door", device); err != nil {
+		t.Fatalf("ConfigureLockouts() error = %v", err)
+	}
+
+	p, ok := client.last("homeassistant/switch/door1_phone_lockout/config")
+	if !ok {
+		t.Fatalf("no phone lockout discovery published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("decode discovery payload: %v", err)
+	}
+	for key, want := range map[string]string{
+		"name":          "Garage Phone Lockout",
+		"command_topic": "dd-door/door1/set_phone_lockout",
+		"state_topic":   "dd-door/door1/phone_lockout",
+		"unique_id":     "phone_lockout_door1",
+	} {
+		if config[key] != want {
+			t.Errorf("config[%q] = %v, want %q", key, config[key], want)
+		}
+	}
+	if _, ok := client.last("homeassistant/switch/door1_remote_lockout/config"); ok {
+		t.Errorf("remote lockout discovery published for device without remote lockout button")
+	}
+}
+
+func TestLockoutCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		command func(string) (int, error)
+		payload string
+		want    int
+	}{
+		{"phone on", PhoneLockoutCommand, PayloadOn, AvailableCommands.PhoneLockoutOn},
+		{"phone off", PhoneLockoutCommand, PayloadOff, AvailableCommands.PhoneLockoutOff},
+		{"remote on", RemoteLockoutCommand, PayloadOn, AvailableCommands.RemoteControlLockoutOn},
+		{"remote off", RemoteLockoutCommand, PayloadOff, AvailableCommands.RemoteControlLockoutOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.command(tt.payload); err != nil || got != tt.want {
+				t.Errorf("command(%s) = %d, %v, want %d", tt.payload, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoorStatusDevice_LockoutState(t *testing.T) {
+	device := DoorStatusDevice{Buttons: []DoorStatusButton{testButton(0, 0, AvailableCommands.RemoteControlLockoutOff)}}
+	if on, ok := device.RemoteLockoutState(); !on || !ok {
+		t.Errorf("RemoteLockoutState() = %v, %v, want true, true", on, ok)
+	}
+	if _, ok := device.PhoneLockoutState(); ok {
+		t.Errorf("PhoneLockoutState() with no phone lockout button ok = true, want false")
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gravypower/dd"
+)
+
+const (
+	// LogSensorConfigTopicTemplate is the HA discovery topic for a door's last log entry sensor
+	LogSensorConfigTopicTemplate = "homeassistant/sensor/%s_log/config"
+	// LogTopicTemplate carries the latest log entry as JSON
+	LogTopicTemplate = "%s/%s/log"
+)
+
+// logsPageSize is how many entries FetchLogs asks for per request.
+var logsPageSize = 50
+
+// logsRequest is the input to /app/res/logs/fetch. Entries are returned newest first,
+// starting below BeforeID if set.
+type logsRequest struct {
+	DeviceID string `json:"deviceId"`
+	BeforeID int64  `json:"beforeLogId,omitempty"`
+	Limit    int    `json:"limit"`
+}
+
+// logsResponse is the wire format returned by /app/res/logs/fetch.
+type logsResponse struct {
+	Logs []DoorStatusLog `json:"logs"`
+}
+
+// FetchLogs fetches up to limit of the device's most recent log entries, newest first,
+// paging through its history as needed.
+func FetchLogs(conn *dd.Conn, deviceID string, limit int) ([]DoorStatusLog, error) {
+	var out []DoorStatusLog
+	var before int64
+	for len(out) < limit {
+		req := logsRequest{DeviceID: deviceID, BeforeID: before, Limit: min(logsPageSize, limit-len(out))}
+		var resp logsResponse
+		err := timedRPC(conn, dd.RPC{
+			Path:   "/app/res/logs/fetch",
+			Input:  req,
+			Output: &resp,
+		})
+		if err != nil {
+			logger.WithError(err).WithField("deviceID", deviceID).Error("Could not fetch logs")
+			return nil, err
+		}
+
+		out = append(out, resp.Logs...)
+		// A short page is the end of the history
+		if len(resp.Logs) < req.Limit {
+			break
+		}
+		before = resp.Logs[len(resp.Logs)-1].ID
+	}
+	return out[:min(len(out), limit)], nil
+}
+
+// ConfigureLogSensor publishes Home Assistant discovery for a sensor showing the door's
+// latest log entry, with the full entry as attributes.
+func (h *MQTTHandler) ConfigureLogSensor(mqttPrefix string, device DoorStatusDevice) error {
+	topic := fmt.Sprintf(LogTopicTemplate, mqttPrefix, device.ID)
+	configPayload := map[string]interface{}{
+		"name":                  fmt.Sprintf("%s Last Event", device.Name),
+		"state_topic":           topic,
+		"value_template":        "{{ value_json.text }}",
+		"json_attributes_topic": topic,
+		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"unique_id":             fmt.Sprintf("log_%s", device.ID),
+		"device": map[string]interface{}{
+			"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+		},
+		"icon": "mdi:history",
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(LogSensorConfigTopicTemplate, device.ID), 0, true, bytes)
+}
+
+// PublishLog publishes a device's latest log entry as retained JSON.
+func (h *MQTTHandler) PublishLog(prefix, deviceID string, entry DoorStatusLog) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode log entry: %w", err)
+	}
+	return h.publishToMQTT(fmt.Sprintf(LogTopicTemplate, prefix, deviceID), 0, true, payload)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFetchLogs_Pages(t *testing.T) {
+	prev := logsPageSize
+	logsPageSize = 3
+	t.Cleanup(func() { logsPageSize = prev })
+
+	server, conn := connectTestServer(t)
+
+	// History of entries 7 (newest) down to 1
+	var pages int
+	server.Handle("/app/res/logs/fetch", func(body []byte) (interface{}, error) {
+		pages++
+		var req logsRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		if req.DeviceID != "door1" {
+			t.Errorf("request device = %q, want door1", req.DeviceID)
+		}
+		next := int64(7)
+		if req.BeforeID != 0 {
+			next = req.BeforeID - 1
+		}
+		var resp logsResponse
+		for id := next; id > 0 && len(resp.Logs) < req.Limit; id-- {
+			resp.Logs = append(resp.Logs, DoorStatusLog{ID: id, Text: "event"})
+		}
+		return resp, nil
+	})
+
+	tests := []struct {
+		limit     int
+		wantFirst int64
+		wantLast  int64
+		wantLen   int
+	}{
+		{limit: 5, wantFirst: 7, wantLast: 3, wantLen: 5},
+		{limit: 20, wantFirst: 7, wantLast: 1, wantLen: 7},
+	}
+	for _, tt := range tests {
+		logs, err := FetchLogs(conn, "door1", tt.limit)
+		if err != nil {
+			t.Fatalf("FetchLogs(%d) error = %v", tt.limit, err)
+		}
+		if len(logs) != tt.wantLen || logs[0].ID != tt.wantFirst || logs[len(logs)-1].ID != tt.wantLast {
+			t.Errorf("FetchLogs(%d) = %+v, want entries %d to %d", tt.limit, logs, tt.wantFirst, tt.wantLast)
+		}
+	}
+	// 2 pages for the first call, 3 for the second
+	if pages != 5 {
+		t.Errorf("requested %d pages, want 5", pages)
+	}
+}
+
+func TestConfigureLogSensor(t *testing.T) {
+	handler, client := newTestHandler()
+	device := DoorStatusDevice{ID: "door1", Name: "Garage"}
+
+	if err := handler.ConfigureLogSensor("dd-door", device); err != nil {
+		t.Fatalf("ConfigureLogSensor() error = %v", err)
+	}
+
+	p, ok := client.last("homeassistant/sensor/door1_log/config")
+	if !ok {
+		t.Fatalf("no log sensor discovery published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("decode discovery payload: %v", err)
+	}
+	for key, want := range map[string]string{
+		"state_topic":           "dd-door/door1/log",
+		"json_attributes_topic": "dd-door/door1/log",
+		"unique_id":             "log_door1",
+	} {
+		if config[key] != want {
+			t.Errorf("config[%q] = %v, want %q", key, config[key], want)
+		}
+	}
+}
+
+func TestPublishLog(t *testing.T) {
+	handler, client := newTestHandler()
+	entry := DoorStatusLog{ID: 42, Alert: AvailableCommands.Open, Text: "Opened by phone", Time: 1700000000000}
+
+	if err := handler.PublishLog("dd-door", "door1", entry); err != nil {
+		t.Fatalf("PublishLog() error = %v", err)
+	}
+	p, ok := client.last("dd-door/door1/log")
+	if !ok || !p.Retained {
+		t.Fatalf("log entry not published retained")
+	}
+	var got DoorStatusLog
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &got); err != nil {
+		t.Fatalf("decode log payload: %v", err)
+	}
+	if got != entry {
+		t.Errorf("published log = %+v, want %+v", got, entry)
+	}
+}
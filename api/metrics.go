@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravypower/dd"
+	"github.com/looplab/fsm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// MeterName is the instrumentation scope used for the api package's metrics.
+const MeterName = "github.com/gravypower/dd/api"
+
+// Results recorded on the dd.door.events counter.
+const (
+	EventResultSuccess = "success"
+	EventResultNoop    = "noop" // event left the device in the same state
+	EventResultError   = "error"
+)
+
+// Results recorded on the dd.commands and dd.rpc.duration instruments.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// doorMetrics holds the OpenTelemetry instruments for door activity.
+type doorMetrics struct {
+	events          metric.Int64Counter
+	position        metric.Float64Histogram
+	currentPosition metric.Int64Gauge
+	available       metric.Int64Gauge
+	statusUpdates   metric.Int64Counter
+	commands        metric.Int64Counter
+	publishFailures metric.Int64Counter
+	rpcErrors       metric.Int64Counter
+	rpcDuration     metric.Float64Histogram
+}
+
+var metrics atomic.Pointer[doorMetrics]
+
+func init() {
+	if err := SetMeterProvider(otel.GetMeterProvider()); err != nil {
+		logger.WithError(err).Error("Failed to create door metrics")
+	}
+}
+
+// SetMeterProvider (re)creates the door instruments from mp. Until it's called the
+// global otel MeterProvider is used, which is a no-op unless the application sets one.
+func SetMeterProvider(mp metric.MeterProvider) error {
+	meter := mp.Meter(MeterName)
+
+	events, err := meter.Int64Counter("dd.door.events",
+		metric.WithDescription("Door FSM events processed"),
+		metric.WithUnit("{event}"))
+	if err != nil {
+		return err
+	}
+	position, err := meter.Float64Histogram("dd.door.position",
+		metric.WithDescription("Door position reported by the hub"),
+		metric.WithUnit("%"))
+	if err != nil {
+		return err
+	}
+
+	currentPosition, err := meter.Int64Gauge("dd.door.current_position",
+		metric.WithDescription("Last door position reported by the hub"),
+		metric.WithUnit("%"))
+	if err != nil {
+		return err
+	}
+	available, err := meter.Int64Gauge("dd.door.available",
+		metric.WithDescription("Whether the door is online (1) or offline (0)"))
+	if err != nil {
+		return err
+	}
+	statusUpdates, err := meter.Int64Counter("dd.status.updates",
+		metric.WithDescription("Door status updates received from the hub"),
+		metric.WithUnit("{update}"))
+	if err != nil {
+		return err
+	}
+	commands, err := meter.Int64Counter("dd.commands",
+		metric.WithDescription("Door commands sent to the hub"),
+		metric.WithUnit("{command}"))
+	if err != nil {
+		return err
+	}
+	publishFailures, err := meter.Int64Counter("dd.mqtt.publish.failures",
+		metric.WithDescription("MQTT publishes that failed or were skipped"),
+		metric.WithUnit("{publish}"))
+	if err != nil {
+		return err
+	}
+	rpcErrors, err := meter.Int64Counter("dd.rpc.errors",
+		metric.WithDescription("Hub RPCs that returned an error"),
+		metric.WithUnit("{error}"))
+	if err != nil {
+		return err
+	}
+	rpcDuration, err := meter.Float64Histogram("dd.rpc.duration",
+		metric.WithDescription("Time taken by hub RPCs, including waiting for the response"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+
+	metrics.Store(&doorMetrics{
+		events:          events,
+		position:        position,
+		currentPosition: currentPosition,
+		available:       available,
+		statusUpdates:   statusUpdates,
+		commands:        commands,
+		publishFailures: publishFailures,
+		rpcErrors:       rpcErrors,
+		rpcDuration:     rpcDuration,
+	})
+	return nil
+}
+
+// recordDoorEvent counts an FSM event for a device, classifying the outcome from err.
+func recordDoorEvent(ctx context.Context, deviceID, event string, err error) {
+	result := EventResultSuccess
+	if err != nil {
+		result = EventResultError
+		var noTransition fsm.NoTransitionError
+		if errors.As(err, &noTransition) {
+			result = EventResultNoop
+		}
+	}
+	metrics.Load().events.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("device_id", deviceID),
+		attribute.String("event", event),
+		attribute.String("result", result),
+	))
+}
+
+// RecordDoorStatus counts a status update and records the position of every device in it.
+func RecordDoorStatus(ctx context.Context, status *DoorStatus) {
+	m := metrics.Load()
+	m.statusUpdates.Add(ctx, 1)
+	for _, device := range status.Devices {
+		attrs := metric.WithAttributes(attribute.String("device_id", device.ID))
+		m.position.Record(ctx, float64(device.Device.Position), attrs)
+		m.currentPosition.Record(ctx, int64(device.Device.Position), attrs)
+	}
+}
+
+// recordAvailability sets the availability gauge for a device.
+func recordAvailability(ctx context.Context, deviceID string, online bool) {
+	var value int64
+	if online {
+		value = 1
+	}
+	metrics.Load().available.Record(ctx, value, metric.WithAttributes(
+		attribute.String("device_id", deviceID),
+	))
+}
+
+// recordCommand counts a command sent to a device.
+func recordCommand(ctx context.Context, deviceID string, command int, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	metrics.Load().commands.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("device_id", deviceID),
+		attribute.String("command", strconv.Itoa(command)),
+		attribute.String("result", result),
+	))
+}
+
+// recordPublishFailure counts a failed MQTT publish; reason is a short label like "timeout".
+func recordPublishFailure(ctx context.Context, reason string) {
+	metrics.Load().publishFailures.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("reason", reason),
+	))
+}
+
+// timedRPC performs rpc on conn, recording its duration and any error.
+func timedRPC(conn *dd.Conn, rpc dd.RPC) error {
+	return timedRPCContext(context.Background(), conn, rpc)
+}
+
+// timedRPCContext is timedRPC with a context bounding the request.
+func timedRPCContext(ctx context.Context, conn *dd.Conn, rpc dd.RPC) error {
+	start := time.Now()
+	err := conn.RPCContext(ctx, rpc)
+
+	m := metrics.Load()
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+		m.rpcErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("path", rpc.Path)))
+	}
+	m.rpcDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("path", rpc.Path),
+		attribute.String("result", result),
+	))
+	return err
+}
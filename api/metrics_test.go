@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// useTestMeterProvider routes door metrics to an in-memory reader for the test.
+func useTestMeterProvider(t *testing.T) *sdkmetric.ManualReader {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	if err := SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatalf("SetMeterProvider() error = %v", err)
+	}
+	t.Cleanup(func() {
+		SetMeterProvider(otel.GetMeterProvider())
+	})
+	return reader
+}
+
+// collectMetric returns the named metric from reader.
+func collectMetric(t *testing.T, reader *sdkmetric.ManualReader, name string) metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	t.Fatalf("metric %q not recorded", name)
+	return metricdata.Metrics{}
+}
+
+func TestDoorEventsCounter(t *testing.T) {
+	reader := useTestMeterProvider(t)
+	handler, _ := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+
+	ctx := context.Background()
+	df.Trigger(ctx, "go_online")
+	df.Trigger(ctx, "go_opened")
+	df.Trigger(ctx, "go_opened")  // already open
+	df.Trigger(ctx, "go_stopped") // invalid from open
+
+	sum, ok := collectMetric(t, reader, "dd.door.events").Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("dd.door.events is not an int64 sum")
+	}
+
+	counts := make(map[[2]string]int64)
+	for _, dp := range sum.DataPoints {
+		device, _ := dp.Attributes.Value(attribute.Key("device_id"))
+		if device.AsString() != "door1" {
+			t.Errorf("device_id = %q, want %q", device.AsString(), "door1")
+		}
+		event, _ := dp.Attributes.Value(attribute.Key("event"))
+		result, _ := dp.Attributes.Value(attribute.Key("result"))
+		counts[[2]string{event.AsString(), result.AsString()}] += dp.Value
+	}
+
+	want := map[[2]string]int64{
+		{"go_online", EventResultSuccess}: 1,
+		{"go_opened", EventResultSuccess}: 1,
+		{"go_opened", EventResultNoop}:    1,
+		{"go_stopped", EventResultError}:  1,
+	}
+	for k, v := range want {
+		if counts[k] != v {
+			t.Errorf("dd.door.events{event=%s,result=%s} = %d, want %d", k[0], k[1], counts[k], v)
+		}
+	}
+}
+
+func TestRecordDoorStatus(t *testing.T) {
+	reader := useTestMeterProvider(t)
+
+	status := &DoorStatus{Devices: []DoorStatusDevice{{ID: "door1"}, {ID: "door2"}}}
+	status.Devices[0].Device.Position = 100
+	status.Devices[1].Device.Position = 40
+	RecordDoorStatus(context.Background(), status)
+
+	hist, ok := collectMetric(t, reader, "dd.door.position").Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("dd.door.position is not a float64 histogram")
+	}
+	if len(hist.DataPoints) != 2 {
+		t.Fatalf("dd.door.position has %d series, want 2", len(hist.DataPoints))
+	}
+	for _, dp := range hist.DataPoints {
+		device, _ := dp.Attributes.Value(attribute.Key("device_id"))
+		want := map[string]float64{"door1": 100, "door2": 40}[device.AsString()]
+		if dp.Count != 1 || dp.Sum != want {
+			t.Errorf("dd.door.position{device_id=%s} count=%d sum=%v, want 1 and %v", device.AsString(), dp.Count, dp.Sum, want)
+		}
+	}
+}
+
+func TestAvailabilityGauge(t *testing.T) {
+	reader := useTestMeterProvider(t)
+	handler, _ := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+
+	ctx := context.Background()
+	df.Trigger(ctx, "go_online")
+	df.Trigger(ctx, "go_offline")
+
+	gauge, ok := collectMetric(t, reader, "dd.door.available").Data.(metricdata.Gauge[int64])
+	if !ok {
+		t.Fatalf("dd.door.available is not an int64 gauge")
+	}
+	if len(gauge.DataPoints) != 1 || gauge.DataPoints[0].Value != 0 {
+		t.Errorf("dd.door.available = %+v, want a single 0 (offline) point", gauge.DataPoints)
+	}
+}
+
+func TestStatusUpdateMetrics(t *testing.T) {
+	reader := useTestMeterProvider(t)
+	status := &DoorStatus{Devices: []DoorStatusDevice{{ID: "door1"}}}
+	status.Devices[0].Device.Position = 40
+
+	RecordDoorStatus(context.Background(), status)
+	RecordDoorStatus(context.Background(), status)
+
+	updates, ok := collectMetric(t, reader, "dd.status.updates").Data.(metricdata.Sum[int64])
+	if !ok || len(updates.DataPoints) != 1 || updates.DataPoints[0].Value != 2 {
+		t.Errorf("dd.status.updates = %+v, want 2", updates.DataPoints)
+	}
+	gauge, ok := collectMetric(t, reader, "dd.door.current_position").Data.(metricdata.Gauge[int64])
+	if !ok || len(gauge.DataPoints) != 1 || gauge.DataPoints[0].Value != 40 {
+		t.Errorf("dd.door.current_position = %+v, want 40", gauge.DataPoints)
+	}
+}
+
+func TestPublishFailureCounter(t *testing.T) {
+	reader := useTestMeterProvider(t)
+	handler, client := newTestHandler()
+	client.connected = false
+
+	handler.PublishStatus("dd-door", "door1", "open")
+
+	sum, ok := collectMetric(t, reader, "dd.mqtt.publish.failures").Data.(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 {
+		t.Fatalf("dd.mqtt.publish.failures = %+v, want one point", sum.DataPoints)
+	}
+	if reason, _ := sum.DataPoints[0].Attributes.Value("reason"); reason.AsString() != "not_connected" || sum.DataPoints[0].Value != 1 {
+		t.Errorf("dd.mqtt.publish.failures = %+v, want 1 not_connected", sum.DataPoints[0])
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// ObstructionConfigTopicTemplate is the HA discovery topic for a door's obstruction sensor
+	ObstructionConfigTopicTemplate = "homeassistant/binary_sensor/%s_obstruction/config"
+	// ObstructionTopicTemplate carries the obstruction state (ON/OFF)
+	ObstructionTopicTemplate = "%s/%s/obstruction"
+)
+
+// obstructionKeywords are matched, case-insensitively, against log entry text. The hub's
+// alert codes for obstructions aren't documented, so the text is all there is to go on.
+var obstructionKeywords = []string{"obstruct", "safety beam", "photo beam", "photocell", "revers"}
+
+// Reasons an Obstruction was reported
+const (
+	ObstructionReversed = "reversed"
+	ObstructionLog      = "log"
+)
+
+// Obstruction reports that a door reversed while closing, or logged an entry describing
+// an obstruction.
+type Obstruction struct {
+	DeviceID string
+	Reason   string // ObstructionReversed or ObstructionLog
+	Position int    // position when it was detected
+	Entry    *DoorStatusLog
+}
+
+func (Obstruction) Kind() EventKind { return EventObstruction }
+
+// ObstructionDetector recognises obstructions from a stream of device statuses: a door
+// whose position stops falling and starts rising before it's closed, as when a safety beam
+// is broken, or a log entry describing one. A detected obstruction stays set until the
+// door next closes fully, or Clear is called.
+type ObstructionDetector struct {
+	mu         sync.Mutex
+	last       map[string]DoorStatusDevice
+	closing    map[string]bool
+	obstructed map[string]bool
+}
+
+// Observe records a device's latest status and returns the obstruction it shows, if any.
+func (d *ObstructionDetector) Observe(device DoorStatusDevice) *Obstruction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last == nil {
+		d.last = make(map[string]DoorStatusDevice)
+		d.closing = make(map[string]bool)
+		d.obstructed = make(map[string]bool)
+	}
+
+	prev, seen := d.last[device.ID]
+	d.last[device.ID] = device
+	if !seen {
+		return nil
+	}
+
+	position, previous := device.Device.Position, prev.Device.Position
+	var obstruction *Obstruction
+	switch {
+	case position < previous:
+		d.closing[device.ID] = true
+	case position > previous:
+		if d.closing[device.ID] && previous > PositionClosed {
+			obstruction = &Obstruction{DeviceID: device.ID, Reason: ObstructionReversed, Position: position}
+		}
+		d.closing[device.ID] = false
+	}
+	if obstruction == nil && device.Log.ID != 0 && device.Log.ID != prev.Log.ID && describesObstruction(device.Log) {
+		entry := device.Log
+		obstruction = &Obstruction{DeviceID: device.ID, Reason: ObstructionLog, Position: position, Entry: &entry}
+	}
+
+	if obstruction != nil {
+		d.obstructed[device.ID] = true
+	} else if position == PositionClosed {
+		d.obstructed[device.ID] = false
+	}
+	return obstruction
+}
+
+// Obstructed reports whether an obstruction was detected on the device since it last closed.
+func (d *ObstructionDetector) Obstructed(deviceID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.obstructed[deviceID]
+}
+
+// Clear forgets a detected obstruction, e.g. one explained by a command to open.
+func (d *ObstructionDetector) Clear(deviceID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.obstructed, deviceID)
+}
+
+// describesObstruction reports whether a log entry's text mentions an obstruction.
+func describesObstruction(entry DoorStatusLog) bool {
+	text := strings.ToLower(entry.Text)
+	for _, keyword := range obstructionKeywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigureObstructionSensor publishes Home Assistant discovery for a binary sensor that
+// turns on when the door is obstructed.
+func (h *MQTTHandler) ConfigureObstructionSensor(mqttPrefix string, device DoorStatusDevice) error {
+	configPayload := map[string]interface{}{
+		"name":                  fmt.Sprintf("%s Obstruction", device.Name),
+		"state_topic":           fmt.Sprintf(ObstructionTopicTemplate, mqttPrefix, device.ID),
+		"availability_topic":    fmt.Sprintf(AvailabilityTopicTemplate, mqttPrefix, device.ID),
+		"payload_on":            PayloadOn,
+		"payload_off":           PayloadOff,
+		"payload_available":     "online",
+		"payload_not_available": "offline",
+		"device_class":          "problem",
+		"unique_id":             fmt.Sprintf("obstruction_%s", device.ID),
+		"device": map[string]interface{}{
+			"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+		},
+		"icon": "mdi:garage-alert",
+	}
+	bytes, err := json.Marshal(configPayload)
+	if err != nil {
+		return err
+	}
+	return h.publishToMQTT(fmt.Sprintf(ObstructionConfigTopicTemplate, device.ID), 0, true, bytes)
+}
+
+// PublishObstruction publishes whether a device is obstructed (retained).
+func (h *MQTTHandler) PublishObstruction(prefix, deviceID string, obstructed bool) error {
+	return h.publishOnOff(fmt.Sprintf(ObstructionTopicTemplate, prefix, deviceID), obstructed)
+}
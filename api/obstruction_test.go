@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestObstructionDetector_Observe(t *testing.T) {
+	logEntry := func(d DoorStatusDevice, id int64, text string) DoorStatusDevice {
+		d.Log = DoorStatusLog{ID: id, Text: text}
+		return d
+	}
+
+	tests := []struct {
+		name           string
+		positions      []int
+		last           DoorStatusDevice // observed after positions, if its ID is set
+		wantReason     string
+		wantObstructed bool
+	}{
+		{"closes normally", []int{100, 60, 20, 0}, DoorStatusDevice{}, "", false},
+		{"opens normally", []int{0, 40, 100}, DoorStatusDevice{}, "", false},
+		{"reverses while closing", []int{100, 60, 30, 50}, DoorStatusDevice{}, ObstructionReversed, true},
+		{"opens after closing", []int{100, 0, 40}, DoorStatusDevice{}, "", false},
+		{"cleared by closing", []int{100, 30, 50, 0}, DoorStatusDevice{}, ObstructionReversed, false},
+		{"log entry", []int{100}, logEntry(statusDevice("door1", 100, 0, 0), 7, "Safety beam obstructed"), ObstructionLog, true},
+		{"other log entry", []int{100}, logEntry(statusDevice("door1", 100, 0, 0), 7, "Opened by remote"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d ObstructionDetector
+			var got *Obstruction
+			for _, position := range tt.positions {
+				if o := d.Observe(statusDevice("door1", position, 0, 0)); o != nil {
+					got = o
+				}
+			}
+			if tt.last.ID != "" {
+				if o := d.Observe(tt.last); o != nil {
+					got = o
+				}
+			}
+
+			if tt.wantReason == "" && got != nil {
+				t.Errorf("Observe() = %+v, want none", got)
+			}
+			if tt.wantReason != "" && (got == nil || got.Reason != tt.wantReason) {
+				t.Errorf("Observe() = %+v, want reason %q", got, tt.wantReason)
+			}
+			if obstructed := d.Obstructed("door1"); obstructed != tt.wantObstructed {
+				t.Errorf("Obstructed() = %v, want %v", obstructed, tt.wantObstructed)
+			}
+		})
+	}
+}
+
+func TestObstructionDetector_Clear(t *testing.T) {
+	var d ObstructionDetector
+	for _, position := range []int{100, 30, 50} {
+		d.Observe(statusDevice("door1", position, 0, 0))
+	}
+	d.Clear("door1")
+	if d.Obstructed("door1") {
+		t.Errorf("Obstructed() after Clear = true, want false")
+	}
+}
+
+func TestMQTTHandler_ConfigureObstructionSensor(t *testing.T) {
+	handler, client := newTestHandler()
+	if err := handler.ConfigureObstructionSensor("dd-door", DoorStatusDevice{ID: "door1", Name: "Garage"}); err != nil {
+		t.Fatalf("ConfigureObstructionSensor() error = %v", err)
+	}
+	p, ok := client.last(fmt.Sprintf(ObstructionConfigTopicTemplate, "door1"))
+	if !ok {
+		t.Fatalf("no discovery config published")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("discovery config is not valid JSON: %v", err)
+	}
+	if config["state_topic"] != "dd-door/door1/obstruction" {
+		t.Errorf("state_topic = %v, want dd-door/door1/obstruction", config["state_topic"])
+	}
+
+	if err := handler.PublishObstruction("dd-door", "door1", true); err != nil {
+		t.Fatalf("PublishObstruction() error = %v", err)
+	}
+	if p, _ := client.last("dd-door/door1/obstruction"); payloadString(p.Payload) != PayloadOn || !p.Retained {
+		t.Errorf("obstruction = %q (retained %v), want retained %q", payloadString(p.Payload), p.Retained, PayloadOn)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gravypower/dd"
+)
+
+// ErrNoPhoneSecret is returned by RotatePhoneSecret when the hub doesn't send a new secret.
+var ErrNoPhoneSecret = errors.New("no phone secret in response")
+
+// passwordChangeRequest is the input to /app/res/user/password.
+type passwordChangeRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// phoneSecretResponse is the wire format returned by /app/res/phone/secret.
+type phoneSecretResponse struct {
+	PhoneSecret string `json:"phoneSecret"`
+}
+
+// ChangeUserPassword changes the connected user's password, e.g. once
+// conn.IsPasswordExpired reports it has expired. The stored credentials' UserPassword
+// must be updated to match before the next Connect.
+func ChangeUserPassword(conn *dd.Conn, oldPassword, newPassword string) error {
+	err := timedRPC(conn, dd.RPC{
+		Path:  "/app/res/user/password",
+		Input: passwordChangeRequest{OldPassword: oldPassword, NewPassword: newPassword},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not change user password")
+	}
+	return err
+}
+
+// RotatePhoneSecret asks the hub for a new phone secret, returning it. The old secret
+// stops working, so the new one must be saved before the next Connect.
+func RotatePhoneSecret(conn *dd.Conn) (string, error) {
+	var resp phoneSecretResponse
+	err := timedRPC(conn, dd.RPC{
+		Path:   "/app/res/phone/secret",
+		Output: &resp,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not rotate phone secret")
+		return "", err
+	}
+	if resp.PhoneSecret == "" {
+		return "", ErrNoPhoneSecret
+	}
+	return resp.PhoneSecret, nil
+}
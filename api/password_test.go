@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gravypower/dd/ddtest"
+)
+
+func TestChangeUserPassword(t *testing.T) {
+	server, conn := connectTestServer(t)
+	var got map[string]interface{}
+	server.Handle("/app/res/user/password", func(body []byte) (interface{}, error) {
+		return nil, json.Unmarshal(body, &got)
+	})
+
+	if err := ChangeUserPassword(conn, "old", "new"); err != nil {
+		t.Fatalf("ChangeUserPassword() error = %v", err)
+	}
+	want := map[string]interface{}{"oldPassword": "old", "newPassword": "new"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("/app/res/user/password request = %v, want %v", got, want)
+	}
+}
+
+func TestRotatePhoneSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    interface{}
+		want    string
+		wantErr error
+	}{
+		{"rotated", phoneSecretResponse{PhoneSecret: "fresh"}, "fresh", nil},
+		{"missing", map[string]interface{}{}, "", ErrNoPhoneSecret},
+	}
+
+	server, conn := connectTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.Handle("/app/res/phone/secret", func([]byte) (interface{}, error) {
+				return tt.resp, nil
+			})
+
+			got, err := RotatePhoneSecret(conn)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RotatePhoneSecret() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RotatePhoneSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPasswordExpired(t *testing.T) {
+	for _, expired := range []bool{false, true} {
+		server := ddtest.NewServer()
+		server.SetPasswordExpired(expired)
+		conn := server.Conn()
+		if err := conn.Connect(server.Credential); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if got := conn.IsPasswordExpired(); got != expired {
+			t.Errorf("IsPasswordExpired() = %v, want %v", got, expired)
+		}
+		conn.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// QueueTopicTemplate carries a device's command queue state as JSON, for diagnostics
+const QueueTopicTemplate = "%s/%s/queue"
+
+// Actions a QueuedCommand can take
+const (
+	QueueOpen     = "open"
+	QueueClose    = "close"
+	QueueStop     = "stop"
+	QueuePosition = "position"
+)
+
+// DefaultReverseDelay is how long a CommandQueue lets a door settle after stopping it to
+// reverse direction.
+const DefaultReverseDelay = time.Second
+
+// QueuedCommand is a command waiting in, or being sent by, a CommandQueue.
+type QueuedCommand struct {
+	Action   string `json:"action"`
+	Position int    `json:"position,omitempty"` // for QueuePosition
+	// Source is where the command came from, recorded in the transitions it causes. It
+	// doesn't make otherwise identical commands distinct.
+	Source string `json:"source,omitempty"`
+}
+
+// same reports whether c and other do the same thing, whatever their sources.
+func (c QueuedCommand) same(other QueuedCommand) bool {
+	return c.Action == other.Action && c.Position == other.Position
+}
+
+// isAction returns a func reporting whether a command's action is action.
+func isAction(action string) func(QueuedCommand) bool {
+	return func(c QueuedCommand) bool { return c.Action == action }
+}
+
+// opposite returns the motion action that reverses c's, or "" if it has none.
+func (c QueuedCommand) opposite() string {
+	switch c.Action {
+	case QueueOpen:
+		return QueueClose
+	case QueueClose:
+		return QueueOpen
+	}
+	return ""
+}
+
+// movingStates maps motion actions to the FSM state of a door carrying them out.
+var movingStates = map[string]string{
+	QueueOpen:  "opening",
+	QueueClose: "closing",
+}
+
+// CommandQueueState is a snapshot of a CommandQueue, as published on its queue topic.
+type CommandQueueState struct {
+	InFlight *QueuedCommand  `json:"inFlight"`
+	Pending  []QueuedCommand `json:"pending"`
+}
+
+// CommandQueue serializes a device's commands so they don't collide with each other or
+// with the door's motion. Submitting a command the door is already carrying out, or that
+// is already queued, does nothing; a stop drops queued motion; and reversing a moving door
+// (open while closing) stops it first. Commands are sent one at a time by a worker that
+// only runs while the queue has work.
+type CommandQueue struct {
+	fsm *DeviceFSM
+
+	// ReverseDelay is how long to wait after stopping a door before reversing it.
+	// Zero uses DefaultReverseDelay.
+	ReverseDelay time.Duration
+
+	mu       sync.Mutex
+	pending  []QueuedCommand
+	inFlight *QueuedCommand
+	running  bool
+
+	execute func(QueuedCommand) // defaults to run; replaced in tests
+}
+
+func newCommandQueue(fsm *DeviceFSM) *CommandQueue {
+	q := &CommandQueue{fsm: fsm}
+	q.execute = q.run
+	return q
+}
+
+// Submit queues cmd, returning false if it was dropped as a duplicate.
+func (q *CommandQueue) Submit(cmd QueuedCommand) bool {
+	state := q.fsm.Current()
+
+	q.mu.Lock()
+	if q.duplicate(cmd, state) {
+		q.mu.Unlock()
+		q.log().WithField("command", cmd).Info("Dropping duplicate command")
+		return false
+	}
+
+	switch {
+	case cmd.Action == QueueStop:
+		// Stopping supersedes anything still waiting to move the door
+		q.pending = slices.DeleteFunc(q.pending, func(c QueuedCommand) bool { return c.Action != QueueStop })
+	case cmd.opposite() != "":
+		opposite := cmd.opposite()
+		q.pending = slices.DeleteFunc(q.pending, isAction(opposite))
+		moving := state == movingStates[opposite] || (q.inFlight != nil && q.inFlight.Action == opposite)
+		if moving && !slices.ContainsFunc(q.pending, isAction(QueueStop)) {
+			q.pending = append(q.pending, QueuedCommand{Action: QueueStop, Source: cmd.Source})
+		}
+	}
+	q.pending = append(q.pending, cmd)
+
+	start := !q.running
+	q.running = true
+	q.mu.Unlock()
+
+	q.publish()
+	if start {
+		go q.work()
+	}
+	return true
+}
+
+// duplicate reports whether cmd is already queued, in flight, or being carried out by the
+// door. q.mu must be held.
+func (q *CommandQueue) duplicate(cmd QueuedCommand, state string) bool {
+	if slices.ContainsFunc(q.pending, cmd.same) {
+		return true
+	}
+	if q.inFlight != nil && q.inFlight.same(cmd) && len(q.pending) == 0 {
+		return true
+	}
+	if len(q.pending) > 0 || q.inFlight != nil {
+		return false
+	}
+	if cmd.Action == QueueStop {
+		return state == "stopping" || state == "stopped"
+	}
+	return state != "" && state == movingStates[cmd.Action]
+}
+
+// State returns a snapshot of the queue.
+func (q *CommandQueue) State() CommandQueueState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state := CommandQueueState{Pending: slices.Clone(q.pending)}
+	if state.Pending == nil {
+		state.Pending = []QueuedCommand{}
+	}
+	if q.inFlight != nil {
+		inFlight := *q.inFlight
+		state.InFlight = &inFlight
+	}
+	return state
+}
+
+// work sends queued commands in order until the queue is empty.
+func (q *CommandQueue) work() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.inFlight = nil
+			q.running = false
+			q.mu.Unlock()
+			q.publish()
+			return
+		}
+		cmd := q.pending[0]
+		q.pending = q.pending[1:]
+		q.inFlight = &cmd
+		q.mu.Unlock()
+
+		q.publish()
+		q.execute(cmd)
+	}
+}
+
+// run sends cmd through the device's FSM, or straight to the hub for a position.
+func (q *CommandQueue) run(cmd QueuedCommand) {
+	ctx := WithSource(context.Background(), cmd.Source)
+	log := q.log().WithField("command", cmd)
+
+	var event string
+	switch cmd.Action {
+	case QueueOpen:
+		event = "go_open"
+	case QueueClose:
+		event = "go_close"
+	case QueueStop:
+		event = "go_stop"
+	case QueuePosition:
+		if err := SafeCommand(q.fsm.Conn, q.fsm.ID, GetCommandForPosition(cmd.Position)); err != nil {
+			log.WithError(err).Error("Failed to execute position command")
+			return
+		}
+		log.Info("Position command executed successfully")
+		return
+	default:
+		log.Warn("Unknown queued command")
+		return
+	}
+
+	if event != "go_stop" && q.fsm.Current() == "stopping" {
+		// The door was stopped to reverse it; let it settle, then let the FSM move again
+		delay := q.ReverseDelay
+		if delay == 0 {
+			delay = DefaultReverseDelay
+		}
+		time.Sleep(delay)
+		if err := q.fsm.Trigger(ctx, "go_stopped"); err != nil {
+			log.WithError(err).Warn("Failed to process 'go_stopped' event")
+		}
+	}
+	if err := q.fsm.Trigger(ctx, event); err != nil {
+		log.WithError(err).Error("Failed to process '" + event + "' event")
+	}
+}
+
+// publish sends the queue state to the device's queue topic.
+func (q *CommandQueue) publish() {
+	if q.fsm.mqttHandler == nil {
+		return
+	}
+	if err := q.fsm.mqttHandler.PublishQueueState(q.fsm.MQTTPrefix, q.fsm.ID, q.State()); err != nil {
+		q.log().WithError(err).Debug("Failed to publish queue state")
+	}
+}
+
+func (q *CommandQueue) log() *logrus.Entry {
+	return q.fsm.mqttHandler.log().WithField("deviceID", q.fsm.ID)
+}
+
+// PublishQueueState publishes a device's command queue state as JSON.
+func (h *MQTTHandler) PublishQueueState(prefix, deviceID string, state CommandQueueState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode queue state: %w", err)
+	}
+	return h.publishToMQTT(fmt.Sprintf(QueueTopicTemplate, prefix, deviceID), 0, false, payload)
+}
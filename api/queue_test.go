@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestQueue returns a queue whose commands are recorded rather than sent. Commands
+// block until release is called, so tests can submit while one is in flight; submit waits
+// for the first command to be picked up.
+func newTestQueue(state string) (q *CommandQueue, submit func(...QueuedCommand), executed func() []QueuedCommand, release func(), client *mockClient) {
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.FSM.SetState(state)
+
+	var got []QueuedCommand
+	gate := make(chan struct{})
+	started := make(chan QueuedCommand, 16)
+	q = df.Queue()
+	q.execute = func(cmd QueuedCommand) {
+		started <- cmd
+		<-gate
+	}
+	submit = func(cmds ...QueuedCommand) {
+		for i, cmd := range cmds {
+			if q.Submit(cmd) && i == 0 {
+				got = append(got, <-started)
+			}
+		}
+	}
+	executed = func() []QueuedCommand {
+		for {
+			select {
+			case cmd := <-started:
+				got = append(got, cmd)
+			case <-time.After(50 * time.Millisecond):
+				return got
+			}
+		}
+	}
+	release = func() { close(gate) }
+	return q, submit, executed, release, client
+}
+
+func TestCommandQueue_Submit(t *testing.T) {
+	open := QueuedCommand{Action: QueueOpen}
+	closeCmd := QueuedCommand{Action: QueueClose}
+	stop := QueuedCommand{Action: QueueStop}
+	position := QueuedCommand{Action: QueuePosition, Position: 40}
+
+	tests := []struct {
+		name   string
+		state  string
+		submit []QueuedCommand
+		want   []QueuedCommand
+	}{
+		{"serializes in order", "closed", []QueuedCommand{open, position}, []QueuedCommand{open, position}},
+		{"drops queued duplicates", "closed", []QueuedCommand{position, open, open}, []QueuedCommand{position, open}},
+		{"drops in-flight duplicate", "closed", []QueuedCommand{open, open}, []QueuedCommand{open}},
+		{"drops motion already under way", "opening", []QueuedCommand{open}, nil},
+		{"stops before reversing", "closing", []QueuedCommand{open}, []QueuedCommand{stop, open}},
+		{"reverses in-flight motion", "closed", []QueuedCommand{open, closeCmd}, []QueuedCommand{open, stop, closeCmd}},
+		{"cancels queued opposite", "closed", []QueuedCommand{position, open, closeCmd}, []QueuedCommand{position, closeCmd}},
+		{"stop drops queued motion", "closed", []QueuedCommand{position, open, stop}, []QueuedCommand{position, stop}},
+		{"duplicates ignore source", "closed", []QueuedCommand{position, open, {Action: QueueOpen, Source: SourceSchedule}}, []QueuedCommand{position, open}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, submit, executed, release, _ := newTestQueue(tt.state)
+			submit(tt.submit...)
+			release()
+			if got := executed(); !slices.Equal(got, tt.want) {
+				t.Errorf("executed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandQueue_PublishesState(t *testing.T) {
+	q, submit, executed, release, client := newTestQueue("closed")
+	submit(QueuedCommand{Action: QueueOpen}, QueuedCommand{Action: QueuePosition, Position: 40})
+
+	topic := fmt.Sprintf(QueueTopicTemplate, "dd-door", "door1")
+	p, ok := client.last(topic)
+	if !ok {
+		t.Fatalf("nothing published to %s", topic)
+	}
+	var state CommandQueueState
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &state); err != nil {
+		t.Fatalf("queue state is not valid JSON: %v", err)
+	}
+	if state.InFlight == nil || *state.InFlight != (QueuedCommand{Action: QueueOpen}) {
+		t.Errorf("inFlight = %v, want open", state.InFlight)
+	}
+	if want := []QueuedCommand{{Action: QueuePosition, Position: 40}}; !slices.Equal(state.Pending, want) {
+		t.Errorf("pending = %v, want %v", state.Pending, want)
+	}
+
+	release()
+	executed()
+	if state := q.State(); state.InFlight != nil || len(state.Pending) != 0 {
+		t.Errorf("State() after draining = %+v, want empty", state)
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"maps"
+	"sync"
+)
+
+// DeviceRegistry holds the FSMs for a bridge's devices, by device ID. Each bridge owns
+// its own, so several can run in one process. It's safe for concurrent use, and the zero
+// value is an empty registry.
+type DeviceRegistry struct {
+	mu      sync.RWMutex
+	devices map[string]*DeviceFSM
+}
+
+// NewDeviceRegistry returns an empty registry.
+func NewDeviceRegistry() *DeviceRegistry {
+	return &DeviceRegistry{devices: make(map[string]*DeviceFSM)}
+}
+
+// Get returns the FSM registered for deviceID.
+func (r *DeviceRegistry) Get(deviceID string) (*DeviceFSM, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fsm, exists := r.devices[deviceID]
+	return fsm, exists
+}
+
+// Set registers fsm for deviceID, replacing any FSM already registered for it.
+func (r *DeviceRegistry) Set(deviceID string, fsm *DeviceFSM) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.devices == nil {
+		r.devices = make(map[string]*DeviceFSM)
+	}
+	r.devices[deviceID] = fsm
+}
+
+// Delete removes the FSM registered for deviceID, if any.
+func (r *DeviceRegistry) Delete(deviceID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.devices, deviceID)
+}
+
+// All returns a copy of the registered FSMs, so callers can range over it without
+// holding the registry's lock.
+func (r *DeviceRegistry) All() map[string]*DeviceFSM {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return maps.Clone(r.devices)
+}
+
+var (
+	// DeviceFSMs holds the default registry's devices.
+	//
+	// Deprecated: Create a DeviceRegistry with NewDeviceRegistry and use its methods.
+	DeviceFSMs = make(map[string]*DeviceFSM)
+
+	// defaultRegistry backs the deprecated package-level helpers.
+	defaultRegistry = &DeviceRegistry{devices: DeviceFSMs}
+)
+
+// GetDeviceFSM safely retrieves a device FSM by ID from the default registry.
+//
+// Deprecated: Use DeviceRegistry.Get.
+func GetDeviceFSM(deviceID string) (*DeviceFSM, bool) {
+	return defaultRegistry.Get(deviceID)
+}
+
+// SetDeviceFSM safely sets a device FSM in the default registry.
+//
+// Deprecated: Use DeviceRegistry.Set.
+func SetDeviceFSM(deviceID string, fsm *DeviceFSM) {
+	defaultRegistry.Set(deviceID, fsm)
+}
+
+// GetAllDeviceFSMs safely returns all device FSMs in the default registry.
+//
+// Deprecated: Use DeviceRegistry.All.
+func GetAllDeviceFSMs() map[string]*DeviceFSM {
+	return defaultRegistry.All()
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDeviceRegistry(t *testing.T) {
+	handler, _ := newTestHandler()
+	door1 := NewDeviceFSM("door1", "dd-door", nil, handler)
+	door2 := NewDeviceFSM("door2", "dd-door", nil, handler)
+
+	var r DeviceRegistry
+	if _, ok := r.Get("door1"); ok {
+		t.Errorf("Get() on an empty registry found a device")
+	}
+	r.Set("door1", door1)
+	r.Set("door2", door2)
+	if got, ok := r.Get("door1"); !ok || got != door1 {
+		t.Errorf("Get(door1) = %v, %v, want door1's FSM", got, ok)
+	}
+
+	all := r.All()
+	delete(all, "door1")
+	if _, ok := r.Get("door1"); !ok {
+		t.Errorf("changing All()'s result changed the registry")
+	}
+
+	r.Delete("door1")
+	if _, ok := r.Get("door1"); ok {
+		t.Errorf("Get(door1) after Delete() found a device")
+	}
+	if len(r.All()) != 1 {
+		t.Errorf("All() = %v, want only door2", r.All())
+	}
+}
+
+func TestDeviceRegistry_Isolated(t *testing.T) {
+	handler, _ := newTestHandler()
+	a, b := NewDeviceRegistry(), NewDeviceRegistry()
+
+	a.ConfigureDevice(handler, nil, "dd-door", DoorStatusDevice{ID: "isolated1"}, BasicInfo{}, DeviceOptions{})
+	if _, ok := a.Get("isolated1"); !ok {
+		t.Errorf("ConfigureDevice() didn't register the device")
+	}
+	if _, ok := b.Get("isolated1"); ok {
+		t.Errorf("device registered in one registry is visible in another")
+	}
+	if _, ok := GetDeviceFSM("isolated1"); ok {
+		t.Errorf("device registered in a registry is visible in the default registry")
+	}
+}
+
+func TestDeviceRegistry_Concurrent(t *testing.T) {
+	handler, _ := newTestHandler()
+	r := NewDeviceRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("door%d", i)
+			r.Set(id, NewDeviceFSM(id, "dd-door", nil, handler))
+			r.Get(id)
+			r.All()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(r.All()) != 10 {
+		t.Errorf("All() has %d devices, want 10", len(r.All()))
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gravypower/dd"
+)
+
+// AccessWindow is a recurring period during which the user may operate the hub.
+type AccessWindow struct {
+	Start      time.Time
+	End        time.Time
+	DaysOfWeek []time.Weekday
+}
+
+// AccessSchedule lists the access windows configured for the connected user.
+type AccessSchedule struct {
+	Windows []AccessWindow
+}
+
+// accessScheduleResponse is the wire format returned by /app/res/schedule/fetch.
+// Times are epoch milliseconds, days are 0 (Sunday) to 6 (Saturday).
+type accessScheduleResponse struct {
+	Windows []struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+		Days  []int `json:"days"`
+	} `json:"windows"`
+}
+
+// schedule converts the wire format into an AccessSchedule, dropping invalid days.
+func (r *accessScheduleResponse) schedule() *AccessSchedule {
+	out := &AccessSchedule{}
+	for _, w := range r.Windows {
+		window := AccessWindow{
+			Start: time.UnixMilli(w.Start),
+			End:   time.UnixMilli(w.End),
+		}
+		for _, d := range w.Days {
+			if d < int(time.Sunday) || d > int(time.Saturday) {
+				logger.WithField("day", d).Warn("Ignoring invalid day in access schedule")
+				continue
+			}
+			window.DaysOfWeek = append(window.DaysOfWeek, time.Weekday(d))
+		}
+		out.Windows = append(out.Windows, window)
+	}
+	return out
+}
+
+// FetchAccessSchedule fetches the access schedule for the connected user.
+func FetchAccessSchedule(conn *dd.Conn) (*AccessSchedule, error) {
+	var resp accessScheduleResponse
+	err := timedRPC(conn, dd.RPC{
+		Path:   "/app/res/schedule/fetch",
+		Output: &resp,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not fetch access schedule")
+		return nil, err
+	}
+	return resp.schedule(), nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAccessScheduleResponse_Schedule(t *testing.T) {
+	fixture := `{
+		"windows": [
+			{"start": 1700000000000, "end": 1700003600000, "days": [1, 2, 3, 4, 5]},
+			{"start": 1700100000000, "end": 1700110000000, "days": [0, 6, 9]}
+		]
+	}`
+
+	var resp accessScheduleResponse
+	if err := json.Unmarshal([]byte(fixture), &resp); err != nil {
+		t.Fatalf("failed to parse fixture: %v", err)
+	}
+	schedule := resp.schedule()
+
+	if len(schedule.Windows) != 2 {
+		t.Fatalf("schedule has %d windows, want 2", len(schedule.Windows))
+	}
+
+	weekdays := schedule.Windows[0]
+	if !weekdays.Start.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("window[0].Start = %v, want %v", weekdays.Start, time.UnixMilli(1700000000000))
+	}
+	if got := weekdays.End.Sub(weekdays.Start); got != time.Hour {
+		t.Errorf("window[0] duration = %v, want 1h", got)
+	}
+	wantDays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	if len(weekdays.DaysOfWeek) != len(wantDays) {
+		t.Fatalf("window[0].DaysOfWeek = %v, want %v", weekdays.DaysOfWeek, wantDays)
+	}
+	for i, d := range wantDays {
+		if weekdays.DaysOfWeek[i] != d {
+			t.Errorf("window[0].DaysOfWeek[%d] = %v, want %v", i, weekdays.DaysOfWeek[i], d)
+		}
+	}
+
+	// Invalid day 9 should be dropped
+	weekend := schedule.Windows[1]
+	if len(weekend.DaysOfWeek) != 2 || weekend.DaysOfWeek[0] != time.Sunday || weekend.DaysOfWeek[1] != time.Saturday {
+		t.Errorf("window[1].DaysOfWeek = %v, want [Sunday Saturday]", weekend.DaysOfWeek)
+	}
+}
+
+func TestAccessScheduleResponse_Empty(t *testing.T) {
+	var resp accessScheduleResponse
+	if err := json.Unmarshal([]byte(`{}`), &resp); err != nil {
+		t.Fatalf("failed to parse fixture: %v", err)
+	}
+	if got := resp.schedule(); len(got.Windows) != 0 {
+		t.Errorf("empty response produced %d windows, want 0", len(got.Windows))
+	}
+}
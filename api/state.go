@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// restingStates are the FSM states worth saving: where a door ends up, rather than the
+// motion or availability on the way there.
+var restingStates = map[string]bool{
+	"open":           true,
+	"closed":         true,
+	"partially_open": true,
+	"stopped":        true,
+}
+
+// SavedDevice is a device's last known resting state and position, and its stats.
+type SavedDevice struct {
+	MQTTPrefix string    `json:"mqttPrefix"`
+	State      string    `json:"state"`
+	Position   int       `json:"position"`
+	Updated    time.Time `json:"updated"`
+
+	Stats DeviceStats `json:"stats"`
+}
+
+// StateStore keeps each device's last known state in a JSON file, so a restarted bridge
+// can publish it straight away rather than leaving Home Assistant without a state until
+// the hub is polled. It's safe for concurrent use.
+type StateStore struct {
+	path string
+
+	mu      sync.Mutex
+	devices map[string]SavedDevice
+}
+
+// OpenStateStore reads the states saved at path. A missing file is an empty store, and
+// is created on the first save.
+func OpenStateStore(path string) (*StateStore, error) {
+	s := &StateStore{path: path, devices: make(map[string]SavedDevice)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.devices); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the saved state of deviceID.
+func (s *StateStore) Get(deviceID string) (SavedDevice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.devices[deviceID]
+	return saved, ok
+}
+
+// All returns a copy of every saved state, by device ID.
+func (s *StateStore) All() map[string]SavedDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.devices)
+}
+
+// Save records the state of deviceID and writes the file. Saving the state, position and
+// stats already recorded doesn't rewrite it.
+func (s *StateStore) Save(deviceID string, saved SavedDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.devices[deviceID]; ok && prev.MQTTPrefix == saved.MQTTPrefix &&
+		prev.State == saved.State && prev.Position == saved.Position && prev.Stats.Updated.Equal(saved.Stats.Updated) {
+		return nil
+	}
+	s.devices[deviceID] = saved
+
+	b, err := json.MarshalIndent(s.devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write beside the file and rename over it, so a crash mid-write leaves the old states
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// PublishSavedState publishes a device's saved state and position, e.g. on startup before
+// the hub has been polled.
+func (h *MQTTHandler) PublishSavedState(deviceID string, saved SavedDevice) error {
+	if status, ok := publishedStates[saved.State]; ok {
+		if err := h.PublishStatus(saved.MQTTPrefix, deviceID, status); err != nil {
+			return err
+		}
+	}
+	return h.PublishPosition(saved.MQTTPrefix, deviceID, saved.Position)
+}
+
+// saveState records the device's state in its StateStore, if it has one and the state is
+// a resting one.
+func (d *DeviceFSM) saveState(state string) {
+	if d.States == nil || !restingStates[state] {
+		return
+	}
+	saved := SavedDevice{
+		MQTTPrefix: d.MQTTPrefix,
+		State:      state,
+		Position:   d.travel.lastPosition(),
+		Updated:    time.Now(),
+		Stats:      d.Stats(),
+	}
+	if err := d.States.Save(d.ID, saved); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Warn("Failed to save device state")
+	}
+}
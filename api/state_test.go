@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateStore_SaveAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := OpenStateStore(path)
+	if err != nil {
+		t.Fatalf("OpenStateStore() on a missing file error = %v", err)
+	}
+	if len(s.All()) != 0 {
+		t.Errorf("new store has states %v", s.All())
+	}
+
+	if err := s.Save("door1", SavedDevice{MQTTPrefix: "dd-door", State: "partially_open", Position: 40}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reopened, err := OpenStateStore(path)
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	saved, ok := reopened.Get("door1")
+	if !ok || saved.State != "partially_open" || saved.Position != 40 || saved.MQTTPrefix != "dd-door" {
+		t.Errorf("Get(door1) = %+v, %v, want partially_open at 40 under dd-door", saved, ok)
+	}
+}
+
+func TestStateStore_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStateStore(path); err == nil {
+		t.Errorf("OpenStateStore() on a corrupt file error = nil")
+	}
+}
+
+func TestDeviceFSM_SavesRestingState(t *testing.T) {
+	states, err := OpenStateStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	handler, _ := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.States = states
+
+	ctx := context.Background()
+	df.Trigger(ctx, "go_online")
+	if _, ok := states.Get("door1"); ok {
+		t.Errorf("online state was saved")
+	}
+
+	df.Trigger(ctx, "go_closed")
+	if saved, _ := states.Get("door1"); saved.State != "closed" || saved.Position != PositionClosed {
+		t.Errorf("saved = %+v, want closed at %d", saved, PositionClosed)
+	}
+
+	df.ObservePosition(30)
+	df.Trigger(ctx, "go_partially_opened")
+	df.ObservePosition(45)
+	if saved, _ := states.Get("door1"); saved.State != "partially_open" || saved.Position != 45 {
+		t.Errorf("saved = %+v, want partially_open at 45", saved)
+	}
+}
+
+func TestMQTTHandler_PublishSavedState(t *testing.T) {
+	handler, client := newTestHandler()
+	saved := SavedDevice{MQTTPrefix: "dd-door", State: "partially_open", Position: 40}
+	if err := handler.PublishSavedState("door1", saved); err != nil {
+		t.Fatalf("PublishSavedState() error = %v", err)
+	}
+
+	if p, ok := client.last(fmt.Sprintf(StateTopicTemplate, "dd-door", "door1")); !ok || payloadString(p.Payload) != "open" {
+		t.Errorf("state published = %v, want open", p.Payload)
+	}
+	if p, ok := client.last(fmt.Sprintf(PositionTopicTemplate, "dd-door", "door1")); !ok || payloadString(p.Payload) != "40" {
+		t.Errorf("position published = %v, want 40", p.Payload)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StatsTopicTemplate carries a device's cycle counters and runtime statistics as JSON
+const StatsTopicTemplate = "%s/%s/stats"
+
+// doorStates are the FSM states that say whether the door is closed, as opposed to the
+// bridge's view of it (initial, online and offline).
+var doorStates = map[string]bool{
+	"opening":        true,
+	"closing":        true,
+	"open":           true,
+	"closed":         true,
+	"partially_open": true,
+	"stopping":       true,
+	"stopped":        true,
+}
+
+// DeviceStats counts a door's cycles and how long it's been open, e.g. for a maintenance
+// reminder after so many cycles. The door opens when it leaves closed and closes when it
+// gets back there; the first state seen for a door isn't counted as either.
+type DeviceStats struct {
+	Opens  int `json:"opens"`
+	Closes int `json:"closes"`
+	// OpenToday is how long the door has been open on Day (local time) as of Updated, in
+	// seconds
+	OpenToday  float64   `json:"openTodaySeconds"`
+	Day        string    `json:"day,omitempty"` // YYYY-MM-DD
+	LastOpened time.Time `json:"lastOpened"`
+	OpenSince  time.Time `json:"openSince"` // zero while the door is closed
+	Updated    time.Time `json:"updated"`
+}
+
+// advance adds the time the door has been open since Updated to OpenToday, starting
+// again from zero on a new day.
+func (s *DeviceStats) advance(now time.Time) {
+	day := now.Format(time.DateOnly)
+	if s.Day != day {
+		s.Day = day
+		s.OpenToday = 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := s.Updated
+	if from.Before(midnight) {
+		from = midnight
+	}
+	if !s.OpenSince.IsZero() && now.After(from) {
+		s.OpenToday += now.Sub(from).Seconds()
+	}
+	s.Updated = now
+}
+
+// observe updates the stats for the door entering state at now. known is whether the
+// door's previous state was seen; if not, state is taken as where the door already was.
+func (s *DeviceStats) observe(state string, known bool, now time.Time) {
+	s.advance(now)
+	closed := state == "closed"
+	switch {
+	case closed && !s.OpenSince.IsZero():
+		if known {
+			s.Closes++
+		}
+		s.OpenSince = time.Time{}
+	case !closed && s.OpenSince.IsZero():
+		if known {
+			s.Opens++
+			s.LastOpened = now
+		}
+		s.OpenSince = now
+	}
+}
+
+// Stats returns the device's cycle counters and runtime statistics.
+func (d *DeviceFSM) Stats() DeviceStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loadStats()
+	return d.stats
+}
+
+// loadStats restores the stats saved in States the first time they're needed. d.mu must
+// be held.
+func (d *DeviceFSM) loadStats() {
+	if d.statsLoaded || d.States == nil {
+		return
+	}
+	d.statsLoaded = true
+	if saved, ok := d.States.Get(d.ID); ok && !saved.Stats.Updated.IsZero() {
+		d.stats = saved.Stats
+		d.statsKnown = true
+	}
+}
+
+// observeStats updates the device's stats for it entering state, and publishes them.
+func (d *DeviceFSM) observeStats(state string) {
+	if !doorStates[state] {
+		return
+	}
+	d.mu.Lock()
+	d.loadStats()
+	d.stats.observe(state, d.statsKnown, time.Now())
+	d.statsKnown = true
+	stats := d.stats
+	d.mu.Unlock()
+
+	if d.mqttHandler == nil {
+		return
+	}
+	if err := d.mqttHandler.PublishStats(d.MQTTPrefix, d.ID, stats); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Error("Failed to publish stats")
+	}
+}
+
+// PublishStats publishes a device's stats as retained JSON.
+func (h *MQTTHandler) PublishStats(prefix, deviceID string, stats DeviceStats) error {
+	return h.publishJSON(fmt.Sprintf(StatsTopicTemplate, prefix, deviceID), stats)
+}
+
+// statsSensor describes one HA sensor reading a field from the stats topic.
+type statsSensor struct {
+	suffix        string // appended to the entity ID and name
+	name          string
+	valueTemplate string
+	deviceClass   string
+	unit          string
+	stateClass    string
+	icon          string
+}
+
+var statsSensors = []statsSensor{
+	{"opens", "Open Cycles", "{{ value_json.opens }}", "", "", "total_increasing", "mdi:counter"},
+	{"closes", "Close Cycles", "{{ value_json.closes }}", "", "", "total_increasing", "mdi:counter"},
+	{"open_today", "Open Time Today", "{{ value_json.openTodaySeconds | round(0) }}", "duration", "s", "total_increasing", ""},
+	{"last_opened", "Last Opened", "{{ value_json.lastOpened if value_json.opens else None }}", "timestamp", "", "", ""},
+}
+
+// ConfigureStats publishes Home Assistant discovery for sensors showing a device's
+// cycle counters, open time today and when it was last opened.
+func (h *MQTTHandler) ConfigureStats(mqttPrefix string, device DoorStatusDevice) error {
+	stateTopic := fmt.Sprintf(StatsTopicTemplate, mqttPrefix, device.ID)
+	for _, sensor := range statsSensors {
+		entityID := fmt.Sprintf("%s_%s", device.ID, sensor.suffix)
+		configPayload := map[string]interface{}{
+			"name":            fmt.Sprintf("%s %s", device.Name, sensor.name),
+			"state_topic":     stateTopic,
+			"value_template":  sensor.valueTemplate,
+			"entity_category": "diagnostic",
+			"unique_id":       entityID,
+			"device": map[string]interface{}{
+				"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+				"name":        device.Name,
+			},
+		}
+		for key, value := range map[string]string{
+			"device_class":        sensor.deviceClass,
+			"unit_of_measurement": sensor.unit,
+			"state_class":         sensor.stateClass,
+			"icon":                sensor.icon,
+		} {
+			if value != "" {
+				configPayload[key] = value
+			}
+		}
+		bytes, err := json.Marshal(configPayload)
+		if err != nil {
+			return err
+		}
+		if err := h.publishToMQTT(fmt.Sprintf(DiagnosticsConfigTopicTemplate, entityID), 0, true, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
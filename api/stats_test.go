@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeviceStats_Observe(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+
+	var s DeviceStats
+	s.observe("open", false, at(7, 0)) // already open when first seen
+	if s.Opens != 0 || s.OpenSince != at(7, 0) {
+		t.Errorf("first state counted: %+v", s)
+	}
+	s.observe("closed", true, at(7, 30))
+	s.observe("opening", true, at(18, 0))
+	s.observe("open", true, at(18, 1))
+	s.observe("closing", true, at(18, 10))
+	s.observe("closed", true, at(18, 11))
+
+	if s.Opens != 1 || s.Closes != 2 {
+		t.Errorf("Opens, Closes = %d, %d, want 1, 2", s.Opens, s.Closes)
+	}
+	if s.LastOpened != at(18, 0) {
+		t.Errorf("LastOpened = %v, want %v", s.LastOpened, at(18, 0))
+	}
+	if want := (41 * time.Minute).Seconds(); s.OpenToday != want {
+		t.Errorf("OpenToday = %v, want %v", s.OpenToday, want)
+	}
+
+	// Left open over midnight, only today's part counts
+	s.observe("open", true, at(23, 0))
+	s.observe("closed", true, at(24, 15))
+	if want := (15 * time.Minute).Seconds(); s.OpenToday != want || s.Day != "2024-03-02" {
+		t.Errorf("OpenToday = %v on %s, want %v on 2024-03-02", s.OpenToday, s.Day, want)
+	}
+}
+
+func TestDeviceFSM_Stats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	states, err := OpenStateStore(path)
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.States = states
+
+	ctx := context.Background()
+	df.Trigger(ctx, "go_online")
+	df.Trigger(ctx, "go_closed")
+	df.Trigger(ctx, "go_opened")
+	df.Trigger(ctx, "go_closed")
+	df.Trigger(ctx, "go_partially_opened")
+
+	if stats := df.Stats(); stats.Opens != 2 || stats.Closes != 1 {
+		t.Errorf("Stats() = %+v, want 2 opens and 1 close", stats)
+	}
+	p, ok := client.last(fmt.Sprintf(StatsTopicTemplate, "dd-door", "door1"))
+	if !ok || !p.Retained {
+		t.Fatalf("stats not published retained")
+	}
+	var published DeviceStats
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &published); err != nil || published.Opens != 2 {
+		t.Errorf("published stats = %s, %v, want 2 opens", payloadString(p.Payload), err)
+	}
+
+	// A restarted bridge carries on from the saved counts
+	reopened, err := OpenStateStore(path)
+	if err != nil {
+		t.Fatalf("OpenStateStore() error = %v", err)
+	}
+	restarted := NewDeviceFSM("door1", "dd-door", nil, handler)
+	restarted.States = reopened
+	restarted.Trigger(ctx, "go_online")
+	restarted.Trigger(ctx, "go_closed")
+	if stats := restarted.Stats(); stats.Opens != 2 || stats.Closes != 2 {
+		t.Errorf("Stats() after restart = %+v, want 2 opens and 2 closes", stats)
+	}
+}
+
+func TestMQTTHandler_ConfigureStats(t *testing.T) {
+	handler, client := newTestHandler()
+	if err := handler.ConfigureStats("dd-door", DoorStatusDevice{ID: "door1", Name: "Garage"}); err != nil {
+		t.Fatalf("ConfigureStats() error = %v", err)
+	}
+
+	for suffix, want := range map[string]string{
+		"opens":       "total_increasing",
+		"open_today":  "total_increasing",
+		"last_opened": "",
+	} {
+		p, ok := client.last(fmt.Sprintf(DiagnosticsConfigTopicTemplate, "door1_"+suffix))
+		if !ok {
+			t.Errorf("no %s sensor discovery published", suffix)
+			continue
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+			t.Fatalf("discovery config is not valid JSON: %v", err)
+		}
+		if got, _ := config["state_class"].(string); got != want {
+			t.Errorf("%s state_class = %q, want %q", suffix, got, want)
+		}
+		if config["state_topic"] != "dd-door/door1/stats" {
+			t.Errorf("%s state_topic = %v", suffix, config["state_topic"])
+		}
+	}
+}
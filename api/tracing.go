@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/looplab/fsm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer atomic.Pointer[trace.Tracer]
+
+func init() {
+	SetTracerProvider(otel.GetTracerProvider())
+}
+
+// SetTracerProvider routes the spans for door FSM events to tp. Until it's called the
+// global otel TracerProvider is used, which is a no-op unless the application sets one.
+func SetTracerProvider(tp trace.TracerProvider) {
+	t := tp.Tracer(MeterName)
+	tracer.Store(&t)
+}
+
+// traceDoorEvent runs event on d's FSM in a dd.door.event span recording the transition.
+// An event that leaves the door where it was isn't an error.
+func traceDoorEvent(ctx context.Context, d *DeviceFSM, event string) error {
+	ctx, span := (*tracer.Load()).Start(ctx, "dd.door.event", trace.WithAttributes(
+		attribute.String("device_id", d.ID),
+		attribute.String("event", event),
+		attribute.String("from", d.FSM.Current()),
+	))
+	defer span.End()
+
+	err := d.FSM.Event(ctx, event)
+	span.SetAttributes(attribute.String("to", d.FSM.Current()))
+	var noTransition fsm.NoTransitionError
+	if err != nil && !errors.As(err, &noTransition) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
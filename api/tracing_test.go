@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDoorEventSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { SetTracerProvider(otel.GetTracerProvider()) })
+
+	handler, _ := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+
+	ctx := context.Background()
+	df.Trigger(ctx, "go_online")
+	df.Trigger(ctx, "go_opened")
+	df.Trigger(ctx, "go_opened")  // already open
+	df.Trigger(ctx, "go_stopped") // invalid from open
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("ended %d spans, want 4", len(spans))
+	}
+	opened := spans[1]
+	if opened.Name() != "dd.door.event" {
+		t.Errorf("span name = %q, want dd.door.event", opened.Name())
+	}
+	attrs := attribute.NewSet(opened.Attributes()...)
+	if v, _ := attrs.Value("event"); v.AsString() != "go_opened" {
+		t.Errorf("event = %q, want go_opened", v.AsString())
+	}
+	if v, _ := attrs.Value("to"); v.AsString() != df.FSM.Current() {
+		t.Errorf("to = %q, want %q", v.AsString(), df.FSM.Current())
+	}
+
+	wantErr := []bool{false, false, false, true}
+	for i, span := range spans {
+		if got := span.Status().Code == codes.Error; got != wantErr[i] {
+			t.Errorf("span %d error status = %v, want %v", i, got, wantErr[i])
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AttributesTopicTemplate carries a cover's extra attributes as JSON, shown by HA on the entity
+const AttributesTopicTemplate = "%s/%s/attributes"
+
+// Bounds on a travel time sample; anything outside wasn't a single uninterrupted run
+const (
+	minTravelSample = time.Second
+	maxTravelSample = 5 * time.Minute
+)
+
+// travelSmoothing is how much each new sample moves the learned travel time.
+const travelSmoothing = 0.3
+
+// CoverAttributes are published on a device's attributes topic.
+type CoverAttributes struct {
+	// PositionEstimated is set while the published position is interpolated from the
+	// learned travel time rather than reported by the hub.
+	PositionEstimated  bool    `json:"position_estimated"`
+	OpenTravelSeconds  float64 `json:"open_travel_seconds,omitempty"`
+	CloseTravelSeconds float64 `json:"close_travel_seconds,omitempty"`
+}
+
+// PublishAttributes publishes a device's cover attributes as JSON.
+func (h *MQTTHandler) PublishAttributes(prefix, deviceID string, attributes CoverAttributes) error {
+	payload, err := json.Marshal(attributes)
+	if err != nil {
+		return fmt.Errorf("encode attributes: %w", err)
+	}
+	return h.publishToMQTT(fmt.Sprintf(AttributesTopicTemplate, prefix, deviceID), 0, true, payload)
+}
+
+// travelTracker learns how long a door takes to fully open and close, and estimates its
+// position while it moves. Travel times are learned only from runs that went end to end.
+type travelTracker struct {
+	mu       sync.Mutex
+	learned  map[string]time.Duration // by QueueOpen or QueueClose
+	position int                      // last known position
+
+	direction string // QueueOpen or QueueClose while moving, else ""
+	from      int
+	start     time.Time
+	stop      chan struct{} // closed to end the current run's estimates
+}
+
+// observe records a position reported by the hub.
+func (t *travelTracker) observe(position int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.position = position
+}
+
+// lastPosition returns the last known position.
+func (t *travelTracker) lastPosition() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.position
+}
+
+// begin starts a run in direction from the last known position, returning a channel
+// closed when the run ends.
+func (t *travelTracker) begin(direction string, now time.Time) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endLocked()
+	t.direction = direction
+	t.from = t.position
+	t.start = now
+	t.stop = make(chan struct{})
+	return t.stop
+}
+
+// end finishes the current run at position, learning its travel time if it went from one
+// end to the other. A position outside 0-100 aborts the run without learning from it.
+func (t *travelTracker) end(position int, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.direction == "" {
+		return
+	}
+	fullOpen := t.direction == QueueOpen && t.from == PositionClosed && position == PositionOpen
+	fullClose := t.direction == QueueClose && t.from == PositionOpen && position == PositionClosed
+	if sample := now.Sub(t.start); (fullOpen || fullClose) && sample >= minTravelSample && sample <= maxTravelSample {
+		if t.learned == nil {
+			t.learned = make(map[string]time.Duration)
+		}
+		if prev, ok := t.learned[t.direction]; ok {
+			sample = prev + time.Duration(travelSmoothing*float64(sample-prev))
+		}
+		t.learned[t.direction] = sample
+	}
+	if position >= PositionClosed && position <= PositionOpen {
+		t.position = position
+	}
+	t.endLocked()
+}
+
+func (t *travelTracker) endLocked() {
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+	t.direction = ""
+}
+
+// estimate returns the interpolated position of the moving door, or false if it isn't
+// moving or its travel time hasn't been learned. Estimates stop short of the ends, which
+// only the hub reports.
+func (t *travelTracker) estimate(now time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	travel, ok := t.learned[t.direction]
+	if t.direction == "" || !ok {
+		return 0, false
+	}
+	moved := int(float64(PositionOpen) * float64(now.Sub(t.start)) / float64(travel))
+	position := t.from + moved
+	if t.direction == QueueClose {
+		position = t.from - moved
+	}
+	return min(max(position, PositionClosed+1), PositionOpen-1), true
+}
+
+// attributes returns the cover attributes for the tracker's current state.
+func (t *travelTracker) attributes(estimated bool) CoverAttributes {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return CoverAttributes{
+		PositionEstimated:  estimated,
+		OpenTravelSeconds:  t.learned[QueueOpen].Seconds(),
+		CloseTravelSeconds: t.learned[QueueClose].Seconds(),
+	}
+}
+
+// ObservePosition records a position reported by the hub, which estimates during the
+// next run start from. A door at rest has its new position saved to States.
+func (d *DeviceFSM) ObservePosition(position int) {
+	d.travel.observe(position)
+	d.saveState(d.Current())
+}
+
+// beginTravel starts a run and, with EstimateInterval set, publishes estimated positions
+// every interval until it ends.
+func (d *DeviceFSM) beginTravel(direction string) {
+	stop := d.travel.begin(direction, time.Now())
+	if d.EstimateInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(d.EstimateInterval)
+		defer ticker.Stop()
+		estimated := false
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				position, ok := d.travel.estimate(now)
+				if !ok {
+					return
+				}
+				if !estimated {
+					estimated = true
+					d.publishAttributes(true)
+				}
+				if err := d.mqttHandler.PublishPosition(d.MQTTPrefix, d.ID, position); err != nil {
+					d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Debug("Failed to publish estimated position")
+				}
+			}
+		}
+	}()
+}
+
+// endTravel ends the current run at position (-1 if unknown) and publishes that the
+// position is no longer estimated, along with any newly learned travel time.
+func (d *DeviceFSM) endTravel(position int) {
+	d.travel.end(position, time.Now())
+	d.publishAttributes(false)
+}
+
+func (d *DeviceFSM) publishAttributes(estimated bool) {
+	if err := d.mqttHandler.PublishAttributes(d.MQTTPrefix, d.ID, d.travel.attributes(estimated)); err != nil {
+		d.mqttHandler.log().WithError(err).WithField("deviceID", d.ID).Debug("Failed to publish attributes")
+	}
+}
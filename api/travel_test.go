@@ -0,0 +1,115 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTravelTracker_Learns(t *testing.T) {
+	start := time.Now()
+	var tr travelTracker
+	tr.observe(PositionClosed)
+
+	// Nothing is learned yet, so there's nothing to estimate
+	tr.begin(QueueOpen, start)
+	if _, ok := tr.estimate(start.Add(time.Second)); ok {
+		t.Errorf("estimate() before learning ok = true, want false")
+	}
+	tr.end(PositionOpen, start.Add(20*time.Second))
+
+	tests := []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{0, 1},
+		{5 * time.Second, 25},
+		{10 * time.Second, 50},
+		{30 * time.Second, 99},
+	}
+	tr.observe(PositionClosed)
+	stop := tr.begin(QueueOpen, start)
+	for _, tt := range tests {
+		if got, ok := tr.estimate(start.Add(tt.elapsed)); !ok || got != tt.want {
+			t.Errorf("estimate(+%v) = %d, %v, want %d, true", tt.elapsed, got, ok, tt.want)
+		}
+	}
+
+	// A second run moves the learned time toward the new sample
+	tr.end(PositionOpen, start.Add(30*time.Second))
+	select {
+	case <-stop:
+	default:
+		t.Errorf("end() didn't close the run's stop channel")
+	}
+	if got, want := tr.attributes(false).OpenTravelSeconds, 23.0; got != want {
+		t.Errorf("OpenTravelSeconds = %v, want %v", got, want)
+	}
+}
+
+func TestTravelTracker_IgnoresPartialRuns(t *testing.T) {
+	start := time.Now()
+	var tr travelTracker
+
+	tests := []struct {
+		name      string
+		from      int
+		direction string
+		to        int
+		took      time.Duration
+	}{
+		{"partial start", 40, QueueOpen, PositionOpen, 10 * time.Second},
+		{"stopped", PositionClosed, QueueOpen, -1, 10 * time.Second},
+		{"wrong end", PositionClosed, QueueOpen, PositionClosed, 10 * time.Second},
+		{"too fast", PositionOpen, QueueClose, PositionClosed, 100 * time.Millisecond},
+		{"too slow", PositionOpen, QueueClose, PositionClosed, time.Hour},
+	}
+	for _, tt := range tests {
+		tr.observe(tt.from)
+		tr.begin(tt.direction, start)
+		tr.end(tt.to, start.Add(tt.took))
+		if a := tr.attributes(false); a.OpenTravelSeconds != 0 || a.CloseTravelSeconds != 0 {
+			t.Errorf("%s: learned %+v, want nothing", tt.name, a)
+		}
+	}
+}
+
+func TestTravelTracker_EstimatesClosingFromPartial(t *testing.T) {
+	start := time.Now()
+	tr := travelTracker{learned: map[string]time.Duration{QueueClose: 10 * time.Second}}
+	tr.observe(60)
+	tr.begin(QueueClose, start)
+	if got, _ := tr.estimate(start.Add(2 * time.Second)); got != 40 {
+		t.Errorf("estimate() = %d, want 40", got)
+	}
+}
+
+func TestDeviceFSM_PublishesEstimates(t *testing.T) {
+	handler, client := newTestHandler()
+	df := NewDeviceFSM("door1", "dd-door", nil, handler)
+	df.EstimateInterval = 5 * time.Millisecond
+	df.travel.learned = map[string]time.Duration{QueueOpen: time.Minute}
+	df.FSM.SetState("closed")
+	df.ObservePosition(PositionClosed)
+
+	df.beginTravel(QueueOpen)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := client.last("dd-door/door1/position"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no estimated position published")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p, _ := client.last("dd-door/door1/attributes")
+	if got := payloadString(p.Payload); got != `{"position_estimated":true,"open_travel_seconds":60}` {
+		t.Errorf("attributes = %s, want estimated", got)
+	}
+
+	df.endTravel(PositionOpen)
+	p, _ = client.last("dd-door/door1/attributes")
+	if got := payloadString(p.Payload); got != `{"position_estimated":false,"open_travel_seconds":60}` {
+		t.Errorf("attributes after end = %s, want not estimated", got)
+	}
+}
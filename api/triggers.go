@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DeviceTriggerConfigTopicTemplate is the HA discovery topic for a button trigger, keyed by deviceID_row_col
+	DeviceTriggerConfigTopicTemplate = "homeassistant/device_automation/%s_%d_%d/config"
+	// ButtonTriggerTopicTemplate receives a "press" payload when a button is pressed
+	ButtonTriggerTopicTemplate = "%s/%s/button/%d_%d"
+	buttonPressPayload         = "press"
+)
+
+// ConfigureButtonTriggers publishes Home Assistant device trigger discovery for
+// each visible button on the device, so automations can react to button presses.
+func (h *MQTTHandler) ConfigureButtonTriggers(mqttPrefix string, device DoorStatusDevice) error {
+	for i, button := range device.Buttons {
+		if button.Hide != 0 {
+			continue
+		}
+		configPayload := map[string]interface{}{
+			"automation_type": "trigger",
+			"topic":           fmt.Sprintf(ButtonTriggerTopicTemplate, mqttPrefix, device.ID, button.Row, button.Col),
+			"payload":         buttonPressPayload,
+			"type":            fmt.Sprintf("button_%d_press", i+1),
+			"subtype":         fmt.Sprintf("button_%d_%d", button.Row, button.Col),
+			"device": map[string]interface{}{
+				"identifiers": []string{fmt.Sprintf("garage_door_%s", device.ID)},
+			},
+		}
+		bytes, err := json.Marshal(configPayload)
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf(DeviceTriggerConfigTopicTemplate, device.ID, button.Row, button.Col)
+		if err := h.publishToMQTT(topic, 0, true, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishButtonPress fires the device trigger for button.
+func (h *MQTTHandler) PublishButtonPress(prefix, deviceID string, button DoorStatusButton) error {
+	topic := fmt.Sprintf(ButtonTriggerTopicTemplate, prefix, deviceID, button.Row, button.Col)
+	return h.publishToMQTT(topic, 0, false, buttonPressPayload)
+}
+
+// PressedButton reports which button, if any, caused the change from prev to cur.
+// A press is recognised when a new log entry appears whose alert code matches a
+// button's command.
+func PressedButton(prev, cur DoorStatusDevice) (*DoorStatusButton, bool) {
+	if cur.Log.ID == prev.Log.ID {
+		return nil, false
+	}
+	for i := range cur.Buttons {
+		if cur.Buttons[i].Action.Command == cur.Log.Alert {
+			return &cur.Buttons[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// testButton returns a visible button at row/col sending cmd.
+func testButton(row, col, cmd int) DoorStatusButton {
+	var b DoorStatusButton
+	b.Row = row
+	b.Col = col
+	b.Action.Command = cmd
+	return b
+}
+
+func TestConfigureButtonTriggers(t *testing.T) {
+	handler, client := newTestHandler()
+
+	hidden := testButton(2, 0, AvailableCommands.LightOn)
+	hidden.Hide = 1
+	device := DoorStatusDevice{
+		ID: "door1",
+		Buttons: []DoorStatusButton{
+			testButton(0, 0, AvailableCommands.Open),
+			testButton(0, 1, AvailableCommands.Close),
+			hidden,
+		},
+	}
+
+	if err := handler.ConfigureButtonTriggers("dd-door", device); err != nil {
+		t.Fatalf("ConfigureButtonTriggers() error = %v", err)
+	}
+
+	p, ok := client.last(fmt.Sprintf(DeviceTriggerConfigTopicTemplate, "door1", 0, 1))
+	if !ok {
+		t.Fatalf("no discovery published for button 0,1")
+	}
+	if !p.Retained {
+		t.Errorf("trigger discovery should be retained")
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(payloadString(p.Payload)), &config); err != nil {
+		t.Fatalf("discovery payload is not valid JSON: %v", err)
+	}
+	want := map[string]string{
+		"automation_type": "trigger",
+		"type":            "button_2_press",
+		"subtype":         "button_0_1",
+		"topic":           "dd-door/door1/button/0_1",
+		"payload":         "press",
+	}
+	for k, v := range want {
+		if config[k] != v {
+			t.Errorf("discovery %s = %v, want %q", k, config[k], v)
+		}
+	}
+
+	if _, ok := client.last(fmt.Sprintf(DeviceTriggerConfigTopicTemplate, "door1", 2, 0)); ok {
+		t.Errorf("hidden button should not get a trigger")
+	}
+}
+
+func TestPressedButton(t *testing.T) {
+	prev := DoorStatusDevice{
+		ID:      "door1",
+		Buttons: []DoorStatusButton{testButton(0, 0, AvailableCommands.Open), testButton(0, 1, AvailableCommands.Close)},
+	}
+	prev.Log.ID = 1
+
+	cur := prev
+	cur.Log.ID = 2
+	cur.Log.Alert = AvailableCommands.Close
+
+	button, ok := PressedButton(prev, cur)
+	if !ok {
+		t.Fatalf("PressedButton() did not detect press")
+	}
+	if button.Row != 0 || button.Col != 1 {
+		t.Errorf("PressedButton() = %d,%d, want 0,1", button.Row, button.Col)
+	}
+
+	// Same log entry: no press
+	if _, ok := PressedButton(cur, cur); ok {
+		t.Errorf("PressedButton() detected press without a new log entry")
+	}
+
+	// New log entry with an unrelated alert
+	other := cur
+	other.Log.ID = 3
+	other.Log.Alert = 999
+	if _, ok := PressedButton(cur, other); ok {
+		t.Errorf("PressedButton() detected press for unknown alert code")
+	}
+}
+
+func TestPublishButtonPress(t *testing.T) {
+	handler, client := newTestHandler()
+
+	if err := handler.PublishButtonPress("dd-door", "door1", testButton(1, 2, AvailableCommands.Stop)); err != nil {
+		t.Fatalf("PublishButtonPress() error = %v", err)
+	}
+	p, ok := client.last("dd-door/door1/button/1_2")
+	if !ok {
+		t.Fatalf("nothing published to trigger topic")
+	}
+	if payloadString(p.Payload) != "press" {
+		t.Errorf("trigger payload = %q, want %q", payloadString(p.Payload), "press")
+	}
+}
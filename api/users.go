@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gravypower/dd"
+)
+
+// ErrNoUsers is returned by ListUsers when the hub sends no users, which it only does for
+// admin credentials.
+var ErrNoUsers = errors.New("no users in status; admin credentials are required")
+
+// userUpdateRequest is the input to /app/res/users/update.
+type userUpdateRequest struct {
+	Username string `json:"userName"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// userRemoveRequest is the input to /app/res/users/remove.
+type userRemoveRequest struct {
+	Username string `json:"userName"`
+}
+
+// ListUsers returns the hub's users, as carried in the status sent to admin credentials.
+func ListUsers(conn *dd.Conn) ([]DoorStatusUsers, error) {
+	status, err := SafeFetchStatus(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Users) == 0 {
+		return nil, ErrNoUsers
+	}
+	return status.Users, nil
+}
+
+// EnableUser allows username to operate the hub again.
+func EnableUser(conn *dd.Conn, username string) error {
+	return setUserEnabled(conn, username, true)
+}
+
+// DisableUser stops username operating the hub without removing it.
+func DisableUser(conn *dd.Conn, username string) error {
+	return setUserEnabled(conn, username, false)
+}
+
+func setUserEnabled(conn *dd.Conn, username string, enabled bool) error {
+	err := timedRPC(conn, dd.RPC{
+		Path:  "/app/res/users/update",
+		Input: userUpdateRequest{Username: username, Enabled: enabled},
+	})
+	if err != nil {
+		logger.WithError(err).WithField("user", username).Error("Could not update user")
+	}
+	return err
+}
+
+// RemoveUser deletes username from the hub; it has to be shared again to regain access.
+func RemoveUser(conn *dd.Conn, username string) error {
+	err := timedRPC(conn, dd.RPC{
+		Path:  "/app/res/users/remove",
+		Input: userRemoveRequest{Username: username},
+	})
+	if err != nil {
+		logger.WithError(err).WithField("user", username).Error("Could not remove user")
+	}
+	return err
+}
+
+// shareRequest is the input to /app/res/users/share.
+type shareRequest struct {
+	Username string `json:"userName"`
+	IsAdmin  bool   `json:"isAdmin"`
+}
+
+// shareResponse is the wire format returned by /app/res/users/share.
+type shareResponse struct {
+	Code string `json:"remoteRegistrationCode"`
+}
+
+// CreateShare invites a new user, returning the share code they register with
+// (see RegisterRequest.RemoteRegistrationCode).
+func CreateShare(conn *dd.Conn, username string, admin bool) (string, error) {
+	var resp shareResponse
+	err := timedRPC(conn, dd.RPC{
+		Path:   "/app/res/users/share",
+		Input:  shareRequest{Username: username, IsAdmin: admin},
+		Output: &resp,
+	})
+	if err != nil {
+		logger.WithError(err).WithField("user", username).Error("Could not create share")
+		return "", err
+	}
+	return resp.Code, nil
+}
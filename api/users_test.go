@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gravypower/dd"
+	"github.com/gravypower/dd/ddtest"
+)
+
+// connectTestServer returns a Conn connected to a new ddtest.Server.
+func connectTestServer(t *testing.T) (*ddtest.Server, *dd.Conn) {
+	t.Helper()
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+
+	conn := server.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	return server, conn
+}
+
+func TestListUsers(t *testing.T) {
+	server, conn := connectTestServer(t)
+	users := []DoorStatusUsers{{Enabled: true, Username: "alice"}, {Enabled: false, Username: "bob"}}
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{Users: users}, nil
+	})
+
+	got, err := ListUsers(conn)
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("ListUsers() = %+v, want %+v", got, users)
+	}
+}
+
+func TestListUsers_NotAdmin(t *testing.T) {
+	server, conn := connectTestServer(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{DeviceOrder: []string{"door1"}}, nil
+	})
+
+	if _, err := ListUsers(conn); !errors.Is(err, ErrNoUsers) {
+		t.Errorf("ListUsers() error = %v, want ErrNoUsers", err)
+	}
+}
+
+func TestUserUpdates(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(*dd.Conn, string) error
+		path string
+		want map[string]interface{}
+	}{
+		{"enable", EnableUser, "/app/res/users/update", map[string]interface{}{"userName": "bob", "enabled": true}},
+		{"disable", DisableUser, "/app/res/users/update", map[string]interface{}{"userName": "bob", "enabled": false}},
+		{"remove", RemoveUser, "/app/res/users/remove", map[string]interface{}{"userName": "bob"}},
+	}
+
+	server, conn := connectTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			server.Handle(tt.path, func(body []byte) (interface{}, error) {
+				return nil, json.Unmarshal(body, &got)
+			})
+
+			if err := tt.call(conn, "bob"); err != nil {
+				t.Fatalf("%s error = %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s request = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveUser_Restricted(t *testing.T) {
+	server, conn := connectTestServer(t)
+	server.Handle("/app/res/users/remove", func([]byte) (interface{}, error) {
+		return nil, &dd.RPCError{Code: 5, Description: "restricted to admin"}
+	})
+
+	if err := RemoveUser(conn, "bob"); !errors.Is(err, dd.ErrAccessRestricted) {
+		t.Errorf("RemoveUser() error = %v, want ErrAccessRestricted", err)
+	}
+}
+
+func TestCreateShare(t *testing.T) {
+	server, conn := connectTestServer(t)
+	var got shareRequest
+	server.Handle("/app/res/users/share", func(body []byte) (interface{}, error) {
+		if err := json.Unmarshal(body, &got); err != nil {
+			return nil, err
+		}
+		return shareResponse{Code: "ABC123"}, nil
+	})
+
+	code, err := CreateShare(conn, "carol", true)
+	if err != nil {
+		t.Fatalf("CreateShare() error = %v", err)
+	}
+	if code != "ABC123" {
+		t.Errorf("CreateShare() = %q, want %q", code, "ABC123")
+	}
+	if want := (shareRequest{Username: "carol", IsAdmin: true}); got != want {
+		t.Errorf("share request = %+v, want %+v", got, want)
+	}
+}
+
+func TestRenameDevice(t *testing.T) {
+	server, conn := connectTestServer(t)
+	var got renameRequest
+	server.Handle("/app/res/devices/rename", func(body []byte) (interface{}, error) {
+		return nil, json.Unmarshal(body, &got)
+	})
+
+	if err := RenameDevice(conn, "door1", "Garage"); err != nil {
+		t.Fatalf("RenameDevice() error = %v", err)
+	}
+	if want := (renameRequest{DeviceID: "door1", Name: "Garage"}); got != want {
+		t.Errorf("rename request = %+v, want %+v", got, want)
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	server, conn := connectTestServer(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return DoorStatus{
+			DeviceOrder: []string{"door2", "door1"},
+			Devices:     []DoorStatusDevice{{ID: "door1"}, {ID: "door2"}},
+		}, nil
+	})
+
+	devices, err := ListDevices(conn)
+	if err != nil {
+		t.Fatalf("ListDevices() error = %v", err)
+	}
+	if len(devices) != 2 || devices[0].ID != "door2" || devices[1].ID != "door1" {
+		t.Errorf("ListDevices() = %+v, want door2 then door1", devices)
+	}
+}
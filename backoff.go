@@ -0,0 +1,57 @@
+package dd
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultBackoffJitter randomizes retry delays by ±20%, so clients that failed together
+// don't retry in lockstep.
+const DefaultBackoffJitter = 0.2
+
+// Backoff computes retry delays that start at Base and double on each attempt up to Max,
+// each randomized by Jitter. It's used by every retry loop in this module: reconnects,
+// message polling, RPC response polling, command retries and MQTT publishes. A Backoff
+// isn't safe for concurrent use; give each retry loop its own.
+type Backoff struct {
+	Base   time.Duration // delay before the first retry
+	Max    time.Duration // cap on the delay before jitter; zero means no cap
+	Jitter float64       // fraction of the delay randomized either way, from 0 to 1
+
+	attempt int
+}
+
+// Next returns the delay before the next attempt and advances the Backoff.
+func (b *Backoff) Next() time.Duration {
+	d := b.Delay(b.attempt)
+	b.attempt++
+	return d
+}
+
+// Reset starts the Backoff again from Base, as after a success.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Delay returns the delay before retry number attempt, counting from 0, without
+// advancing the Backoff.
+func (b *Backoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 0; i < attempt && d < math.MaxInt64/2 && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 {
+		d = min(d, b.Max)
+	}
+	return jitter(d, b.Jitter)
+}
+
+// jitter randomizes d by up to ±fraction of it.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+	fraction = min(fraction, 1)
+	return d + time.Duration((rand.Float64()*2-1)*fraction*float64(d))
+}
@@ -0,0 +1,58 @@
+package dd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Base: time.Second, Max: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.Delay(tt.attempt); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+
+	uncapped := Backoff{Base: time.Second}
+	if got := uncapped.Delay(1000); got <= 0 {
+		t.Errorf("Delay(1000) without a cap = %v, want > 0", got)
+	}
+}
+
+func TestBackoff_NextAndReset(t *testing.T) {
+	b := Backoff{Base: time.Millisecond, Max: time.Second}
+	for i, want := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond} {
+		if got := b.Next(); got != want {
+			t.Errorf("Next() #%d = %v, want %v", i, got, want)
+		}
+	}
+	b.Reset()
+	if got := b.Next(); got != time.Millisecond {
+		t.Errorf("Next() after Reset = %v, want %v", got, time.Millisecond)
+	}
+}
+
+func TestBackoff_Jitter(t *testing.T) {
+	b := Backoff{Base: 5 * time.Second, Jitter: DefaultBackoffJitter}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		d := b.Delay(0)
+		if d < b.Base*8/10 || d > b.Base*12/10 {
+			t.Fatalf("Delay(0) = %v, want within ±20%% of %v", d, b.Base)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Delay(0) always returned the same delay")
+	}
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
 
 	"github.com/gravypower/dd"
 	ddapi "github.com/gravypower/dd/api"
@@ -10,78 +16,184 @@ import (
 )
 
 var (
-	flagCredentialsPath = flag.String("credentials", "dd-credentials.json", "path to credentials file")
-	flagHost            = flag.String("host", "", "host to connect to")
-	flagCommand         = flag.String("command", "", "command to send")
-	flagDebug           = flag.Bool("debug", false, "debug")
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagCommand          = flag.String("command", "", "command to send")
+	flagDevice           = flag.String("device", "", "ID or name of the device to control, or \"all\" (default first)")
+	flagWait             = flag.Bool("wait", false, "wait until the door reaches the command's position")
+	flagJSON             = flag.Bool("json", false, "print a line of JSON for each device")
+	flagDryRun           = flag.Bool("dryRun", false, "log the command instead of sending it")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
 )
 
-func main() {
-	flag.Parse()
+// allDevices is the -device value that targets every device.
+const allDevices = "all"
 
-	var command, err = ddapi.ParseCommand(*flagCommand)
+// errFailed is returned by run when the command failed on some device.
+var errFailed = errors.New("command failed")
 
-	if err != nil {
-		log.Fatalf("could not find a suitable command for: %s", *flagCommand)
-	}
+// options are the flags run acts on.
+type options struct {
+	command string
+	device  string
+	wait    bool
+	json    bool
+	debug   bool
+}
 
-	if *flagDebug {
-		log.Printf("found command: %v, mapped to int: %v", *flagCommand, command)
-	}
+// result is the outcome of the command on one device, as printed by -json.
+type result struct {
+	DeviceID string `json:"deviceId"`
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	Code     int    `json:"code,omitempty"`
+	Position *int   `json:"position,omitempty"` // where the door stopped, with -wait
+	Error    string `json:"error,omitempty"`
+}
+
+func main() {
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
 
 	creds, err := helper.LoadCreds(*flagCredentialsPath)
 	if err != nil {
 		log.Fatalf("can't open credentials file: %v %v", *flagCredentialsPath, err)
 	}
 
-	conn := dd.Conn{Host: *flagHost, Debug: *flagDebug}
+	conn := dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
 	err = conn.Connect(creds.Credential)
 	if err != nil {
 		log.Fatalf("failed to connect: %v", err)
 	}
 
-	// Fetch basic info from SDK endpoint.
-	var info ddapi.BasicInfo
-	err = conn.SimpleRequest(dd.SimpleRequest{
-		Path:   "/sdk/info",
-		Target: dd.SDKTarget,
-		Output: &info,
-	})
-	if err != nil {
-		log.Fatalf("could not get basic info: %v", err)
+	if !*flagJSON {
+		// Fetch basic info from SDK endpoint.
+		var info ddapi.BasicInfo
+		err = conn.SimpleRequest(dd.SimpleRequest{
+			Path:   "/sdk/info",
+			Target: dd.SDKTarget,
+			Output: &info,
+		})
+		if err != nil {
+			log.Fatalf("could not get basic info: %v", err)
+		}
+		log.Printf("basic info: %+v", info)
+	}
+
+	if *flagDryRun {
+		ddapi.EnableDryRun(nil)
+	}
+
+	opts := options{command: *flagCommand, device: *flagDevice, wait: *flagWait, json: *flagJSON, debug: *flagDebug}
+	if err := run(context.Background(), &conn, opts, os.Stdout); err != nil {
+		if errors.Is(err, errFailed) {
+			os.Exit(1)
+		}
+		log.Fatalf("%v", err)
 	}
-	log.Printf("basic info: %+v", info)
+}
 
-	// Fetch list of devices and control 1st.
-	var devices ddapi.DoorStatus
-	err = conn.RPC(dd.RPC{
-		Path:   "/app/res/devices/fetch",
-		Output: &devices,
-	})
+// run sends the command to the devices opts selects, printing a JSON result for each if
+// opts.json is set. It returns errFailed if the command failed on any of them.
+func run(ctx context.Context, conn *dd.Conn, opts options, stdout io.Writer) error {
+	all, err := ddapi.ListDevices(conn)
 	if err != nil {
-		log.Fatalf("Could not do request: %v", err)
+		return fmt.Errorf("could not fetch devices: %w", err)
 	}
-	log.Printf("Got devices: %+v", devices)
-	if len(devices.DeviceOrder) == 0 {
-		log.Fatalf("No devices to control")
+	if !opts.json {
+		log.Printf("Got devices: %+v", all)
+	}
+	devices, err := selectDevices(all, opts.device)
+	if err != nil {
+		return err
 	}
-	deviceId := devices.DeviceOrder[0]
 
-	// Send the requested command.
-	var commandInput ddapi.CommandInput
-	commandInput.DeviceId = deviceId
-	commandInput.Action.Command = command
-	var commandOutput ddapi.CommandOutput
-	err = conn.RPC(dd.RPC{
-		Path:   "/app/res/action",
-		Input:  &commandInput,
-		Output: &commandOutput,
-	})
+	// Resolve against the static commands plus each device's own button names.
+	registry := ddapi.ParseCommandsFromButtons(&ddapi.DoorStatus{Devices: all})
+	enc := json.NewEncoder(stdout)
+	failed := false
+	for _, device := range devices {
+		r := act(ctx, conn, registry, device, opts)
+		if r.Error != "" {
+			failed = true
+		}
+		if opts.json {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			continue
+		}
+		switch {
+		case r.Error != "":
+			log.Printf("%s: %s", device.Name, r.Error)
+		case r.Position != nil:
+			log.Printf("%s: %s done, door at %d%%", device.Name, opts.command, *r.Position)
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
 
+// selectDevices returns the device with the ID or name spec, every device for "all", or
+// the first device if spec is empty.
+func selectDevices(devices []ddapi.DoorStatusDevice, spec string) ([]ddapi.DoorStatusDevice, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no devices to control")
+	}
+	switch spec {
+	case "":
+		return devices[:1], nil
+	case allDevices:
+		return devices, nil
+	}
+	for _, device := range devices {
+		if device.ID == spec {
+			return []ddapi.DoorStatusDevice{device}, nil
+		}
+	}
+	device, err := ddapi.FindDeviceByName(devices, spec)
 	if err != nil {
-		log.Fatalf("Could not do request: %v", err)
+		return nil, err
 	}
+	return []ddapi.DoorStatusDevice{*device}, nil
+}
 
-	log.Printf("Got command response: %+v", commandOutput)
+// act sends the command to one device, waiting for it to finish moving if opts.wait is
+// set and the command has a target position.
+func act(ctx context.Context, conn *dd.Conn, registry map[string]map[string]int, device ddapi.DoorStatusDevice, opts options) result {
+	r := result{DeviceID: device.ID, Name: device.Name, Command: opts.command}
+	command, err := ddapi.GetCommandForDevice(device.ID, opts.command, registry)
+	if err != nil {
+		r.Error = fmt.Sprintf("could not find a suitable command for: %s", opts.command)
+		return r
+	}
+	r.Code = command
+	if opts.debug {
+		log.Printf("found command: %v, mapped to int: %v", opts.command, command)
+	}
+
+	// In dry-run mode the door won't move, so there's nothing to wait for
+	if _, ok := ddapi.PositionForCommand(command); opts.wait && ok && !ddapi.DryRun() {
+		position, err := ddapi.NewDoor(conn, device.ID).CommandAndWait(ctx, command)
+		r.Position = &position
+		if err != nil {
+			r.Error = err.Error()
+		}
+		return r
+	}
 
+	output, err := ddapi.SendCommand(ctx, conn, device.ID, command, ddapi.CommandOptions{})
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if !opts.json {
+		log.Printf("Got command response: %+v", output)
+	}
+	return r
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+)
+
+func testDevice(id, name string, position int) ddapi.DoorStatusDevice {
+	device := ddapi.DoorStatusDevice{ID: id, Name: name}
+	device.Device.Position = position
+	return device
+}
+
+// testHub returns a connected Conn for a ddtest hub with two closed doors, and the
+// devices each command it receives was sent to.
+func testHub(t *testing.T) (*dd.Conn, func() []string) {
+	t.Helper()
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+	conn := server.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	positions := map[string]int{"door1": 0, "door2": 0}
+	status := func() ddapi.DoorStatus {
+		return ddapi.DoorStatus{
+			DeviceOrder: []string{"door1", "door2"},
+			Devices:     []ddapi.DoorStatusDevice{testDevice("door1", "Garage", positions["door1"]), testDevice("door2", "Side", positions["door2"])},
+		}
+	}
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return status(), nil
+	})
+	var sent []string
+	server.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		var in ddapi.CommandInput
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, in.DeviceId)
+		if target, ok := ddapi.PositionForCommand(in.Action.Command); ok {
+			positions[in.DeviceId] = target
+		}
+		return ddapi.CommandOutput{}, server.Push(status())
+	})
+	return conn, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+}
+
+// decodeResults decodes run's -json output.
+func decodeResults(t *testing.T, out string) []result {
+	t.Helper()
+	var results []result
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var r result
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding %q: %v", out, err)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestRun_Devices(t *testing.T) {
+	tests := []struct {
+		device string
+		want   []string
+	}{
+		{"", []string{"door1"}},
+		{"door2", []string{"door2"}},
+		{"side", []string{"door2"}},
+		{"all", []string{"door1", "door2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			conn, sent := testHub(t)
+			var out bytes.Buffer
+			opts := options{command: "open", device: tt.device, json: true}
+			if err := run(context.Background(), conn, opts, &out); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			if got := strings.Join(sent(), ","); got != strings.Join(tt.want, ",") {
+				t.Errorf("commands sent to %s, want %s", got, strings.Join(tt.want, ","))
+			}
+			results := decodeResults(t, out.String())
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d:\n%s", len(results), len(tt.want), out.String())
+			}
+			for i, r := range results {
+				if r.DeviceID != tt.want[i] || r.Code != ddapi.AvailableCommands.Open || r.Error != "" || r.Position != nil {
+					t.Errorf("result %d = %+v, want open sent to %s", i, r, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRun_UnknownDevice(t *testing.T) {
+	conn, sent := testHub(t)
+	err := run(context.Background(), conn, options{command: "open", device: "Shed", json: true}, &bytes.Buffer{})
+	if !errors.Is(err, ddapi.ErrDeviceNotFound) {
+		t.Errorf("run() error = %v, want ErrDeviceNotFound", err)
+	}
+	if len(sent()) != 0 {
+		t.Errorf("commands sent to %v, want none", sent())
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	conn, _ := testHub(t)
+	var out bytes.Buffer
+	err := run(context.Background(), conn, options{command: "fly", json: true}, &out)
+	if !errors.Is(err, errFailed) {
+		t.Errorf("run() error = %v, want errFailed", err)
+	}
+	if results := decodeResults(t, out.String()); len(results) != 1 || results[0].Error == "" {
+		t.Errorf("results = %+v, want an error for door1", results)
+	}
+}
+
+func TestRun_Wait(t *testing.T) {
+	conn, _ := testHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	if err := run(ctx, conn, options{command: strconv.Itoa(ddapi.AvailableCommands.OpenPercent50), device: "all", wait: true, json: true}, &out); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	results := decodeResults(t, out.String())
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2:\n%s", len(results), out.String())
+	}
+	for _, r := range results {
+		if r.Position == nil || *r.Position != 50 || r.Error != "" {
+			t.Errorf("result = %+v, want %s at 50%%", r, r.DeviceID)
+		}
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	conn, sent := testHub(t)
+	ddapi.EnableDryRun(nil)
+	t.Cleanup(ddapi.DisableDryRun)
+
+	var out bytes.Buffer
+	if err := run(context.Background(), conn, options{command: "open", device: "all", wait: true, json: true}, &out); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(sent()) != 0 {
+		t.Errorf("dry run sent commands to %v", sent())
+	}
+	for _, r := range decodeResults(t, out.String()) {
+		if r.Error != "" || r.Position != nil {
+			t.Errorf("result = %+v, want success without waiting", r)
+		}
+	}
+}
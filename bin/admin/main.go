@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+)
+
+var (
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "admin credentials file, or store URI such as keyring://dd/admin")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
+)
+
+// errUsage is returned for a missing or malformed command; usage is printed instead.
+var errUsage = errors.New("usage")
+
+// command is a "<group> <name>" subcommand, e.g. "users list".
+type command struct {
+	group, name string
+	usage       string // arguments, e.g. "<user>"
+	args        int    // number of positional arguments required
+	run         func(conn *dd.Conn, flags *flag.FlagSet, stdout io.Writer) error
+	flags       func(flags *flag.FlagSet) // optional, defines the subcommand's flags
+}
+
+var commands = []command{
+	{group: "users", name: "list", run: usersList},
+	{group: "users", name: "enable", usage: "<user>", args: 1, run: func(conn *dd.Conn, f *flag.FlagSet, stdout io.Writer) error {
+		return ddapi.EnableUser(conn, f.Arg(0))
+	}},
+	{group: "users", name: "disable", usage: "<user>", args: 1, run: func(conn *dd.Conn, f *flag.FlagSet, stdout io.Writer) error {
+		return ddapi.DisableUser(conn, f.Arg(0))
+	}},
+	{group: "users", name: "remove", usage: "<user>", args: 1, run: func(conn *dd.Conn, f *flag.FlagSet, stdout io.Writer) error {
+		return ddapi.RemoveUser(conn, f.Arg(0))
+	}},
+	{group: "share", name: "create", usage: "[-admin] <user>", args: 1, run: shareCreate, flags: func(f *flag.FlagSet) {
+		f.Bool("admin", false, "give the new user admin rights")
+	}},
+	{group: "device", name: "list", run: deviceList},
+	{group: "device", name: "rename", usage: "<deviceID> <name>", args: 2, run: func(conn *dd.Conn, f *flag.FlagSet, stdout io.Writer) error {
+		return ddapi.RenameDevice(conn, f.Arg(0), f.Arg(1))
+	}},
+}
+
+func main() {
+	flag.Usage = func() { usage(flag.CommandLine.Output()) }
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
+
+	err := run(flag.Args(), connect, os.Stdout)
+	if errors.Is(err, errUsage) {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// connect loads the credentials and connects to the hub given by the flags.
+func connect() (*dd.Conn, error) {
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open credentials file: %v %w", *flagCredentialsPath, err)
+	}
+
+	conn := &dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+	if err := conn.Connect(creds.Credential); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return conn, nil
+}
+
+// run finds the subcommand named by args, connects, and runs it.
+func run(args []string, connect func() (*dd.Conn, error), stdout io.Writer) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	for _, c := range commands {
+		if c.group != args[0] || c.name != args[1] {
+			continue
+		}
+
+		flags := flag.NewFlagSet(c.group+" "+c.name, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		if c.flags != nil {
+			c.flags(flags)
+		}
+		if err := flags.Parse(args[2:]); err != nil || flags.NArg() != c.args {
+			return fmt.Errorf("%w: %s %s %s", errUsage, c.group, c.name, c.usage)
+		}
+
+		conn, err := connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return c.run(conn, flags, stdout)
+	}
+	return fmt.Errorf("%w: unknown command %q", errUsage, strings.Join(args[:2], " "))
+}
+
+func usersList(conn *dd.Conn, _ *flag.FlagSet, stdout io.Writer) error {
+	users, err := ddapi.ListUsers(conn)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tSTATUS")
+	for _, u := range users {
+		status := "disabled"
+		if u.Enabled {
+			status = "enabled"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", u.Username, status)
+	}
+	return w.Flush()
+}
+
+func deviceList(conn *dd.Conn, _ *flag.FlagSet, stdout io.Writer) error {
+	devices, err := ddapi.ListDevices(conn)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPOSITION")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%d%%\n", d.ID, d.Name, d.Device.Position)
+	}
+	return w.Flush()
+}
+
+func shareCreate(conn *dd.Conn, f *flag.FlagSet, stdout io.Writer) error {
+	admin := f.Lookup("admin").Value.(flag.Getter).Get().(bool)
+	code, err := ddapi.CreateShare(conn, f.Arg(0), admin)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Share code for %s: %s\n", f.Arg(0), code)
+	return nil
+}
+
+// usage prints the global flags and the subcommands.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: admin [flags] <command> [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s %s\n", c.group, c.name, c.usage)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+}
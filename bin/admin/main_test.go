@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+)
+
+// testConnect returns a connect func for a new ddtest.Server.
+func testConnect(t *testing.T) (*ddtest.Server, func() (*dd.Conn, error)) {
+	t.Helper()
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+	return server, func() (*dd.Conn, error) {
+		conn := server.Conn()
+		return conn, conn.Connect(server.Credential)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no command", nil},
+		{"group only", []string{"users"}},
+		{"unknown command", []string{"users", "frobnicate"}},
+		{"missing argument", []string{"users", "disable"}},
+		{"extra argument", []string{"device", "rename", "door1", "Garage", "extra"}},
+		{"unknown flag", []string{"share", "create", "-owner", "carol"}},
+	}
+
+	connect := func() (*dd.Conn, error) {
+		t.Fatal("connect called for a usage error")
+		return nil, nil
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := run(tt.args, connect, &bytes.Buffer{}); !errors.Is(err, errUsage) {
+				t.Errorf("run(%q) error = %v, want errUsage", tt.args, err)
+			}
+		})
+	}
+}
+
+func TestRun_UsersList(t *testing.T) {
+	server, connect := testConnect(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return ddapi.DoorStatus{Users: []ddapi.DoorStatusUsers{
+			{Enabled: true, Username: "alice"},
+			{Enabled: false, Username: "bob"},
+		}}, nil
+	})
+
+	var out bytes.Buffer
+	if err := run([]string{"users", "list"}, connect, &out); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	for _, want := range []string{"alice  enabled", "bob    disabled"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output = %q, want it to contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestRun_ShareCreate(t *testing.T) {
+	server, connect := testConnect(t)
+	server.Handle("/app/res/users/share", func(body []byte) (interface{}, error) {
+		if !strings.Contains(string(body), `"isAdmin":true`) {
+			t.Errorf("share request = %s, want isAdmin", body)
+		}
+		return map[string]string{"remoteRegistrationCode": "ABC123"}, nil
+	})
+
+	var out bytes.Buffer
+	if err := run([]string{"share", "create", "-admin", "carol"}, connect, &out); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if want := "Share code for carol: ABC123\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRun_DeviceList(t *testing.T) {
+	server, connect := testConnect(t)
+	server.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return ddapi.DoorStatus{
+			DeviceOrder: []string{"door2", "door1"},
+			Devices:     []ddapi.DoorStatusDevice{{ID: "door1", Name: "Garage"}, {ID: "door2", Name: "Gate"}},
+		}, nil
+	})
+
+	var out bytes.Buffer
+	if err := run([]string{"device", "list"}, connect, &out); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if gate, garage := strings.Index(out.String(), "Gate"), strings.Index(out.String(), "Garage"); gate < 0 || garage < gate {
+		t.Errorf("output = %q, want Gate listed before Garage", out.String())
+	}
+}
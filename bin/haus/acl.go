@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/sirupsen/logrus"
+)
+
+// securityTopicTemplate is where commands denied by the ACL are published.
+const securityTopicTemplate = "%s/bridge/security"
+
+// sourceGateway is the source of commands sent through the HTTP gateway.
+const sourceGateway = "gateway"
+
+var (
+	// ErrACLDenied is returned for commands the ACL doesn't allow.
+	ErrACLDenied = errors.New("command not allowed")
+	// ErrACLHours is returned for an ACL rule with malformed hours.
+	ErrACLHours = errors.New("acl hours must be HH:MM-HH:MM")
+	// ErrACLSource is returned for an ACL rule naming an unknown source.
+	ErrACLSource = errors.New("acl source must be mqtt, gateway or schedule")
+)
+
+// ACLRule restricts the commands it matches to the given users, sources and hours. A
+// command no rule matches is allowed; one that rules match is allowed if any of them
+// permits it.
+type ACLRule struct {
+	// Commands are the commands the rule covers: open, close, stop, position, light, aux,
+	// audio_alarm, motion_alarm, phone_lockout, remote_lockout, auto_close, schedules or
+	// reload, or for the gateway and schedules any other command name, e.g. pet_open. Empty
+	// covers every command.
+	Commands []string `yaml:"commands"`
+	// Devices are the device IDs the rule covers; empty covers every device.
+	Devices []string `yaml:"devices"`
+
+	// Users are the MQTT usernames allowed. The broker doesn't say who published a
+	// message, so a user's commands must be published to the command topic with
+	// /<username> appended, and the broker must only let each user publish to their own
+	// (e.g. mosquitto's "pattern write dd-door/+/+/%u").
+	Users []string `yaml:"users"`
+	// Sources are where commands are allowed from: mqtt, gateway or schedule.
+	Sources []string `yaml:"sources"`
+	// Hours is when commands are allowed, as HH:MM-HH:MM in local time, e.g. 22:00-06:00.
+	Hours string `yaml:"hours"`
+}
+
+// aclRule is an ACLRule with its hours parsed into minutes since midnight.
+type aclRule struct {
+	ACLRule
+	from, to int
+}
+
+// parseACLRule validates r.
+func parseACLRule(r ACLRule) (aclRule, error) {
+	rule := aclRule{ACLRule: r}
+	for _, source := range r.Sources {
+		switch source {
+		case ddapi.SourceMQTT, sourceGateway, ddapi.SourceSchedule:
+		default:
+			return rule, fmt.Errorf("%w: %q", ErrACLSource, source)
+		}
+	}
+	if r.Hours == "" {
+		return rule, nil
+	}
+	from, to, ok := strings.Cut(r.Hours, "-")
+	if !ok {
+		return rule, fmt.Errorf("%w: %q", ErrACLHours, r.Hours)
+	}
+	var err error
+	if rule.from, err = parseClock(from); err != nil {
+		return rule, fmt.Errorf("%w: %q", ErrACLHours, r.Hours)
+	}
+	if rule.to, err = parseClock(to); err != nil {
+		return rule, fmt.Errorf("%w: %q", ErrACLHours, r.Hours)
+	}
+	return rule, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// matches reports whether the rule covers req.
+func (r aclRule) matches(req commandRequest) bool {
+	if len(r.Commands) > 0 && !slices.Contains(r.Commands, req.Command) {
+		return false
+	}
+	return len(r.Devices) == 0 || slices.Contains(r.Devices, req.Device)
+}
+
+// permits reports whether the rule allows req at t and, if not, why.
+func (r aclRule) permits(req commandRequest, t time.Time) (string, bool) {
+	if len(r.Users) > 0 && !slices.Contains(r.Users, req.User) {
+		if req.User == "" {
+			return "no user given", false
+		}
+		return "user " + req.User + " not allowed", false
+	}
+	if len(r.Sources) > 0 && !slices.Contains(r.Sources, req.Source) {
+		return "source " + req.Source + " not allowed", false
+	}
+	if r.Hours != "" {
+		now := t.Hour()*60 + t.Minute()
+		in := now >= r.from && now < r.to
+		if r.from >= r.to {
+			// The window wraps past midnight
+			in = now >= r.from || now < r.to
+		}
+		if !in {
+			return "outside hours " + r.Hours, false
+		}
+	}
+	return "", true
+}
+
+// commandRequest is a command to check against the ACL.
+type commandRequest struct {
+	Device  string `json:"device,omitempty"`
+	Command string `json:"command"`
+	Source  string `json:"source"`
+	User    string `json:"user,omitempty"`
+}
+
+// aclDenial is published to securityTopicTemplate for each denied command.
+type aclDenial struct {
+	commandRequest
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// access enforces the config's ACL on MQTT commands. It's set before anything connects.
+var access *accessControl
+
+// accessControl enforces the ACL rules. A nil *accessControl allows everything.
+type accessControl struct {
+	prefix  string // for denials of commands that aren't for a known device
+	devices *ddapi.DeviceRegistry
+
+	mu     sync.Mutex
+	rules  []aclRule
+	client mqtt.Client // set once connected to MQTT
+
+	now func() time.Time
+}
+
+// newAccessControl returns an accessControl enforcing rules, which may be none.
+func newAccessControl(rules []ACLRule, prefix string, devices *ddapi.DeviceRegistry) (*accessControl, error) {
+	parsed, err := parseACLRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &accessControl{rules: parsed, prefix: prefix, devices: devices, now: time.Now}, nil
+}
+
+// parseACLRules validates rules.
+func parseACLRules(rules []ACLRule) ([]aclRule, error) {
+	var parsed []aclRule
+	for i, r := range rules {
+		rule, err := parseACLRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("acl rule %d: %w", i+1, err)
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
+
+// setRules replaces the rules, e.g. on reloading the config.
+func (a *accessControl) setRules(rules []aclRule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = rules
+}
+
+// identifiesUsers reports whether any rule restricts users, so commands must also be
+// accepted on per-user topics.
+func (a *accessControl) identifiesUsers() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.ContainsFunc(a.rules, func(r aclRule) bool { return len(r.Users) > 0 })
+}
+
+// setClient sets the MQTT client denials are published with. It's called on every
+// (re)connect to the broker.
+func (a *accessControl) setClient(client mqtt.Client) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.client = client
+}
+
+// check returns an error wrapping ErrACLDenied if the rules don't allow req, having
+// logged it and published it to the security topic.
+func (a *accessControl) check(req commandRequest) error {
+	if a == nil {
+		return nil
+	}
+	now := a.now()
+	reason, ok := a.decide(req, now)
+	if ok {
+		return nil
+	}
+
+	logger.WithFields(logrus.Fields{
+		"deviceID": req.Device,
+		"command":  req.Command,
+		"source":   req.Source,
+		"user":     req.User,
+		"reason":   reason,
+	}).Warn("ACL denied command")
+
+	a.mu.Lock()
+	client := a.client
+	a.mu.Unlock()
+	if client != nil {
+		prefix := a.prefix
+		if device, ok := a.devices.Get(req.Device); ok {
+			prefix = device.MQTTPrefix
+		}
+		publishBridgeEvent(client, fmt.Sprintf(securityTopicTemplate, prefix), aclDenial{commandRequest: req, Reason: reason, Time: now})
+	}
+	return fmt.Errorf("%w: %s", ErrACLDenied, reason)
+}
+
+// decide reports whether req is allowed at t and, if not, why.
+func (a *accessControl) decide(req commandRequest, t time.Time) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	matched := false
+	var reason string
+	for _, r := range a.rules {
+		if !r.matches(req) {
+			continue
+		}
+		matched = true
+		why, ok := r.permits(req, t)
+		if ok {
+			return "", true
+		}
+		if reason == "" {
+			reason = why
+		}
+	}
+	return reason, !matched
+}
+
+// userTopics returns the topics to subscribe to for a command topic: the topic itself
+// and, if the ACL needs to know who sent commands, its per-user topics.
+func (a *accessControl) userTopics(topic string) []string {
+	if !a.identifiesUsers() {
+		return []string{topic}
+	}
+	return []string{topic, topic + "/+"}
+}
+
+// splitUser splits the username off topic if it was published to a per-user topic of
+// pattern, returning the command topic and the username.
+func splitUser(pattern, topic string) (string, string) {
+	if strings.Count(topic, "/") <= strings.Count(pattern, "/") {
+		return topic, ""
+	}
+	i := strings.LastIndex(topic, "/")
+	return topic[:i], topic[i+1:]
+}
+
+// commandName returns the ACL name of a command received on an MQTT command topic
+// named name (e.g. set_light) with payload.
+func commandName(name, payload string) string {
+	if name != "command" {
+		return strings.TrimPrefix(name, "set_")
+	}
+	switch command := strings.ToUpper(strings.TrimSpace(payload)); command {
+	case "GO_OPEN":
+		return ddapi.QueueOpen
+	case "GO_CLOSE":
+		return ddapi.QueueClose
+	default:
+		return strings.ToLower(command)
+	}
+}
+
+// normalizeCommand returns the ACL name of a gateway or scheduled command, normalized as
+// button names are (e.g. "Pet Open" becomes pet_open).
+func normalizeCommand(command string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(command)), " ", "_")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+func TestNewAccessControl_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ACLRule
+		want error
+	}{
+		{"no dash", ACLRule{Hours: "22:00"}, ErrACLHours},
+		{"bad time", ACLRule{Hours: "7am-22:00"}, ErrACLHours},
+		{"hour out of range", ACLRule{Hours: "07:00-25:00"}, ErrACLHours},
+		{"unknown source", ACLRule{Sources: []string{"telepathy"}}, ErrACLSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newAccessControl([]ACLRule{tt.rule}, "dd-door", ddapi.NewDeviceRegistry()); !errors.Is(err, tt.want) {
+				t.Errorf("newAccessControl() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	a, err := newAccessControl(nil, "dd-door", ddapi.NewDeviceRegistry())
+	if err != nil {
+		t.Fatalf("newAccessControl(nil) error = %v", err)
+	}
+	if err := a.check(commandRequest{Device: "door1", Command: "open", Source: ddapi.SourceMQTT}); err != nil {
+		t.Errorf("check() with no rules = %v, want allowed", err)
+	}
+}
+
+func TestAccessControl_Check(t *testing.T) {
+	a, err := newAccessControl([]ACLRule{
+		{Commands: []string{"open"}, Users: []string{"alice", "bob"}},
+		{Commands: []string{"open"}, Sources: []string{"schedule"}},
+		{Commands: []string{"light"}, Devices: []string{"door1"}, Hours: "22:00-06:00"},
+	}, "dd-door", ddapi.NewDeviceRegistry())
+	if err != nil {
+		t.Fatalf("newAccessControl() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		req   commandRequest
+		clock string
+		allow bool
+	}{
+		{"allowed user", commandRequest{Device: "door1", Command: "open", Source: "mqtt", User: "alice"}, "12:00", true},
+		{"other user", commandRequest{Device: "door1", Command: "open", Source: "mqtt", User: "eve"}, "12:00", false},
+		{"no user", commandRequest{Device: "door1", Command: "open", Source: "mqtt"}, "12:00", false},
+		{"allowed source", commandRequest{Device: "door1", Command: "open", Source: "schedule"}, "12:00", true},
+		{"gateway", commandRequest{Device: "door1", Command: "open", Source: "gateway"}, "12:00", false},
+		{"unrestricted command", commandRequest{Device: "door1", Command: "close", Source: "gateway"}, "12:00", true},
+		{"before midnight", commandRequest{Device: "door1", Command: "light", Source: "mqtt"}, "23:30", true},
+		{"after midnight", commandRequest{Device: "door1", Command: "light", Source: "mqtt"}, "05:59", true},
+		{"end of hours", commandRequest{Device: "door1", Command: "light", Source: "mqtt"}, "06:00", false},
+		{"daytime", commandRequest{Device: "door1", Command: "light", Source: "mqtt"}, "12:00", false},
+		{"other device", commandRequest{Device: "door2", Command: "light", Source: "mqtt"}, "12:00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock, _ := time.Parse("15:04", tt.clock)
+			a.now = func() time.Time {
+				return time.Date(2024, time.June, 3, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+			}
+			err := a.check(tt.req)
+			if allowed := err == nil; allowed != tt.allow {
+				t.Errorf("check(%+v) at %s = %v, want allowed %v", tt.req, tt.clock, err, tt.allow)
+			}
+			if err != nil && !errors.Is(err, ErrACLDenied) {
+				t.Errorf("check() error = %v, want %v", err, ErrACLDenied)
+			}
+		})
+	}
+}
+
+func TestAccessControl_UserTopics(t *testing.T) {
+	var none *accessControl
+	if got := none.userTopics("dd-door/+/command"); len(got) != 1 {
+		t.Errorf("userTopics() without an ACL = %v, want just the command topic", got)
+	}
+	a, err := newAccessControl([]ACLRule{{Users: []string{"alice"}}}, "dd-door", ddapi.NewDeviceRegistry())
+	if err != nil {
+		t.Fatalf("newAccessControl() error = %v", err)
+	}
+	if got := a.userTopics("dd-door/+/command"); len(got) != 2 || got[1] != "dd-door/+/command/+" {
+		t.Errorf("userTopics() with users = %v, want the command and per-user topics", got)
+	}
+}
+
+func TestSplitUser(t *testing.T) {
+	tests := []struct {
+		topic, wantTopic, wantUser string
+	}{
+		{"home/dd-door/abc123/command", "home/dd-door/abc123/command", ""},
+		{"home/dd-door/abc123/command/alice", "home/dd-door/abc123/command", "alice"},
+	}
+	for _, tt := range tests {
+		topic, user := splitUser("home/dd-door/+/command", tt.topic)
+		if topic != tt.wantTopic || user != tt.wantUser {
+			t.Errorf("splitUser(%q) = %q, %q, want %q, %q", tt.topic, topic, user, tt.wantTopic, tt.wantUser)
+		}
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	tests := []struct {
+		name, payload, want string
+	}{
+		{"command", "GO_OPEN", "open"},
+		{"command", "go_close", "close"},
+		{"command", "STOP", "stop"},
+		{"set_position", "50", "position"},
+		{"set_light", "ON", "light"},
+		{"set_auto_close", "15", "auto_close"},
+	}
+	for _, tt := range tests {
+		if got := commandName(tt.name, tt.payload); got != tt.want {
+			t.Errorf("commandName(%q, %q) = %q, want %q", tt.name, tt.payload, got, tt.want)
+		}
+	}
+}
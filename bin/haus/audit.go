@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// Audit log defaults, for settings left at zero
+const (
+	defaultAuditMaxSizeMB  = 10
+	defaultAuditMaxBackups = 5
+)
+
+// Kinds of audit log entry
+const (
+	auditCommand    = "command"    // a command received, or denied by the ACL
+	auditTransition = "transition" // a door's state changed
+	auditHubLog     = "hub_log"    // an entry in the hub's own log, e.g. "Opened by remote"
+)
+
+// AuditConfig sets up the audit log: every command haus receives, each door state change
+// and the hub's own log entries, as JSON lines appended to File.
+type AuditConfig struct {
+	File string `yaml:"file"` // empty disables the audit log
+	// MaxSizeMB is how large the file may grow before it's rotated to File.1, File.1 to
+	// File.2 and so on. Zero uses 10.
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	// MaxBackups is how many rotated files are kept. Zero uses 5.
+	MaxBackups int `yaml:"maxBackups"`
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Device  string    `json:"device,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Command string    `json:"command,omitempty"`
+	State   string    `json:"state,omitempty"`   // the resulting state, for transitions
+	Denied  string    `json:"denied,omitempty"`  // why the ACL denied a command
+	Message string    `json:"message,omitempty"` // the hub's log text
+}
+
+// audit is the bridge's audit log, if one is configured. It's set before anything connects.
+var audit *auditLog
+
+// auditLog appends entries to a file, rotating it once it reaches maxSize. A nil
+// *auditLog records nothing.
+type auditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	now func() time.Time
+}
+
+// openAuditLog opens the audit log config describes, or returns nil if it's disabled.
+func openAuditLog(config AuditConfig) (*auditLog, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	a := &auditLog{
+		path:       config.File,
+		maxSize:    int64(config.MaxSizeMB) << 20,
+		maxBackups: config.MaxBackups,
+		now:        time.Now,
+	}
+	if a.maxSize <= 0 {
+		a.maxSize = defaultAuditMaxSizeMB << 20
+	}
+	if a.maxBackups <= 0 {
+		a.maxBackups = defaultAuditMaxBackups
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open opens the file for appending.
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, info.Size()
+	return nil
+}
+
+// command records a command received from source, denied by the ACL if err isn't nil.
+func (a *auditLog) command(req commandRequest, err error) {
+	entry := auditEntry{Kind: auditCommand, Device: req.Device, Source: req.Source, User: req.User, Command: req.Command}
+	if err != nil {
+		entry.Denied = err.Error()
+	}
+	a.write(entry)
+}
+
+// transition returns a func recording deviceID's transitions, for DeviceFSM.OnTransition.
+func (a *auditLog) transition(deviceID string) func(ddapi.Transition) {
+	return func(t ddapi.Transition) {
+		a.write(auditEntry{Time: t.Time, Kind: auditTransition, Device: deviceID, Source: t.Source, Command: t.Event, State: t.Dst})
+	}
+}
+
+// hubLog records an entry from the hub's log for deviceID.
+func (a *auditLog) hubLog(deviceID string, entry ddapi.DoorStatusLog) {
+	a.write(auditEntry{Time: time.UnixMilli(entry.Time), Kind: auditHubLog, Device: deviceID, Message: entry.Text})
+}
+
+// write appends entry, stamping it with the current time if it has none.
+func (a *auditLog) write(entry auditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = a.now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.WithError(err).Error("Failed to encode audit entry")
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return
+	}
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			logger.WithError(err).WithField("file", a.path).Error("Failed to rotate audit log")
+			if a.f == nil {
+				return
+			}
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		logger.WithError(err).WithField("file", a.path).Error("Failed to write audit log")
+	}
+}
+
+// rotate moves the file to path.1, shifting older backups along and dropping any beyond
+// maxBackups, and starts a new file. a.mu must be held.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	a.f = nil
+	os.Remove(fmt.Sprintf("%s.%d", a.path, a.maxBackups))
+	for i := a.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	if err := os.Rename(a.path, a.path+".1"); err != nil {
+		// Keep appending to the file we have rather than losing entries
+		if openErr := a.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return a.open()
+}
+
+// Close closes the file.
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
+
+// authorize checks req against access and records it in the audit log, returning an
+// error wrapping ErrACLDenied if it isn't allowed.
+func authorize(access *accessControl, req commandRequest) error {
+	err := access.check(req)
+	audit.command(req, err)
+	return err
+}
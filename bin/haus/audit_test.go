@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// readAudit returns the entries in an audit log file.
+func readAudit(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog_Entries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(AuditConfig{File: path})
+	if err != nil {
+		t.Fatalf("openAuditLog() error = %v", err)
+	}
+	at := time.Date(2024, time.June, 3, 3, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return at }
+
+	a.command(commandRequest{Device: "door1", Command: "open", Source: ddapi.SourceMQTT, User: "alice"}, nil)
+	a.command(commandRequest{Device: "door1", Command: "open", Source: sourceGateway}, errors.New("command not allowed: source gateway not allowed"))
+	a.transition("door1")(ddapi.Transition{Time: at, Src: "closed", Dst: "opening", Event: "go_open", Source: ddapi.SourceMQTT})
+	a.hubLog("door1", ddapi.DoorStatusLog{ID: 7, Time: at.UnixMilli(), Text: "Opened by remote"})
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	want := []auditEntry{
+		{Time: at, Kind: auditCommand, Device: "door1", Source: "mqtt", User: "alice", Command: "open"},
+		{Time: at, Kind: auditCommand, Device: "door1", Source: "gateway", Command: "open", Denied: "command not allowed: source gateway not allowed"},
+		{Time: at, Kind: auditTransition, Device: "door1", Source: "mqtt", Command: "go_open", State: "opening"},
+		{Time: at, Kind: auditHubLog, Device: "door1", Message: "Opened by remote"},
+	}
+	got := readAudit(t, path)
+	if len(got) != len(want) {
+		t.Fatalf("audit log has %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("entry %d time = %v, want %v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Entries are appended across restarts
+	a, err = openAuditLog(AuditConfig{File: path})
+	if err != nil {
+		t.Fatalf("openAuditLog() error = %v", err)
+	}
+	a.command(commandRequest{Device: "door1", Command: "close", Source: ddapi.SourceMQTT}, nil)
+	a.Close()
+	if got := readAudit(t, path); len(got) != len(want)+1 {
+		t.Errorf("audit log has %d entries after reopening, want %d", len(got), len(want)+1)
+	}
+}
+
+func TestAuditLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(AuditConfig{File: path, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("openAuditLog() error = %v", err)
+	}
+	defer a.Close()
+	a.maxSize = 150 // about one entry
+
+	for i := 0; i < 5; i++ {
+		a.command(commandRequest{Device: "door1", Command: "open", Source: ddapi.SourceMQTT}, nil)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if got := readAudit(t, name); len(got) != 1 {
+			t.Errorf("%s has %d entries, want 1", filepath.Base(name), len(got))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Stat(audit.log.3) error = %v, want it not to exist beyond MaxBackups", err)
+	}
+}
+
+func TestAuditLog_Disabled(t *testing.T) {
+	a, err := openAuditLog(AuditConfig{})
+	if a != nil || err != nil {
+		t.Fatalf("openAuditLog() = %v, %v, want nil with no file", a, err)
+	}
+	// A nil log records nothing, without panicking
+	a.command(commandRequest{Command: "open"}, nil)
+	if err := a.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional YAML (or JSON) config file for haus. Any flag given on the
+// command line or in the environment (see helper.SetFlagsFromEnv) overrides the matching
+// file value; flags not given fill in values the file leaves unset.
+type Config struct {
+	Host        string     `yaml:"host"`
+	Port        int        `yaml:"port"`
+	SDKPort     int        `yaml:"sdkPort"`
+	Credentials string     `yaml:"credentials"`
+	LogLevel    string     `yaml:"logLevel"` // logrus level name, e.g. "debug"
+	MQTT        MQTTConfig `yaml:"mqtt"`
+
+	TLSFingerprint string `yaml:"tlsFingerprint"`
+
+	// Mode is how hubs are reached: local (the default), cloud, or auto to fall back to the
+	// cloud API at RemoteHost when a hub is unreachable on the LAN.
+	Mode       string `yaml:"mode"`
+	RemoteHost string `yaml:"remoteHost"`
+
+	// HTTPAddr serves the local HTTP/WebSocket gateway. With no MQTT broker set, haus runs
+	// the gateway alone.
+	HTTPAddr string `yaml:"httpAddr"`
+
+	// DryRun logs door commands and publishes them to <prefix>/<device>/dry_run instead of
+	// sending them to the hub; see ddapi.EnableDryRun.
+	DryRun bool `yaml:"dryRun"`
+
+	// ReadOnly publishes states and sensors but rejects commands from Home Assistant and
+	// the gateway, publishing a warning to <prefix>/bridge/warning for each.
+	ReadOnly bool `yaml:"readOnly"`
+
+	// ACL restricts who may send which commands, and when; see ACLRule
+	ACL []ACLRule `yaml:"acl"`
+
+	// Audit records commands and state changes to a file; see AuditConfig
+	Audit AuditConfig `yaml:"audit"`
+
+	// StateFile saves each device's last resting state and position, which are published
+	// on startup before the hubs are polled. Empty disables it.
+	StateFile string `yaml:"stateFile"`
+
+	// Hubs lists the base stations to bridge. If empty, the top-level host, ports and
+	// credentials describe a single hub.
+	Hubs []HubConfig `yaml:"hubs"`
+
+	Devices []DeviceConfig `yaml:"devices"`
+
+	// Schedules run door actions on cron schedules; see ScheduleConfig
+	Schedules []ScheduleConfig `yaml:"schedules"`
+
+	// Webhooks are posted on door and hub events; see WebhookConfig
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// DeviceConfig holds per-device overrides, matched by device ID.
+type DeviceConfig struct {
+	ID           string `yaml:"id"`
+	ExpireAfter  int    `yaml:"expireAfter"`
+	ScanInterval int    `yaml:"scanInterval"`
+
+	// Home Assistant entity settings; see ddapi.DeviceOptions
+	Name        string `yaml:"name"`
+	ObjectID    string `yaml:"objectId"`
+	DeviceClass string `yaml:"deviceClass"` // e.g. gate or shutter
+	Icon        string `yaml:"icon"`
+
+	// AutoClose closes the door once it's been open this many minutes; 0 leaves it open.
+	// It can be changed from Home Assistant until haus restarts.
+	AutoClose int `yaml:"autoClose"`
+	// AutoCloseWarning fires the auto-close warning trigger this many minutes before closing
+	AutoCloseWarning int `yaml:"autoCloseWarning"`
+}
+
+// options returns the discovery options d overrides.
+func (d DeviceConfig) options() ddapi.DeviceOptions {
+	return ddapi.DeviceOptions{
+		ExpireAfter:  d.ExpireAfter,
+		ScanInterval: d.ScanInterval,
+		Name:         d.Name,
+		ObjectID:     d.ObjectID,
+		DeviceClass:  d.DeviceClass,
+		Icon:         d.Icon,
+	}
+}
+
+// loadConfig reads a Config from a YAML file. JSON is accepted too, being a subset of YAML.
+func loadConfig(p string) (*Config, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	err = yaml.Unmarshal(b, &config)
+	return &config, err
+}
+
+// applyFlags merges the command line into c. set holds the names of flags that were
+// given explicitly on the command line or in the environment, as reported by flag.Visit.
+func (c *Config) applyFlags(set map[string]bool) {
+	override(set, "host", &c.Host, *flagHost)
+	override(set, "port", &c.Port, *flagPort)
+	override(set, "sdk-port", &c.SDKPort, *flagSDKPort)
+	override(set, "credentials", &c.Credentials, *flagCredentialsPath)
+	override(set, "tlsFingerprint", &c.TLSFingerprint, *flagTLSFingerprint)
+	override(set, "mode", &c.Mode, *flagMode)
+	override(set, "remoteHost", &c.RemoteHost, *flagRemoteHost)
+	override(set, "httpAddr", &c.HTTPAddr, *flagHTTPAddr)
+	override(set, "stateFile", &c.StateFile, *flagStateFile)
+	override(set, "dryRun", &c.DryRun, *flagDryRun)
+	override(set, "readOnly", &c.ReadOnly, *flagReadOnly)
+	override(set, "auditLog", &c.Audit.File, *flagAuditLog)
+	override(set, "mqtt", &c.MQTT.Broker, *flagMqtt)
+	override(set, "mqttPort", &c.MQTT.Port, *flagMqttPort)
+	override(set, "mqttUser", &c.MQTT.User, *flagMqttUser)
+	override(set, "mqttPassword", &c.MQTT.Password, *flagMqttPassword)
+	override(set, "mqttClientID", &c.MQTT.ClientID, *flagMqttClientID)
+	override(set, "mqttPrefix", &c.MQTT.Prefix, *flagMqttPrefix)
+	override(set, "mqttTLS", &c.MQTT.TLS, *flagMqttTLS)
+	override(set, "mqttCA", &c.MQTT.CAFile, *flagMqttCA)
+	override(set, "mqttCert", &c.MQTT.CertFile, *flagMqttCert)
+	override(set, "mqttKey", &c.MQTT.KeyFile, *flagMqttKey)
+	if *flagDebug {
+		c.LogLevel = logrus.DebugLevel.String()
+	}
+}
+
+// override sets *dst to flagValue if the flag was given, or if the file left *dst unset.
+func override[T comparable](set map[string]bool, name string, dst *T, flagValue T) {
+	var zero T
+	if set[name] || *dst == zero {
+		*dst = flagValue
+	}
+}
+
+// setFlags returns the names of the flags given on the command line or in the environment.
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// deviceOptions returns the discovery options for deviceID, or defaults if it has no overrides.
+func (c *Config) deviceOptions(deviceID string) ddapi.DeviceOptions {
+	return c.deviceConfig(deviceID).options()
+}
+
+// deviceConfig returns the overrides for deviceID, or an empty DeviceConfig if it has none.
+func (c *Config) deviceConfig(deviceID string) DeviceConfig {
+	for _, d := range c.Devices {
+		if d.ID == deviceID {
+			return d
+		}
+	}
+	return DeviceConfig{ID: deviceID}
+}
+
+// validateDevices checks every device override is usable.
+func (c *Config) validateDevices() error {
+	for _, d := range c.Devices {
+		if err := d.options().Validate(); err != nil {
+			return fmt.Errorf("device %s: %w", d.ID, err)
+		}
+		if d.AutoClose < 0 || d.AutoClose > ddapi.MaxAutoCloseMinutes {
+			return fmt.Errorf("device %s: %w: %d", d.ID, ddapi.ErrInvalidAutoClose, d.AutoClose)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+func TestLoadConfig_DeviceOptions(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "haus.yaml")
+	validYAML := `
+devices:
+  - id: abc123
+    expireAfter: 120
+    scanInterval: 20
+    name: Side Gate
+    objectId: side_gate
+    deviceClass: gate
+    icon: mdi:gate
+    autoClose: 15
+    autoCloseWarning: 1
+`
+	if err := os.WriteFile(configFile, []byte(validYAML), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	options := config.deviceOptions("abc123")
+	if options.ExpireAfter != 120 {
+		t.Errorf("deviceOptions(abc123).ExpireAfter = %d, want 120", options.ExpireAfter)
+	}
+	if options.ScanInterval != 20 {
+		t.Errorf("deviceOptions(abc123).ScanInterval = %d, want 20", options.ScanInterval)
+	}
+
+	if options.Name != "Side Gate" || options.ObjectID != "side_gate" || options.DeviceClass != "gate" || options.Icon != "mdi:gate" {
+		t.Errorf("deviceOptions(abc123) = %+v, want entity overrides", options)
+	}
+	if err := config.validateDevices(); err != nil {
+		t.Errorf("validateDevices() error = %v", err)
+	}
+	if d := config.deviceConfig("abc123"); d.AutoClose != 15 || d.AutoCloseWarning != 1 {
+		t.Errorf("deviceConfig(abc123) auto-close = %d, %d, want 15, 1", d.AutoClose, d.AutoCloseWarning)
+	}
+
+	if options := config.deviceOptions("other"); options != (ddapi.DeviceOptions{}) {
+		t.Errorf("deviceOptions(other) = %+v, want defaults", options)
+	}
+}
+
+func TestConfig_ValidateDevices(t *testing.T) {
+	config := &Config{Devices: []DeviceConfig{{ID: "abc123", DeviceClass: "portcullis"}}}
+	if err := config.validateDevices(); !errors.Is(err, ddapi.ErrInvalidDeviceClass) {
+		t.Errorf("validateDevices() error = %v, want %v", err, ddapi.ErrInvalidDeviceClass)
+	}
+	config = &Config{Devices: []DeviceConfig{{ID: "abc123", AutoClose: -5}}}
+	if err := config.validateDevices(); !errors.Is(err, ddapi.ErrInvalidAutoClose) {
+		t.Errorf("validateDevices() error = %v, want %v", err, ddapi.ErrInvalidAutoClose)
+	}
+}
+
+func TestConfig_ValidateReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"off", Config{Schedules: []ScheduleConfig{{Name: "night"}}, Devices: []DeviceConfig{{ID: "abc123", AutoClose: 5}}}, false},
+		{"on", Config{ReadOnly: true, Devices: []DeviceConfig{{ID: "abc123", Name: "Garage"}}}, false},
+		{"schedule", Config{ReadOnly: true, Schedules: []ScheduleConfig{{Name: "night"}}}, true},
+		{"auto-close", Config{ReadOnly: true, Devices: []DeviceConfig{{ID: "abc123", AutoClose: 5}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateReadOnly()
+			if got := errors.Is(err, ErrReadOnlyConflict); got != tt.wantErr {
+				t.Errorf("validateReadOnly() error = %v, want conflict %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_FileNotFound(t *testing.T) {
+	if _, err := loadConfig("nonexistent_config.yaml"); err == nil {
+		t.Errorf("loadConfig() with nonexistent file should return error")
+	}
+}
+
+func TestLoadConfig_Full(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "haus.json")
+	validJSON := `{
+  "host": "192.168.1.20",
+  "credentials": "/config/dd.json",
+  "logLevel": "warn",
+  "dryRun": true,
+  "readOnly": true,
+  "acl": [{"commands": ["open"], "users": ["alice"], "hours": "07:00-22:00"}],
+  "mqtt": {"broker": "broker.local", "port": 8883, "tls": true, "prefix": "garage"}
+}`
+	if err := os.WriteFile(configFile, []byte(validJSON), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Host != "192.168.1.20" || config.Credentials != "/config/dd.json" || config.LogLevel != "warn" {
+		t.Errorf("loadConfig() = %+v, want host, credentials and logLevel from file", config)
+	}
+	if !config.DryRun || !config.ReadOnly {
+		t.Errorf("loadConfig() DryRun = %v, ReadOnly = %v, want true from file", config.DryRun, config.ReadOnly)
+	}
+	if len(config.ACL) != 1 || config.ACL[0].Users[0] != "alice" || config.ACL[0].Hours != "07:00-22:00" {
+		t.Errorf("loadConfig() ACL = %+v, want the rule from file", config.ACL)
+	}
+	if config.MQTT.Broker != "broker.local" || config.MQTT.Port != 8883 || !config.MQTT.TLS || config.MQTT.Prefix != "garage" {
+		t.Errorf("loadConfig() MQTT = %+v, want settings from file", config.MQTT)
+	}
+}
+
+func TestConfig_ApplyFlags(t *testing.T) {
+	prevHost, prevBroker := *flagHost, *flagMqtt
+	t.Cleanup(func() { *flagHost, *flagMqtt = prevHost, prevBroker })
+	*flagHost = "10.0.0.5"
+	*flagMqtt = "flag-broker"
+
+	config := &Config{
+		Host: "192.168.1.20",
+		MQTT: MQTTConfig{Broker: "file-broker", Prefix: "garage"},
+	}
+	config.applyFlags(map[string]bool{"host": true})
+
+	// Flags given on the command line win
+	if config.Host != "10.0.0.5" {
+		t.Errorf("Host = %q, want flag value %q", config.Host, "10.0.0.5")
+	}
+	// File values win over flags that weren't given
+	if config.MQTT.Broker != "file-broker" {
+		t.Errorf("MQTT.Broker = %q, want file value %q", config.MQTT.Broker, "file-broker")
+	}
+	if config.MQTT.Prefix != "garage" {
+		t.Errorf("MQTT.Prefix = %q, want file value %q", config.MQTT.Prefix, "garage")
+	}
+	// Flag defaults fill in what the file leaves unset
+	if config.MQTT.Port != 1883 || config.MQTT.ClientID != "dd_haus" || config.Credentials != "dd-credentials.json" {
+		t.Errorf("defaults not applied: port %d, client ID %q, credentials %q",
+			config.MQTT.Port, config.MQTT.ClientID, config.Credentials)
+	}
+}
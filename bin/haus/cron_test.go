@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCron_Matches(t *testing.T) {
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"0 22 * * *", at(3, 22, 0), true},
+		{"0 22 * * *", at(3, 22, 1), false},
+		{"0 22 * * *", at(3, 21, 0), false},
+		{"*/15 * * * *", at(3, 9, 45), true},
+		{"*/15 * * * *", at(3, 9, 46), false},
+		{"5/20 * * * *", at(3, 9, 25), true},
+		{"0 7 * * 1-5", at(3, 7, 0), true},
+		{"0 7 * * 1-5", at(8, 7, 0), false}, // Saturday
+		{"0 7 * * 0", at(9, 7, 0), true},    // Sunday
+		{"0 7 * * 7", at(9, 7, 0), true},    // Sunday as 7
+		{"0 7 1,15 * *", at(15, 7, 0), true},
+		{"0 7 1,15 * *", at(14, 7, 0), false},
+		{"0 7 * 1-5 *", at(3, 7, 0), false},
+		// Both day fields restricted: either matches
+		{"0 7 1 * 1", at(3, 7, 0), true},
+		{"0 7 1 * 1", at(4, 7, 0), false},
+		{"@daily", at(3, 0, 0), true},
+		{"@hourly", at(3, 13, 0), true},
+		{"@hourly", at(3, 13, 30), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.expr, err)
+			}
+			if got := c.matches(tt.t); got != tt.want {
+				t.Errorf("parseCron(%q).matches(%v) = %v, want %v", tt.expr, tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "0 22 * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		if _, err := parseCron(expr); !errors.Is(err, ErrInvalidCron) {
+			t.Errorf("parseCron(%q) error = %v, want %v", expr, err, ErrInvalidCron)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// gatewayClientBuffer is how many updates a slow /events client may fall behind before
+// updates to it are dropped.
+const gatewayClientBuffer = 16
+
+// gatewayDevice is a device as served by the gateway: its latest status and the hub it's on.
+type gatewayDevice struct {
+	Hub string `json:"hub,omitempty"`
+	ddapi.DoorStatusDevice
+
+	conn *dd.Conn
+}
+
+// gatewayCommand is the body of POST /devices/{id}/command. Command is a command name
+// (as for bin/action) or code; Position, if set, moves the door to that percentage instead.
+type gatewayCommand struct {
+	Command  string `json:"command"`
+	Position *int   `json:"position"`
+}
+
+// gateway serves door status and commands over HTTP, for integrations without an MQTT broker:
+//
+//	GET  /devices               all devices, as JSON
+//	POST /devices/{id}/command  send {"command": "open"} or {"position": 50}
+//	GET  /events                WebSocket of device updates, starting with every device
+type gateway struct {
+	mu      sync.Mutex
+	devices map[string]gatewayDevice
+	order   []string // device IDs, in the order first seen
+	clients map[chan gatewayDevice]struct{}
+
+	readOnly bool           // reject commands; see -readOnly
+	access   *accessControl // nil allows every command
+
+	upgrader websocket.Upgrader
+}
+
+func newGateway() *gateway {
+	return &gateway{
+		devices: make(map[string]gatewayDevice),
+		clients: make(map[chan gatewayDevice]struct{}),
+	}
+}
+
+// update records a status update from h and sends changed devices to /events clients.
+func (g *gateway) update(h *hub, status ddapi.DoorStatus) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, device := range status.Devices {
+		prev, seen := g.devices[device.ID]
+		d := gatewayDevice{Hub: h.name, DoorStatusDevice: device, conn: h.conn}
+		g.devices[device.ID] = d
+		if !seen {
+			g.order = append(g.order, device.ID)
+		} else if device.Equal(prev.DoorStatusDevice) && device.Name == prev.Name {
+			continue
+		}
+
+		for ch := range g.clients {
+			select {
+			case ch <- d:
+			default:
+				logger.WithField("deviceID", device.ID).Warn("Gateway client is behind; dropping update")
+			}
+		}
+	}
+}
+
+// snapshot returns every device in order.
+func (g *gateway) snapshot() []gatewayDevice {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]gatewayDevice, 0, len(g.order))
+	for _, id := range g.order {
+		out = append(out, g.devices[id])
+	}
+	return out
+}
+
+func (g *gateway) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /devices", g.handleDevices)
+	mux.HandleFunc("POST /devices/{id}/command", g.handleCommand)
+	mux.HandleFunc("GET /events", g.handleEvents)
+	return mux
+}
+
+func (g *gateway) handleDevices(w http.ResponseWriter, r *http.Request) {
+	writeGatewayJSON(w, http.StatusOK, g.snapshot())
+}
+
+func (g *gateway) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if g.readOnly {
+		logger.WithField("device", r.PathValue("id")).Warn("Read-only: rejected gateway command")
+		writeGatewayError(w, http.StatusForbidden, ErrReadOnly)
+		return
+	}
+	// Requiring JSON stops browsers being used to post commands cross-site
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeGatewayError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+		return
+	}
+	var req gatewayCommand
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeGatewayError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id := r.PathValue("id")
+	g.mu.Lock()
+	device, ok := g.devices[id]
+	g.mu.Unlock()
+	if !ok {
+		writeGatewayError(w, http.StatusNotFound, ddapi.ErrDeviceNotFound)
+		return
+	}
+
+	var command int
+	if req.Position != nil {
+		if *req.Position < ddapi.PositionClosed || *req.Position > ddapi.PositionOpen {
+			writeGatewayError(w, http.StatusBadRequest, errors.New("position must be 0-100"))
+			return
+		}
+		command = ddapi.GetCommandForPosition(*req.Position)
+	} else {
+		registry := ddapi.ParseCommandsFromButtons(&ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{device.DoorStatusDevice}})
+		var err error
+		command, err = ddapi.GetCommandForDevice(id, req.Command, registry)
+		if err != nil {
+			writeGatewayError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	name := ddapi.QueuePosition
+	if req.Position == nil {
+		name = normalizeCommand(req.Command)
+	}
+	if err := authorize(g.access, commandRequest{Device: id, Command: name, Source: sourceGateway}); err != nil {
+		writeGatewayError(w, http.StatusForbidden, err)
+		return
+	}
+
+	if err := ddapi.SafeCommandContext(r.Context(), device.conn, id, command); err != nil {
+		writeGatewayError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (g *gateway) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ws, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied
+		return
+	}
+	defer ws.Close()
+
+	ch := make(chan gatewayDevice, gatewayClientBuffer)
+	g.mu.Lock()
+	g.clients[ch] = struct{}{}
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.clients, ch)
+		g.mu.Unlock()
+	}()
+
+	// Reading is only needed to notice the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, d := range g.snapshot() {
+		if err := ws.WriteJSON(d); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case d := <-ch:
+			if err := ws.WriteJSON(d); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeGatewayJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.WithError(err).Debug("Failed to write gateway response")
+	}
+}
+
+func writeGatewayError(w http.ResponseWriter, status int, err error) {
+	writeGatewayJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// serveGateway listens on addr and serves g until the returned shutdown func is called.
+func serveGateway(addr string, g *gateway) (func(context.Context) error, error) {
+	// Listen up front so a bad address fails startup rather than a background goroutine
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: g.handler()}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("Gateway server stopped")
+		}
+	}()
+	return server.Shutdown, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+)
+
+// gatewayDoor returns a device at position.
+func gatewayDoor(id string, position int) ddapi.DoorStatusDevice {
+	d := ddapi.DoorStatusDevice{ID: id, Name: "Door " + id}
+	d.Device.Position = position
+	return d
+}
+
+// newTestGateway returns a gateway serving one hub backed by a ddtest.Server.
+func newTestGateway(t *testing.T) (*gateway, *hub, *ddtest.Server, *httptest.Server) {
+	t.Helper()
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+	conn := server.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	g := newGateway()
+	h := &hub{name: "garage", conn: conn, gateway: g}
+	web := httptest.NewServer(g.handler())
+	t.Cleanup(web.Close)
+	return g, h, server, web
+}
+
+func TestGateway_Devices(t *testing.T) {
+	g, h, _, web := newTestGateway(t)
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 0), gatewayDoor("door2", 100)}})
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 40)}})
+
+	resp, err := http.Get(web.URL + "/devices")
+	if err != nil {
+		t.Fatalf("GET /devices error = %v", err)
+	}
+	defer resp.Body.Close()
+	var devices []gatewayDevice
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		t.Fatalf("decode /devices: %v", err)
+	}
+	if len(devices) != 2 || devices[0].ID != "door1" || devices[1].ID != "door2" {
+		t.Fatalf("GET /devices = %+v, want door1 then door2", devices)
+	}
+	if devices[0].Device.Position != 40 || devices[0].Hub != "garage" {
+		t.Errorf("door1 = %+v, want position 40 on hub garage", devices[0])
+	}
+}
+
+func TestGateway_Command(t *testing.T) {
+	g, h, server, web := newTestGateway(t)
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 0)}})
+	var sent ddapi.CommandInput
+	server.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		return nil, json.Unmarshal(body, &sent)
+	})
+
+	tests := []struct {
+		name        string
+		device      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCommand int
+	}{
+		{"open", "door1", "application/json", `{"command":"open"}`, http.StatusAccepted, ddapi.AvailableCommands.Open},
+		{"position", "door1", "application/json", `{"position":50}`, http.StatusAccepted, ddapi.AvailableCommands.OpenPercent50},
+		{"unknown command", "door1", "application/json", `{"command":"fly"}`, http.StatusBadRequest, 0},
+		{"bad position", "door1", "application/json", `{"position":150}`, http.StatusBadRequest, 0},
+		{"unknown device", "door9", "application/json", `{"command":"open"}`, http.StatusNotFound, 0},
+		{"form post", "door1", "application/x-www-form-urlencoded", `command=open`, http.StatusUnsupportedMediaType, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent = ddapi.CommandInput{}
+			resp, err := http.Post(web.URL+"/devices/"+tt.device+"/command", tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("POST error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("POST status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if sent.Action.Command != tt.wantCommand {
+				t.Errorf("sent command %d, want %d", sent.Action.Command, tt.wantCommand)
+			}
+		})
+	}
+}
+
+func TestGateway_ReadOnly(t *testing.T) {
+	g, h, server, web := newTestGateway(t)
+	g.readOnly = true
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 0)}})
+	server.Handle("/app/res/action", func([]byte) (interface{}, error) {
+		t.Error("read-only gateway sent a command")
+		return nil, nil
+	})
+
+	resp, err := http.Post(web.URL+"/devices/door1/command", "application/json", strings.NewReader(`{"command":"open"}`))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	resp, err = http.Get(web.URL + "/devices")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /devices status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestGateway_ACL(t *testing.T) {
+	g, h, server, web := newTestGateway(t)
+	access, err := newAccessControl([]ACLRule{{Commands: []string{"open"}, Sources: []string{"mqtt"}}}, "dd-door", ddapi.NewDeviceRegistry())
+	if err != nil {
+		t.Fatalf("newAccessControl() error = %v", err)
+	}
+	g.access = access
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 0)}})
+	var sent ddapi.CommandInput
+	server.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		return nil, json.Unmarshal(body, &sent)
+	})
+
+	tests := []struct {
+		body        string
+		wantStatus  int
+		wantCommand int
+	}{
+		{`{"command":"open"}`, http.StatusForbidden, 0},
+		{`{"command":"close"}`, http.StatusAccepted, ddapi.AvailableCommands.Close},
+	}
+	for _, tt := range tests {
+		sent = ddapi.CommandInput{}
+		resp, err := http.Post(web.URL+"/devices/door1/command", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("POST error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus || sent.Action.Command != tt.wantCommand {
+			t.Errorf("POST %s = status %d, sent %d; want %d, %d", tt.body, resp.StatusCode, sent.Action.Command, tt.wantStatus, tt.wantCommand)
+		}
+	}
+}
+
+func TestGateway_Events(t *testing.T) {
+	g, h, _, web := newTestGateway(t)
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 0)}})
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(web.URL, "http")+"/events", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer ws.Close()
+
+	read := func() gatewayDevice {
+		t.Helper()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var d gatewayDevice
+		if err := ws.ReadJSON(&d); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return d
+	}
+
+	// Every known device first
+	if d := read(); d.ID != "door1" || d.Device.Position != 0 {
+		t.Errorf("first event = %+v, want door1 at 0", d)
+	}
+
+	// Unchanged devices aren't sent again
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 0)}})
+	g.update(h, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{gatewayDoor("door1", 60)}})
+	if d := read(); d.ID != "door1" || d.Device.Position != 60 {
+		t.Errorf("event = %+v, want door1 at 60", d)
+	}
+}
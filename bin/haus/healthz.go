@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// healthServer answers container health checks:
+//
+//	GET /healthz  liveness: 503 once a hub has gone maxAge without answering, i.e. haus is wedged
+//	GET /readyz   readiness: 503 until MQTT is connected and every hub has answered within
+//	              maxAge and sent a status
+//
+// Both answer with a healthReport. A Runner polls each hub continuously, so a hub that
+// hasn't answered in minutes means its status loop is stuck or it has been unreachable
+// throughout; a restart is the way out of the former.
+type healthServer struct {
+	hubs []*hub
+	// connected reports whether haus is connected to the broker. Nil without MQTT.
+	connected func() bool
+	maxAge    time.Duration
+
+	started time.Time
+	now     func() time.Time
+}
+
+// hubHealth is a hub's part of a healthReport. Ages are in seconds, and null if the hub
+// never answered or sent a status.
+type hubHealth struct {
+	Name       string `json:"name,omitempty"`
+	SessionAge *int   `json:"sessionAge"` // since the hub last answered a connect or poll
+	StatusAge  *int   `json:"statusAge"`  // since the last status update
+	Healthy    bool   `json:"healthy"`    // the hub is answering health check pings
+}
+
+// healthReport is the body of /healthz and /readyz.
+type healthReport struct {
+	OK       bool        `json:"ok"`
+	MQTT     *bool       `json:"mqttConnected,omitempty"` // omitted without MQTT
+	Hubs     []hubHealth `json:"hubs"`
+	Problems []string    `json:"problems,omitempty"`
+}
+
+func newHealthServer(hubs []*hub, connected func() bool, maxAge time.Duration) *healthServer {
+	return &healthServer{hubs: hubs, connected: connected, maxAge: maxAge, started: time.Now(), now: time.Now}
+}
+
+func (s *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.write(w, s.report(false))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.write(w, s.report(true))
+	})
+	return mux
+}
+
+func (s *healthServer) write(w http.ResponseWriter, report healthReport) {
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	writeGatewayJSON(w, status, report)
+}
+
+// report checks liveness, or readiness if ready is set.
+func (s *healthServer) report(ready bool) healthReport {
+	now := s.now()
+	report := healthReport{Hubs: make([]hubHealth, 0, len(s.hubs))}
+	if s.connected != nil {
+		connected := s.connected()
+		report.MQTT = &connected
+		if ready && !connected {
+			report.Problems = append(report.Problems, "not connected to MQTT")
+		}
+	}
+	for _, h := range s.hubs {
+		health := hubHealth{Name: h.name, Healthy: h.conn.IsHealthy()}
+		name := h.name
+		if name == "" {
+			name = "hub"
+		}
+
+		last := h.conn.LastResponse()
+		if !last.IsZero() {
+			health.SessionAge = ageSeconds(now.Sub(last))
+		} else {
+			// Count from startup, so a hub that never connects only fails liveness
+			// once it's had as long as a stuck one
+			last = s.started
+			if ready {
+				report.Problems = append(report.Problems, name+" not connected")
+			}
+		}
+		if age := now.Sub(last); age > s.maxAge {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s not answered for %s", name, age.Round(time.Second)))
+		}
+
+		h.mu.Lock()
+		lastStatus := h.lastStatus
+		h.mu.Unlock()
+		if !lastStatus.IsZero() {
+			health.StatusAge = ageSeconds(now.Sub(lastStatus))
+		} else if ready {
+			report.Problems = append(report.Problems, name+" has sent no status")
+		}
+		report.Hubs = append(report.Hubs, health)
+	}
+	report.OK = len(report.Problems) == 0
+	return report
+}
+
+// watchdogHealthy reports whether haus is live and connected to MQTT, for the systemd
+// watchdog.
+func (s *healthServer) watchdogHealthy() bool {
+	report := s.report(false)
+	return report.OK && (report.MQTT == nil || *report.MQTT)
+}
+
+func ageSeconds(d time.Duration) *int {
+	seconds := int(d.Round(time.Second) / time.Second)
+	return &seconds
+}
+
+// serveHealth listens on addr and serves s until the returned shutdown func is called.
+func serveHealth(addr string, s *healthServer) (func(context.Context) error, error) {
+	// Listen up front so a bad address fails startup rather than a background goroutine
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: s.handler()}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("Health server stopped")
+		}
+	}()
+	return server.Shutdown, nil
+}
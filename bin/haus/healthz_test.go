@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gravypower/dd"
+)
+
+// getHealth fetches path from s, returning the status code and report.
+func getHealth(t *testing.T, s *healthServer, path string) (int, healthReport) {
+	t.Helper()
+	web := httptest.NewServer(s.handler())
+	defer web.Close()
+	resp, err := http.Get(web.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", path, err)
+	}
+	defer resp.Body.Close()
+	var report healthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return resp.StatusCode, report
+}
+
+func TestHealthServer(t *testing.T) {
+	_, h, _, _ := newTestGateway(t)
+	connected := false
+	s := newHealthServer([]*hub{h}, func() bool { return connected }, 10*time.Minute)
+
+	// Connected to the hub, but no status yet and MQTT down: alive, not ready
+	if code, report := getHealth(t, s, "/healthz"); code != http.StatusOK || !report.OK {
+		t.Errorf("GET /healthz = %d %+v, want 200", code, report)
+	}
+	code, report := getHealth(t, s, "/readyz")
+	if code != http.StatusServiceUnavailable || len(report.Problems) != 2 {
+		t.Errorf("GET /readyz = %d %+v, want 503 for MQTT and the missing status", code, report)
+	}
+	if report.MQTT == nil || *report.MQTT || report.Hubs[0].SessionAge == nil || report.Hubs[0].StatusAge != nil {
+		t.Errorf("GET /readyz report = %+v, want MQTT down, a session age and no status age", report)
+	}
+
+	if s.watchdogHealthy() {
+		t.Error("watchdogHealthy() without MQTT = true, want false")
+	}
+
+	connected = true
+	if !s.watchdogHealthy() {
+		t.Error("watchdogHealthy() = false, want true")
+	}
+	h.handleStatus(context.Background(), nil, &Config{}, statusAt(0))
+	if code, report := getHealth(t, s, "/readyz"); code != http.StatusOK || report.Hubs[0].StatusAge == nil {
+		t.Errorf("GET /readyz = %d %+v, want 200 with a status age", code, report)
+	}
+
+	// Once the hub hasn't answered for maxAge, haus is wedged
+	s.now = func() time.Time { return time.Now().Add(11 * time.Minute) }
+	if code, _ := getHealth(t, s, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /healthz after 11m = %d, want 503", code)
+	}
+}
+
+func TestHealthServer_NeverConnected(t *testing.T) {
+	h := &hub{name: "garage", conn: &dd.Conn{}}
+	s := newHealthServer([]*hub{h}, nil, time.Minute)
+
+	code, report := getHealth(t, s, "/healthz")
+	if code != http.StatusOK || report.MQTT != nil {
+		t.Errorf("GET /healthz at startup = %d %+v, want 200 without MQTT", code, report)
+	}
+	if code, _ := getHealth(t, s, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz before connecting = %d, want 503", code)
+	}
+	s.now = func() time.Time { return s.started.Add(2 * time.Minute) }
+	if code, _ := getHealth(t, s, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /healthz after maxAge without connecting = %d, want 503", code)
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+	"github.com/sirupsen/logrus"
+)
+
+// discoverHost is the host setting that finds the hub on the LAN by its base station ID.
+const discoverHost = "auto"
+
+// discoverTimeout bounds each LAN scan for a hub with host auto.
+var discoverTimeout = 15 * time.Second
+
+var (
+	// ErrHubNotFound is returned when a hub with host auto isn't found on the LAN.
+	ErrHubNotFound = errors.New("hub not found on the LAN")
+	// ErrHubNameRequired is returned when more than one hub is configured and one has no name.
+	ErrHubNameRequired = errors.New("hub name is required when more than one hub is configured")
+	// ErrDuplicateHubName is returned when two hubs share a name, and so an MQTT topic prefix.
+	ErrDuplicateHubName = errors.New("duplicate hub name")
+)
+
+// HubConfig describes one base station the bridge manages. Its devices are published
+// under {mqtt prefix}/{name}, or directly under the MQTT prefix if name is empty.
+type HubConfig struct {
+	Name           string `yaml:"name"`
+	Host           string `yaml:"host"`
+	Port           int    `yaml:"port"`
+	SDKPort        int    `yaml:"sdkPort"`
+	Credentials    string `yaml:"credentials"`
+	TLSFingerprint string `yaml:"tlsFingerprint"`
+	Mode           string `yaml:"mode"`       // defaults to the top-level mode
+	RemoteHost     string `yaml:"remoteHost"` // defaults to the top-level remote host
+}
+
+// hubConfigs returns the hubs to manage. Without a hubs list, the top-level host and
+// credentials describe a single unnamed hub, keeping the original topic layout.
+func (c *Config) hubConfigs() ([]HubConfig, error) {
+	if len(c.Hubs) == 0 {
+		hub := HubConfig{
+			Host:           c.Host,
+			Port:           c.Port,
+			SDKPort:        c.SDKPort,
+			Credentials:    c.Credentials,
+			TLSFingerprint: c.TLSFingerprint,
+			Mode:           c.Mode,
+			RemoteHost:     c.RemoteHost,
+		}
+		if _, err := hub.connMode(); err != nil {
+			return nil, err
+		}
+		return []HubConfig{hub}, nil
+	}
+
+	names := make(map[string]bool)
+	hubs := make([]HubConfig, len(c.Hubs))
+	for i, h := range c.Hubs {
+		if h.Name == "" && len(c.Hubs) > 1 {
+			return nil, fmt.Errorf("hub %d (%s): %w", i, h.Host, ErrHubNameRequired)
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateHubName, h.Name)
+		}
+		names[h.Name] = true
+		// Hubs fall back to the top-level credentials file, e.g. one account for every hub
+		if h.Credentials == "" {
+			h.Credentials = c.Credentials
+		}
+		if h.Mode == "" {
+			h.Mode = c.Mode
+		}
+		if h.RemoteHost == "" {
+			h.RemoteHost = c.RemoteHost
+		}
+		if _, err := h.connMode(); err != nil {
+			return nil, fmt.Errorf("hub %s: %w", h.Name, err)
+		}
+		hubs[i] = h
+	}
+	return hubs, nil
+}
+
+// connMode returns how the hub is reached.
+func (h HubConfig) connMode() (dd.ConnMode, error) {
+	return dd.ParseConnMode(h.Mode)
+}
+
+// topicPrefix returns the MQTT prefix the hub's devices are published under.
+func (h HubConfig) topicPrefix(base string) string {
+	if h.Name == "" {
+		return base
+	}
+	return base + "/" + h.Name
+}
+
+// hub is the bridge's state for one base station.
+type hub struct {
+	name   string
+	prefix string
+	conn   *dd.Conn
+	cred   dd.Credential
+
+	// mu guards basicInfo, previousStatus and lastStatus, which rediscover reads from the MQTT client's goroutine
+	mu        sync.Mutex
+	basicInfo *ddapi.BasicInfo
+	// Last seen state per device, to skip polls that didn't change anything
+	previousStatus map[string]ddapi.DoorStatusDevice
+	obstructions   ddapi.ObstructionDetector
+	// hubAnnounced is set once the hub's diagnostics have been published for this connection
+	hubAnnounced bool
+	// health holds the latest health check readings, published with the hub's diagnostics
+	health ddapi.HubDiagnostics
+	// lastStatus is when the hub last sent a status, for -healthAddr
+	lastStatus time.Time
+
+	// gateway, if set, also serves this hub's devices over HTTP
+	gateway *gateway
+	// notifier, if set, sends webhooks for this hub's events
+	notifier *notifier
+
+	// discover, if set, finds the hub's address before each connect (host auto)
+	discover func(context.Context) ([]dd.DiscoveredHub, error)
+
+	// devices is the bridge's device registry, shared by all its hubs
+	devices *ddapi.DeviceRegistry
+	// states, if set, saves each device's resting state for the next startup
+	states *ddapi.StateStore
+}
+
+// newHub loads the hub's credentials and prepares its Conn without connecting. Its
+// devices' FSMs are kept in devices.
+func newHub(config HubConfig, basePrefix string, devices *ddapi.DeviceRegistry, debug bool) (*hub, error) {
+	cred, err := helper.LoadCreds(config.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("can't open credentials file %s: %w", config.Credentials, err)
+	}
+
+	mode, err := config.connMode()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &dd.Conn{
+		Host:            config.Host,
+		RemoteHost:      config.RemoteHost,
+		LocalPort:       config.Port,
+		SDKPortOverride: config.SDKPort,
+		Mode:            mode,
+		Debug:           debug,
+		LongPoll:        *flagLongPoll,
+		ReplayWindow:    *flagReplayWindow,
+	}
+	if config.TLSFingerprint != "" {
+		conn.TLSConfig = dd.PinnedTLSConfig(config.TLSFingerprint, nil)
+	}
+	if *flagStrictSignatures {
+		conn.Signatures = dd.SignaturesStrict
+	}
+
+	h := &hub{
+		name:           config.Name,
+		prefix:         config.topicPrefix(basePrefix),
+		conn:           conn,
+		cred:           cred.Credential,
+		previousStatus: make(map[string]ddapi.DoorStatusDevice),
+		devices:        devices,
+	}
+	if config.Host == discoverHost {
+		conn.Host = ""
+		h.discover = dd.Discover
+	}
+	return h, nil
+}
+
+// log returns the package logger tagged with the hub's name, if it has one.
+func (h *hub) log() logrus.FieldLogger {
+	if h.name == "" {
+		return logger
+	}
+	return logger.WithField("hub", h.name)
+}
+
+// connect opens the session with the hub and fetches its basic info.
+func (h *hub) connect() error {
+	if h.discover != nil && h.conn.Mode != dd.CloudMode {
+		if err := h.locate(); err != nil {
+			if h.conn.Mode == dd.LocalMode {
+				return err
+			}
+			h.log().WithError(err).Warn("Hub not found on the LAN; connecting through the cloud")
+			h.conn.Host = ""
+		}
+	}
+
+	if err := h.conn.Connect(h.cred); err != nil {
+		return fmt.Errorf("failed to connect to dd: %w", err)
+	}
+
+	basicInfo, err := ddapi.FetchBasicInfo(h.conn)
+	if err != nil {
+		return fmt.Errorf("failed to fetch basic device info: %w", err)
+	}
+	h.mu.Lock()
+	h.basicInfo = basicInfo
+	h.hubAnnounced = false
+	h.mu.Unlock()
+	h.log().WithField("basicInfo", basicInfo).Debug("Fetched basic information about the connection")
+	return nil
+}
+
+// Backoff between attempts to reconnect to a hub
+var (
+	reconnectMinInterval = 5 * time.Second
+	reconnectMaxInterval = 5 * time.Minute
+)
+
+// run publishes the hub's status updates until ctx is done, using the config current
+// when each arrives. If the connection to the hub is lost its devices are marked offline,
+// and it reconnects and resumes streaming.
+func (h *hub) run(ctx context.Context, mqttHandler *ddapi.MQTTHandler, config *atomic.Pointer[Config]) {
+	for {
+		statusCh := make(chan ddapi.DoorStatus)
+		done := make(chan error, 1)
+		go func() { done <- handleStatusUpdates(ctx, h.conn, statusCh) }()
+
+		// Health checks stop before reconnecting; Connect mustn't overlap a Ping
+		healthCtx, stopHealth := context.WithCancel(ctx)
+		healthDone := make(chan struct{})
+		go func() {
+			defer close(healthDone)
+			if *flagHealthInterval > 0 {
+				h.monitorHealth(healthCtx, mqttHandler, *flagHealthInterval)
+			}
+		}()
+
+		for status := range statusCh {
+			h.handleStatus(ctx, mqttHandler, config.Load(), status)
+		}
+		err := <-done
+		stopHealth()
+		<-healthDone
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.log().WithError(err).Error("Lost connection to hub; reconnecting")
+		h.setAvailability(false)
+		if err := h.reconnect(ctx); err != nil {
+			return
+		}
+		h.log().Info("Reconnected to hub")
+		h.setAvailability(true)
+		// Forget what was published so the next status update re-announces every device
+		h.mu.Lock()
+		clear(h.previousStatus)
+		h.mu.Unlock()
+	}
+}
+
+// locate scans the LAN for the hub with our base station ID and points the Conn at it.
+// It runs before every connect, so a hub that changes address is found again.
+func (h *hub) locate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	found, err := h.discover(ctx)
+	if err != nil {
+		return fmt.Errorf("discover hubs: %w", err)
+	}
+	for _, d := range found {
+		if d.BaseStation == h.cred.BaseStation {
+			h.log().WithField("host", d.Host).WithField("name", d.Name).Info("Discovered hub on the LAN")
+			h.conn.Host = d.Host
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s (found %d others)", ErrHubNotFound, h.cred.BaseStation, len(found))
+}
+
+// reconnect re-establishes the hub session, backing off exponentially with jitter between attempts,
+// until it succeeds or ctx is done.
+func (h *hub) reconnect(ctx context.Context) error {
+	backoff := dd.Backoff{Base: reconnectMinInterval, Max: reconnectMaxInterval, Jitter: dd.DefaultBackoffJitter}
+	interval := backoff.Next()
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		err := h.connect()
+		if err == nil {
+			return nil
+		}
+		interval = backoff.Next()
+		h.log().WithError(err).WithField("retryIn", interval).Warn("Failed to reconnect to hub")
+	}
+}
+
+// setAvailability marks every device on this hub online or offline; the FSM publishes
+// the matching availability.
+func (h *hub) setAvailability(online bool) {
+	event := "go_offline"
+	if online {
+		event = "go_online"
+	} else if h.notifier != nil {
+		h.notifier.hubOffline(h)
+	}
+	for deviceID, deviceFSM := range h.devices.All() {
+		if deviceFSM.Conn != h.conn || (deviceFSM.Current() == "offline") != online {
+			continue
+		}
+		if err := deviceFSM.Trigger(ddapi.WithSource(context.Background(), ddapi.SourceBridge), event); err != nil {
+			h.log().WithError(err).WithField("deviceID", deviceID).Error("Failed to process '" + event + "' event")
+		}
+	}
+}
+
+// handleStatus publishes one status update and drives each device's FSM from it. Without
+// an MQTT handler, the update only goes to the gateway.
+func (h *hub) handleStatus(ctx context.Context, mqttHandler *ddapi.MQTTHandler, config *Config, status ddapi.DoorStatus) {
+	ddapi.RecordDoorStatus(ctx, &status)
+	h.mu.Lock()
+	h.lastStatus = time.Now()
+	h.mu.Unlock()
+	if h.gateway != nil {
+		h.gateway.update(h, status)
+	}
+	if h.notifier != nil {
+		h.notifier.update(h, status)
+	}
+	if mqttHandler == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.hubAnnounced {
+		h.announceHub(mqttHandler)
+		h.hubAnnounced = true
+	}
+	if err := mqttHandler.PublishBridgeStatus(h.prefix, status); err != nil {
+		h.log().WithError(err).Error("Failed to publish bridge status")
+	}
+
+	for _, device := range status.Devices {
+		h.handleDevice(mqttHandler, config, device)
+	}
+}
+
+// handleDevice publishes a device's state and drives its FSM toward its reported position.
+func (h *hub) handleDevice(mqttHandler *ddapi.MQTTHandler, config *Config, device ddapi.DoorStatusDevice) {
+	log := h.log().WithField("deviceID", device.ID)
+	// Transitions driven from here are the hub reporting the door's state
+	ctx := ddapi.WithSource(context.Background(), ddapi.SourceStatus)
+
+	prev, seen := h.previousStatus[device.ID]
+	if seen && device.Equal(prev) {
+		log.Debug("Device unchanged since last update")
+		return
+	}
+	h.previousStatus[device.ID] = device
+
+	if button, ok := ddapi.PressedButton(prev, device); seen && ok {
+		log.WithField("button", button.Title).Info("Button pressed")
+		if err := mqttHandler.PublishButtonPress(h.prefix, device.ID, *button); err != nil {
+			log.WithError(err).Error("Failed to publish button press")
+		}
+	}
+
+	log.WithField("Position", device.Device.Position).Info("Announcing Position")
+
+	deviceFSM, exists := h.devices.Get(device.ID)
+	if !exists {
+		deviceFSM = h.devices.ConfigureDevice(mqttHandler, h.conn, h.prefix, device, *h.basicInfo, config.deviceOptions(device.ID))
+		deviceFSM.States = h.states
+		if audit != nil {
+			deviceFSM.OnTransition = audit.transition(device.ID)
+		}
+		deviceFSM.StopTimeout = *flagStopTimeout
+		deviceFSM.EstimateInterval = *flagEstimateInterval
+		deviceConfig := config.deviceConfig(device.ID)
+		deviceFSM.AutoCloseWarning = time.Duration(deviceConfig.AutoCloseWarning) * time.Minute
+		h.configureEntities(mqttHandler, device, log)
+		// Subscriptions are handled in MQTT OnConnect handler
+		log.Info("Waiting on status updates...")
+		err := deviceFSM.Trigger(ctx, "go_online")
+		if err != nil {
+			log.WithError(err).Error("Failed to process 'go_online' event")
+		}
+		deviceFSM.SetAutoClose(time.Duration(deviceConfig.AutoClose) * time.Minute)
+	} else {
+		log.Info("Device already configured")
+	}
+
+	h.observeObstruction(deviceFSM, device, log)
+
+	// Always publish position updates from the device
+	h.publishState(mqttHandler, device, log)
+	if device.Log.ID != 0 && (!seen || device.Log.ID != prev.Log.ID) {
+		h.publishLog(mqttHandler, device, log)
+		audit.hubLog(device.ID, device.Log)
+	}
+
+	deviceFSM.ObservePosition(device.Device.Position)
+
+	// Determine the desired FSM state based on position
+	var haState string
+	switch device.Device.Position {
+	case OPEN:
+		haState = "go_opened"
+	case CLOSE:
+		haState = "go_closed"
+	default:
+		haState = "go_partially_opened"
+	}
+
+	// An update after a stop command reports where the door came to rest
+	if err := deviceFSM.CompleteStop(ctx); err != nil {
+		log.WithError(err).Error("Failed to complete stop")
+	}
+
+	currentState := deviceFSM.Current()
+	// Intermediate positions while the door is moving are progress, not a resting state;
+	// the position is already published above
+	if haState == "go_partially_opened" &&
+		(currentState == "opening" || currentState == "closing" || currentState == "stopping" || currentState == "partially_open") {
+		log.WithFields(logrus.Fields{
+			"Position":     device.Device.Position,
+			"currentState": currentState,
+		}).Debug("Device at intermediate position")
+		return
+	}
+	// Skip redundant transitions to the same final state (idempotent)
+	if (currentState == "closed" && haState == "go_closed") ||
+		(currentState == "open" && haState == "go_opened") {
+		log.WithFields(logrus.Fields{
+			"currentState": currentState,
+			"haState":      haState,
+		}).Debug("Ignoring redundant transition to the same state")
+		return
+	}
+
+	if (currentState == "opening" && haState == "go_closed") ||
+		(currentState == "closing" && haState == "go_opened") {
+		log.WithFields(logrus.Fields{
+			"currentState": currentState,
+			"haState":      haState,
+		}).Debug("Ignoring invalid state transition while opening or closing")
+		return
+	}
+
+	// Process the state transition
+	err := deviceFSM.Trigger(ctx, haState)
+	if err != nil {
+		log.WithError(err).
+			WithField("haState", haState).
+			WithField("currentState", deviceFSM.Current()).
+			Error("Failed to process event")
+	}
+}
+
+// configureEntities publishes discovery for a device's entities other than its cover.
+func (h *hub) configureEntities(mqttHandler *ddapi.MQTTHandler, device ddapi.DoorStatusDevice, log logrus.FieldLogger) {
+	if err := mqttHandler.ConfigureButtonTriggers(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure button triggers")
+	}
+	if err := mqttHandler.ConfigureLight(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure light")
+	}
+	if err := mqttHandler.ConfigureAux(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure aux switch")
+	}
+	if err := mqttHandler.ConfigureLogSensor(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure log sensor")
+	}
+	if err := mqttHandler.ConfigureObstructionSensor(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure obstruction sensor")
+	}
+	if err := mqttHandler.ConfigureDiagnostics(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure diagnostic sensors")
+	}
+	if err := mqttHandler.ConfigureCameraAlarms(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure camera alarms")
+	}
+	if err := mqttHandler.ConfigureLockouts(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure lockouts")
+	}
+	if err := mqttHandler.ConfigureAutoClose(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure auto-close")
+	}
+	if err := mqttHandler.ConfigureStats(h.prefix, device); err != nil {
+		log.WithError(err).Error("Failed to configure stats sensors")
+	}
+}
+
+// announceHub publishes discovery and readings for the hub's own diagnostic sensors.
+// h.mu must be held.
+func (h *hub) announceHub(mqttHandler *ddapi.MQTTHandler) {
+	if err := mqttHandler.ConfigureHubDiagnostics(h.prefix, *h.basicInfo); err != nil {
+		h.log().WithError(err).Error("Failed to configure hub diagnostic sensors")
+	}
+	if *flagHealthInterval > 0 {
+		if err := mqttHandler.ConfigureHubHealth(h.prefix, *h.basicInfo); err != nil {
+			h.log().WithError(err).Error("Failed to configure hub health sensors")
+		}
+	}
+	if err := mqttHandler.PublishHubDiagnostics(h.prefix, h.diagnostics()); err != nil {
+		h.log().WithError(err).Error("Failed to publish hub diagnostics")
+	}
+}
+
+// diagnostics returns the hub's readings with the latest health check. h.mu must be held.
+func (h *hub) diagnostics() ddapi.HubDiagnostics {
+	d := h.basicInfo.Diagnostics()
+	d.Latency, d.ClockSkew, d.Healthy = h.health.Latency, h.health.ClockSkew, h.health.Healthy
+	return d
+}
+
+// monitorHealth pings the hub every interval until ctx is done, publishing the results
+// with the hub's diagnostics. Its devices are marked offline while the hub is unhealthy
+// (see dd.Conn.IsHealthy) and online again once it answers.
+func (h *hub) monitorHealth(ctx context.Context, mqttHandler *ddapi.MQTTHandler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := h.conn.Ping(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			h.log().WithError(err).Debug("Hub health check failed")
+		}
+		if now := h.conn.IsHealthy(); now != healthy {
+			healthy = now
+			if healthy {
+				h.log().Info("Hub is answering health checks again")
+			} else {
+				h.log().WithError(err).Warn("Hub is not answering health checks; marking devices offline")
+			}
+			h.setAvailability(healthy)
+		}
+		h.recordHealth(mqttHandler, result, err == nil, healthy)
+	}
+}
+
+// recordHealth keeps a health check's readings and publishes them. A failed check keeps
+// the last latency and clock skew.
+func (h *hub) recordHealth(mqttHandler *ddapi.MQTTHandler, result dd.PingResult, ok, healthy bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ok {
+		latency := int(result.Latency.Milliseconds())
+		h.health.Latency = &latency
+		// The hub's clock isn't visible through the cloud
+		h.health.ClockSkew = nil
+		if !h.conn.IsCloud() {
+			skew := int(result.ClockSkew.Milliseconds())
+			h.health.ClockSkew = &skew
+		}
+	}
+	h.health.Healthy = &healthy
+	if mqttHandler == nil || h.basicInfo == nil {
+		return
+	}
+	if err := mqttHandler.PublishHubDiagnostics(h.prefix, h.diagnostics()); err != nil {
+		h.log().WithError(err).Error("Failed to publish hub diagnostics")
+	}
+}
+
+// publishState publishes a device's position, light, aux, camera alarm, lockout and
+// obstruction states and diagnostics.
+func (h *hub) publishState(mqttHandler *ddapi.MQTTHandler, device ddapi.DoorStatusDevice, log logrus.FieldLogger) {
+	if err := mqttHandler.PublishPosition(h.prefix, device.ID, device.Device.Position); err != nil {
+		log.WithError(err).Error("Failed to publish position update")
+	}
+	if on, ok := device.LightState(); ok {
+		if err := mqttHandler.PublishLightState(h.prefix, device.ID, on); err != nil {
+			log.WithError(err).Error("Failed to publish light state")
+		}
+	}
+	if on, ok := device.AuxState(); ok {
+		if err := mqttHandler.PublishAuxState(h.prefix, device.ID, on); err != nil {
+			log.WithError(err).Error("Failed to publish aux state")
+		}
+	}
+	if on, ok := device.AudioAlarmState(); ok {
+		if err := mqttHandler.PublishAudioAlarmState(h.prefix, device.ID, on); err != nil {
+			log.WithError(err).Error("Failed to publish audio alarm state")
+		}
+	}
+	if on, ok := device.MotionAlarmState(); ok {
+		if err := mqttHandler.PublishMotionAlarmState(h.prefix, device.ID, on); err != nil {
+			log.WithError(err).Error("Failed to publish motion alarm state")
+		}
+	}
+	if on, ok := device.PhoneLockoutState(); ok {
+		if err := mqttHandler.PublishPhoneLockoutState(h.prefix, device.ID, on); err != nil {
+			log.WithError(err).Error("Failed to publish phone lockout state")
+		}
+	}
+	if on, ok := device.RemoteLockoutState(); ok {
+		if err := mqttHandler.PublishRemoteLockoutState(h.prefix, device.ID, on); err != nil {
+			log.WithError(err).Error("Failed to publish remote lockout state")
+		}
+	}
+	if err := mqttHandler.PublishObstruction(h.prefix, device.ID, h.obstructions.Obstructed(device.ID)); err != nil {
+		log.WithError(err).Error("Failed to publish obstruction state")
+	}
+	if err := mqttHandler.PublishDiagnostics(h.prefix, device.ID, device.Diagnostics()); err != nil {
+		log.WithError(err).Error("Failed to publish diagnostics")
+	}
+}
+
+// observeObstruction checks a device update for an obstruction. A door reversing while the
+// FSM is opening was told to, so that isn't one.
+func (h *hub) observeObstruction(deviceFSM *ddapi.DeviceFSM, device ddapi.DoorStatusDevice, log logrus.FieldLogger) {
+	obstruction := h.obstructions.Observe(device)
+	if obstruction == nil {
+		return
+	}
+	if obstruction.Reason == ddapi.ObstructionReversed && deviceFSM.Current() == "opening" {
+		h.obstructions.Clear(device.ID)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"reason":   obstruction.Reason,
+		"position": obstruction.Position,
+	}).Warn("Door obstructed")
+	if h.notifier != nil {
+		h.notifier.obstruction(h, device, obstruction)
+	}
+}
+
+// publishLog publishes a device's latest log entry.
+func (h *hub) publishLog(mqttHandler *ddapi.MQTTHandler, device ddapi.DoorStatusDevice, log logrus.FieldLogger) {
+	if err := mqttHandler.PublishLog(h.prefix, device.ID, device.Log); err != nil {
+		log.WithError(err).Error("Failed to publish log entry")
+	}
+}
+
+// rediscover publishes discovery, availability and state again for every device seen so
+// far, for a Home Assistant that restarted and lost them.
+func (h *hub) rediscover(mqttHandler *ddapi.MQTTHandler, config *Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.basicInfo != nil {
+		h.announceHub(mqttHandler)
+	}
+	for _, device := range h.previousStatus {
+		log := h.log().WithField("deviceID", device.ID)
+		if err := mqttHandler.PublishDeviceConfig(h.prefix, device, *h.basicInfo, config.deviceOptions(device.ID)); err != nil {
+			log.WithError(err).Error("Failed to republish device config")
+		}
+		h.configureEntities(mqttHandler, device, log)
+		if deviceFSM, ok := h.devices.Get(device.ID); ok {
+			if err := deviceFSM.Republish(); err != nil {
+				log.WithError(err).Error("Failed to republish availability and state")
+			}
+		}
+		h.publishState(mqttHandler, device, log)
+		if device.Log.ID != 0 {
+			h.publishLog(mqttHandler, device, log)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+)
+
+func TestLoadConfig_Hubs(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "haus.yaml")
+	validYAML := `
+credentials: /config/dd.json
+hubs:
+  - name: garage
+    host: 192.168.1.20
+  - name: shed
+    host: 192.168.1.21
+    port: 9000
+    credentials: /config/shed.json
+`
+	if err := os.WriteFile(configFile, []byte(validYAML), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	hubs, err := config.hubConfigs()
+	if err != nil {
+		t.Fatalf("hubConfigs() error = %v", err)
+	}
+
+	want := []HubConfig{
+		{Name: "garage", Host: "192.168.1.20", Credentials: "/config/dd.json"},
+		{Name: "shed", Host: "192.168.1.21", Port: 9000, Credentials: "/config/shed.json"},
+	}
+	if !reflect.DeepEqual(hubs, want) {
+		t.Errorf("hubConfigs() = %+v, want %+v", hubs, want)
+	}
+}
+
+func TestConfig_HubConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []HubConfig
+		wantErr error
+	}{
+		{
+			name:   "single hub from top-level settings",
+			config: Config{Host: "192.168.1.20", Port: 8989, Credentials: "dd.json", TLSFingerprint: "ab:cd"},
+			want:   []HubConfig{{Host: "192.168.1.20", Port: 8989, Credentials: "dd.json", TLSFingerprint: "ab:cd"}},
+		},
+		{
+			name:   "single unnamed hub in list",
+			config: Config{Credentials: "dd.json", Hubs: []HubConfig{{Host: "192.168.1.20"}}},
+			want:   []HubConfig{{Host: "192.168.1.20", Credentials: "dd.json"}},
+		},
+		{
+			name:    "unnamed hub among several",
+			config:  Config{Hubs: []HubConfig{{Name: "garage", Host: "a"}, {Host: "b"}}},
+			wantErr: ErrHubNameRequired,
+		},
+		{
+			name:   "hubs inherit the top-level mode",
+			config: Config{Mode: "auto", RemoteHost: "cloud.example", Hubs: []HubConfig{{Name: "garage", Host: "a"}, {Name: "shed", Mode: "cloud"}}},
+			want: []HubConfig{
+				{Name: "garage", Host: "a", Mode: "auto", RemoteHost: "cloud.example"},
+				{Name: "shed", Mode: "cloud", RemoteHost: "cloud.example"},
+			},
+		},
+		{
+			name:    "invalid mode",
+			config:  Config{Host: "a", Mode: "satellite"},
+			wantErr: dd.ErrInvalidConnMode,
+		},
+		{
+			name:    "duplicate names",
+			config:  Config{Hubs: []HubConfig{{Name: "garage", Host: "a"}, {Name: "garage", Host: "b"}}},
+			wantErr: ErrDuplicateHubName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.hubConfigs()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("hubConfigs() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hubConfigs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubConfig_TopicPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		hub  HubConfig
+		want string
+	}{
+		{"unnamed hub keeps the base prefix", HubConfig{}, "dd-door"},
+		{"named hub is namespaced", HubConfig{Name: "garage"}, "dd-door/garage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hub.topicPrefix("dd-door"); got != tt.want {
+				t.Errorf("topicPrefix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHub_ReconnectGivesUpOnCancel(t *testing.T) {
+	prevMin, prevMax := reconnectMinInterval, reconnectMaxInterval
+	reconnectMinInterval, reconnectMaxInterval = time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { reconnectMinInterval, reconnectMaxInterval = prevMin, prevMax })
+
+	// Nothing listens on port 1, so every attempt fails
+	h := &hub{conn: &dd.Conn{Host: "127.0.0.1", LocalPort: 1, SDKPortOverride: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := h.reconnect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("reconnect() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestHub_Locate(t *testing.T) {
+	found := []dd.DiscoveredHub{
+		{Host: "192.168.1.20", BaseStation: "bs-other"},
+		{Host: "192.168.1.21", BaseStation: "bs-garage", Name: "Garage"},
+	}
+	tests := []struct {
+		name         string
+		bsid         string
+		mode         dd.ConnMode
+		wantHost     string
+		wantNotFound bool
+	}{
+		{"found", "bs-garage", dd.LocalMode, "192.168.1.21", false},
+		{"not found", "bs-missing", dd.LocalMode, "", true},
+		{"not found falls back to cloud", "bs-missing", dd.AutoMode, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &hub{
+				// Nothing listens on port 1, and the cloud is unreachable, so connecting
+				// fails once the host is resolved
+				conn: &dd.Conn{Mode: tt.mode, LocalPort: 1, SDKPortOverride: 1, RemoteHost: "127.0.0.1:1"},
+				cred: dd.Credential{BaseStation: tt.bsid},
+				discover: func(context.Context) ([]dd.DiscoveredHub, error) {
+					return found, nil
+				},
+			}
+			err := h.connect()
+			if got := errors.Is(err, ErrHubNotFound); got != tt.wantNotFound {
+				t.Errorf("connect() error = %v, want ErrHubNotFound %v", err, tt.wantNotFound)
+			}
+			if h.conn.Host != tt.wantHost {
+				t.Errorf("conn.Host = %q, want %q", h.conn.Host, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestHub_MonitorHealth(t *testing.T) {
+	server := ddtest.NewServer()
+	server.SetInfo(map[string]interface{}{"bsid": server.Credential.BaseStation, "clock": time.Now().UnixMilli()})
+	conn := server.Conn()
+	defer conn.Close()
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	h := &hub{conn: conn, basicInfo: &ddapi.BasicInfo{BaseStation: server.Credential.BaseStation}, devices: ddapi.NewDeviceRegistry()}
+
+	// waitHealthy runs health checks until the hub's recorded health is want
+	waitHealthy := func(want bool) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		go h.monitorHealth(ctx, nil, 5*time.Millisecond)
+		for ctx.Err() == nil {
+			h.mu.Lock()
+			healthy := h.health.Healthy
+			h.mu.Unlock()
+			if healthy != nil && *healthy == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("hub health never became %v", want)
+	}
+
+	waitHealthy(true)
+	h.mu.Lock()
+	if h.health.Latency == nil || h.health.ClockSkew == nil {
+		t.Errorf("health = %+v, want latency and clock skew", h.health)
+	}
+	h.mu.Unlock()
+
+	server.Close()
+	waitHealthy(false)
+}
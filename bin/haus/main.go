@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -31,15 +33,51 @@ var logger = logrus.New()
 
 // Flags
 var (
-	flagCredentialsPath = flag.String("credentials", "dd-credentials.json", "path to credentials file")
-	flagHost            = flag.String("host", "", "host to connect to")
-	flagMqtt            = flag.String("mqtt", "", "mqtt server")
-	flagMqttPort        = flag.Int("mqttPort", 1883, "mqtt port")
-	flagMqttUser        = flag.String("mqttUser", "", "mqtt user")
-	flagMqttPassword    = flag.String("mqttPassword", "", "mqtt password")
-	flagMqttPrefix      = flag.String("mqttPrefix", "dd-door", "prefix for mqtt")
-	flagRemoveEntity    = flag.String("removeEntity", "", "entity to remove from haus")
-	flagDebug           = flag.Bool("debug", false, "debug mode")
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagConfigPath       = flag.String("config", "", "path to optional YAML config file")
+	flagHost             = flag.String("host", "", "host to connect to, or auto to find the hub on the LAN")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagMode             = flag.String("mode", "", "how to reach the hub: local (default), cloud, or auto to fall back to the cloud when the hub is unreachable on the LAN")
+	flagRemoteHost       = flag.String("remoteHost", "", "cloud API host for cloud and auto modes (default "+dd.RemoteAPIBase+")")
+	flagTLSFingerprint   = flag.String("tlsFingerprint", "", "SHA-256 fingerprint of the hub certificate to pin (default skips verification)")
+	flagReplayWindow     = flag.Duration("replayWindow", dd.DefaultReplayWindow, "drop hub messages whose time is further than this from the hub's clock as replays (negative disables)")
+	flagStrictSignatures = flag.Bool("strictSignatures", false, "reject hub responses and messages that are unsigned or whose signature doesn't match (default logs mismatches)")
+	flagMqtt             = flag.String("mqtt", "", "mqtt server")
+	flagMqttPort         = flag.Int("mqttPort", 1883, "mqtt port")
+	flagMqttUser         = flag.String("mqttUser", "", "mqtt user")
+	flagMqttPassword     = flag.String("mqttPassword", "", "mqtt password")
+	flagMqttTLS          = flag.Bool("mqttTLS", false, "connect to the mqtt broker over TLS")
+	flagMqttCA           = flag.String("mqttCA", "", "PEM CA file to verify the mqtt broker (default system roots)")
+	flagMqttCert         = flag.String("mqttCert", "", "PEM client certificate for mqtt mutual TLS")
+	flagMqttKey          = flag.String("mqttKey", "", "PEM client key for mqtt mutual TLS")
+	flagMqttPrefix       = flag.String("mqttPrefix", "dd-door", "prefix for mqtt")
+	flagMqttClientID     = flag.String("mqttClientID", "dd_haus", "mqtt client ID; must be unique per instance and stable across restarts")
+	flagStateQoS         = flag.Int("mqttStateQoS", 0, "QoS for door state publishes")
+	flagStateRetain      = flag.Bool("mqttStateRetain", false, "retain door state publishes")
+	flagPositionQoS      = flag.Int("mqttPositionQoS", 1, "QoS for door position publishes")
+	flagPositionRetain   = flag.Bool("mqttPositionRetain", true, "retain door position publishes")
+	flagAvailQoS         = flag.Int("mqttAvailabilityQoS", 0, "QoS for availability publishes")
+	flagAvailRetain      = flag.Bool("mqttAvailabilityRetain", true, "retain availability publishes")
+	flagRemoveEntity     = flag.String("removeEntity", "", "entity to remove from haus")
+	flagPauseOffline     = flag.Bool("pauseWhenOffline", false, "drop door commands while the hub reports the base station offline")
+	flagDryRun           = flag.Bool("dryRun", false, "log door commands and publish them to <prefix>/<device>/dry_run instead of sending them to the hub")
+	flagReadOnly         = flag.Bool("readOnly", false, "publish states and sensors but reject commands, publishing a warning to <prefix>/bridge/warning")
+	flagAuditLog         = flag.String("auditLog", "", "file to append an audit log of commands and door state changes to, as JSON lines")
+	flagStopTimeout      = flag.Duration("stopTimeout", 30*time.Second, "how long a stopping or stopped door waits for a position update before fetching status (0 disables)")
+	flagEstimateInterval = flag.Duration("estimateInterval", time.Second, "how often to publish estimated positions while a door moves, once its travel time is learned (0 disables)")
+	flagHealthInterval   = flag.Duration("healthInterval", time.Minute, "how often to ping the hub, publishing latency and connectivity and marking doors offline while it's unreachable (0 disables)")
+	flagLongPoll         = flag.Duration("longPoll", 0, "ask the hub to hold message polls open this long for near-real-time updates (0 polls on an interval)")
+	flagOtelEndpoint     = flag.String("otel-metrics-endpoint", "", "OTLP gRPC endpoint for door metrics, e.g. http://localhost:4317")
+	flagMetricsAddr      = flag.String("metricsAddr", "", "address to serve Prometheus /metrics on, e.g. :9100")
+	flagHealthAddr       = flag.String("healthAddr", "", "address to serve /healthz and /readyz on for container health checks, e.g. :8081")
+	flagHealthMaxAge     = flag.Duration("healthMaxAge", 10*time.Minute, "how long a hub may go without answering before /healthz fails and systemd watchdog pings stop")
+	flagOtelTraces       = flag.String("otel-traces-endpoint", "", "OTLP gRPC endpoint for hub RPC and door event traces, e.g. http://localhost:4317")
+	flagStateFile        = flag.String("stateFile", "", "file to save each door's last state in, published on startup so Home Assistant keeps it across restarts")
+	flagHTTPAddr         = flag.String("httpAddr", "", "address to serve the HTTP/WebSocket gateway on, e.g. 127.0.0.1:8080; runs without MQTT if -mqtt is unset")
+	flagRecord           = flag.String("record", "", "file to record hub traffic to, decrypted, as JSON lines for replaying in tests with ddtest.LoadReplay (single hub only)")
+	flagDebug            = flag.Bool("debug", false, "debug mode")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
 )
 
 func init() {
@@ -49,166 +87,420 @@ func init() {
 		ForceColors:   true,
 	})
 	logger.SetLevel(logrus.InfoLevel)
+	logger.AddHook(dd.RedactHook{})
 }
 
 func main() {
-	flag.Parse()
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
 
-	credentials, err := helper.LoadCreds(*flagCredentialsPath)
+	config := &Config{}
+	if *flagConfigPath != "" {
+		var err error
+		config, err = loadConfig(*flagConfigPath)
+		if err != nil {
+			logger.WithField("*flagConfigPath", *flagConfigPath).WithError(err).Fatal("can't load config file")
+		}
+	}
+	config.applyFlags(setFlags())
+
+	debug := false
+	if config.LogLevel != "" {
+		level, err := logrus.ParseLevel(config.LogLevel)
+		if err != nil {
+			logger.WithField("logLevel", config.LogLevel).WithError(err).Fatal("invalid log level")
+		}
+		logger.SetLevel(level)
+		debug = level >= logrus.DebugLevel
+	}
+
+	if err := config.validateDevices(); err != nil {
+		logger.WithError(err).Fatal("invalid device settings")
+	}
+	if err := config.validateReadOnly(); err != nil {
+		logger.WithError(err).Fatal("invalid read-only settings")
+	}
+	readOnly = config.ReadOnly
+	if readOnly {
+		logger.Warn("Read-only: commands from Home Assistant and the gateway will be rejected")
+	}
+
+	// Every hub's devices go in one registry, so commands find them by device ID alone
+	devices := ddapi.NewDeviceRegistry()
+
+	var err error
+	access, err = newAccessControl(config.ACL, config.MQTT.Prefix, devices)
 	if err != nil {
-		logger.WithField("*flagCredentialsPath", *flagCredentialsPath).WithError(err).Fatal("can't open credentials file")
+		logger.WithError(err).Fatal("invalid ACL")
 	}
 
-	// MQTT connection setup
-	mqttClient := connectToMQTT(*flagMqtt, *flagMqttUser, *flagMqttPassword, *flagMqttPort)
-	mqttHandler := ddapi.NewMQTTHandler(mqttClient, logger)
+	audit, err = openAuditLog(config.Audit)
+	if err != nil {
+		logger.WithField("file", config.Audit.File).WithError(err).Fatal("can't open audit log")
+	}
 
-	// Wait for MQTT to be available before proceeding to init state machine (bounded)
-	maxWait := 60 * time.Second
-	deadline := time.Now().Add(maxWait)
-	for !mqttClient.IsConnected() {
-		if time.Now().After(deadline) {
-			logger.Error("MQTT did not connect within 60s. Check broker address, port, and credentials (username/password). Exiting.")
-			os.Exit(1)
+	schedules := newScheduler(config.MQTT.Prefix, devices)
+	schedules.access = access
+
+	// The config in effect, replaced when it's reloaded
+	var current atomic.Pointer[Config]
+	current.Store(config)
+	reload := &reloader{
+		path:      *flagConfigPath,
+		prefix:    config.MQTT.Prefix,
+		current:   &current,
+		devices:   devices,
+		schedules: schedules,
+		access:    access,
+	}
+	for _, schedule := range config.Schedules {
+		if err := schedules.set(schedule); err != nil {
+			logger.WithError(err).Fatal("invalid schedule")
 		}
-		logger.Warn("MQTT not available yet; waiting before initializing state machine...")
-		time.Sleep(5 * time.Second)
 	}
-	logger.Info("MQTT is connected; proceeding with initialization")
 
-	if *flagRemoveEntity != "" {
-		err := mqttHandler.RemoveEntity(*flagRemoveEntity)
+	hubConfigs, err := config.hubConfigs()
+	if err != nil {
+		logger.WithError(err).Fatal("invalid hub settings")
+	}
+	var states *ddapi.StateStore
+	if config.StateFile != "" {
+		states, err = ddapi.OpenStateStore(config.StateFile)
 		if err != nil {
-			logger.WithField("*flagRemoveEntity", *flagRemoveEntity).WithError(err).Fatal("can't remove entity")
+			logger.WithField("stateFile", config.StateFile).WithError(err).Fatal("can't open state file")
 		}
-		return
 	}
 
-	ddConn := dd.Conn{Host: *flagHost, Debug: *flagDebug}
-	err = ddConn.Connect(credentials.Credential)
+	notifier, err := newNotifier(config.Webhooks)
 	if err != nil {
-		logger.WithError(err).Fatal("failed to connect to dd")
+		logger.WithError(err).Fatal("invalid webhook")
 	}
 
-	basicInfo, err := ddapi.FetchBasicInfo(&ddConn)
-	if err != nil {
-		logger.WithError(err).Fatal("failed to fetch basic device info")
+	hubs := make([]*hub, len(hubConfigs))
+	prefixes := make([]string, len(hubConfigs))
+	for i, hubConfig := range hubConfigs {
+		hubs[i], err = newHub(hubConfig, config.MQTT.Prefix, devices, debug)
+		if err != nil {
+			logger.WithField("hub", hubConfig.Name).WithError(err).Fatal("can't set up hub")
+		}
+		hubs[i].states = states
+		hubs[i].notifier = notifier
+		prefixes[i] = hubs[i].prefix
+	}
+
+	// A recording replays one hub's traffic, so it can't mix several
+	var recorder *dd.Recorder
+	if *flagRecord != "" {
+		if len(hubs) > 1 {
+			logger.Fatal("-record needs a single hub")
+		}
+		recorder, err = dd.OpenRecorder(*flagRecord)
+		if err != nil {
+			logger.WithField("file", *flagRecord).WithError(err).Fatal("can't open recording")
+		}
+		hubs[0].conn.Recorder = recorder
+		logger.WithField("file", *flagRecord).Warn("Recording hub traffic, with decrypted payloads")
+	}
+
+	// MQTT is optional when the gateway is serving instead
+	var mqttHandler *ddapi.MQTTHandler
+	if config.MQTT.Broker != "" || config.HTTPAddr == "" {
+		// When Home Assistant restarts it may have lost retained discovery and state
+		rediscover := func(c mqtt.Client) {
+			handler := ddapi.NewMQTTHandler(c, logger)
+			handler.Options = publishOptions()
+			for _, h := range hubs {
+				h.rediscover(handler, current.Load())
+			}
+		}
+		onConnect := func(c mqtt.Client) {
+			access.setClient(c)
+			reload.setClient(c)
+			schedules.subscribe(c)
+			reload.subscribe(c)
+		}
+		reload.refresh = func(c mqtt.Client) {
+			handler := ddapi.NewMQTTHandler(c, logger)
+			for _, prefix := range prefixes {
+				subscribeToMQTTCommandTopics(handler, devices, prefix)
+			}
+			schedules.subscribe(c)
+			reload.subscribe(c)
+			rediscover(c)
+		}
+		mqttHandler = setupMQTT(config.MQTT, prefixes, devices, rediscover, onConnect)
+
+		if *flagRemoveEntity != "" {
+			err := mqttHandler.RemoveEntity(*flagRemoveEntity)
+			if err != nil {
+				logger.WithField("*flagRemoveEntity", *flagRemoveEntity).WithError(err).Fatal("can't remove entity")
+			}
+			return
+		}
+
+		// Until the hubs are polled, Home Assistant gets the states saved last run
+		if states != nil {
+			publishSavedStates(mqttHandler, states, prefixes)
+		}
+	}
+
+	if config.DryRun {
+		ddapi.EnableDryRun(func(c ddapi.DryRunCommand) {
+			device, ok := devices.Get(c.DeviceID)
+			if mqttHandler == nil || !ok {
+				return
+			}
+			if err := mqttHandler.PublishDryRun(device.MQTTPrefix, c); err != nil {
+				logger.WithField("deviceID", c.DeviceID).WithError(err).Warn("Failed to publish dry run command")
+			}
+		})
+		logger.Warn("Dry run: door commands will be logged, not sent to the hub")
+	}
+
+	for _, h := range hubs {
+		if err := h.connect(); err != nil {
+			h.log().WithError(err).Fatal("failed to connect to hub")
+		}
 	}
-	logger.WithField("basicInfo", basicInfo).Debug("Fetched basic information about the connection")
 
 	// Context for background goroutines
 	ctx, cancel := context.WithCancel(context.Background())
 
+	go schedules.run(ctx)
+
+	shutdownMetrics := func(context.Context) error { return nil }
+	if *flagOtelEndpoint != "" || *flagMetricsAddr != "" {
+		shutdownMetrics, err = setupMetrics(ctx, *flagOtelEndpoint, *flagMetricsAddr)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to set up metrics")
+		}
+		logger.WithFields(logrus.Fields{
+			"otlpEndpoint": *flagOtelEndpoint,
+			"metricsAddr":  *flagMetricsAddr,
+		}).Info("Exporting metrics")
+	}
+
+	shutdownTracing := func(context.Context) error { return nil }
+	if *flagOtelTraces != "" {
+		shutdownTracing, err = setupTracing(ctx, *flagOtelTraces)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to set up tracing")
+		}
+		logger.WithField("otlpEndpoint", *flagOtelTraces).Info("Exporting traces")
+	}
+
+	shutdownGateway := func(context.Context) error { return nil }
+	if config.HTTPAddr != "" {
+		gw := newGateway()
+		gw.readOnly = readOnly
+		gw.access = access
+		shutdownGateway, err = serveGateway(config.HTTPAddr, gw)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to start gateway")
+		}
+		for _, h := range hubs {
+			h.gateway = gw
+		}
+		logger.WithField("httpAddr", config.HTTPAddr).Info("Serving gateway")
+	}
+
+	var connected func() bool
+	if mqttHandler != nil {
+		connected = mqttHandler.Client.IsConnected
+	}
+	health := newHealthServer(hubs, connected, *flagHealthMaxAge)
+	shutdownHealth := func(context.Context) error { return nil }
+	if *flagHealthAddr != "" {
+		shutdownHealth, err = serveHealth(*flagHealthAddr, health)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to start health server")
+		}
+		logger.WithField("healthAddr", *flagHealthAddr).Info("Serving health checks")
+	}
+
 	stopCh := make(chan os.Signal, 1)
 	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
 
+	// SIGHUP reloads the config file without reconnecting
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			logger.Info("SIGHUP received; reloading config")
+			reload.run(reloadFromSignal)
+		}
+	}()
+
 	// Wait for the termination signal
 	go func() {
 		<-stopCh
 		logger.Info("Termination signal received")
+		if err := sdNotify(sdStopping); err != nil {
+			logger.WithError(err).Warn("Failed to notify systemd")
+		}
 		// Ensure resources are cleaned up
 		logger.Info("Shutting down gracefully")
 		// Cancel the background status loop first
 		cancel()
-		// Use thread-safe helper to get all devices
-		allDevices := ddapi.GetAllDeviceFSMs()
-		for deviceID, fsm := range allDevices {
+		for deviceID, fsm := range devices.All() {
 			logger.Infof("Shutting down device: %s", deviceID)
-			err := fsm.Trigger(context.Background(), "go_offline")
+			err := fsm.Trigger(ddapi.WithSource(context.Background(), ddapi.SourceBridge), "go_offline")
 			if err != nil {
 				logger.WithField("deviceID", deviceID).WithError(err).Error("Failed to set device to offline")
 			} else {
 				logger.WithField("deviceID", deviceID).Info("Device successfully set to offline")
 			}
 		}
-		mqttClient.Disconnect(250)
+		if err := shutdownMetrics(context.Background()); err != nil {
+			logger.WithError(err).Warn("Failed to flush metrics")
+		}
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.WithError(err).Warn("Failed to flush traces")
+		}
+		if err := shutdownGateway(context.Background()); err != nil {
+			logger.WithError(err).Warn("Failed to stop gateway")
+		}
+		if err := shutdownHealth(context.Background()); err != nil {
+			logger.WithError(err).Warn("Failed to stop health server")
+		}
+		if mqttHandler != nil {
+			mqttHandler.Close()
+		}
+		if err := audit.Close(); err != nil {
+			logger.WithError(err).Warn("Failed to close audit log")
+		}
+		if recorder != nil {
+			if err := recorder.Close(); err != nil {
+				logger.WithError(err).Warn("Failed to write recording")
+			}
+		}
 		os.Exit(0)
 	}()
 
-	statusCh := make(chan ddapi.DoorStatus)
-	go handleStatusUpdates(ctx, &ddConn, statusCh)
-
-	for status := range statusCh {
-		for _, device := range status.Devices {
-			logger.WithField("Position", device.Device.Position).Info("Announcing Position")
-
-			// Ensure thread-safe access to DeviceFSMs using helper functions
-			deviceFSM, exists := ddapi.GetDeviceFSM(device.ID)
-			if !exists {
-				deviceFSM = ddapi.ConfigureDevice(mqttHandler, &ddConn, *flagMqttPrefix, device, *basicInfo)
-				// Subscriptions are handled in MQTT OnConnect handler
-				logger.Info("Waiting on status updates...")
-				err := deviceFSM.Trigger(context.Background(), "go_online")
-				if err != nil {
-					logger.WithError(err).Error("Failed to process 'go_online' event")
-				}
-			} else {
-				logger.WithField("deviceID", device.ID).Info("Device already configured")
-			}
-
-			// Always publish position updates from the device
-			err := mqttHandler.PublishPosition(*flagMqttPrefix, device.ID, device.Device.Position)
-			if err != nil {
-				logger.WithError(err).WithField("deviceID", device.ID).Error("Failed to publish position update")
-			}
+	// Under systemd (Type=notify), haus is ready once connected; its watchdog (WatchdogSec=)
+	// restarts haus if the status loops or MQTT connection stay unhealthy
+	if err := sdNotify(sdReady); err != nil {
+		logger.WithError(err).Warn("Failed to notify systemd")
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(ctx, interval, health.watchdogHealthy)
+		logger.WithField("interval", interval).Info("Pinging systemd watchdog")
+	}
 
-			// Determine the desired FSM state based on position
-			var haState string
-			switch device.Device.Position {
-			case OPEN:
-				haState = "go_opened"
-			case CLOSE:
-				haState = "go_closed"
-			default:
-				// Intermediate position - we've already published the position above
-				logger.WithFields(logrus.Fields{
-					"Position": device.Device.Position,
-					"deviceID": device.ID,
-				}).Debug("Device at intermediate position")
-				continue // Don't trigger FSM for intermediate positions
-			}
+	// One status loop per hub; their devices all share the MQTT handler, FSM registry and gateway
+	var wg sync.WaitGroup
+	for _, h := range hubs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.run(ctx, mqttHandler, &current)
+		}()
+	}
+	wg.Wait()
+}
 
-			currentState := deviceFSM.Current()
-			// Skip redundant transitions to the same final state (idempotent)
-			if (currentState == "closed" && haState == "go_closed") ||
-				(currentState == "open" && haState == "go_opened") {
-				logger.WithFields(logrus.Fields{
-					"currentState": currentState,
-					"haState":      haState,
-					"deviceID":     device.ID,
-				}).Debug("Ignoring redundant transition to the same state")
-				continue
-			}
+// setupMQTT connects to the broker and waits (bounded) for the connection, exiting if it
+// can't be made. Commands are sent to the devices in devices.
+func setupMQTT(config MQTTConfig, prefixes []string, devices *ddapi.DeviceRegistry, onHomeAssistantOnline, onConnect func(mqtt.Client)) *ddapi.MQTTHandler {
+	options := publishOptions()
+	if err := options.Validate(); err != nil {
+		logger.WithError(err).Fatal("invalid MQTT settings")
+	}
+	mqttClient, err := connectToMQTT(config, prefixes, devices, onHomeAssistantOnline, onConnect)
+	if err != nil {
+		logger.WithError(err).Fatal("invalid MQTT settings")
+	}
+	mqttHandler := ddapi.NewMQTTHandler(mqttClient, logger)
+	mqttHandler.Options = options
 
-			if (currentState == "opening" && haState == "go_closed") ||
-				(currentState == "closing" && haState == "go_opened") {
-				logger.WithFields(logrus.Fields{
-					"currentState": currentState,
-					"haState":      haState,
-					"deviceID":     device.ID,
-				}).Debug("Ignoring invalid state transition while opening or closing")
-				continue
-			}
+	// Wait for MQTT to be available before proceeding to init state machine (bounded)
+	maxWait := 60 * time.Second
+	deadline := time.Now().Add(maxWait)
+	backoff := dd.Backoff{Base: time.Second, Max: 10 * time.Second, Jitter: dd.DefaultBackoffJitter}
+	for !mqttClient.IsConnected() {
+		if time.Now().After(deadline) {
+			logger.Error("MQTT did not connect within 60s. Check broker address, port, and credentials (username/password). Exiting.")
+			os.Exit(1)
+		}
+		delay := backoff.Next()
+		logger.WithField("retryIn", delay).Warn("MQTT not available yet; waiting before initializing state machine...")
+		time.Sleep(delay)
+	}
+	logger.Info("MQTT is connected; proceeding with initialization")
+	return mqttHandler
+}
 
-			// Process the state transition
-			err = deviceFSM.Trigger(context.Background(), haState)
-			if err != nil {
-				logger.WithError(err).
-					WithField("haState", haState).
-					WithField("currentState", deviceFSM.Current()).
-					Error("Failed to process event")
-			}
+// publishSavedStates publishes the saved state of every device under one of prefixes.
+// Devices saved under a prefix no longer in use are skipped.
+func publishSavedStates(mqttHandler *ddapi.MQTTHandler, states *ddapi.StateStore, prefixes []string) {
+	for deviceID, saved := range states.All() {
+		if !slices.Contains(prefixes, saved.MQTTPrefix) {
+			continue
+		}
+		log := logger.WithFields(logrus.Fields{"deviceID": deviceID, "state": saved.State, "position": saved.Position})
+		if err := mqttHandler.PublishSavedState(deviceID, saved); err != nil {
+			log.WithError(err).Warn("Failed to publish saved state")
+			continue
 		}
+		log.Info("Published saved state")
 	}
+}
 
+// publishOptions returns the QoS and retain settings given by the -mqtt*QoS and
+// -mqtt*Retain flags.
+func publishOptions() ddapi.MQTTOptions {
+	return ddapi.MQTTOptions{
+		State:        ddapi.PublishOptions{QoS: byte(*flagStateQoS), Retain: *flagStateRetain},
+		Position:     ddapi.PublishOptions{QoS: byte(*flagPositionQoS), Retain: *flagPositionRetain},
+		Availability: ddapi.PublishOptions{QoS: byte(*flagAvailQoS), Retain: *flagAvailRetain},
+	}
 }
 
 // Connect to MQTT broker
-func connectToMQTT(broker, user, password string, port int) mqtt.Client {
+func connectToMQTT(config MQTTConfig, prefixes []string, devices *ddapi.DeviceRegistry, onHomeAssistantOnline, onConnect func(mqtt.Client)) (mqtt.Client, error) {
+	opts, err := newMQTTOptions(config, prefixes, devices, onHomeAssistantOnline, onConnect)
+	if err != nil {
+		return nil, err
+	}
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); !token.WaitTimeout(3 * time.Second) {
+		logger.Warn("Initial MQTT connect timed out; auto-reconnect will continue in background")
+	} else if err := token.Error(); err != nil {
+		// Detect common authentication/authorization failures and fail fast
+		errStr := strings.ToLower(err.Error())
+		if strings.Contains(errStr, "not authorized") || strings.Contains(errStr, "not authorised") || strings.Contains(errStr, "bad user name or password") || strings.Contains(errStr, "unauthor") {
+			logger.WithError(err).Error("MQTT authentication failed. Check username/password and broker ACLs.")
+			os.Exit(1)
+		}
+		logger.WithError(err).Warn("Initial MQTT connect failed; will keep retrying in background")
+	}
+
+	return client, nil
+}
+
+// newMQTTOptions builds the client options used by connectToMQTT.
+//
+// The client ID must be unique per broker: a second client connecting with the
+// same ID disconnects the first. Because CleanSession is false, it must also be
+// stable across restarts, otherwise the broker can't resume the persistent session.
+//
+// Command topics are subscribed under each of prefixes, one per hub, and look up their
+// device in devices. If
+// onHomeAssistantOnline is set, it's called whenever Home Assistant announces it's online.
+// If onConnect is set, it's called on every (re)connect, after the command subscriptions.
+func newMQTTOptions(config MQTTConfig, prefixes []string, devices *ddapi.DeviceRegistry, onHomeAssistantOnline, onConnect func(mqtt.Client)) (*mqtt.ClientOptions, error) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", broker, port))
+	opts.AddBroker(config.brokerURL())
 	// Use a stable client ID for a persistent session
-	opts.SetClientID("dd_haus")
+	opts.SetClientID(config.ClientID)
+
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 
 	// Networking and timeouts
 	opts.SetConnectTimeout(5 * time.Second)
@@ -224,40 +516,35 @@ func connectToMQTT(broker, user, password string, port int) mqtt.Client {
 	opts.SetOnConnectHandler(func(c mqtt.Client) {
 		logger.Info("Connected to MQTT broker")
 		// Subscribe (or resubscribe) on every (re)connect
-		subscribeToMQTTCommandTopics(ddapi.NewMQTTHandler(c, logger), *flagMqttPrefix)
+		handler := ddapi.NewMQTTHandler(c, logger)
+		for _, prefix := range prefixes {
+			subscribeToMQTTCommandTopics(handler, devices, prefix)
+		}
+		if onHomeAssistantOnline != nil {
+			subscribeToHomeAssistantStatus(c, onHomeAssistantOnline)
+		}
+		if onConnect != nil {
+			onConnect(c)
+		}
 	})
 	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
 		logger.WithError(err).Warn("MQTT connection lost; will retry")
 	})
 
-	if user != "" {
-		opts.SetUsername(user)
+	if config.User != "" {
+		opts.SetUsername(config.User)
 	}
 
-	if password != "" {
-		opts.SetPassword(password)
+	if config.Password != "" {
+		opts.SetPassword(config.Password)
 	}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); !token.WaitTimeout(3 * time.Second) {
-		logger.Warn("Initial MQTT connect timed out; auto-reconnect will continue in background")
-	} else if err := token.Error(); err != nil {
-		// Detect common authentication/authorization failures and fail fast
-		errStr := strings.ToLower(err.Error())
-		if strings.Contains(errStr, "not authorized") || strings.Contains(errStr, "not authorised") || strings.Contains(errStr, "bad user name or password") || strings.Contains(errStr, "unauthor") {
-			logger.WithError(err).Error("MQTT authentication failed. Check username/password and broker ACLs.")
-			os.Exit(1)
-		}
-		logger.WithError(err).Warn("Initial MQTT connect failed; will keep retrying in background")
-	}
-
-	return client
+	return opts, nil
 }
 
 // Subscribe to MQTT topics
-func subscribeToMQTTCommandTopics(mqttHandler *ddapi.MQTTHandler, prefix string) {
+func subscribeToMQTTCommandTopics(mqttHandler *ddapi.MQTTHandler, devices *ddapi.DeviceRegistry, prefix string) {
 	commandTopics := fmt.Sprintf(ddapi.CommandTopicTemplate, prefix, "+")
-	setPositionTopics := fmt.Sprintf(ddapi.SetPositionTopicTemplate, prefix, "+")
 
 	// If not connected, skip subscribing; OnConnect will invoke us again
 	if !mqttHandler.Client.IsConnected() {
@@ -265,82 +552,117 @@ func subscribeToMQTTCommandTopics(mqttHandler *ddapi.MQTTHandler, prefix string)
 		return
 	}
 
-	// Subscribe to command topic
-	token := mqttHandler.Client.Subscribe(commandTopics, 0, func(client mqtt.Client, msg mqtt.Message) {
-		payload := strings.ToUpper(string(msg.Payload()))
-		logger.WithField("payload", payload).WithField("topic", msg.Topic()).Info("processing mqtt command")
-		handleCommand(msg.Topic(), payload)
-	})
-	if !token.WaitTimeout(3 * time.Second) {
-		logger.WithField("topic", commandTopics).Warn("Subscribe timed out; will retry on next reconnect")
-		return
+	subscriptions := []struct {
+		name    string
+		topic   string
+		handler func(devices *ddapi.DeviceRegistry, topic, payload string)
+	}{
+		{"command", commandTopics, func(devices *ddapi.DeviceRegistry, topic, payload string) {
+			handleCommand(devices, topic, strings.ToUpper(payload))
+		}},
+		{"set_position", fmt.Sprintf(ddapi.SetPositionTopicTemplate, prefix, "+"), handleSetPosition},
+		{"set_light", fmt.Sprintf(ddapi.LightCommandTopicTemplate, prefix, "+"), handleSetLight},
+		{"set_aux", fmt.Sprintf(ddapi.AuxCommandTopicTemplate, prefix, "+"), handleSetAux},
+		{"set_audio_alarm", fmt.Sprintf(ddapi.AudioAlarmCommandTopicTemplate, prefix, "+"), handleSetAudioAlarm},
+		{"set_motion_alarm", fmt.Sprintf(ddapi.MotionAlarmCommandTopicTemplate, prefix, "+"), handleSetMotionAlarm},
+		{"set_phone_lockout", fmt.Sprintf(ddapi.PhoneLockoutCommandTopicTemplate, prefix, "+"), handleSetPhoneLockout},
+		{"set_remote_lockout", fmt.Sprintf(ddapi.RemoteLockoutCommandTopicTemplate, prefix, "+"), handleSetRemoteLockout},
+		{"set_auto_close", fmt.Sprintf(ddapi.AutoCloseCommandTopicTemplate, prefix, "+"), handleSetAutoClose},
 	}
-	if err := token.Error(); err != nil {
-		logger.WithError(err).WithField("topic", commandTopics).Warn("Subscribe failed; will retry on next reconnect")
-		return
+
+	for _, sub := range subscriptions {
+		name, pattern, handler := sub.name, sub.topic, sub.handler
+		for _, topic := range access.userTopics(pattern) {
+			token := mqttHandler.Client.Subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+				payload := string(msg.Payload())
+				logger.WithField("payload", payload).WithField("topic", msg.Topic()).Info("processing mqtt " + name)
+				if readOnly {
+					rejectCommand(client, prefix, msg.Topic(), payload)
+					return
+				}
+				commandTopic, user := splitUser(pattern, msg.Topic())
+				deviceID, _ := deviceIDFromTopic(commandTopic)
+				req := commandRequest{Device: deviceID, Command: commandName(name, payload), Source: ddapi.SourceMQTT, User: user}
+				if err := authorize(access, req); err != nil {
+					return
+				}
+				handler(devices, commandTopic, payload)
+			})
+			if !token.WaitTimeout(3 * time.Second) {
+				logger.WithField("topic", topic).Warn("Subscribe timed out; will retry on next reconnect")
+				return
+			}
+			if err := token.Error(); err != nil {
+				logger.WithError(err).WithField("topic", topic).Warn("Subscribe failed; will retry on next reconnect")
+				return
+			}
+			logger.WithField("topic", topic).Info("Subscribed to " + name + " topic")
+		}
 	}
-	logger.WithField("commandTopics", commandTopics).Info("Subscribed to command topic")
+}
 
-	// Subscribe to set_position topic
-	token = mqttHandler.Client.Subscribe(setPositionTopics, 0, func(client mqtt.Client, msg mqtt.Message) {
-		payload := string(msg.Payload())
-		logger.WithField("payload", payload).WithField("topic", msg.Topic()).Info("processing mqtt set_position")
-		handleSetPosition(msg.Topic(), payload)
+// subscribeToHomeAssistantStatus calls onOnline whenever Home Assistant publishes its
+// "online" birth message.
+func subscribeToHomeAssistantStatus(client mqtt.Client, onOnline func(mqtt.Client)) {
+	token := client.Subscribe(ddapi.HomeAssistantStatusTopic, 0, func(c mqtt.Client, msg mqtt.Message) {
+		if string(msg.Payload()) != "online" {
+			return
+		}
+		logger.Info("Home Assistant is online; republishing discovery and state")
+		// Publishing waits on the client, so it mustn't block the message handler
+		go onOnline(c)
 	})
 	if !token.WaitTimeout(3 * time.Second) {
-		logger.WithField("topic", setPositionTopics).Warn("Subscribe timed out; will retry on next reconnect")
+		logger.WithField("topic", ddapi.HomeAssistantStatusTopic).Warn("Subscribe timed out; will retry on next reconnect")
 		return
 	}
 	if err := token.Error(); err != nil {
-		logger.WithError(err).WithField("topic", setPositionTopics).Warn("Subscribe failed; will retry on next reconnect")
+		logger.WithError(err).WithField("topic", ddapi.HomeAssistantStatusTopic).Warn("Subscribe failed; will retry on next reconnect")
 		return
 	}
-	logger.WithField("setPositionTopics", setPositionTopics).Info("Subscribed to set_position topic")
+	logger.WithField("topic", ddapi.HomeAssistantStatusTopic).Info("Subscribed to Home Assistant status topic")
 }
 
 // Handle incoming MQTT messages
-func handleCommand(topic string, command string) {
-	parts := strings.Split(topic, "/")
-	if len(parts) < 3 {
+func handleCommand(devices *ddapi.DeviceRegistry, topic string, command string) {
+	deviceID, ok := deviceIDFromTopic(topic)
+	if !ok {
 		logger.WithField("topic", topic).Warn("Invalid topic format")
 		return
 	}
-
-	deviceID := parts[1]
-	// Use thread-safe helper to access DeviceFSMs
-	deviceFSM, exists := ddapi.GetDeviceFSM(deviceID)
+	deviceFSM, exists := devices.Get(deviceID)
 
 	if !exists {
 		logger.WithField("device", deviceID).Error("Device does not exist")
 		return
 	}
 
+	switch command {
+	case "GO_OPEN", "GO_CLOSE", "STOP":
+		if !checkBasestationOnline(deviceFSM.Conn, deviceID) {
+			return
+		}
+	}
+
 	switch command {
 	case "ONLINE":
-		err := deviceFSM.Trigger(context.Background(), "go_online")
+		err := deviceFSM.Trigger(ddapi.WithSource(context.Background(), ddapi.SourceMQTT), "go_online")
 		if err != nil {
 			logger.WithError(err).Error("Failed to process 'go_online' event")
 		}
 	case "OFFLINE":
-		err := deviceFSM.Trigger(context.Background(), "go_offline")
+		err := deviceFSM.Trigger(ddapi.WithSource(context.Background(), ddapi.SourceMQTT), "go_offline")
 		if err != nil {
 			logger.WithError(err).Error("Failed to process 'go_offline' event")
 		}
+	// Door motion goes through the device's queue, so it can't collide with the door's
+	// current motion or with other commands still being sent
 	case "GO_OPEN":
-		err := deviceFSM.Trigger(context.Background(), "go_open")
-		if err != nil {
-			logger.WithError(err).Error("Failed to process 'open' event")
-		}
+		deviceFSM.Queue().Submit(ddapi.QueuedCommand{Action: ddapi.QueueOpen, Source: ddapi.SourceMQTT})
 	case "GO_CLOSE":
-		err := deviceFSM.Trigger(context.Background(), "go_close")
-		if err != nil {
-			logger.WithError(err).Error("Failed to process 'close' event")
-		}
+		deviceFSM.Queue().Submit(ddapi.QueuedCommand{Action: ddapi.QueueClose, Source: ddapi.SourceMQTT})
 	case "STOP":
-		err := deviceFSM.Trigger(context.Background(), "go_stop")
-		if err != nil {
-			logger.WithError(err).Error("Failed to process 'stop' event")
-		}
+		deviceFSM.Queue().Submit(ddapi.QueuedCommand{Action: ddapi.QueueStop, Source: ddapi.SourceMQTT})
 	default:
 		logger.WithFields(logrus.Fields{
 			"deviceID": deviceID,
@@ -348,25 +670,36 @@ func handleCommand(topic string, command string) {
 	}
 }
 
-// Handle set_position MQTT messages
-func handleSetPosition(topic string, positionStr string) {
+// deviceIDFromTopic extracts the device ID from a {prefix}/{deviceID}/{suffix} topic.
+// The prefix may itself contain slashes.
+func deviceIDFromTopic(topic string) (string, bool) {
 	parts := strings.Split(topic, "/")
-	if len(parts) < 3 {
+	if len(parts) < 3 || parts[len(parts)-2] == "" {
+		return "", false
+	}
+	return parts[len(parts)-2], true
+}
+
+// Handle set_position MQTT messages
+func handleSetPosition(devices *ddapi.DeviceRegistry, topic string, positionStr string) {
+	deviceID, ok := deviceIDFromTopic(topic)
+	if !ok {
 		logger.WithField("topic", topic).Warn("Invalid topic format for set_position")
 		return
 	}
-
-	deviceID := parts[1]
-	// Use thread-safe helper to access DeviceFSMs
-	deviceFSM, exists := ddapi.GetDeviceFSM(deviceID)
+	deviceFSM, exists := devices.Get(deviceID)
 
 	if !exists {
 		logger.WithField("device", deviceID).Error("Device does not exist for set_position")
 		return
 	}
 
-	// Parse position
-	position, err := strconv.Atoi(positionStr)
+	if !checkBasestationOnline(deviceFSM.Conn, deviceID) {
+		return
+	}
+
+	// Parse position, clamped to 0-100
+	position, err := ddapi.ParsePosition(positionStr)
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"deviceID": deviceID,
@@ -376,42 +709,115 @@ func handleSetPosition(topic string, positionStr string) {
 		return
 	}
 
-	// Validate range
-	if position < 0 || position > 100 {
-		logger.WithFields(logrus.Fields{
-			"deviceID": deviceID,
-			"position": position,
-		}).Warn("Position out of range - clamping to 0-100")
-	}
-
 	logger.WithFields(logrus.Fields{
 		"deviceID": deviceID,
 		"position": position,
 	}).Info("Setting door position")
 
-	// Get the appropriate command for this position
-	cmd := ddapi.GetCommandForPosition(position)
+	deviceFSM.Queue().Submit(ddapi.QueuedCommand{Action: ddapi.QueuePosition, Position: position, Source: ddapi.SourceMQTT})
+}
+
+// Handle set_light MQTT messages
+func handleSetLight(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	handleOnOffCommand(devices, topic, payload, "light", ddapi.LightCommand)
+}
+
+// Handle set_aux MQTT messages
+func handleSetAux(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	handleOnOffCommand(devices, topic, payload, "aux", ddapi.AuxCommand)
+}
+
+// Handle set_audio_alarm MQTT messages
+func handleSetAudioAlarm(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	handleOnOffCommand(devices, topic, payload, "audio_alarm", ddapi.AudioAlarmCommand)
+}
+
+// Handle set_motion_alarm MQTT messages
+func handleSetMotionAlarm(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	handleOnOffCommand(devices, topic, payload, "motion_alarm", ddapi.MotionAlarmCommand)
+}
+
+// Handle set_phone_lockout MQTT messages
+func handleSetPhoneLockout(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	handleOnOffCommand(devices, topic, payload, "phone_lockout", ddapi.PhoneLockoutCommand)
+}
+
+// Handle set_remote_lockout MQTT messages
+func handleSetRemoteLockout(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	handleOnOffCommand(devices, topic, payload, "remote_lockout", ddapi.RemoteLockoutCommand)
+}
+
+// Handle set_auto_close MQTT messages
+func handleSetAutoClose(devices *ddapi.DeviceRegistry, topic string, payload string) {
+	deviceID, ok := deviceIDFromTopic(topic)
+	if !ok {
+		logger.WithField("topic", topic).Warn("Invalid topic format for set_auto_close")
+		return
+	}
+
+	deviceFSM, exists := devices.Get(deviceID)
+	if !exists {
+		logger.WithField("device", deviceID).Error("Device does not exist for set_auto_close")
+		return
+	}
 
-	// Execute the command
-	err = ddapi.SafeCommand(deviceFSM.Conn, deviceID, cmd)
+	minutes, err := ddapi.ParseAutoCloseMinutes(payload)
 	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"deviceID": deviceID,
-			"position": position,
-			"command":  cmd,
-			"error":    err,
-		}).Error("Failed to execute position command")
+		logger.WithError(err).WithField("deviceID", deviceID).Error("Invalid auto-close value")
 		return
 	}
+	logger.WithFields(logrus.Fields{"deviceID": deviceID, "minutes": minutes}).Info("Setting auto-close")
+	deviceFSM.SetAutoClose(time.Duration(minutes) * time.Minute)
+}
 
-	logger.WithFields(logrus.Fields{
-		"deviceID": deviceID,
-		"position": position,
-		"command":  cmd,
-	}).Info("Position command executed successfully")
+// handleOnOffCommand sends the command that toCommand maps an ON/OFF payload to.
+func handleOnOffCommand(devices *ddapi.DeviceRegistry, topic, payload, name string, toCommand func(string) (int, error)) {
+	deviceID, ok := deviceIDFromTopic(topic)
+	if !ok {
+		logger.WithField("topic", topic).Warn("Invalid topic format for set_" + name)
+		return
+	}
+
+	deviceFSM, exists := devices.Get(deviceID)
+	if !exists {
+		logger.WithField("device", deviceID).Error("Device does not exist for set_" + name)
+		return
+	}
+
+	cmd, err := toCommand(strings.ToUpper(strings.TrimSpace(payload)))
+	if err != nil {
+		logger.WithError(err).WithField("deviceID", deviceID).Error("Invalid " + name + " command")
+		return
+	}
+
+	if !checkBasestationOnline(deviceFSM.Conn, deviceID) {
+		return
+	}
+
+	if err := ddapi.SafeCommand(deviceFSM.Conn, deviceID, cmd); err != nil {
+		logger.WithError(err).WithField("deviceID", deviceID).Error("Failed to execute " + name + " command")
+	}
+}
+
+// checkBasestationOnline warns if the hub reports the base station offline, and
+// returns false if door commands should be dropped (-pauseWhenOffline).
+func checkBasestationOnline(conn *dd.Conn, deviceID string) bool {
+	if conn.IsBasestationOnline() {
+		return true
+	}
+	logger.WithField("deviceID", deviceID).Warn("Base station reported offline")
+	if *flagPauseOffline {
+		logger.WithField("deviceID", deviceID).Warn("Dropping command while base station is offline")
+		return false
+	}
+	return true
 }
 
-func handleStatusUpdates(ctx context.Context, conn *dd.Conn, statusCh chan ddapi.DoorStatus) {
+// handleStatusUpdates sends the hub's current status and then every status update to
+// statusCh, closing it once ctx is done or the connection is lost. It returns why it stopped.
+func handleStatusUpdates(ctx context.Context, conn *dd.Conn, statusCh chan ddapi.DoorStatus) error {
+	defer close(statusCh)
+
 	status, err := ddapi.SafeFetchStatus(conn)
 	if err != nil {
 		logger.WithError(err).Error("Failed to fetch initial status")
@@ -420,9 +826,21 @@ func handleStatusUpdates(ctx context.Context, conn *dd.Conn, statusCh chan ddapi
 		statusCh <- *status
 	}
 
-	if err := helper.LoopMessages(ctx, conn, statusCh); err != nil {
-		logger.WithError(err).Error("Error reading messages - connection may be lost")
-		// Allow graceful shutdown instead of Fatal
-		close(statusCh)
-	}
+	runner := dd.NewRunner(conn, 0)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for m := range runner.Status() {
+			var status ddapi.DoorStatus
+			if err := m.Decode(&status); err != nil {
+				logger.WithError(err).Debug("Ignoring message that is not a door status")
+				continue
+			}
+			statusCh <- status
+		}
+	}()
+
+	err = runner.Run(ctx)
+	<-forwarded
+	return err
 }
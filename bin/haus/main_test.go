@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewMQTTOptions_ClientID(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+	}{
+		{"Default client ID", "dd_haus"},
+		{"Custom client ID", "dd_haus_garage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := newMQTTOptions(MQTTConfig{Broker: "localhost", Port: 1883, ClientID: tt.clientID}, nil, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("newMQTTOptions() error = %v", err)
+			}
+			if opts.ClientID != tt.clientID {
+				t.Errorf("newMQTTOptions() ClientID = %q, want %q", opts.ClientID, tt.clientID)
+			}
+		})
+	}
+}
+
+func TestNewMQTTOptions_Credentials(t *testing.T) {
+	opts, err := newMQTTOptions(MQTTConfig{Broker: "localhost", Port: 1883, ClientID: "dd_haus", User: "user", Password: "pass"}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("newMQTTOptions() error = %v", err)
+	}
+
+	if opts.Username != "user" {
+		t.Errorf("newMQTTOptions() Username = %q, want %q", opts.Username, "user")
+	}
+	if opts.Password != "pass" {
+		t.Errorf("newMQTTOptions() Password = %q, want %q", opts.Password, "pass")
+	}
+	if len(opts.Servers) != 1 || opts.Servers[0].String() != "tcp://localhost:1883" {
+		t.Errorf("newMQTTOptions() Servers = %v, want [tcp://localhost:1883]", opts.Servers)
+	}
+}
+
+func TestDeviceIDFromTopic(t *testing.T) {
+	tests := []struct {
+		topic  string
+		want   string
+		wantOK bool
+	}{
+		{"dd-door/abc123/set_position", "abc123", true},
+		{"home/dd-door/abc123/command", "abc123", true},
+		{"dd-door/command", "", false},
+		{"dd-door//command", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := deviceIDFromTopic(tt.topic)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("deviceIDFromTopic(%q) = %q, %v, want %q, %v", tt.topic, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
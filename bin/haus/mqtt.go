@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// MQTTConfig holds the broker connection settings.
+type MQTTConfig struct {
+	Broker   string `yaml:"broker"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"clientID"`
+	Prefix   string `yaml:"prefix"` // topic prefix, e.g. dd-door
+
+	TLS      bool   `yaml:"tls"`      // connect with ssl:// instead of tcp://
+	CAFile   string `yaml:"caFile"`   // PEM CA bundle to verify the broker, defaults to the system roots
+	CertFile string `yaml:"certFile"` // PEM client certificate for mutual TLS
+	KeyFile  string `yaml:"keyFile"`  // PEM client key for mutual TLS
+}
+
+// brokerURL returns the broker URL, using ssl:// when TLS is enabled.
+func (c MQTTConfig) brokerURL() string {
+	scheme := "tcp"
+	if c.TLS {
+		scheme = "ssl"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, c.Broker, c.Port)
+}
+
+// tlsConfig builds the TLS settings for the broker connection, or nil if TLS is disabled.
+func (c MQTTConfig) tlsConfig() (*tls.Config, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read MQTT CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("MQTT client certificate and key must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load MQTT client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key as PEM files in dir.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "broker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMQTTConfig_BrokerURL(t *testing.T) {
+	tests := []struct {
+		config MQTTConfig
+		want   string
+	}{
+		{MQTTConfig{Broker: "localhost", Port: 1883}, "tcp://localhost:1883"},
+		{MQTTConfig{Broker: "localhost", Port: 8883, TLS: true}, "ssl://localhost:8883"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.config.brokerURL(); got != tt.want {
+			t.Errorf("brokerURL() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestMQTTConfig_TLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+
+	config, err := MQTTConfig{TLS: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}.tlsConfig()
+	if err != nil {
+		t.Fatalf("tlsConfig() error = %v", err)
+	}
+	if config.RootCAs == nil {
+		t.Errorf("tlsConfig() RootCAs = nil, want CA pool")
+	}
+	if len(config.Certificates) != 1 {
+		t.Errorf("tlsConfig() has %d client certificates, want 1", len(config.Certificates))
+	}
+
+	if config, err := (MQTTConfig{}).tlsConfig(); config != nil || err != nil {
+		t.Errorf("tlsConfig() without TLS = %v, %v, want nil, nil", config, err)
+	}
+}
+
+func TestMQTTConfig_TLSConfigErrors(t *testing.T) {
+	certFile, _ := writeTestCert(t, t.TempDir())
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	tests := []struct {
+		name   string
+		config MQTTConfig
+	}{
+		{"Missing CA file", MQTTConfig{TLS: true, CAFile: missing}},
+		{"CA file without certificates", MQTTConfig{TLS: true, CAFile: writeFile(t, "not a cert")}},
+		{"Certificate without key", MQTTConfig{TLS: true, CertFile: certFile}},
+		{"Missing key file", MQTTConfig{TLS: true, CertFile: certFile, KeyFile: missing}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.config.tlsConfig(); err == nil {
+				t.Errorf("tlsConfig() error = nil, want error")
+			}
+		})
+	}
+}
+
+// writeFile writes content to a temp file and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
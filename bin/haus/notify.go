@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Service endpoints, replaced in tests
+var (
+	telegramAPI = "https://api.telegram.org"
+	pushoverAPI = "https://api.pushover.net/1/messages.json"
+)
+
+var (
+	// ErrTelegramConfig is returned for a Telegram notifier without a bot token or chat ID.
+	ErrTelegramConfig = errors.New("telegram needs botToken and chatId")
+	// ErrPushoverConfig is returned for a Pushover notifier without an app token or user key.
+	ErrPushoverConfig = errors.New("pushover needs token and user")
+)
+
+// TelegramConfig sends a webhook's messages from a Telegram bot to a chat.
+type TelegramConfig struct {
+	BotToken string `yaml:"botToken"` // from @BotFather
+	ChatID   string `yaml:"chatId"`   // user, group or channel; the bot must be a member
+}
+
+// PushoverConfig sends a webhook's messages as Pushover notifications.
+type PushoverConfig struct {
+	Token    string `yaml:"token"` // the application's API token
+	User     string `yaml:"user"`  // user or group key
+	Priority int    `yaml:"priority"`
+}
+
+func (c TelegramConfig) validate() error {
+	if c.BotToken == "" || c.ChatID == "" {
+		return ErrTelegramConfig
+	}
+	return nil
+}
+
+func (c PushoverConfig) validate() error {
+	if c.Token == "" || c.User == "" {
+		return ErrPushoverConfig
+	}
+	return nil
+}
+
+// request returns a sendMessage call posting text to the chat.
+func (c TelegramConfig) request(ctx context.Context, text []byte) (*http.Request, error) {
+	body, err := json.Marshal(map[string]string{"chat_id": c.ChatID, "text": string(text)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+"/bot"+c.BotToken+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// request returns a message call sending text, titled title.
+func (c PushoverConfig) request(ctx context.Context, title string, text []byte) (*http.Request, error) {
+	form := url.Values{
+		"token":   {c.Token},
+		"user":    {c.User},
+		"message": {string(text)},
+	}
+	if title != "" {
+		form.Set("title", title)
+	}
+	if c.Priority != 0 {
+		form.Set("priority", strconv.Itoa(c.Priority))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverAPI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+func TestNewWebhook_Services(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  WebhookConfig
+		wantErr error
+	}{
+		{"telegram", WebhookConfig{Telegram: &TelegramConfig{BotToken: "123:abc", ChatID: "42"}}, nil},
+		{"telegram without chat", WebhookConfig{Telegram: &TelegramConfig{BotToken: "123:abc"}}, ErrTelegramConfig},
+		{"pushover", WebhookConfig{Pushover: &PushoverConfig{Token: "app", User: "user"}}, nil},
+		{"pushover without user", WebhookConfig{Pushover: &PushoverConfig{Token: "app"}}, ErrPushoverConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newWebhook(tt.config); !errors.Is(err, tt.wantErr) {
+				t.Errorf("newWebhook() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotifier_Telegram(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	defer func(api string) { telegramAPI = api }(telegramAPI)
+	telegramAPI = server.URL
+
+	n := newTestNotifier(t, WebhookConfig{
+		Telegram: &TelegramConfig{BotToken: "123:abc", ChatID: "42"},
+		Template: "{{.Message}} at {{.Position}}%: {{.Log}}",
+	})
+	device := statusAt(0).Devices[0]
+	n.update(&hub{}, statusAt(0))
+	device.Device.Position = 30
+	device.Log.Text = "Opened by remote"
+	n.update(&hub{}, ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{device}})
+	n.wg.Wait()
+
+	bodies := server.received()
+	if len(bodies) != 1 {
+		t.Fatalf("received %v, want one message", bodies)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal([]byte(bodies[0]), &msg); err != nil {
+		t.Fatalf("sendMessage body is not JSON: %v", err)
+	}
+	if msg["chat_id"] != "42" || msg["text"] != "Garage opened at 30%: Opened by remote" {
+		t.Errorf("sendMessage = %v, want the rendered message for chat 42", msg)
+	}
+}
+
+func TestNotifier_Pushover(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	defer func(api string) { pushoverAPI = api }(pushoverAPI)
+	pushoverAPI = server.URL
+
+	n := newTestNotifier(t, WebhookConfig{Pushover: &PushoverConfig{Token: "app", User: "user", Priority: 1}})
+	n.update(&hub{}, statusAt(0))
+	n.update(&hub{}, statusAt(100))
+	n.wg.Wait()
+
+	bodies := server.received()
+	if len(bodies) != 1 {
+		t.Fatalf("received %v, want one notification", bodies)
+	}
+	form, err := url.ParseQuery(bodies[0])
+	if err != nil {
+		t.Fatalf("message body is not a form: %v", err)
+	}
+	for key, want := range map[string]string{"token": "app", "user": "user", "message": "Garage opened", "title": "Garage", "priority": "1"} {
+		if got := form.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
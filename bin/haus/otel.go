@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// setupMetrics routes the dd and api packages' metrics to an OTLP gRPC endpoint (e.g.
+// http://localhost:4317) and/or a Prometheus /metrics endpoint served on
+// prometheusAddr (e.g. :9100). Either may be empty. The returned func stops the
+// HTTP server and flushes and stops the exporters.
+func setupMetrics(ctx context.Context, otlpEndpoint, prometheusAddr string) (func(context.Context) error, error) {
+	var opts []sdkmetric.Option
+	if otlpEndpoint != "" {
+		exporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpointURL(otlpEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	}
+
+	var server *http.Server
+	if prometheusAddr != "" {
+		registry := prometheus.NewRegistry()
+		exporter, err := otelprom.New(otelprom.WithRegisterer(registry))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdkmetric.WithReader(exporter))
+
+		// Listen up front so a bad address fails startup rather than a background goroutine
+		ln, err := net.Listen("tcp", prometheusAddr)
+		if err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		server = &http.Server{Handler: mux}
+		go func() {
+			if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.WithError(err).Error("Prometheus metrics server stopped")
+			}
+		}()
+	}
+
+	provider := sdkmetric.NewMeterProvider(opts...)
+	shutdown := func(ctx context.Context) error {
+		var serverErr error
+		if server != nil {
+			serverErr = server.Shutdown(ctx)
+		}
+		return errors.Join(serverErr, provider.Shutdown(ctx))
+	}
+	if err := errors.Join(ddapi.SetMeterProvider(provider), dd.SetMeterProvider(provider)); err != nil {
+		shutdown(ctx)
+		return nil, err
+	}
+	return shutdown, nil
+}
+
+// setupTracing exports the spans for hub RPCs, message polls and door FSM events to an
+// OTLP gRPC endpoint (e.g. http://localhost:4317). The returned func flushes and stops
+// the exporter.
+func setupTracing(ctx context.Context, otlpEndpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(otlpEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("haus"))),
+	)
+	dd.SetTracerProvider(provider)
+	ddapi.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
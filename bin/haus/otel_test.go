@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	ddapi "github.com/gravypower/dd/api"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupMetrics_Prometheus(t *testing.T) {
+	// Reserve a free port for the metrics server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx := context.Background()
+	shutdown, err := setupMetrics(ctx, "", addr)
+	if err != nil {
+		t.Fatalf("setupMetrics() error = %v", err)
+	}
+	t.Cleanup(func() {
+		shutdown(ctx)
+		ddapi.SetMeterProvider(otel.GetMeterProvider())
+	})
+
+	ddapi.RecordDoorStatus(ctx, &ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{{ID: "door1"}}})
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{"dd_status_updates", "dd_door_current_position"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestSetupMetrics_BadAddr(t *testing.T) {
+	if _, err := setupMetrics(context.Background(), "", "not-an-address"); err == nil {
+		t.Errorf("setupMetrics() with invalid address error = nil, want error")
+	}
+}
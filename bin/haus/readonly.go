@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// bridgeWarningTopicTemplate is where warnings about the bridge itself are published,
+// such as commands rejected in read-only mode.
+const bridgeWarningTopicTemplate = "%s/bridge/warning"
+
+var (
+	// ErrReadOnly is the reason given for commands rejected in read-only mode.
+	ErrReadOnly = errors.New("haus is read-only; commands are rejected")
+	// ErrReadOnlyConflict is returned for settings that move doors by themselves, which
+	// read-only mode can't honour.
+	ErrReadOnlyConflict = errors.New("not allowed in read-only mode")
+)
+
+// readOnly rejects commands from Home Assistant and the gateway while still publishing
+// states and sensors. It's set from the config before anything connects.
+var readOnly bool
+
+// bridgeWarning is published to bridgeWarningTopicTemplate.
+type bridgeWarning struct {
+	Warning string    `json:"warning"`
+	Topic   string    `json:"topic,omitempty"`
+	Payload string    `json:"payload,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// validateReadOnly checks that c doesn't configure anything that would move a door when
+// read-only mode is on.
+func (c *Config) validateReadOnly() error {
+	if !c.ReadOnly {
+		return nil
+	}
+	if len(c.Schedules) > 0 {
+		return fmt.Errorf("schedule %s: %w", c.Schedules[0].Name, ErrReadOnlyConflict)
+	}
+	for _, d := range c.Devices {
+		if d.AutoClose > 0 {
+			return fmt.Errorf("device %s: autoClose %w", d.ID, ErrReadOnlyConflict)
+		}
+	}
+	return nil
+}
+
+// rejectCommand logs a command received on topic in read-only mode and publishes a
+// warning about it under prefix.
+func rejectCommand(client mqtt.Client, prefix, topic, payload string) {
+	logger.WithFields(logrus.Fields{"topic": topic, "payload": payload}).Warn("Read-only: rejected command")
+
+	warning := bridgeWarning{Warning: ErrReadOnly.Error(), Topic: topic, Payload: payload, Time: time.Now()}
+	publishBridgeEvent(client, fmt.Sprintf(bridgeWarningTopicTemplate, prefix), warning)
+}
+
+// publishBridgeEvent publishes v as JSON to topic, without retaining it. It publishes in
+// the background, so it can be called from message handlers.
+func publishBridgeEvent(client mqtt.Client, topic string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logger.WithError(err).WithField("topic", topic).Error("Failed to encode bridge event")
+		return
+	}
+	// Publishing waits on the client, so it mustn't block the message handler
+	go func() {
+		token := client.Publish(topic, 0, false, b)
+		if !token.WaitTimeout(3*time.Second) || token.Error() != nil {
+			logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to publish bridge event")
+		}
+	}()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/sirupsen/logrus"
+)
+
+// adminTopicTemplate receives bridge admin commands: "reload" reloads the config file.
+const adminTopicTemplate = "%s/bridge/admin"
+
+// reloadTopicTemplate is where the outcome of each reload is published.
+const reloadTopicTemplate = "%s/bridge/reload"
+
+// Where a reload was asked for, as published with its outcome
+const (
+	reloadFromSignal = "signal"
+	reloadFromMQTT   = "mqtt"
+)
+
+// ErrNoConfigFile is returned for a reload when haus wasn't started with -config.
+var ErrNoConfigFile = errors.New("no config file to reload; start haus with -config")
+
+// reloadResult is published to reloadTopicTemplate after each reload.
+type reloadResult struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// reloader reloads the config file, applying what can change without reconnecting to
+// the hubs or the broker: device overrides, schedules, the ACL and the log level.
+type reloader struct {
+	path      string
+	prefix    string
+	current   *atomic.Pointer[Config]
+	devices   *ddapi.DeviceRegistry
+	schedules *scheduler
+	access    *accessControl
+
+	// refresh subscribes to the command topics again and republishes discovery, for
+	// changed ACL users and device overrides. Nil without MQTT.
+	refresh func(mqtt.Client)
+
+	reloading sync.Mutex // serializes reloads
+
+	mu     sync.Mutex
+	client mqtt.Client // set once connected to MQTT
+}
+
+// setClient sets the MQTT client used to refresh subscriptions and publish results. It's
+// called on every (re)connect to the broker.
+func (r *reloader) setClient(client mqtt.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client = client
+}
+
+// mqttClient returns the client set by setClient, or nil.
+func (r *reloader) mqttClient() mqtt.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.client
+}
+
+// subscribe subscribes to the admin topic, and its per-user topics if the ACL needs them.
+// It's called on every (re)connect to the broker.
+func (r *reloader) subscribe(client mqtt.Client) {
+	pattern := fmt.Sprintf(adminTopicTemplate, r.prefix)
+	for _, topic := range r.access.userTopics(pattern) {
+		token := client.Subscribe(topic, 0, func(c mqtt.Client, msg mqtt.Message) {
+			payload := strings.TrimSpace(string(msg.Payload()))
+			logger.WithField("payload", payload).WithField("topic", msg.Topic()).Info("processing mqtt admin")
+			if !strings.EqualFold(payload, "reload") {
+				logger.WithField("payload", payload).Warn("Unknown admin command")
+				return
+			}
+			_, user := splitUser(pattern, msg.Topic())
+			if err := authorize(r.access, commandRequest{Command: "reload", Source: ddapi.SourceMQTT, User: user}); err != nil {
+				return
+			}
+			// Reloading resubscribes, which waits on the client, so it mustn't block the
+			// message handler
+			go r.run(reloadFromMQTT)
+		})
+		if !token.WaitTimeout(3 * time.Second) {
+			logger.WithField("topic", topic).Warn("Subscribe timed out; will retry on next reconnect")
+			return
+		}
+		if err := token.Error(); err != nil {
+			logger.WithError(err).WithField("topic", topic).Warn("Subscribe failed; will retry on next reconnect")
+			return
+		}
+		logger.WithField("topic", topic).Info("Subscribed to admin topic")
+	}
+}
+
+// run reloads the config, logging and publishing the outcome.
+func (r *reloader) run(source string) {
+	result := reloadResult{Source: source}
+	if err := r.reload(); err != nil {
+		logger.WithError(err).Error("Failed to reload config; keeping the current one")
+		result.Error = err.Error()
+	}
+	result.Time = time.Now()
+	if client := r.mqttClient(); client != nil {
+		publishBridgeEvent(client, fmt.Sprintf(reloadTopicTemplate, r.prefix), result)
+	}
+}
+
+// reload reads the config file again, with the command line still overriding it, and
+// applies it.
+func (r *reloader) reload() error {
+	if r.path == "" {
+		return ErrNoConfigFile
+	}
+	config, err := loadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	config.applyFlags(setFlags())
+	return r.apply(config)
+}
+
+// apply validates config and applies the settings that can change while running. Nothing
+// is applied if any of it is invalid.
+func (r *reloader) apply(config *Config) error {
+	level := logrus.InfoLevel
+	if config.LogLevel != "" {
+		var err error
+		if level, err = logrus.ParseLevel(config.LogLevel); err != nil {
+			return fmt.Errorf("invalid log level: %w", err)
+		}
+	}
+	if err := config.validateDevices(); err != nil {
+		return err
+	}
+	if config.ReadOnly != readOnly {
+		logger.Warn("readOnly only changes once haus restarts")
+		config.ReadOnly = readOnly
+	}
+	if err := config.validateReadOnly(); err != nil {
+		return err
+	}
+	rules, err := parseACLRules(config.ACL)
+	if err != nil {
+		return err
+	}
+
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+	// Replacing the schedules is the last thing that can fail, and does so without
+	// changing them
+	if err := r.schedules.replace(config.Schedules); err != nil {
+		return err
+	}
+	logger.SetLevel(level)
+	r.access.setRules(rules)
+
+	previous := r.current.Swap(config)
+	if needsRestart(previous, config) {
+		logger.Warn("Hub, MQTT and gateway settings only change once haus restarts")
+	}
+	for deviceID, deviceFSM := range r.devices.All() {
+		before, after := previous.deviceConfig(deviceID), config.deviceConfig(deviceID)
+		// Only changes to the file override a delay set from Home Assistant
+		if after.AutoClose != before.AutoClose {
+			deviceFSM.SetAutoClose(time.Duration(after.AutoClose) * time.Minute)
+		}
+		if after.AutoCloseWarning != before.AutoCloseWarning {
+			deviceFSM.SetAutoCloseWarning(time.Duration(after.AutoCloseWarning) * time.Minute)
+		}
+	}
+
+	if client := r.mqttClient(); client != nil && client.IsConnected() && r.refresh != nil {
+		r.refresh(client)
+	}
+	logger.Info("Reloaded config")
+	return nil
+}
+
+// needsRestart reports whether before and after differ in settings that can't be
+// reloaded.
+func needsRestart(before, after *Config) bool {
+	a, b := *before, *after
+	for _, c := range []*Config{&a, &b} {
+		c.LogLevel, c.Devices, c.Schedules, c.ACL = "", nil, nil, nil
+	}
+	return !reflect.DeepEqual(a, b)
+}
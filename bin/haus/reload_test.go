@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestReloader returns a reloader whose current config is config.
+func newTestReloader(t *testing.T, config *Config) *reloader {
+	t.Helper()
+	prevLevel := logger.GetLevel()
+	t.Cleanup(func() { logger.SetLevel(prevLevel) })
+
+	devices := ddapi.NewDeviceRegistry()
+	access, err := newAccessControl(config.ACL, "dd-door", devices)
+	if err != nil {
+		t.Fatalf("newAccessControl() error = %v", err)
+	}
+	schedules := newScheduler("dd-door", devices)
+	if err := schedules.replace(config.Schedules); err != nil {
+		t.Fatalf("replace() error = %v", err)
+	}
+	var current atomic.Pointer[Config]
+	current.Store(config)
+	return &reloader{prefix: "dd-door", current: &current, devices: devices, schedules: schedules, access: access}
+}
+
+func TestReloader_Apply(t *testing.T) {
+	r := newTestReloader(t, &Config{
+		Schedules: []ScheduleConfig{{Name: "night", Cron: "0 22 * * *", Device: "door1", Action: "close"}},
+	})
+	gatewayOpen := commandRequest{Device: "door1", Command: "open", Source: sourceGateway}
+
+	config := &Config{
+		LogLevel:  "debug",
+		Schedules: []ScheduleConfig{{Name: "morning", Cron: "0 7 * * *", Device: "door1", Action: "open"}},
+		ACL:       []ACLRule{{Commands: []string{"open"}, Sources: []string{"mqtt"}}},
+	}
+	if err := r.apply(config); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if got := r.schedules.list(); len(got) != 1 || got[0].Name != "morning" {
+		t.Errorf("schedules = %+v, want just morning", got)
+	}
+	if err := r.access.check(gatewayOpen); !errors.Is(err, ErrACLDenied) {
+		t.Errorf("check(gateway open) = %v, want the new ACL to deny it", err)
+	}
+	if logger.GetLevel() != logrus.DebugLevel {
+		t.Errorf("log level = %v, want debug", logger.GetLevel())
+	}
+	if r.current.Load() != config {
+		t.Error("current config wasn't replaced")
+	}
+}
+
+func TestReloader_ApplyInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"log level", Config{LogLevel: "loud"}},
+		{"device", Config{Devices: []DeviceConfig{{ID: "door1", DeviceClass: "portcullis"}}}},
+		{"acl", Config{ACL: []ACLRule{{Hours: "noon"}}}},
+		{"schedule", Config{Schedules: []ScheduleConfig{{Name: "broken", Cron: "whenever", Device: "door1", Action: "open"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := &Config{Schedules: []ScheduleConfig{{Name: "night", Cron: "0 22 * * *", Device: "door1", Action: "close"}}}
+			r := newTestReloader(t, previous)
+			config := tt.config
+			if err := r.apply(&config); err == nil {
+				t.Fatal("apply() error = nil, want an error")
+			}
+			if got := r.schedules.list(); len(got) != 1 || got[0].Name != "night" {
+				t.Errorf("schedules = %+v, want them unchanged", got)
+			}
+			if r.current.Load() != previous {
+				t.Error("current config was replaced")
+			}
+		})
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	r := newTestReloader(t, &Config{})
+	if err := r.reload(); !errors.Is(err, ErrNoConfigFile) {
+		t.Errorf("reload() without a file error = %v, want %v", err, ErrNoConfigFile)
+	}
+
+	r.path = filepath.Join(t.TempDir(), "haus.yaml")
+	yaml := "schedules:\n  - name: night\n    cron: \"0 22 * * *\"\n    device: door1\n    action: close\n"
+	if err := os.WriteFile(r.path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if got := r.schedules.list(); len(got) != 1 || got[0].Name != "night" {
+		t.Errorf("schedules = %+v, want night from the file", got)
+	}
+}
+
+func TestNeedsRestart(t *testing.T) {
+	before := &Config{Host: "192.168.1.20", LogLevel: "info"}
+	if needsRestart(before, &Config{Host: "192.168.1.20", LogLevel: "debug", ACL: []ACLRule{{Users: []string{"alice"}}}}) {
+		t.Error("needsRestart() = true for reloadable changes")
+	}
+	if !needsRestart(before, &Config{Host: "192.168.1.21", LogLevel: "info"}) {
+		t.Error("needsRestart() = false for a new host")
+	}
+}
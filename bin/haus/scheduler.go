@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// scheduleTopicTemplate carries the current schedules as a JSON array (retained)
+	scheduleTopicTemplate = "%s/schedules"
+	// scheduleCommandTopicTemplate receives schedules to add, replace or delete
+	scheduleCommandTopicTemplate = "%s/schedules/set"
+)
+
+// Conditions a schedule can be limited to
+const (
+	scheduleIfOpen   = "open"   // door isn't fully closed
+	scheduleIfClosed = "closed" // door is fully closed
+)
+
+var (
+	// ErrScheduleName is returned for a schedule without a name.
+	ErrScheduleName = errors.New("schedule name is required")
+	// ErrScheduleDevice is returned for a schedule without a device.
+	ErrScheduleDevice = errors.New("schedule device is required")
+	// ErrScheduleAction is returned for a schedule without an action.
+	ErrScheduleAction = errors.New("schedule action is required")
+	// ErrSchedulePosition is returned for a position schedule outside 0-100.
+	ErrSchedulePosition = errors.New("schedule position must be 0-100")
+	// ErrScheduleCondition is returned for an unknown schedule condition.
+	ErrScheduleCondition = errors.New("schedule condition must be open or closed")
+)
+
+// ScheduleConfig is a door action run on a cron schedule, e.g. closing the garage at
+// 22:00 if it's open.
+type ScheduleConfig struct {
+	Name   string `yaml:"name" json:"name"`
+	Cron   string `yaml:"cron" json:"cron"`     // five fields, in local time
+	Device string `yaml:"device" json:"device"` // device ID
+
+	// Action is open, close, stop or position, which go through the device's command
+	// queue, or any other command name or code, including the device's own buttons
+	// (e.g. pet_open).
+	Action   string `yaml:"action" json:"action"`
+	Position int    `yaml:"position" json:"position,omitempty"` // for position
+
+	If       string `yaml:"if" json:"if,omitempty"` // run only when the door is open or closed
+	Disabled bool   `yaml:"disabled" json:"disabled,omitempty"`
+}
+
+// validate checks the schedule is complete, returning its parsed cron expression.
+func (s ScheduleConfig) validate() (*cronSchedule, error) {
+	switch {
+	case s.Name == "":
+		return nil, ErrScheduleName
+	case s.Device == "":
+		return nil, ErrScheduleDevice
+	case s.Action == "":
+		return nil, ErrScheduleAction
+	case s.Action == ddapi.QueuePosition && (s.Position < ddapi.PositionClosed || s.Position > ddapi.PositionOpen):
+		return nil, fmt.Errorf("%w: %d", ErrSchedulePosition, s.Position)
+	case s.If != "" && s.If != scheduleIfOpen && s.If != scheduleIfClosed:
+		return nil, fmt.Errorf("%w: %q", ErrScheduleCondition, s.If)
+	}
+	return parseCron(s.Cron)
+}
+
+// conditionMet reports whether a door at position satisfies the schedule's If.
+func (s ScheduleConfig) conditionMet(position int) bool {
+	switch s.If {
+	case scheduleIfOpen:
+		return position > ddapi.PositionClosed
+	case scheduleIfClosed:
+		return position == ddapi.PositionClosed
+	}
+	return true
+}
+
+// scheduleCommand is a message on the schedule command topic: a schedule to add, or to
+// replace the one with the same name, or with Delete set the name of one to remove.
+type scheduleCommand struct {
+	ScheduleConfig
+	Delete bool `json:"delete"`
+}
+
+type scheduleEntry struct {
+	config ScheduleConfig
+	cron   *cronSchedule
+}
+
+// scheduler runs scheduled door actions. Schedules come from the config file and can be
+// changed at runtime over MQTT; runtime changes aren't saved back to the file.
+type scheduler struct {
+	prefix  string
+	devices *ddapi.DeviceRegistry
+
+	mu        sync.Mutex
+	schedules map[string]scheduleEntry
+	client    mqtt.Client // set once connected to MQTT
+
+	access *accessControl // nil allows every scheduled action
+
+	now     func() time.Time
+	execute func(ScheduleConfig) error // defaults to runSchedule; replaced in tests
+}
+
+func newScheduler(prefix string, devices *ddapi.DeviceRegistry) *scheduler {
+	s := &scheduler{
+		prefix:    prefix,
+		devices:   devices,
+		schedules: make(map[string]scheduleEntry),
+		now:       time.Now,
+	}
+	s.execute = s.runSchedule
+	return s
+}
+
+// set adds config, replacing any schedule with the same name.
+func (s *scheduler) set(config ScheduleConfig) error {
+	cron, err := config.validate()
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", config.Name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[config.Name] = scheduleEntry{config: config, cron: cron}
+	return nil
+}
+
+// replace swaps every schedule for configs, e.g. on reloading the config, and publishes
+// them. Nothing changes if any of configs is invalid.
+func (s *scheduler) replace(configs []ScheduleConfig) error {
+	schedules := make(map[string]scheduleEntry, len(configs))
+	for _, config := range configs {
+		cron, err := config.validate()
+		if err != nil {
+			return fmt.Errorf("schedule %s: %w", config.Name, err)
+		}
+		schedules[config.Name] = scheduleEntry{config: config, cron: cron}
+	}
+	s.mu.Lock()
+	s.schedules = schedules
+	s.mu.Unlock()
+	s.publish()
+	return nil
+}
+
+// remove deletes the named schedule, reporting whether it existed.
+func (s *scheduler) remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.schedules[name]
+	delete(s.schedules, name)
+	return ok
+}
+
+// list returns the schedules, sorted by name.
+func (s *scheduler) list() []ScheduleConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScheduleConfig, 0, len(s.schedules))
+	for _, entry := range s.schedules {
+		out = append(out, entry.config)
+	}
+	slices.SortFunc(out, func(a, b ScheduleConfig) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// run fires due schedules at the start of every minute until ctx is done.
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		now := s.now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+		s.tick(next)
+	}
+}
+
+// tick runs every enabled schedule due in the minute containing t.
+func (s *scheduler) tick(t time.Time) {
+	s.mu.Lock()
+	var due []ScheduleConfig
+	for _, entry := range s.schedules {
+		if !entry.config.Disabled && entry.cron.matches(t) {
+			due = append(due, entry.config)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, config := range due {
+		log := logger.WithFields(logrus.Fields{"schedule": config.Name, "deviceID": config.Device, "action": config.Action})
+		log.Info("Running scheduled action")
+		if err := authorize(s.access, commandRequest{Device: config.Device, Command: normalizeCommand(config.Action), Source: ddapi.SourceSchedule}); err != nil {
+			continue
+		}
+		if err := s.execute(config); err != nil {
+			log.WithError(err).Error("Scheduled action failed")
+		}
+	}
+}
+
+// subscribe publishes the schedules and subscribes to the schedule command topic, and its
+// per-user topics if the ACL needs them. It's called on every (re)connect to the broker.
+func (s *scheduler) subscribe(client mqtt.Client) {
+	s.mu.Lock()
+	s.client = client
+	s.mu.Unlock()
+	s.publish()
+
+	pattern := fmt.Sprintf(scheduleCommandTopicTemplate, s.prefix)
+	for _, topic := range s.access.userTopics(pattern) {
+		if !s.subscribeTopic(client, pattern, topic) {
+			return
+		}
+	}
+}
+
+// subscribeTopic subscribes to topic, a schedule command topic or a per-user topic of
+// pattern, reporting whether it succeeded.
+func (s *scheduler) subscribeTopic(client mqtt.Client, pattern, topic string) bool {
+	token := client.Subscribe(topic, 0, func(c mqtt.Client, msg mqtt.Message) {
+		logger.WithField("payload", string(msg.Payload())).WithField("topic", msg.Topic()).Info("processing mqtt schedule")
+		if readOnly {
+			rejectCommand(c, s.prefix, msg.Topic(), string(msg.Payload()))
+			return
+		}
+		_, user := splitUser(pattern, msg.Topic())
+		if err := authorize(s.access, commandRequest{Command: "schedules", Source: ddapi.SourceMQTT, User: user}); err != nil {
+			return
+		}
+		// Publishing waits on the client, so it mustn't block the message handler
+		go func() {
+			if err := s.handleCommand(msg.Payload()); err != nil {
+				logger.WithError(err).Error("Invalid schedule command")
+			}
+		}()
+	})
+	if !token.WaitTimeout(3 * time.Second) {
+		logger.WithField("topic", topic).Warn("Subscribe timed out; will retry on next reconnect")
+		return false
+	}
+	if err := token.Error(); err != nil {
+		logger.WithError(err).WithField("topic", topic).Warn("Subscribe failed; will retry on next reconnect")
+		return false
+	}
+	logger.WithField("topic", topic).Info("Subscribed to schedule topic")
+	return true
+}
+
+// handleCommand applies a scheduleCommand and publishes the resulting schedules.
+func (s *scheduler) handleCommand(payload []byte) error {
+	var cmd scheduleCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return fmt.Errorf("decode schedule: %w", err)
+	}
+	if cmd.Delete {
+		if !s.remove(cmd.Name) {
+			return fmt.Errorf("schedule %q not found", cmd.Name)
+		}
+	} else if err := s.set(cmd.ScheduleConfig); err != nil {
+		return err
+	}
+	s.publish()
+	return nil
+}
+
+// publish sends the schedules to the schedule topic, if connected to MQTT.
+func (s *scheduler) publish() {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if client == nil {
+		return
+	}
+
+	payload, err := json.Marshal(s.list())
+	if err != nil {
+		logger.WithError(err).Error("Failed to encode schedules")
+		return
+	}
+	topic := fmt.Sprintf(scheduleTopicTemplate, s.prefix)
+	token := client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(3*time.Second) || token.Error() != nil {
+		logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to publish schedules")
+	}
+}
+
+// runSchedule carries out a scheduled action, checking its condition against the hub's
+// current status first.
+func (s *scheduler) runSchedule(config ScheduleConfig) error {
+	deviceFSM, ok := s.devices.Get(config.Device)
+	if !ok {
+		return fmt.Errorf("%w: %s", ddapi.ErrDeviceNotFound, config.Device)
+	}
+	status, err := ddapi.SafeFetchStatus(deviceFSM.Conn)
+	if err != nil {
+		return fmt.Errorf("fetch status: %w", err)
+	}
+	i := slices.IndexFunc(status.Devices, func(d ddapi.DoorStatusDevice) bool { return d.ID == config.Device })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ddapi.ErrDeviceNotFound, config.Device)
+	}
+	if position := status.Devices[i].Device.Position; !config.conditionMet(position) {
+		logger.WithFields(logrus.Fields{"schedule": config.Name, "position": position}).Info("Skipping scheduled action; door is not " + config.If)
+		return nil
+	}
+	if !checkBasestationOnline(deviceFSM.Conn, config.Device) {
+		return nil
+	}
+
+	switch config.Action {
+	case ddapi.QueueOpen, ddapi.QueueClose, ddapi.QueueStop:
+		deviceFSM.Queue().Submit(ddapi.QueuedCommand{Action: config.Action, Source: ddapi.SourceSchedule})
+	case ddapi.QueuePosition:
+		deviceFSM.Queue().Submit(ddapi.QueuedCommand{Action: ddapi.QueuePosition, Position: config.Position, Source: ddapi.SourceSchedule})
+	default:
+		registry := ddapi.ParseCommandsFromButtons(status)
+		command, err := ddapi.GetCommandForDevice(config.Device, config.Action, registry)
+		if err != nil {
+			return err
+		}
+		return ddapi.SafeCommand(deviceFSM.Conn, config.Device, command)
+	}
+	return nil
+}
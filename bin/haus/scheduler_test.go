@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+func TestScheduleConfig_Validate(t *testing.T) {
+	valid := ScheduleConfig{Name: "night", Cron: "0 22 * * *", Device: "door1", Action: "close", If: "open"}
+	tests := []struct {
+		name    string
+		modify  func(*ScheduleConfig)
+		wantErr error
+	}{
+		{"valid", func(*ScheduleConfig) {}, nil},
+		{"no name", func(s *ScheduleConfig) { s.Name = "" }, ErrScheduleName},
+		{"no device", func(s *ScheduleConfig) { s.Device = "" }, ErrScheduleDevice},
+		{"no action", func(s *ScheduleConfig) { s.Action = "" }, ErrScheduleAction},
+		{"bad condition", func(s *ScheduleConfig) { s.If = "ajar" }, ErrScheduleCondition},
+		{"bad position", func(s *ScheduleConfig) { s.Action, s.Position = "position", 101 }, ErrSchedulePosition},
+		{"bad cron", func(s *ScheduleConfig) { s.Cron = "22:00" }, ErrInvalidCron},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.modify(&s)
+			if _, err := s.validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleConfig_ConditionMet(t *testing.T) {
+	tests := []struct {
+		condition string
+		position  int
+		want      bool
+	}{
+		{"", 0, true},
+		{"", 100, true},
+		{"open", 0, false},
+		{"open", 30, true},
+		{"open", 100, true},
+		{"closed", 0, true},
+		{"closed", 30, false},
+	}
+	for _, tt := range tests {
+		if got := (ScheduleConfig{If: tt.condition}).conditionMet(tt.position); got != tt.want {
+			t.Errorf("conditionMet(%d) with if %q = %v, want %v", tt.position, tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestScheduler_Tick(t *testing.T) {
+	s := newScheduler("dd-door", ddapi.NewDeviceRegistry())
+	var ran []string
+	s.execute = func(config ScheduleConfig) error {
+		ran = append(ran, config.Name)
+		return nil
+	}
+	for _, config := range []ScheduleConfig{
+		{Name: "night", Cron: "0 22 * * *", Device: "door1", Action: "close"},
+		{Name: "pet", Cron: "0 7 * * *", Device: "door1", Action: "pet_open"},
+		{Name: "off", Cron: "0 22 * * *", Device: "door1", Action: "close", Disabled: true},
+	} {
+		if err := s.set(config); err != nil {
+			t.Fatalf("set(%s) error = %v", config.Name, err)
+		}
+	}
+
+	s.tick(time.Date(2024, time.June, 3, 22, 0, 0, 0, time.Local))
+	if !slices.Equal(ran, []string{"night"}) {
+		t.Errorf("tick(22:00) ran %v, want [night]", ran)
+	}
+}
+
+func TestScheduler_TickACL(t *testing.T) {
+	s := newScheduler("dd-door", ddapi.NewDeviceRegistry())
+	access, err := newAccessControl([]ACLRule{{Commands: []string{"open"}, Sources: []string{"mqtt"}}}, "dd-door", ddapi.NewDeviceRegistry())
+	if err != nil {
+		t.Fatalf("newAccessControl() error = %v", err)
+	}
+	s.access = access
+	var ran []string
+	s.execute = func(config ScheduleConfig) error {
+		ran = append(ran, config.Name)
+		return nil
+	}
+	for _, config := range []ScheduleConfig{
+		{Name: "morning", Cron: "0 7 * * *", Device: "door1", Action: "open"},
+		{Name: "pet", Cron: "0 7 * * *", Device: "door1", Action: "Pet Open"},
+	} {
+		if err := s.set(config); err != nil {
+			t.Fatalf("set(%s) error = %v", config.Name, err)
+		}
+	}
+
+	s.tick(time.Date(2024, time.June, 3, 7, 0, 0, 0, time.Local))
+	if !slices.Equal(ran, []string{"pet"}) {
+		t.Errorf("tick(07:00) ran %v, want [pet], the ACL only allowing open from mqtt", ran)
+	}
+}
+
+func TestScheduler_HandleCommand(t *testing.T) {
+	s := newScheduler("dd-door", ddapi.NewDeviceRegistry())
+	if err := s.set(ScheduleConfig{Name: "night", Cron: "0 22 * * *", Device: "door1", Action: "close"}); err != nil {
+		t.Fatalf("set() error = %v", err)
+	}
+
+	// Replace by name, then add another
+	if err := s.handleCommand([]byte(`{"name":"night","cron":"30 22 * * *","device":"door1","action":"close","if":"open"}`)); err != nil {
+		t.Fatalf("handleCommand(replace) error = %v", err)
+	}
+	if err := s.handleCommand([]byte(`{"name":"morning","cron":"0 7 * * 1-5","device":"door1","action":"position","position":20}`)); err != nil {
+		t.Fatalf("handleCommand(add) error = %v", err)
+	}
+	got := s.list()
+	want := []ScheduleConfig{
+		{Name: "morning", Cron: "0 7 * * 1-5", Device: "door1", Action: "position", Position: 20},
+		{Name: "night", Cron: "30 22 * * *", Device: "door1", Action: "close", If: "open"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("list() = %+v, want %+v", got, want)
+	}
+
+	if err := s.handleCommand([]byte(`{"name":"night","delete":true}`)); err != nil {
+		t.Fatalf("handleCommand(delete) error = %v", err)
+	}
+	if got := s.list(); len(got) != 1 || got[0].Name != "morning" {
+		t.Errorf("list() after delete = %+v, want only morning", got)
+	}
+
+	for _, payload := range []string{`not json`, `{"name":"night","delete":true}`, `{"name":"bad","cron":"daily","device":"door1","action":"open"}`} {
+		if err := s.handleCommand([]byte(payload)); err == nil {
+			t.Errorf("handleCommand(%s) error = nil, want error", payload)
+		}
+	}
+	if got := s.list(); len(got) != 1 {
+		t.Errorf("list() after invalid commands = %+v, want unchanged", got)
+	}
+}
+
+func TestScheduler_RunUnknownDevice(t *testing.T) {
+	s := newScheduler("dd-door", ddapi.NewDeviceRegistry())
+	err := s.runSchedule(ScheduleConfig{Name: "night", Device: "door1", Action: "close"})
+	if !errors.Is(err, ddapi.ErrDeviceNotFound) {
+		t.Errorf("runSchedule() error = %v, want ErrDeviceNotFound", err)
+	}
+}
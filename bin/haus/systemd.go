@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notifications sent to systemd; see sd_notify(3)
+const (
+	sdReady    = "READY=1"
+	sdStopping = "STOPPING=1"
+	sdWatchdog = "WATCHDOG=1"
+)
+
+// sdNotify sends state to systemd's notification socket, as sd_notify(3) does, for a
+// service with Type=notify. It does nothing when NOTIFY_SOCKET isn't set, i.e. outside
+// systemd.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// A leading @ is an abstract socket
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often systemd expects a WATCHDOG=1 (WatchdogSec=), or 0
+// if its watchdog isn't watching this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog pings systemd's watchdog at half interval while healthy reports true, until
+// ctx is done. Pings stop while haus is unhealthy, so systemd restarts it once that has
+// lasted the whole interval.
+func runWatchdog(ctx context.Context, interval time.Duration, healthy func() bool) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	wasHealthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !healthy() {
+			if wasHealthy {
+				logger.WithField("interval", interval).Warn("Unhealthy; holding back systemd watchdog pings")
+			}
+			wasHealthy = false
+			continue
+		}
+		if !wasHealthy {
+			logger.Info("Healthy again; resuming systemd watchdog pings")
+		}
+		wasHealthy = true
+		if err := sdNotify(sdWatchdog); err != nil {
+			logger.WithError(err).Warn("Failed to ping systemd watchdog")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenNotify sets NOTIFY_SOCKET to a socket for the test and returns it.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify returns the next notification sent to conn, or "" if none arrives in time.
+func readNotify(t *testing.T, conn *net.UnixConn, timeout time.Duration) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	b := make([]byte, 256)
+	n, err := conn.Read(b)
+	if err != nil {
+		return ""
+	}
+	return string(b[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify(sdReady); err != nil {
+		t.Errorf("sdNotify() outside systemd error = %v, want nil", err)
+	}
+
+	conn := listenNotify(t)
+	if err := sdNotify(sdReady); err != nil {
+		t.Fatalf("sdNotify() error = %v", err)
+	}
+	if got := readNotify(t, conn, time.Second); got != sdReady {
+		t.Errorf("notification = %q, want %q", got, sdReady)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		{"30000000", "1", 0}, // another process's watchdog
+		{"soon", "", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := watchdogInterval(); got != tt.want {
+			t.Errorf("watchdogInterval() with WATCHDOG_USEC=%q WATCHDOG_PID=%q = %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := listenNotify(t)
+	var healthy atomic.Bool
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runWatchdog(ctx, 40*time.Millisecond, healthy.Load)
+
+	// No pings while unhealthy
+	if got := readNotify(t, conn, 100*time.Millisecond); got != "" {
+		t.Errorf("notification while unhealthy = %q, want none", got)
+	}
+	healthy.Store(true)
+	if got := readNotify(t, conn, time.Second); got != sdWatchdog {
+		t.Errorf("notification while healthy = %q, want %q", got, sdWatchdog)
+	}
+}
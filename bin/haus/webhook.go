@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// Events a webhook can be sent for
+const (
+	webhookDoorOpened   = "door_opened"    // door left fully closed
+	webhookDoorLeftOpen = "door_left_open" // door still open after the webhook's OpenFor
+	webhookObstruction  = "obstruction"    // door reported obstructed
+	webhookHubOffline   = "hub_offline"    // lost the hub, or it stopped answering health checks
+)
+
+var webhookEvents = []string{webhookDoorOpened, webhookDoorLeftOpen, webhookObstruction, webhookHubOffline}
+
+// Defaults for unset WebhookConfig fields
+const (
+	defaultWebhookOpenFor = 10 // minutes
+	defaultWebhookRetries = 3
+)
+
+var (
+	// ErrWebhookURL is returned for a webhook without an http or https URL.
+	ErrWebhookURL = errors.New("webhook url must be http or https")
+	// ErrWebhookEvent is returned for a webhook listening for an unknown event.
+	ErrWebhookEvent = errors.New("unknown webhook event")
+)
+
+// webhookTimeout bounds each attempt to deliver a webhook
+var webhookTimeout = 10 * time.Second
+
+// WebhookConfig posts a JSON payload to URL when one of Events happens, for alerts
+// (Slack and the like) that don't need Home Assistant. With Telegram or Pushover set it
+// sends a message through that service instead of posting to URL.
+type WebhookConfig struct {
+	URL      string          `yaml:"url"`
+	Telegram *TelegramConfig `yaml:"telegram"`
+	Pushover *PushoverConfig `yaml:"pushover"`
+
+	Events  []string `yaml:"events"`  // see webhookEvents; empty is every event
+	Devices []string `yaml:"devices"` // device IDs; empty is every device
+
+	// OpenFor is how many minutes a door must stay open for door_left_open (default 10)
+	OpenFor int `yaml:"openFor"`
+
+	// Template, if set, is a text/template for the body, given a webhookEvent; e.g.
+	// {"text": {{json .Message}}} for Slack. By default the event itself is sent as JSON,
+	// or for Telegram and Pushover its Message. For those the template gives the message.
+	Template string            `yaml:"template"`
+	Headers  map[string]string `yaml:"headers"` // for URL
+
+	// Retries is how many times a failed delivery is retried (default 3; -1 for none)
+	Retries int `yaml:"retries"`
+}
+
+// webhookEvent is the payload of a webhook, and what its template is given.
+type webhookEvent struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"` // a sentence describing the event
+	Hub      string    `json:"hub,omitempty"`
+	DeviceID string    `json:"deviceId,omitempty"`
+	Device   string    `json:"device,omitempty"` // the device's name
+	Position *int      `json:"position,omitempty"`
+	OpenFor  int       `json:"openFor,omitempty"` // minutes, for door_left_open
+	Reason   string    `json:"reason,omitempty"`  // for obstruction
+	Log      string    `json:"log,omitempty"`     // the device's latest log entry
+}
+
+// webhook is a validated WebhookConfig.
+type webhook struct {
+	config   WebhookConfig
+	template *template.Template
+}
+
+var webhookFuncs = template.FuncMap{
+	// json encodes a value, e.g. to quote a string inside a JSON template
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// newWebhook validates c and fills in its defaults.
+func newWebhook(c WebhookConfig) (*webhook, error) {
+	switch {
+	case c.Telegram != nil:
+		if err := c.Telegram.validate(); err != nil {
+			return nil, err
+		}
+	case c.Pushover != nil:
+		if err := c.Pushover.validate(); err != nil {
+			return nil, err
+		}
+	case !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://"):
+		return nil, fmt.Errorf("%w: %q", ErrWebhookURL, c.URL)
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return nil, fmt.Errorf("%w: %q", ErrWebhookEvent, event)
+		}
+	}
+	if c.OpenFor <= 0 {
+		c.OpenFor = defaultWebhookOpenFor
+	}
+	if c.Retries == 0 {
+		c.Retries = defaultWebhookRetries
+	}
+	w := &webhook{config: c}
+	if c.Template != "" {
+		t, err := template.New(w.name()).Funcs(webhookFuncs).Parse(c.Template)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s template: %w", w.name(), err)
+		}
+		w.template = t
+	}
+	return w, nil
+}
+
+// name identifies the webhook in logs, without its tokens.
+func (w *webhook) name() string {
+	switch {
+	case w.config.Telegram != nil:
+		return "telegram"
+	case w.config.Pushover != nil:
+		return "pushover"
+	}
+	return w.config.URL
+}
+
+// wants reports whether the webhook is sent for event on deviceID ("" for hub events).
+func (w *webhook) wants(event, deviceID string) bool {
+	if len(w.config.Events) > 0 && !slices.Contains(w.config.Events, event) {
+		return false
+	}
+	return deviceID == "" || len(w.config.Devices) == 0 || slices.Contains(w.config.Devices, deviceID)
+}
+
+// body renders the payload for e.
+func (w *webhook) body(e webhookEvent) ([]byte, error) {
+	if w.template == nil {
+		if w.config.Telegram != nil || w.config.Pushover != nil {
+			return []byte(e.Message), nil
+		}
+		return json.Marshal(e)
+	}
+	var b bytes.Buffer
+	if err := w.template.Execute(&b, e); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// request returns the request delivering e, rendered as body.
+func (w *webhook) request(ctx context.Context, e webhookEvent, body []byte) (*http.Request, error) {
+	switch {
+	case w.config.Telegram != nil:
+		return w.config.Telegram.request(ctx, body)
+	case w.config.Pushover != nil:
+		return w.config.Pushover.request(ctx, e.Device, body)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// notifier sends webhooks for door and hub events seen in status updates. Deliveries run
+// in the background, retrying with backoff.
+type notifier struct {
+	hooks  []*webhook
+	client *http.Client
+	now    func() time.Time
+
+	mu sync.Mutex
+	// closed is whether each device was last seen fully closed
+	closed map[string]bool
+	// openTimers fire door_left_open, by device ID and then webhook
+	openTimers map[string][]*time.Timer
+
+	retryBase time.Duration
+	wg        sync.WaitGroup // deliveries in flight
+}
+
+// newNotifier validates configs, returning nil if there are none.
+func newNotifier(configs []WebhookConfig) (*notifier, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	n := &notifier{
+		client:     &http.Client{Timeout: webhookTimeout},
+		now:        time.Now,
+		closed:     make(map[string]bool),
+		openTimers: make(map[string][]*time.Timer),
+		retryBase:  time.Second,
+	}
+	for _, c := range configs {
+		w, err := newWebhook(c)
+		if err != nil {
+			return nil, err
+		}
+		n.hooks = append(n.hooks, w)
+	}
+	return n, nil
+}
+
+// update looks for doors opening in a status update from h. The first update for a
+// device only records where it is.
+func (n *notifier) update(h *hub, status ddapi.DoorStatus) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, device := range status.Devices {
+		closed := device.Device.Position == ddapi.PositionClosed
+		wasClosed, seen := n.closed[device.ID]
+		n.closed[device.ID] = closed
+		switch {
+		case closed:
+			n.stopOpenTimers(device.ID)
+		case !seen || wasClosed:
+			if seen {
+				n.send(n.deviceEvent(h, device, webhookDoorOpened, "%s opened"))
+			}
+			n.startOpenTimers(h, device)
+		}
+	}
+}
+
+// startOpenTimers arms door_left_open for a device that just opened. n.mu must be held.
+func (n *notifier) startOpenTimers(h *hub, device ddapi.DoorStatusDevice) {
+	n.stopOpenTimers(device.ID)
+	for _, w := range n.hooks {
+		if !w.wants(webhookDoorLeftOpen, device.ID) {
+			continue
+		}
+		openFor := w.config.OpenFor
+		n.openTimers[device.ID] = append(n.openTimers[device.ID], time.AfterFunc(time.Duration(openFor)*time.Minute, func() {
+			e := n.deviceEvent(h, device, webhookDoorLeftOpen, fmt.Sprintf("%%s has been open for %d minutes", openFor))
+			e.OpenFor = openFor
+			n.deliver(w, e)
+		}))
+	}
+}
+
+// stopOpenTimers cancels door_left_open for a device. n.mu must be held.
+func (n *notifier) stopOpenTimers(deviceID string) {
+	for _, t := range n.openTimers[deviceID] {
+		t.Stop()
+	}
+	delete(n.openTimers, deviceID)
+}
+
+// obstruction sends obstruction for a device.
+func (n *notifier) obstruction(h *hub, device ddapi.DoorStatusDevice, obstruction *ddapi.Obstruction) {
+	e := n.deviceEvent(h, device, webhookObstruction, "%s is obstructed")
+	e.Reason = obstruction.Reason
+	n.send(e)
+}
+
+// hubOffline sends hub_offline for h.
+func (n *notifier) hubOffline(h *hub) {
+	message := "Lost the hub"
+	if h.name != "" {
+		message = fmt.Sprintf("Lost hub %s", h.name)
+	}
+	n.send(webhookEvent{Event: webhookHubOffline, Time: n.now(), Message: message, Hub: h.name})
+}
+
+// deviceEvent returns an event for device; message is a format for the device's name.
+func (n *notifier) deviceEvent(h *hub, device ddapi.DoorStatusDevice, event, message string) webhookEvent {
+	name := device.Name
+	if name == "" {
+		name = device.ID
+	}
+	position := device.Device.Position
+	return webhookEvent{
+		Event:    event,
+		Time:     n.now(),
+		Message:  fmt.Sprintf(message, name),
+		Hub:      h.name,
+		DeviceID: device.ID,
+		Device:   device.Name,
+		Position: &position,
+		Log:      device.Log.Text,
+	}
+}
+
+// send delivers e to every webhook that wants it.
+func (n *notifier) send(e webhookEvent) {
+	for _, w := range n.hooks {
+		if w.wants(e.Event, e.DeviceID) {
+			n.deliver(w, e)
+		}
+	}
+}
+
+// deliver posts e to w in the background, retrying failures.
+func (n *notifier) deliver(w *webhook, e webhookEvent) {
+	log := logger.WithField("webhook", w.name()).WithField("event", e.Event)
+	body, err := w.body(e)
+	if err != nil {
+		log.WithError(err).Error("Failed to render webhook")
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		backoff := dd.Backoff{Base: n.retryBase, Max: time.Minute, Jitter: dd.DefaultBackoffJitter}
+		for attempt := 0; ; attempt++ {
+			err := n.post(w, e, body)
+			if err == nil {
+				log.Debug("Sent webhook")
+				return
+			}
+			if attempt >= w.config.Retries {
+				log.WithError(err).Error("Failed to send webhook")
+				return
+			}
+			delay := backoff.Next()
+			log.WithError(err).WithField("retryIn", delay).Warn("Failed to send webhook; retrying")
+			time.Sleep(delay)
+		}
+	}()
+}
+
+// post makes one attempt to deliver e, rendered as body, to w.
+func (n *notifier) post(w *webhook, e webhookEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := w.request(ctx, e, body)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Telegram's URL holds the bot token, so leave it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// webhookServer records the bodies posted to it, failing the first failures requests.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	failures int
+}
+
+func newWebhookServer(t *testing.T, failures int) *webhookServer {
+	s := &webhookServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		s.bodies = append(s.bodies, string(b))
+		s.headers = append(s.headers, r.Header)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func newTestNotifier(t *testing.T, configs ...WebhookConfig) *notifier {
+	n, err := newNotifier(configs)
+	if err != nil {
+		t.Fatalf("newNotifier() error = %v", err)
+	}
+	n.retryBase = time.Millisecond
+	n.now = func() time.Time { return time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC) }
+	return n
+}
+
+func statusAt(position int) ddapi.DoorStatus {
+	device := ddapi.DoorStatusDevice{ID: "door1", Name: "Garage"}
+	device.Device.Position = position
+	return ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{device}}
+}
+
+func TestNewWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  WebhookConfig
+		wantErr error
+	}{
+		{"valid", WebhookConfig{URL: "https://example.com/hook", Events: []string{"door_opened"}}, nil},
+		{"no url", WebhookConfig{}, ErrWebhookURL},
+		{"not http", WebhookConfig{URL: "ftp://example.com"}, ErrWebhookURL},
+		{"bad event", WebhookConfig{URL: "https://example.com", Events: []string{"door_exploded"}}, ErrWebhookEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newWebhook(tt.config); !errors.Is(err, tt.wantErr) {
+				t.Errorf("newWebhook() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := newWebhook(WebhookConfig{URL: "https://example.com", Template: "{{"}); err == nil {
+		t.Errorf("newWebhook() with a bad template error = nil")
+	}
+}
+
+func TestNotifier_DoorOpened(t *testing.T) {
+	server := newWebhookServer(t, 1)
+	n := newTestNotifier(t, WebhookConfig{
+		URL:     server.URL,
+		Events:  []string{webhookDoorOpened},
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	})
+	h := &hub{name: "home"}
+
+	n.update(h, statusAt(0)) // first update only records the position
+	n.update(h, statusAt(40))
+	n.update(h, statusAt(100)) // still open
+	n.wg.Wait()
+
+	bodies := server.received()
+	if len(bodies) != 1 {
+		t.Fatalf("received %d webhooks, want 1 (after a retry): %v", len(bodies), bodies)
+	}
+	var e webhookEvent
+	if err := json.Unmarshal([]byte(bodies[0]), &e); err != nil {
+		t.Fatalf("webhook body is not an event: %v", err)
+	}
+	if e.Event != webhookDoorOpened || e.DeviceID != "door1" || e.Hub != "home" || e.Message != "Garage opened" || *e.Position != 40 {
+		t.Errorf("webhook = %+v, want door1 opened to 40", e)
+	}
+	if got := server.headers[0].Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %q, want the configured header", got)
+	}
+}
+
+func TestNotifier_Template(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	n := newTestNotifier(t, WebhookConfig{URL: server.URL, Template: `{"text": {{json .Message}}}`})
+
+	n.hubOffline(&hub{name: "home"})
+	n.wg.Wait()
+
+	if bodies := server.received(); len(bodies) != 1 || bodies[0] != `{"text": "Lost hub home"}` {
+		t.Errorf("received %v, want the rendered template", bodies)
+	}
+}
+
+func TestNotifier_Filters(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	n := newTestNotifier(t, WebhookConfig{URL: server.URL, Events: []string{webhookObstruction}, Devices: []string{"door2"}})
+	h := &hub{}
+
+	n.update(h, statusAt(0))
+	n.update(h, statusAt(100))
+	device := statusAt(50).Devices[0]
+	n.obstruction(h, device, &ddapi.Obstruction{DeviceID: "door1", Reason: ddapi.ObstructionReversed})
+	device.ID = "door2"
+	n.obstruction(h, device, &ddapi.Obstruction{DeviceID: "door2", Reason: ddapi.ObstructionReversed})
+	n.wg.Wait()
+
+	bodies := server.received()
+	if len(bodies) != 1 {
+		t.Fatalf("received %v, want door2's obstruction only", bodies)
+	}
+	var e webhookEvent
+	if err := json.Unmarshal([]byte(bodies[0]), &e); err != nil || e.DeviceID != "door2" || e.Reason != ddapi.ObstructionReversed {
+		t.Errorf("webhook = %s, want door2 reversed", bodies[0])
+	}
+}
+
+func TestNotifier_LeftOpenCancelledByClosing(t *testing.T) {
+	n := newTestNotifier(t, WebhookConfig{URL: "http://127.0.0.1:0", Events: []string{webhookDoorLeftOpen}})
+	h := &hub{}
+
+	n.update(h, statusAt(100))
+	n.mu.Lock()
+	armed := len(n.openTimers["door1"])
+	n.mu.Unlock()
+	if armed != 1 {
+		t.Fatalf("%d door_left_open timers armed, want 1", armed)
+	}
+
+	n.update(h, statusAt(0))
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.openTimers["door1"]) != 0 {
+		t.Errorf("door_left_open timer still armed after closing")
+	}
+}
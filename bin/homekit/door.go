@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// commandTimeout bounds a command sent in response to a HomeKit target change.
+const commandTimeout = 30 * time.Second
+
+// currentDoorState maps a position report to HomeKit's CurrentDoorState. A door between
+// the ends is opening or closing if it moved since the previous report, else stopped.
+func currentDoorState(position, previous int) int {
+	switch {
+	case position >= ddapi.PositionOpen:
+		return characteristic.CurrentDoorStateOpen
+	case position <= ddapi.PositionClosed:
+		return characteristic.CurrentDoorStateClosed
+	case position > previous:
+		return characteristic.CurrentDoorStateOpening
+	case position < previous:
+		return characteristic.CurrentDoorStateClosing
+	}
+	return characteristic.CurrentDoorStateStopped
+}
+
+// targetDoorState returns the TargetDoorState implied by current, or false if a stopped
+// door leaves the target as it was.
+func targetDoorState(current int) (int, bool) {
+	switch current {
+	case characteristic.CurrentDoorStateOpen, characteristic.CurrentDoorStateOpening:
+		return characteristic.TargetDoorStateOpen, true
+	case characteristic.CurrentDoorStateClosed, characteristic.CurrentDoorStateClosing:
+		return characteristic.TargetDoorStateClosed, true
+	}
+	return 0, false
+}
+
+// accessoryID returns a stable HomeKit accessory ID for a device, so pairings keep their
+// rooms and automations across restarts whatever order the hub lists devices in. ID 1 is
+// the bridge's.
+func accessoryID(deviceID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(deviceID))
+	id := h.Sum64()
+	if id <= 1 {
+		id += 2
+	}
+	return id
+}
+
+// doorAccessory is a GarageDoorOpener accessory for one device.
+type doorAccessory struct {
+	*accessory.GarageDoorOpener
+	door *ddapi.Door
+}
+
+func newDoorAccessory(door *ddapi.Door, device ddapi.DoorStatusDevice) *doorAccessory {
+	a := &doorAccessory{
+		GarageDoorOpener: accessory.NewGarageDoorOpener(accessory.Info{
+			Name:         device.Name,
+			SerialNumber: device.ID,
+			Manufacturer: "Dominator",
+			Model:        "Garage Door",
+		}),
+		door: door,
+	}
+	a.Id = accessoryID(device.ID)
+	a.update(device.Device.Position, device.Device.Position)
+	a.GarageDoorOpener.GarageDoorOpener.TargetDoorState.OnValueRemoteUpdate(a.setTarget)
+	return a
+}
+
+// update sets the accessory's state from a position report and the one before it.
+func (a *doorAccessory) update(position, previous int) {
+	service := a.GarageDoorOpener.GarageDoorOpener
+	current := currentDoorState(position, previous)
+	service.CurrentDoorState.SetValue(current)
+	if target, ok := targetDoorState(current); ok {
+		service.TargetDoorState.SetValue(target)
+	}
+}
+
+// setTarget sends the command for a target state set from HomeKit. The status stream
+// reports the door's progress back.
+func (a *doorAccessory) setTarget(target int) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		defer cancel()
+
+		var err error
+		if target == characteristic.TargetDoorStateOpen {
+			err = a.door.Open(ctx)
+		} else {
+			err = a.door.Close(ctx)
+		}
+		if err != nil {
+			log.Printf("device %s: command failed: %v", a.door.ID(), err)
+		}
+	}()
+}
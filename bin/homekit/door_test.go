@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/brutella/hap/characteristic"
+)
+
+func TestCurrentDoorState(t *testing.T) {
+	tests := []struct {
+		name               string
+		position, previous int
+		want               int
+	}{
+		{"open", 100, 90, characteristic.CurrentDoorStateOpen},
+		{"closed", 0, 10, characteristic.CurrentDoorStateClosed},
+		{"opening", 40, 20, characteristic.CurrentDoorStateOpening},
+		{"closing", 40, 60, characteristic.CurrentDoorStateClosing},
+		{"stopped", 40, 40, characteristic.CurrentDoorStateStopped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := currentDoorState(tt.position, tt.previous); got != tt.want {
+				t.Errorf("currentDoorState(%d, %d) = %d, want %d", tt.position, tt.previous, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetDoorState(t *testing.T) {
+	tests := []struct {
+		current int
+		want    int
+		wantOK  bool
+	}{
+		{characteristic.CurrentDoorStateOpen, characteristic.TargetDoorStateOpen, true},
+		{characteristic.CurrentDoorStateOpening, characteristic.TargetDoorStateOpen, true},
+		{characteristic.CurrentDoorStateClosed, characteristic.TargetDoorStateClosed, true},
+		{characteristic.CurrentDoorStateClosing, characteristic.TargetDoorStateClosed, true},
+		{characteristic.CurrentDoorStateStopped, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := targetDoorState(tt.current)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("targetDoorState(%d) = %d, %v, want %d, %v", tt.current, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestAccessoryID(t *testing.T) {
+	if accessoryID("abc") != accessoryID("abc") {
+		t.Error("accessoryID() is not stable")
+	}
+	if accessoryID("abc") == accessoryID("abd") {
+		t.Error("accessoryID() collides for different devices")
+	}
+	if id := accessoryID("abc"); id <= 1 {
+		t.Errorf("accessoryID() = %d, want > 1", id)
+	}
+}
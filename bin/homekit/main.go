@@ -0,0 +1,126 @@
+// Command homekit bridges a hub's doors to Apple HomeKit, exposing each one as a
+// GarageDoorOpener accessory whose state follows the hub's status stream.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/accessory"
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+)
+
+var (
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagName             = flag.String("name", "Garage", "name of the HomeKit bridge")
+	flagPin              = flag.String("pin", "00102003", "8 digit HomeKit pairing code")
+	flagAddr             = flag.String("addr", "", "address for the HomeKit server to listen on (default a random port)")
+	flagStore            = flag.String("store", "homekit", "directory to keep HomeKit pairings in")
+	flagDryRun           = flag.Bool("dryRun", false, "log door commands instead of sending them to the hub")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
+)
+
+// reconnectInterval is how long to wait between attempts to reconnect to the hub.
+const reconnectInterval = 10 * time.Second
+
+func main() {
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
+	if *flagDryRun {
+		ddapi.EnableDryRun(nil)
+		log.Printf("dry run: door commands will be logged, not sent to the hub")
+	}
+
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		log.Fatalf("can't open credentials file: %v %v", *flagCredentialsPath, err)
+	}
+
+	conn := &dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+	if err := conn.Connect(creds.Credential); err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+
+	devices, err := ddapi.ListDevices(conn)
+	if err != nil {
+		log.Fatalf("could not list devices: %v", err)
+	}
+	if len(devices) == 0 {
+		log.Fatalf("no devices to bridge")
+	}
+
+	doors := make(map[string]*doorAccessory, len(devices))
+	accessories := make([]*accessory.A, 0, len(devices))
+	for _, device := range devices {
+		door := newDoorAccessory(ddapi.NewDoor(conn, device.ID), device)
+		doors[device.ID] = door
+		accessories = append(accessories, door.A)
+		log.Printf("bridging %q (%s)", device.Name, device.ID)
+	}
+
+	bridge := accessory.NewBridge(accessory.Info{Name: *flagName, Manufacturer: "Dominator"})
+	server, err := hap.NewServer(hap.NewFsStore(*flagStore), bridge.A, accessories...)
+	if err != nil {
+		log.Fatalf("could not create HomeKit server: %v", err)
+	}
+	server.Pin = *flagPin
+	server.Addr = *flagAddr
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go followStatus(ctx, conn, creds.Credential, doors)
+
+	log.Printf("HomeKit bridge %q ready; pair with code %s", *flagName, *flagPin)
+	if err := server.ListenAndServe(ctx); err != nil && ctx.Err() == nil {
+		log.Fatalf("HomeKit server stopped: %v", err)
+	}
+}
+
+// followStatus updates doors from the hub's status stream until ctx is done, reconnecting
+// whenever the connection is lost.
+func followStatus(ctx context.Context, conn *dd.Conn, cred dd.Credential, doors map[string]*doorAccessory) {
+	for {
+		stream := ddapi.NewEventStream(conn)
+		done := make(chan error, 1)
+		go func() { done <- stream.Run(ctx) }()
+
+		for event := range stream.Events() {
+			if e, ok := event.(ddapi.PositionChanged); ok {
+				if door, ok := doors[e.DeviceID]; ok {
+					door.update(e.Position, e.Previous)
+				}
+			}
+		}
+		err := <-done
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("lost connection to hub: %v", err)
+
+		for {
+			select {
+			case <-time.After(reconnectInterval):
+			case <-ctx.Done():
+				return
+			}
+			if err := conn.Connect(cred); err != nil {
+				log.Printf("failed to reconnect: %v", err)
+				continue
+			}
+			break
+		}
+	}
+}
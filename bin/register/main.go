@@ -3,30 +3,62 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 
 	"github.com/gravypower/dd"
 	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
 )
 
 var (
-	flagCredentialsPath = flag.String("credentials", "dd-credentials.json", "path to credentials file")
-	flagShareCode       = flag.String("code", "", "share code")
-	flagPassword        = flag.String("password", "", "password")
-	flagPhoneInfo       = flag.String("phone", "API", "phone info to report")
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagShareCode        = flag.String("code", "", "share code")
+	flagPassword         = flag.String("password", "", "password")
+	flagPhoneInfo        = flag.String("phone", "API", "phone info to report")
+	flagRemoteHost       = flag.String("remote-host", dd.RemoteAPIBase, "cloud API host to register against")
+	flagDryRun           = flag.Bool("dry-run", false, "register and print the response to stdout without saving credentials")
+	flagEncrypt          = flag.Bool("encrypt", false, "encrypt the credentials file with a passphrase from -keyFile, $"+helper.KeyFileEnv+" or $"+helper.PassphraseEnv)
+	flagKeyFile          = flag.String("keyFile", "", "file holding the passphrase for -encrypt, or for -rotate of an encrypted file")
+	flagRotate           = flag.Bool("rotate", false, "rotate the phone secret of the saved credentials, updating them in place")
+	flagNewPassword      = flag.String("newPassword", "", "with -rotate, also change the user password to this")
+	flagHost             = flag.String("host", "", "hub to connect to for -rotate")
+	flagPort             = flag.Int("port", 0, "encrypted API port for -rotate (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port for -rotate (default 8991)")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
 )
 
+func init() {
+	// The other commands spell it -dryRun
+	flag.BoolVar(flagDryRun, "dryRun", false, "same as -dry-run")
+	// haus spells it -remoteHost
+	flag.StringVar(flagRemoteHost, "remoteHost", dd.RemoteAPIBase, "same as -remote-host")
+}
+
 func main() {
-	flag.Parse()
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
 
-	if *flagShareCode == "" || *flagPassword == "" {
-		log.Fatalf("must specify -code and -password")
+	if *flagRotate {
+		var passphrase []byte
+		if *flagKeyFile != "" {
+			var err error
+			if passphrase, err = helper.ReadKeyFile(*flagKeyFile); err != nil {
+				log.Fatalf("can't read passphrase: %v", err)
+			}
+		}
+		conn := &dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+		if err := rotate(conn, *flagCredentialsPath, passphrase, *flagNewPassword, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	f, err := os.Create(*flagCredentialsPath)
-	if err != nil {
-		log.Fatalf("can't create credentials file: %v %v", *flagCredentialsPath, err)
+	if *flagShareCode == "" || *flagPassword == "" {
+		log.Fatalf("must specify -code and -password")
 	}
 
 	req := ddapi.RegisterRequest{
@@ -35,25 +67,119 @@ func main() {
 		PhoneName:              *flagPhoneInfo,
 		PhoneModel:             *flagPhoneInfo,
 	}
-	out := ddapi.RegisterResponse{}
 
-	conn := dd.Conn{}
-	err = conn.SimpleRequest(dd.SimpleRequest{
+	var passphrase []byte
+	if *flagEncrypt {
+		var err error
+		if *flagKeyFile != "" {
+			passphrase, err = helper.ReadKeyFile(*flagKeyFile)
+		} else {
+			passphrase, err = helper.PassphraseFromEnv()
+		}
+		if err != nil {
+			log.Fatalf("can't read passphrase: %v", err)
+		}
+	}
+
+	conn := dd.Conn{RemoteHost: *flagRemoteHost}
+	if err := register(&conn, req, *flagCredentialsPath, passphrase, *flagDryRun, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// register performs the remote registration and saves the credentials to path, which may
+// be any store helper.OpenStore accepts, or writes them to stdout instead when dryRun is set.
+// With passphrase set, path must be a file, which is encrypted.
+func register(conn *dd.Conn, req ddapi.RegisterRequest, path string, passphrase []byte, dryRun bool, stdout io.Writer) error {
+	out := ddapi.RegisterResponse{}
+	err := conn.SimpleRequest(dd.SimpleRequest{
 		Path:   "/app/remoteregister",
 		Target: dd.RemoteTarget,
 		Input:  req,
 		Output: &out,
 	})
 	if err != nil {
-		log.Fatalf("can't remoteregister: %+v %v", req, err)
+		return fmt.Errorf("can't remoteregister: %+v %v", req, err)
+	}
+
+	out.UserPassword = req.UserPassword
+
+	if dryRun {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return fmt.Errorf("can't encode response: %+v %v", out, err)
+		}
+		log.Printf("Ok! Dry run, credentials not saved")
+		return nil
+	}
+
+	save := helper.SaveCreds
+	if passphrase != nil {
+		save = func(path string, creds *ddapi.RegisterResponse) error {
+			return helper.SaveEncryptedCreds(path, creds, passphrase)
+		}
 	}
+	if err := save(path, &out); err != nil {
+		return fmt.Errorf("can't save credentials: %v %v", path, err)
+	}
+
+	log.Printf("Ok! Saved at: %v", path)
+	return nil
+}
 
-	out.UserPassword = *flagPassword
+// rotate connects with the credentials saved at path, rotates the phone secret and, if
+// newPassword is set, changes the user password first, then saves the new credentials
+// over the old ones. An encrypted file stays encrypted, under passphrase if set or else
+// the passphrase from the environment. If the hub accepted a change that can't be saved,
+// the new credentials are written to stdout so they aren't lost.
+func rotate(conn *dd.Conn, path string, passphrase []byte, newPassword string, stdout io.Writer) error {
+	var (
+		rotated   *ddapi.RegisterResponse // set once the hub has accepted a change
+		rotateErr error
+	)
+	err := helper.UpdateCreds(path, passphrase, func(creds *ddapi.RegisterResponse) error {
+		if err := conn.Connect(creds.Credential); err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		defer conn.Close()
 
-	err = json.NewEncoder(f).Encode(out)
+		if newPassword != "" {
+			if err := ddapi.ChangeUserPassword(conn, creds.UserPassword, newPassword); err != nil {
+				return fmt.Errorf("can't change password: %w", err)
+			}
+			creds.UserPassword = newPassword
+			rotated = creds
+		} else if conn.IsPasswordExpired() {
+			log.Printf("Warning: the user password has expired; change it with -newPassword")
+		}
+
+		secret, err := ddapi.RotatePhoneSecret(conn)
+		if err != nil {
+			rotateErr = fmt.Errorf("can't rotate phone secret: %w", err)
+			if rotated == nil {
+				return rotateErr
+			}
+			return nil // still save the new password
+		}
+		creds.PhoneSecret = secret
+		rotated = creds
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("can't encode response: %+v %v", out, err)
+		if rotated != nil {
+			enc := json.NewEncoder(stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(rotated)
+			return fmt.Errorf("can't save rotated credentials, printed above: %v %w", path, err)
+		}
+		return err
+	}
+	if rotateErr != nil {
+		log.Printf("Password changed and saved at: %v", path)
+		return rotateErr
 	}
 
-	log.Printf("Ok! Saved at: %v", *flagCredentialsPath)
+	log.Printf("Ok! Rotated credentials saved at: %v", path)
+	return nil
 }
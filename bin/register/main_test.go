@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+	"github.com/gravypower/dd/helper"
+)
+
+func newRegisterServer(t *testing.T) *dd.Conn {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/remoteregister" {
+			t.Errorf("request path = %q, want /app/remoteregister", r.URL.Path)
+		}
+		w.Write([]byte(`{"phoneSecret":"secret","bsid":"bs1","phoneId":"phone1","name":"Garage","userName":"alice"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	conn := &dd.Conn{RemoteHost: strings.TrimPrefix(server.URL, "https://")}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestRegister_DryRun(t *testing.T) {
+	conn := newRegisterServer(t)
+	credFile := filepath.Join(t.TempDir(), "creds.json")
+
+	var stdout bytes.Buffer
+	req := ddapi.RegisterRequest{RemoteRegistrationCode: "code", UserPassword: "pass"}
+	if err := register(conn, req, credFile, nil, true, &stdout); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	var out ddapi.RegisterResponse
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("dry run output is not valid JSON: %v\n%s", err, stdout.String())
+	}
+	if out.BaseStation != "bs1" || out.Phone != "phone1" || out.UserName != "alice" {
+		t.Errorf("dry run output = %+v, missing expected fields", out)
+	}
+	if out.UserPassword != "pass" {
+		t.Errorf("dry run UserPassword = %q, want %q", out.UserPassword, "pass")
+	}
+
+	if _, err := os.Stat(credFile); !os.IsNotExist(err) {
+		t.Errorf("dry run should not write the credentials file (stat err = %v)", err)
+	}
+}
+
+func TestRegister_SavesCredentials(t *testing.T) {
+	conn := newRegisterServer(t)
+	credFile := filepath.Join(t.TempDir(), "creds.json")
+
+	var stdout bytes.Buffer
+	req := ddapi.RegisterRequest{RemoteRegistrationCode: "code", UserPassword: "pass"}
+	if err := register(conn, req, credFile, nil, false, &stdout); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	if stdout.Len() != 0 {
+		t.Errorf("register() wrote to stdout without dry run: %q", stdout.String())
+	}
+	b, err := os.ReadFile(credFile)
+	if err != nil {
+		t.Fatalf("credentials file not written: %v", err)
+	}
+	var out ddapi.RegisterResponse
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("credentials file is not valid JSON: %v", err)
+	}
+	if out.PhoneSecret != "secret" {
+		t.Errorf("saved PhoneSecret = %q, want %q", out.PhoneSecret, "secret")
+	}
+}
+
+func TestRegister_Encrypted(t *testing.T) {
+	conn := newRegisterServer(t)
+	credFile := filepath.Join(t.TempDir(), "creds.json")
+
+	var stdout bytes.Buffer
+	req := ddapi.RegisterRequest{RemoteRegistrationCode: "code", UserPassword: "pass"}
+	if err := register(conn, req, credFile, []byte("hunter2"), false, &stdout); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	b, err := os.ReadFile(credFile)
+	if err != nil {
+		t.Fatalf("credentials file not written: %v", err)
+	}
+	if bytes.Contains(b, []byte("secret")) {
+		t.Errorf("encrypted credentials file contains the phone secret in plaintext")
+	}
+
+	t.Setenv(helper.PassphraseEnv, "hunter2")
+	creds, err := helper.LoadCreds(credFile)
+	if err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if creds.PhoneSecret != "secret" {
+		t.Errorf("decrypted PhoneSecret = %q, want %q", creds.PhoneSecret, "secret")
+	}
+}
+
+// newRotateServer returns a hub that hands out secret as a new phone secret, and
+// credentials for it saved at a new file.
+func newRotateServer(t *testing.T, secret string) (*ddtest.Server, string) {
+	t.Helper()
+	server := ddtest.NewServer()
+	t.Cleanup(server.Close)
+	server.Handle("/app/res/phone/secret", func([]byte) (interface{}, error) {
+		return map[string]string{"phoneSecret": secret}, nil
+	})
+
+	credFile := filepath.Join(t.TempDir(), "creds.json")
+	creds := &ddapi.RegisterResponse{Credential: server.Credential, Name: "Garage"}
+	creds.UserPassword = "pass"
+	if err := helper.SaveCreds(credFile, creds); err != nil {
+		t.Fatalf("SaveCreds() error = %v", err)
+	}
+	return server, credFile
+}
+
+func TestRotate(t *testing.T) {
+	server, credFile := newRotateServer(t, "rotated")
+	var password map[string]string
+	server.Handle("/app/res/user/password", func(body []byte) (interface{}, error) {
+		return nil, json.Unmarshal(body, &password)
+	})
+
+	var stdout bytes.Buffer
+	if err := rotate(server.Conn(), credFile, nil, "newpass", &stdout); err != nil {
+		t.Fatalf("rotate() error = %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("rotate() wrote to stdout: %q", stdout.String())
+	}
+	if password["oldPassword"] != "pass" || password["newPassword"] != "newpass" {
+		t.Errorf("password change request = %v, want pass to newpass", password)
+	}
+
+	creds, err := helper.LoadCreds(credFile)
+	if err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if creds.PhoneSecret != "rotated" || creds.UserPassword != "newpass" || creds.Name != "Garage" {
+		t.Errorf("saved credentials = %+v, want rotated secret and new password", creds)
+	}
+
+	// The saved credentials work once the hub has switched secrets
+	server.Credential.PhoneSecret = "rotated"
+	conn := server.Conn()
+	defer conn.Close()
+	if err := conn.Connect(creds.Credential); err != nil {
+		t.Errorf("Connect() with rotated credentials error = %v", err)
+	}
+}
+
+func TestRotate_Encrypted(t *testing.T) {
+	server, credFile := newRotateServer(t, "rotated")
+	creds, err := helper.LoadCreds(credFile)
+	if err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if err := helper.SaveEncryptedCreds(credFile, creds, []byte("hunter2")); err != nil {
+		t.Fatalf("SaveEncryptedCreds() error = %v", err)
+	}
+
+	if err := rotate(server.Conn(), credFile, []byte("hunter2"), "", io.Discard); err != nil {
+		t.Fatalf("rotate() error = %v", err)
+	}
+	b, err := os.ReadFile(credFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if bytes.Contains(b, []byte("rotated")) {
+		t.Errorf("rotated credentials file contains the phone secret in plaintext")
+	}
+	t.Setenv(helper.PassphraseEnv, "hunter2")
+	if creds, err = helper.LoadCreds(credFile); err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if creds.PhoneSecret != "rotated" {
+		t.Errorf("decrypted PhoneSecret = %q, want %q", creds.PhoneSecret, "rotated")
+	}
+}
+
+func TestRotate_Failed(t *testing.T) {
+	server, credFile := newRotateServer(t, "")
+	before, err := os.ReadFile(credFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	if err := rotate(server.Conn(), credFile, nil, "", io.Discard); !errors.Is(err, ddapi.ErrNoPhoneSecret) {
+		t.Errorf("rotate() error = %v, want %v", err, ddapi.ErrNoPhoneSecret)
+	}
+	after, err := os.ReadFile(credFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Errorf("failed rotate() changed the credentials file")
+	}
+}
@@ -0,0 +1,126 @@
+// Command rest serves a SmartDoor hub as a REST API with server-sent events; its OpenAPI
+// spec is served at /api/openapi.json.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagListen           = flag.String("listen", "127.0.0.1:8080", "address to serve the API on")
+	flagToken            = flag.String("token", "", "bearer token clients must send")
+	flagTLSCert          = flag.String("tlsCert", "", "certificate file to serve HTTPS with")
+	flagTLSKey           = flag.String("tlsKey", "", "private key file for -tlsCert")
+	flagDryRun           = flag.Bool("dryRun", false, "log door commands instead of sending them to the hub")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
+)
+
+var logger = logrus.StandardLogger()
+
+// Backoff between attempts to reconnect to the hub
+var (
+	reconnectMinInterval = 5 * time.Second
+	reconnectMaxInterval = 5 * time.Minute
+)
+
+func main() {
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
+	logger.AddHook(dd.RedactHook{})
+	if *flagDebug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	if *flagToken == "" {
+		logger.Fatal("a token is required; set -token, DD_TOKEN or DD_TOKEN_FILE")
+	}
+	if (*flagTLSCert == "") != (*flagTLSKey == "") {
+		logger.Fatal("-tlsCert and -tlsKey must be given together")
+	}
+	if *flagDryRun {
+		ddapi.EnableDryRun(nil)
+		logger.Warn("Dry run: door commands will be logged, not sent to the hub")
+	}
+
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		logger.WithError(err).Fatalf("can't open credentials file %s", *flagCredentialsPath)
+	}
+	conn := &dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+	if err := conn.Connect(creds.Credential); err != nil {
+		logger.WithError(err).Fatal("failed to connect")
+	}
+	defer conn.Close()
+
+	// Listen up front so a bad address fails before serving
+	ln, err := net.Listen("tcp", *flagListen)
+	if err != nil {
+		logger.WithError(err).Fatalf("can't listen on %s", *flagListen)
+	}
+	s := newServer(conn, *flagToken)
+	httpServer := &http.Server{Handler: s.handler()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	go follow(ctx, s, creds.Credential)
+
+	logger.WithFields(logrus.Fields{"addr": ln.Addr(), "tls": *flagTLSCert != ""}).Info("Serving REST API")
+	if *flagTLSCert != "" {
+		err = httpServer.ServeTLS(ln, *flagTLSCert, *flagTLSKey)
+	} else {
+		err = httpServer.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.WithError(err).Fatal("server stopped")
+	}
+}
+
+// follow keeps s up to date until ctx is done, reconnecting to the hub whenever the
+// connection is lost.
+func follow(ctx context.Context, s *server, cred dd.Credential) {
+	backoff := dd.Backoff{Base: reconnectMinInterval, Max: reconnectMaxInterval, Jitter: dd.DefaultBackoffJitter}
+	for {
+		err := s.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.WithError(err).Error("Lost connection to hub; reconnecting")
+		for {
+			select {
+			case <-time.After(backoff.Next()):
+			case <-ctx.Done():
+				return
+			}
+			if err := s.conn.Connect(cred); err != nil {
+				logger.WithError(err).Warn("Failed to reconnect to hub")
+				continue
+			}
+			backoff.Reset()
+			logger.Info("Reconnected to hub")
+			break
+		}
+	}
+}
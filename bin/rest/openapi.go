@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// schemas are the OpenAPI components the routes refer to by name.
+var schemas = map[string]interface{}{
+	"Device": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"deviceId": map[string]interface{}{"type": "string"},
+			"name":     map[string]interface{}{"type": "string"},
+			"device": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"position": map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 100},
+					"battery":  map[string]interface{}{"type": "integer", "description": "percent, if reported"},
+					"rfSignal": map[string]interface{}{"type": "integer", "description": "dBm, if reported"},
+				},
+			},
+			"buttons": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Button"}},
+			"aux":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Button"}},
+			"log": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"logId": map[string]interface{}{"type": "integer"},
+					"alert": map[string]interface{}{"type": "integer"},
+					"text":  map[string]interface{}{"type": "string"},
+					"time":  map[string]interface{}{"type": "integer", "description": "epoch milliseconds"},
+				},
+			},
+		},
+	},
+	"Button": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string"},
+			"action": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"cmd": map[string]interface{}{"type": "integer"}},
+			},
+		},
+	},
+	"DeviceList": map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"$ref": "#/components/schemas/Device"},
+	},
+	"PositionRequest": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"position"},
+		"properties": map[string]interface{}{
+			"position": map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 100},
+		},
+	},
+	"Error": map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+	},
+}
+
+// openAPISpec generates the OpenAPI 3 document describing routes.
+func openAPISpec(routes []route) map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+	for _, r := range routes {
+		op := map[string]interface{}{
+			"summary":   r.summary,
+			"responses": responses(r),
+		}
+		if r.public {
+			op["security"] = []interface{}{}
+		}
+		if strings.Contains(r.path, "{id}") {
+			op["parameters"] = []interface{}{map[string]interface{}{
+				"name": "id", "in": "path", "required": true,
+				"description": "device ID",
+				"schema":      map[string]interface{}{"type": "string"},
+			}}
+		}
+		if r.body != "" {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(r.body),
+			}
+		}
+		if paths[r.path] == nil {
+			paths[r.path] = make(map[string]interface{})
+		}
+		paths[r.path][strings.ToLower(r.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "SmartDoor REST API",
+			"description": "Door status and commands for a SmartDoor hub.",
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"bearer": []string{}}},
+	}
+}
+
+// responses describes a route's success and error responses.
+func responses(r route) map[string]interface{} {
+	success := map[string]interface{}{"description": http.StatusText(r.status)}
+	switch {
+	case r.stream:
+		success["content"] = map[string]interface{}{
+			"text/event-stream": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type":        "string",
+					"description": "\"device\" events, each with a Device as JSON data",
+				},
+			},
+		}
+	case r.response != "":
+		success["content"] = jsonContent(r.response)
+	}
+	out := map[string]interface{}{strconv.Itoa(r.status): success}
+	if !r.public {
+		out["401"] = errorResponse(http.StatusUnauthorized)
+	}
+	if strings.Contains(r.path, "{id}") {
+		out["404"] = errorResponse(http.StatusNotFound)
+		out["502"] = map[string]interface{}{"description": "The hub didn't accept the command", "content": jsonContent("Error")}
+	}
+	if r.body != "" {
+		out["400"] = errorResponse(http.StatusBadRequest)
+	}
+	return out
+}
+
+func errorResponse(status int) map[string]interface{} {
+	return map[string]interface{}{"description": http.StatusText(status), "content": jsonContent("Error")}
+}
+
+func jsonContent(schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schema},
+		},
+	}
+}
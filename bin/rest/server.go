@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// eventsBuffer is how many updates a slow /api/events client may fall behind before
+// updates to it are dropped.
+const eventsBuffer = 16
+
+// eventsKeepAlive is how often an idle /api/events stream gets a comment, so proxies
+// don't time it out.
+var eventsKeepAlive = 30 * time.Second
+
+// positionRequest is the body of POST /api/devices/{id}/position.
+type positionRequest struct {
+	Position *int `json:"position"`
+}
+
+// server serves the REST API for one hub's Conn. /api/events follows the status updates
+// that follow receives.
+type server struct {
+	conn  *dd.Conn
+	token string // bearer token every request must carry
+
+	mu      sync.Mutex
+	devices map[string]ddapi.DoorStatusDevice
+	order   []string // device IDs, in the order first seen
+	clients map[chan ddapi.DoorStatusDevice]struct{}
+}
+
+func newServer(conn *dd.Conn, token string) *server {
+	return &server{
+		conn:    conn,
+		token:   token,
+		devices: make(map[string]ddapi.DoorStatusDevice),
+		clients: make(map[chan ddapi.DoorStatusDevice]struct{}),
+	}
+}
+
+// follow fetches the current status, then records the hub's status updates until ctx is
+// done or the connection is lost, returning the reason as dd.Runner does.
+func (s *server) follow(ctx context.Context) error {
+	if status, err := ddapi.SafeFetchStatusContext(ctx, s.conn); err == nil {
+		s.update(*status)
+	}
+
+	runner := dd.NewRunner(s.conn, 0)
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	for m := range runner.Status() {
+		var status ddapi.DoorStatus
+		if err := m.Decode(&status); err != nil {
+			logger.WithError(err).Debug("Ignoring message that is not a door status")
+			continue
+		}
+		s.update(status)
+	}
+	return <-done
+}
+
+// update records a status and sends changed devices to /api/events clients.
+func (s *server) update(status ddapi.DoorStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, device := range status.Devices {
+		prev, seen := s.devices[device.ID]
+		s.devices[device.ID] = device
+		if !seen {
+			s.order = append(s.order, device.ID)
+		} else if device.Equal(prev) && device.Name == prev.Name {
+			continue
+		}
+		for ch := range s.clients {
+			select {
+			case ch <- device:
+			default:
+				logger.WithField("deviceID", device.ID).Warn("Events client is behind; dropping update")
+			}
+		}
+	}
+}
+
+// snapshot returns every device in order.
+func (s *server) snapshot() []ddapi.DoorStatusDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ddapi.DoorStatusDevice, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.devices[id])
+	}
+	return out
+}
+
+// route is an API endpoint, described for the OpenAPI spec.
+type route struct {
+	method, path string
+	summary      string
+	body         string // schema of the JSON request body, if any
+	response     string // schema of the JSON response, if any
+	status       int    // success status
+	stream       bool   // responds with text/event-stream
+	public       bool   // served without a token
+
+	handler http.HandlerFunc
+}
+
+// routes returns the API's endpoints. The OpenAPI spec is generated from them.
+func (s *server) routes() []route {
+	command := func(name string, do func(*ddapi.Door, context.Context) error) route {
+		return route{
+			method:  http.MethodPost,
+			path:    "/api/devices/{id}/" + name,
+			summary: fmt.Sprintf("%s the door", strings.ToUpper(name[:1])+name[1:]),
+			status:  http.StatusAccepted,
+			handler: s.doorHandler(func(r *http.Request, door *ddapi.Door) error {
+				return do(door, r.Context())
+			}),
+		}
+	}
+	return []route{
+		{method: http.MethodGet, path: "/api/devices", summary: "List devices and their latest status",
+			response: "DeviceList", status: http.StatusOK, handler: s.handleDevices},
+		command("open", (*ddapi.Door).Open),
+		command("close", (*ddapi.Door).Close),
+		command("stop", (*ddapi.Door).Stop),
+		{method: http.MethodPost, path: "/api/devices/{id}/position", summary: "Move the door to a position",
+			body: "PositionRequest", status: http.StatusAccepted, handler: s.doorHandler(setPosition)},
+		{method: http.MethodGet, path: "/api/events", summary: "Server-sent events: every device, then each device whose status changes",
+			response: "Device", status: http.StatusOK, stream: true, handler: s.handleEvents},
+		{method: http.MethodGet, path: "/api/openapi.json", summary: "This API's OpenAPI spec",
+			status: http.StatusOK, public: true, handler: s.handleSpec},
+	}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, r := range s.routes() {
+		h := http.Handler(r.handler)
+		if !r.public {
+			h = s.authenticate(h)
+		}
+		mux.Handle(r.method+" "+r.path, h)
+	}
+	return mux
+}
+
+// authenticate rejects requests without the bearer token. EventSource can't set headers,
+// so the token may also be given as the access_token query parameter.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("access_token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dd"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.snapshot())
+}
+
+// doorHandler serves a command on the door named by the path, replying 202 once the hub
+// accepts it.
+func (s *server) doorHandler(do func(*http.Request, *ddapi.Door) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		s.mu.Lock()
+		_, ok := s.devices[id]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ddapi.ErrDeviceNotFound, id))
+			return
+		}
+		err := do(r, ddapi.NewDoor(s.conn, id))
+		var badRequest *requestError
+		switch {
+		case errors.As(err, &badRequest):
+			writeError(w, badRequest.status, err)
+		case err != nil:
+			writeError(w, http.StatusBadGateway, err)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+}
+
+// requestError is a problem with the request rather than the hub.
+type requestError struct {
+	status int
+	err    error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+func setPosition(r *http.Request, door *ddapi.Door) error {
+	// Requiring JSON stops browsers being used to post commands cross-site
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return &requestError{http.StatusUnsupportedMediaType, errors.New("content type must be application/json")}
+	}
+	var req positionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &requestError{http.StatusBadRequest, err}
+	}
+	if req.Position == nil || *req.Position < ddapi.PositionClosed || *req.Position > ddapi.PositionOpen {
+		return &requestError{http.StatusBadRequest, errors.New("position must be 0-100")}
+	}
+	return door.SetPosition(r.Context(), *req.Position)
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	ch := make(chan ddapi.DoorStatusDevice, eventsBuffer)
+	s.mu.Lock()
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, device := range s.snapshot() {
+		if err := writeEvent(w, device); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case device := <-ch:
+			if err := writeEvent(w, device); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes device as a "device" server-sent event.
+func writeEvent(w http.ResponseWriter, device ddapi.DoorStatusDevice) error {
+	b, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: device\ndata: %s\n\n", b)
+	return err
+}
+
+func (s *server) handleSpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec(s.routes()))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.WithError(err).Debug("Failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
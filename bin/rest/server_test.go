@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+)
+
+const testToken = "secret"
+
+func testStatus(position int) ddapi.DoorStatus {
+	device := ddapi.DoorStatusDevice{ID: "door1", Name: "Garage"}
+	device.Device.Position = position
+	return ddapi.DoorStatus{DeviceOrder: []string{"door1"}, Devices: []ddapi.DoorStatusDevice{device}}
+}
+
+// startServer serves a server for a new ddtest hub that already knows door1, returning
+// the hub, the server and its URL.
+func startServer(t *testing.T) (*ddtest.Server, *server, string) {
+	t.Helper()
+	hub := ddtest.NewServer()
+	t.Cleanup(hub.Close)
+	conn := hub.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(hub.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	hub.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return testStatus(0), nil
+	})
+
+	s := newServer(conn, testToken)
+	s.update(testStatus(0))
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return hub, s, ts.URL
+}
+
+func do(t *testing.T, method, url, token, contentType, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestServer_Authentication(t *testing.T) {
+	_, _, url := startServer(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"no token", "/api/devices", "", http.StatusUnauthorized},
+		{"wrong token", "/api/devices", "guess", http.StatusUnauthorized},
+		{"bearer token", "/api/devices", testToken, http.StatusOK},
+		{"query token", "/api/devices?access_token=" + testToken, "", http.StatusOK},
+		{"spec is public", "/api/openapi.json", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, http.MethodGet, url+tt.path, tt.token, "", "")
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestServer_Devices(t *testing.T) {
+	_, _, url := startServer(t)
+
+	resp := do(t, http.MethodGet, url+"/api/devices", testToken, "", "")
+	var devices []ddapi.DoorStatusDevice
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		t.Fatalf("decoding devices: %v", err)
+	}
+	if len(devices) != 1 || devices[0].ID != "door1" || devices[0].Name != "Garage" {
+		t.Errorf("devices = %+v, want door1", devices)
+	}
+}
+
+func TestServer_Commands(t *testing.T) {
+	hub, _, url := startServer(t)
+	var mu sync.Mutex
+	var sent []int
+	hub.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		var in ddapi.CommandInput
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		sent = append(sent, in.Action.Command)
+		mu.Unlock()
+		return ddapi.CommandOutput{}, nil
+	})
+
+	tests := []struct {
+		path        string
+		contentType string
+		body        string
+		status      int
+		command     int // sent to the hub, if the request is accepted
+	}{
+		{"/api/devices/door1/open", "", "", http.StatusAccepted, ddapi.AvailableCommands.Open},
+		{"/api/devices/door1/close", "", "", http.StatusAccepted, ddapi.AvailableCommands.Close},
+		{"/api/devices/door1/stop", "", "", http.StatusAccepted, ddapi.AvailableCommands.Stop},
+		{"/api/devices/door1/position", "application/json", `{"position": 50}`, http.StatusAccepted, ddapi.GetCommandForPosition(50)},
+		{"/api/devices/door9/open", "", "", http.StatusNotFound, 0},
+		{"/api/devices/door1/position", "text/plain", `{"position": 50}`, http.StatusUnsupportedMediaType, 0},
+		{"/api/devices/door1/position", "application/json", `{"position": 101}`, http.StatusBadRequest, 0},
+		{"/api/devices/door1/position", "application/json", `{}`, http.StatusBadRequest, 0},
+		{"/api/devices/door1/position", "application/json", `{`, http.StatusBadRequest, 0},
+	}
+	var want []int
+	for _, tt := range tests {
+		resp := do(t, http.MethodPost, url+tt.path, testToken, tt.contentType, tt.body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("POST %s %s = %d, want %d", tt.path, tt.body, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusAccepted {
+			want = append(want, tt.command)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != len(want) {
+		t.Fatalf("hub got commands %v, want %v", sent, want)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("command %d = %d, want %d", i, sent[i], want[i])
+		}
+	}
+}
+
+func TestServer_Spec(t *testing.T) {
+	_, s, url := startServer(t)
+
+	resp := do(t, http.MethodGet, url+"/api/openapi.json", "", "", "")
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatalf("decoding spec: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want 3.x", spec.OpenAPI)
+	}
+	for _, r := range s.routes() {
+		if _, ok := spec.Paths[r.path][strings.ToLower(r.method)]; !ok {
+			t.Errorf("spec is missing %s %s", r.method, r.path)
+		}
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	_, s, url := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/api/events?access_token="+testToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/events error = %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() ddapi.DoorStatusDevice {
+		t.Helper()
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var device ddapi.DoorStatusDevice
+				if err := json.Unmarshal([]byte(data), &device); err != nil {
+					t.Fatalf("decoding event: %v", err)
+				}
+				return device
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return ddapi.DoorStatusDevice{}
+	}
+
+	if first := next(); first.ID != "door1" || first.Device.Position != 0 {
+		t.Errorf("first event = %+v, want door1 closed", first)
+	}
+	s.update(testStatus(0)) // unchanged, so not sent
+	s.update(testStatus(100))
+	if update := next(); update.ID != "door1" || update.Device.Position != 100 {
+		t.Errorf("event after an update = %+v, want door1 open", update)
+	}
+}
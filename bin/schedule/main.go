@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+)
+
+var (
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
+)
+
+func main() {
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
+
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		log.Fatalf("can't open credentials file: %v %v", *flagCredentialsPath, err)
+	}
+
+	conn := dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+	err = conn.Connect(creds.Credential)
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+
+	schedule, err := ddapi.FetchAccessSchedule(&conn)
+	if err != nil {
+		log.Fatalf("could not fetch access schedule: %v", err)
+	}
+
+	if len(schedule.Windows) == 0 {
+		fmt.Println("No access restrictions")
+		return
+	}
+
+	for i, w := range schedule.Windows {
+		days := make([]string, len(w.DaysOfWeek))
+		for j, d := range w.DaysOfWeek {
+			days[j] = d.String()[:3]
+		}
+		fmt.Printf("%d. %s - %s  %s\n", i+1,
+			w.Start.Format("15:04"), w.End.Format("15:04"), strings.Join(days, ","))
+	}
+}
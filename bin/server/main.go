@@ -0,0 +1,107 @@
+// Command server serves a SmartDoor hub over gRPC; see package ddgrpc and its dd.proto.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddgrpc"
+	"github.com/gravypower/dd/helper"
+	"google.golang.org/grpc"
+)
+
+var (
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagListen           = flag.String("listen", "127.0.0.1:50051", "address to serve gRPC on")
+	flagDryRun           = flag.Bool("dryRun", false, "log door commands instead of sending them to the hub")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
+)
+
+// Backoff between attempts to reconnect to the hub
+var (
+	reconnectMinInterval = 5 * time.Second
+	reconnectMaxInterval = 5 * time.Minute
+)
+
+func main() {
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
+	if *flagDryRun {
+		ddapi.EnableDryRun(nil)
+		log.Printf("dry run: door commands will be logged, not sent to the hub")
+	}
+
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		log.Fatalf("can't open credentials file: %v %v", *flagCredentialsPath, err)
+	}
+
+	conn := &dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+	if err := conn.Connect(creds.Credential); err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Listen up front so a bad address fails before serving
+	ln, err := net.Listen("tcp", *flagListen)
+	if err != nil {
+		log.Fatalf("can't listen on %v: %v", *flagListen, err)
+	}
+	server := ddgrpc.NewServer(conn)
+	grpcServer := grpc.NewServer()
+	ddgrpc.RegisterDoorServiceServer(grpcServer, server)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+	go follow(ctx, server, conn, creds.Credential)
+
+	log.Printf("serving gRPC on %v", ln.Addr())
+	if err := grpcServer.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		log.Fatalf("gRPC server stopped: %v", err)
+	}
+}
+
+// follow runs server until ctx is done, reconnecting to the hub whenever the connection
+// is lost.
+func follow(ctx context.Context, server *ddgrpc.Server, conn *dd.Conn, cred dd.Credential) {
+	backoff := dd.Backoff{Base: reconnectMinInterval, Max: reconnectMaxInterval, Jitter: dd.DefaultBackoffJitter}
+	for {
+		err := server.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("lost connection to hub: %v", err)
+		for {
+			delay := backoff.Next()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if err := conn.Connect(cred); err != nil {
+				log.Printf("failed to reconnect: %v", err)
+				continue
+			}
+			backoff.Reset()
+			log.Printf("reconnected to hub")
+			break
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// DoorConfig describes a simulated door.
+type DoorConfig struct {
+	ID         string        `yaml:"id"`
+	Name       string        `yaml:"name"`
+	Position   int           `yaml:"position"`   // starting position, 0-100
+	TravelTime time.Duration `yaml:"travelTime"` // time to travel fully open to closed
+	Light      bool          `yaml:"light"`      // has a courtesy light
+	Aux        bool          `yaml:"aux"`        // has an aux relay
+}
+
+// door is the live state of a simulated door.
+type door struct {
+	config DoorConfig
+
+	position float64
+	target   float64
+	light    bool
+	aux      bool
+
+	logID   int64
+	alert   int
+	logText string
+	logTime int64
+	hash    int
+}
+
+// commandTargets maps positioning commands to the position they move the door to.
+var commandTargets = map[int]int{
+	ddapi.AvailableCommands.Open:      ddapi.PositionOpen,
+	ddapi.AvailableCommands.Close:     ddapi.PositionClosed,
+	ddapi.AvailableCommands.PartOpen2: 20, // pet height
+	ddapi.AvailableCommands.PartOpen3: 68, // parcel height
+}
+
+func init() {
+	// OpenPercent05 to OpenPercent95 are consecutive command codes
+	for i := 0; i < 19; i++ {
+		commandTargets[ddapi.AvailableCommands.OpenPercent05+i] = (i + 1) * 5
+	}
+}
+
+// simHub simulates a base station's doors and answers the hub's RPCs for them.
+type simHub struct {
+	mu    sync.Mutex
+	doors []*door
+	order []string
+
+	// push delivers a status update to connected clients
+	push func(ddapi.DoorStatus)
+}
+
+// newSimHub creates a hub with the given doors. push is called whenever a door changes.
+func newSimHub(configs []DoorConfig, push func(ddapi.DoorStatus)) *simHub {
+	h := &simHub{push: push}
+	for _, c := range configs {
+		position := float64(max(ddapi.PositionClosed, min(ddapi.PositionOpen, c.Position)))
+		h.doors = append(h.doors, &door{config: c, position: position, target: position})
+		h.order = append(h.order, c.ID)
+	}
+	return h
+}
+
+// status returns the hub's current status.
+func (h *simHub) status() ddapi.DoorStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.statusLocked()
+}
+
+func (h *simHub) statusLocked() ddapi.DoorStatus {
+	status := ddapi.DoorStatus{DeviceOrder: h.order}
+	for _, d := range h.doors {
+		status.Devices = append(status.Devices, d.status())
+	}
+	return status
+}
+
+// command applies a door command, as sent to /app/res/action.
+func (h *simHub) command(deviceID string, cmd int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var d *door
+	for _, candidate := range h.doors {
+		if candidate.config.ID == deviceID {
+			d = candidate
+		}
+	}
+	if d == nil {
+		return &dd.RPCError{Path: "/app/res/action", Code: 2, Description: "unknown device " + deviceID}
+	}
+
+	switch {
+	case cmd == ddapi.AvailableCommands.Stop:
+		d.target = math.Round(d.position)
+	case cmd == ddapi.AvailableCommands.LightOn && d.config.Light:
+		d.light = true
+	case cmd == ddapi.AvailableCommands.LightOff && d.config.Light:
+		d.light = false
+	case cmd == ddapi.AvailableCommands.AuxOn && d.config.Aux:
+		d.aux = true
+	case cmd == ddapi.AvailableCommands.AuxOff && d.config.Aux:
+		d.aux = false
+	default:
+		target, ok := commandTargets[cmd]
+		if !ok {
+			return &dd.RPCError{Path: "/app/res/action", Code: 1, Description: fmt.Sprintf("unsupported command %d", cmd)}
+		}
+		d.target = float64(target)
+	}
+
+	d.logID++
+	d.alert = cmd
+	d.logText = fmt.Sprintf("Command %d", cmd)
+	d.logTime = time.Now().UnixMilli()
+	d.hash++
+	h.push(h.statusLocked())
+	return nil
+}
+
+// step moves every door toward its target by dt, pushing a status update if any moved.
+func (h *simHub) step(dt time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	moved := false
+	for _, d := range h.doors {
+		before := d.status().Device.Position
+		d.move(dt)
+		if d.status().Device.Position != before {
+			moved = true
+		}
+	}
+	if moved {
+		h.push(h.statusLocked())
+	}
+}
+
+// run steps the simulation every interval until stop is closed.
+func (h *simHub) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.step(interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleFetch answers /app/res/devices/fetch.
+func (h *simHub) handleFetch(body []byte) (interface{}, error) {
+	return h.status(), nil
+}
+
+// handleAction answers /app/res/action.
+func (h *simHub) handleAction(body []byte) (interface{}, error) {
+	var input ddapi.CommandInput
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, err
+	}
+	if err := h.command(input.DeviceId, input.Action.Command); err != nil {
+		return nil, err
+	}
+	return ddapi.CommandOutput{Value: "ok"}, nil
+}
+
+// move advances the door toward its target; a door with no travel time moves instantly.
+func (d *door) move(dt time.Duration) {
+	if d.config.TravelTime <= 0 {
+		d.position = d.target
+		return
+	}
+	distance := float64(ddapi.PositionOpen) * dt.Seconds() / d.config.TravelTime.Seconds()
+	switch {
+	case d.position < d.target:
+		d.position = min(d.target, d.position+distance)
+	case d.position > d.target:
+		d.position = max(d.target, d.position-distance)
+	}
+}
+
+// status returns the door as the hub reports it, with buttons for the commands it offers.
+func (d *door) status() ddapi.DoorStatusDevice {
+	s := ddapi.DoorStatusDevice{
+		ID:   d.config.ID,
+		Name: d.config.Name,
+		Time: time.Now().UnixMilli(),
+		Hash: d.hash,
+		Buttons: []ddapi.DoorStatusButton{
+			button("Open", ddapi.AvailableCommands.Open, 0, 0),
+			button("Stop", ddapi.AvailableCommands.Stop, 0, 1),
+			button("Close", ddapi.AvailableCommands.Close, 0, 2),
+		},
+	}
+	s.Device.Position = int(math.Round(d.position))
+	s.Log.ID = d.logID
+	s.Log.Alert = d.alert
+	s.Log.Text = d.logText
+	s.Log.Time = d.logTime
+
+	// Toggle buttons offer the opposite of the current state
+	if d.config.Light {
+		if d.light {
+			s.Buttons = append(s.Buttons, button("Light Off", ddapi.AvailableCommands.LightOff, 1, 0))
+		} else {
+			s.Buttons = append(s.Buttons, button("Light On", ddapi.AvailableCommands.LightOn, 1, 0))
+		}
+	}
+	if d.config.Aux {
+		if d.aux {
+			s.Aux = append(s.Aux, button("Aux Off", ddapi.AvailableCommands.AuxOff, 0, 0))
+		} else {
+			s.Aux = append(s.Aux, button("Aux On", ddapi.AvailableCommands.AuxOn, 0, 0))
+		}
+	}
+	return s
+}
+
+func button(title string, cmd, row, col int) ddapi.DoorStatusButton {
+	var b ddapi.DoorStatusButton
+	b.Title = title
+	b.Action.Command = cmd
+	b.Row = row
+	b.Col = col
+	return b
+}
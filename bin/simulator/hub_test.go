@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+)
+
+func TestSimHub_Commands(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  int
+		want int
+	}{
+		{"open", ddapi.AvailableCommands.Open, 100},
+		{"close", ddapi.AvailableCommands.Close, 0},
+		{"pet height", ddapi.AvailableCommands.PartOpen2, 20},
+		{"percent 05", ddapi.AvailableCommands.OpenPercent05, 5},
+		{"percent 95", ddapi.AvailableCommands.OpenPercent95, 95},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newSimHub([]DoorConfig{{ID: "d1", Position: 50}}, func(ddapi.DoorStatus) {})
+			if err := hub.command("d1", tt.cmd); err != nil {
+				t.Fatalf("command(%d) error = %v", tt.cmd, err)
+			}
+			hub.step(time.Second)
+			if got := hub.status().Devices[0].Device.Position; got != tt.want {
+				t.Errorf("position after command(%d) = %d, want %d", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimHub_CommandErrors(t *testing.T) {
+	hub := newSimHub([]DoorConfig{{ID: "d1"}}, func(ddapi.DoorStatus) {})
+
+	var rpcErr *dd.RPCError
+	if err := hub.command("nope", ddapi.AvailableCommands.Open); !errors.As(err, &rpcErr) {
+		t.Errorf("command() on unknown device error = %v, want *dd.RPCError", err)
+	}
+	// Door has no light
+	if err := hub.command("d1", ddapi.AvailableCommands.LightOn); !errors.As(err, &rpcErr) {
+		t.Errorf("command(LightOn) without a light error = %v, want *dd.RPCError", err)
+	}
+}
+
+func TestSimHub_TravelTime(t *testing.T) {
+	var pushes int
+	hub := newSimHub([]DoorConfig{{ID: "d1", TravelTime: 10 * time.Second}}, func(ddapi.DoorStatus) { pushes++ })
+	if err := hub.command("d1", ddapi.AvailableCommands.Open); err != nil {
+		t.Fatalf("command(Open) error = %v", err)
+	}
+
+	hub.step(2 * time.Second)
+	if got := hub.status().Devices[0].Device.Position; got != 20 {
+		t.Errorf("position after 2s = %d, want 20", got)
+	}
+
+	hub.command("d1", ddapi.AvailableCommands.Stop)
+	hub.step(2 * time.Second)
+	if got := hub.status().Devices[0].Device.Position; got != 20 {
+		t.Errorf("position after stop = %d, want 20", got)
+	}
+	// One push per command plus one for the move
+	if pushes != 3 {
+		t.Errorf("pushes = %d, want 3", pushes)
+	}
+}
+
+func TestSimHub_LightAndAux(t *testing.T) {
+	hub := newSimHub([]DoorConfig{{ID: "d1", Light: true, Aux: true}}, func(ddapi.DoorStatus) {})
+	hub.command("d1", ddapi.AvailableCommands.LightOn)
+
+	device := hub.status().Devices[0]
+	if on, ok := device.LightState(); !ok || !on {
+		t.Errorf("LightState() = %v, %v, want on", on, ok)
+	}
+	if on, ok := device.AuxState(); !ok || on {
+		t.Errorf("AuxState() = %v, %v, want off", on, ok)
+	}
+}
+
+func TestSimulator_EndToEnd(t *testing.T) {
+	server := ddtest.NewServer()
+	defer server.Close()
+
+	hub := newSimHub([]DoorConfig{{ID: "d1", Name: "Garage"}}, func(status ddapi.DoorStatus) { server.Push(status) })
+	server.Handle("/app/res/devices/fetch", hub.handleFetch)
+	server.Handle("/app/res/action", hub.handleAction)
+
+	conn := server.Conn()
+	defer conn.Close()
+	if err := conn.Connect(server.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := ddapi.SafeCommand(conn, "d1", ddapi.AvailableCommands.Open); err != nil {
+		t.Fatalf("SafeCommand() error = %v", err)
+	}
+	hub.step(time.Second)
+
+	status, err := ddapi.SafeFetchStatus(conn)
+	if err != nil {
+		t.Fatalf("SafeFetchStatus() error = %v", err)
+	}
+	if device := status.Get("d1"); device == nil || device.Device.Position != 100 {
+		t.Errorf("SafeFetchStatus() device = %+v, want d1 open", device)
+	}
+}
+
+func TestLoadOrWriteCreds(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "creds.json")
+
+	first := ddtest.NewUnstartedServer()
+	if err := loadOrWriteCreds(first, p); err != nil {
+		t.Fatalf("loadOrWriteCreds() writing error = %v", err)
+	}
+
+	// A restarted simulator accepts the credential it wrote before
+	second := ddtest.NewUnstartedServer()
+	if err := loadOrWriteCreds(second, p); err != nil {
+		t.Fatalf("loadOrWriteCreds() reading error = %v", err)
+	}
+	if second.Credential != first.Credential {
+		t.Errorf("loaded credential = %+v, want %+v", second.Credential, first.Credential)
+	}
+}
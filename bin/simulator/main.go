@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+	"github.com/gravypower/dd/helper"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagCredentialsPath = flag.String("credentials", "dd-credentials.json", "credentials file clients connect with; created if missing")
+	flagConfigPath      = flag.String("config", "", "path to optional YAML file describing the doors")
+	flagAddr            = flag.String("addr", "127.0.0.1", "address to listen on")
+	flagPort            = flag.Int("port", 8989, "encrypted API port")
+	flagSDKPort         = flag.Int("sdk-port", 8991, "SDK info port")
+	flagDoors           = flag.Int("doors", 1, "number of doors to simulate without -config")
+	flagTravelTime      = flag.Duration("travelTime", 15*time.Second, "time for a door to fully open or close without -config")
+	flagTick            = flag.Duration("tick", 500*time.Millisecond, "how often moving doors report their position")
+)
+
+// Config is the optional YAML file describing the simulated hub.
+type Config struct {
+	Name  string       `yaml:"name"`
+	Doors []DoorConfig `yaml:"doors"`
+}
+
+// loadConfig reads a Config from a YAML file.
+func loadConfig(p string) (*Config, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	err = yaml.Unmarshal(b, &config)
+	return &config, err
+}
+
+// defaultConfig returns n doors with a light and aux relay, starting closed.
+func defaultConfig(n int, travelTime time.Duration) *Config {
+	config := &Config{}
+	for i := 1; i <= n; i++ {
+		config.Doors = append(config.Doors, DoorConfig{
+			ID:         fmt.Sprintf("sim-door-%d", i),
+			Name:       fmt.Sprintf("Simulated Door %d", i),
+			TravelTime: travelTime,
+			Light:      true,
+			Aux:        true,
+		})
+	}
+	return config
+}
+
+func main() {
+	helper.ParseFlags()
+
+	config := defaultConfig(*flagDoors, *flagTravelTime)
+	if *flagConfigPath != "" {
+		var err error
+		config, err = loadConfig(*flagConfigPath)
+		if err != nil {
+			log.Fatalf("can't load config file %v: %v", *flagConfigPath, err)
+		}
+	}
+	if config.Name == "" {
+		config.Name = "SmartDoor Simulator"
+	}
+
+	server := ddtest.NewUnstartedServer()
+	if err := loadOrWriteCreds(server, *flagCredentialsPath); err != nil {
+		log.Fatal(err)
+	}
+	server.SetInfo(ddapi.BasicInfo{BaseStation: server.Credential.BaseStation, Name: config.Name, Version: 1})
+
+	hub := newSimHub(config.Doors, func(status ddapi.DoorStatus) {
+		if err := server.Push(status); err != nil {
+			log.Printf("failed to queue status: %v", err)
+		}
+	})
+	server.Handle("/app/res/devices/fetch", hub.handleFetch)
+	server.Handle("/app/res/action", hub.handleAction)
+
+	if err := serve(server, *flagAddr, *flagPort, *flagSDKPort); err != nil {
+		log.Fatal(err)
+	}
+	defer server.Close()
+	log.Printf("simulating %d door(s) on %s:%d (SDK port %d), credentials in %s",
+		len(config.Doors), *flagAddr, *flagPort, *flagSDKPort, *flagCredentialsPath)
+
+	stop := make(chan struct{})
+	go hub.run(*flagTick, stop)
+
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
+	<-stopCh
+	close(stop)
+}
+
+// loadOrWriteCreds makes server accept the credential in p, writing the server's own
+// credential there if the file doesn't exist yet.
+func loadOrWriteCreds(server *ddtest.Server, p string) error {
+	creds, err := helper.LoadCreds(p)
+	if err == nil {
+		server.Credential = creds.Credential
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("can't read credentials file %v: %w", p, err)
+	}
+
+	b, err := json.MarshalIndent(ddapi.RegisterResponse{Credential: server.Credential, Name: "simulator"}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, b, 0600); err != nil {
+		return fmt.Errorf("can't write credentials file %v: %w", p, err)
+	}
+	return nil
+}
+
+// serve starts server on addr:port, and serves the same hub over TLS on addr:sdkPort,
+// matching a real hub's separate SDK endpoint.
+func serve(server *ddtest.Server, addr string, port, sdkPort int) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(addr, fmt.Sprint(port)))
+	if err != nil {
+		return err
+	}
+	server.Listener.Close()
+	server.Listener = ln
+	server.StartTLS()
+
+	if sdkPort == port {
+		return nil
+	}
+	sdkLn, err := net.Listen("tcp", net.JoinHostPort(addr, fmt.Sprint(sdkPort)))
+	if err != nil {
+		server.Close()
+		return err
+	}
+	go func() {
+		if err := http.Serve(tls.NewListener(sdkLn, server.TLS), server.Config.Handler); err != nil {
+			log.Printf("SDK endpoint stopped: %v", err)
+		}
+	}()
+	return nil
+}
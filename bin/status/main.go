@@ -0,0 +1,112 @@
+// Command status connects to a hub and prints device status changes as they arrive: a
+// live table by default, or JSON lines with -json.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+)
+
+var (
+	flagCredentialsPath  = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost             = flag.String("host", "", "host to connect to")
+	flagPort             = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort          = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagJSON             = flag.Bool("json", false, "print each change as a line of JSON")
+	flagDebug            = flag.Bool("debug", false, "debug")
+	flagUnsafeLogSecrets = flag.Bool("unsafeLogSecrets", false, "log secrets such as passwords, session secrets and signatures unredacted, for protocol debugging")
+)
+
+// clearScreen moves the cursor home and clears the terminal, for redrawing the table.
+const clearScreen = "\033[H\033[2J"
+
+func main() {
+	helper.ParseFlags()
+	dd.SetUnsafeLogSecrets(*flagUnsafeLogSecrets)
+
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		log.Fatalf("can't open credentials file: %v %v", *flagCredentialsPath, err)
+	}
+
+	conn := dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort, Debug: *flagDebug}
+	err = conn.Connect(creds.Credential)
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	show := printer(os.Stdout, *flagJSON, isTerminal(os.Stdout))
+	w := newWatcher()
+	status, err := ddapi.SafeFetchStatusContext(ctx, &conn)
+	if err != nil {
+		log.Fatalf("could not fetch status: %v", err)
+	}
+	if err := show(w, w.observe(*status, time.Now())); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	runner := dd.NewRunner(&conn, 0)
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+	for m := range runner.Status() {
+		var status ddapi.DoorStatus
+		if err := m.Decode(&status); err != nil {
+			if *flagDebug {
+				log.Printf("ignoring message that is not a door status: %v", err)
+			}
+			continue
+		}
+		changes := w.observe(status, time.Now())
+		if len(changes) == 0 {
+			continue
+		}
+		if err := show(w, changes); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		log.Fatalf("stopped watching: %v", err)
+	}
+}
+
+// printer returns how to print changes: as JSON lines, or by redrawing the table, clearing
+// the screen first on a terminal.
+func printer(out io.Writer, asJSON, terminal bool) func(*watcher, []change) error {
+	if asJSON {
+		return func(_ *watcher, changes []change) error {
+			return writeJSON(out, changes)
+		}
+	}
+	first := true
+	return func(w *watcher, _ []change) error {
+		switch {
+		case terminal:
+			fmt.Fprint(out, clearScreen)
+		case !first:
+			fmt.Fprintln(out)
+		}
+		first = false
+		return w.writeTable(out)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
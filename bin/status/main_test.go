@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+func testDevice(id, name string, position int, logID int64, logText string) ddapi.DoorStatusDevice {
+	device := ddapi.DoorStatusDevice{ID: id, Name: name}
+	device.Device.Position = position
+	device.Log = ddapi.DoorStatusLog{ID: logID, Text: logText}
+	return device
+}
+
+func TestWatcher_Observe(t *testing.T) {
+	w := newWatcher()
+	now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	garage := testDevice("door1", "Garage", 0, 1, "Closed")
+	users := []ddapi.DoorStatusUsers{{Enabled: true, Username: "alice"}}
+
+	tests := []struct {
+		name   string
+		status ddapi.DoorStatus
+		want   [][]string // changed fields of each change
+	}{
+		{"first status", ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{garage}, Users: users},
+			[][]string{{"name", "position", "log"}, {"users"}}},
+		{"unchanged", ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{garage}}, nil},
+		{"position", ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{testDevice("door1", "Garage", 50, 1, "Closed")}},
+			[][]string{{"position"}}},
+		{"log", ddapi.DoorStatus{Devices: []ddapi.DoorStatusDevice{testDevice("door1", "Garage", 50, 2, "Opened by app")}},
+			[][]string{{"log"}}},
+		{"users", ddapi.DoorStatus{Users: []ddapi.DoorStatusUsers{{Enabled: false, Username: "alice"}}},
+			[][]string{{"users"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			for _, c := range w.observe(tt.status, now) {
+				got = append(got, c.Changed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("observe() changed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	status := ddapi.DoorStatus{
+		Devices: []ddapi.DoorStatusDevice{testDevice("door1", "Garage", 50, 1, "Opened by app")},
+		Users:   []ddapi.DoorStatusUsers{{Enabled: true, Username: "alice"}, {Username: "bob"}},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		w := newWatcher()
+		if err := printer(&out, true, false)(w, w.observe(status, now)); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %d lines, want a device and users:\n%s", len(lines), out.String())
+		}
+		var c change
+		if err := json.Unmarshal([]byte(lines[0]), &c); err != nil {
+			t.Fatal(err)
+		}
+		if c.DeviceID != "door1" || c.Position == nil || *c.Position != 50 || c.Log != "Opened by app" || !c.Time.Equal(now) {
+			t.Errorf("device change = %+v, want door1 at 50%% opened by app", c)
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var out bytes.Buffer
+		w := newWatcher()
+		show := printer(&out, false, true)
+		if err := show(w, w.observe(status, now)); err != nil {
+			t.Fatal(err)
+		}
+		got := out.String()
+		for _, want := range []string{clearScreen, "door1", "Garage", "50%", "08:30:00", "Opened by app", "Users: alice, bob (disabled)"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("table missing %q:\n%s", want, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// change is a device or user list that changed, as printed by -json.
+type change struct {
+	Time     time.Time               `json:"time"`
+	DeviceID string                  `json:"deviceId,omitempty"`
+	Name     string                  `json:"name,omitempty"`
+	Position *int                    `json:"position,omitempty"`
+	Log      string                  `json:"log,omitempty"`
+	Users    []ddapi.DoorStatusUsers `json:"users,omitempty"`
+	Changed  []string                `json:"changed"` // the fields that changed
+}
+
+// watcher tracks the latest status to work out what each new one changes.
+type watcher struct {
+	devices map[string]ddapi.DoorStatusDevice
+	updated map[string]time.Time // when each device last changed
+	order   []string             // device IDs, in the order first seen
+	users   []ddapi.DoorStatusUsers
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		devices: make(map[string]ddapi.DoorStatusDevice),
+		updated: make(map[string]time.Time),
+	}
+}
+
+// observe records status, returning what changed. Everything in the first status for a
+// device counts as changed.
+func (w *watcher) observe(status ddapi.DoorStatus, now time.Time) []change {
+	var out []change
+	for _, device := range status.Devices {
+		prev, seen := w.devices[device.ID]
+		var changed []string
+		if !seen || device.Name != prev.Name {
+			changed = append(changed, "name")
+		}
+		if !seen || device.Device.Position != prev.Device.Position {
+			changed = append(changed, "position")
+		}
+		if !seen || device.Log.ID != prev.Log.ID || device.Log.Text != prev.Log.Text {
+			changed = append(changed, "log")
+		}
+		if !seen {
+			w.order = append(w.order, device.ID)
+		}
+		w.devices[device.ID] = device
+		if len(changed) == 0 {
+			continue
+		}
+		w.updated[device.ID] = now
+		position := device.Device.Position
+		out = append(out, change{
+			Time:     now,
+			DeviceID: device.ID,
+			Name:     device.Name,
+			Position: &position,
+			Log:      device.Log.Text,
+			Changed:  changed,
+		})
+	}
+
+	// Device-only updates carry no users, which doesn't mean there are none
+	if len(status.Users) > 0 && !equalUsers(status.Users, w.users) {
+		w.users = status.Users
+		out = append(out, change{Time: now, Users: status.Users, Changed: []string{"users"}})
+	}
+	return out
+}
+
+func equalUsers(a, b []ddapi.DoorStatusUsers) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeJSON writes each change as a line of JSON.
+func writeJSON(out io.Writer, changes []change) error {
+	enc := json.NewEncoder(out)
+	for _, c := range changes {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTable writes every device's latest status as a table, followed by the users.
+func (w *watcher) writeTable(out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tNAME\tPOSITION\tUPDATED\tLOG")
+	for _, id := range w.order {
+		device := w.devices[id]
+		fmt.Fprintf(tw, "%s\t%s\t%d%%\t%s\t%s\n", id, device.Name, device.Device.Position,
+			w.updated[id].Format(time.TimeOnly), device.Log.Text)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(w.users) > 0 {
+		names := make([]string, len(w.users))
+		for i, u := range w.users {
+			names[i] = u.Username
+			if !u.Enabled {
+				names[i] += " (disabled)"
+			}
+		}
+		_, err := fmt.Fprintf(out, "\nUsers: %s\n", strings.Join(names, ", "))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Command tui is a terminal dashboard for a hub: it shows each door's position, light and
+// aux state and recent events, and opens, closes, stops and positions them from the keyboard.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/helper"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	flagCredentialsPath = flag.String("credentials", "dd-credentials.json", "credentials file, or store URI such as keyring://dd/default or env://DD_CREDENTIALS")
+	flagHost            = flag.String("host", "", "host to connect to")
+	flagPort            = flag.Int("port", 0, "encrypted API port (default 8989)")
+	flagSDKPort         = flag.Int("sdk-port", 0, "SDK info port (default 8991)")
+	flagDryRun          = flag.Bool("dryRun", false, "log commands instead of sending them")
+	flagLogFile         = flag.String("log", "", "file to write logs to; they're discarded otherwise, as they'd garble the screen")
+)
+
+func main() {
+	helper.ParseFlags()
+
+	creds, err := helper.LoadCreds(*flagCredentialsPath)
+	if err != nil {
+		log.Fatalf("can't open credentials file: %v %v", *flagCredentialsPath, err)
+	}
+
+	conn := dd.Conn{Host: *flagHost, LocalPort: *flagPort, SDKPortOverride: *flagSDKPort}
+	err = conn.Connect(creds.Credential)
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	status, err := ddapi.SafeFetchStatus(&conn)
+	if err != nil {
+		log.Fatalf("could not fetch status: %v", err)
+	}
+
+	// The library logs through logrus; keep it off the screen
+	logrus.SetOutput(io.Discard)
+	if *flagLogFile != "" {
+		f, err := os.OpenFile(*flagLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			log.Fatalf("can't open log file: %v", err)
+		}
+		defer f.Close()
+		logrus.SetOutput(f)
+	}
+
+	if *flagDryRun {
+		ddapi.EnableDryRun(nil)
+	}
+
+	send := func(ctx context.Context, deviceID string, command int) error {
+		return ddapi.SafeCommandContext(ctx, &conn, deviceID, command)
+	}
+	fetchLogs := func(deviceID string) ([]ddapi.DoorStatusLog, error) {
+		return ddapi.FetchLogs(&conn, deviceID, recentLogs)
+	}
+	m := newModel(*status, send, fetchLogs)
+	p := tea.NewProgram(m, tea.WithAltScreen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := dd.NewRunner(&conn, 0)
+	go func() {
+		err := runner.Run(ctx)
+		if ctx.Err() == nil {
+			p.Send(lostMsg{err})
+		}
+	}()
+	go func() {
+		for msg := range runner.Status() {
+			var status ddapi.DoorStatus
+			if err := msg.Decode(&status); err != nil {
+				continue
+			}
+			p.Send(statusMsg(status))
+		}
+	}()
+
+	if _, err := p.Run(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if m.err != nil {
+		log.Fatalf("lost connection to hub: %v", m.err)
+	}
+}
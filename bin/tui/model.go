@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+// recentLogs is how many log entries are kept and shown for the selected door.
+const recentLogs = 5
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true)
+	selectedStyle = lipgloss.NewStyle().Reverse(true)
+	dimStyle      = lipgloss.NewStyle().Faint(true)
+	errorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+// statusMsg is a status received from the hub.
+type statusMsg ddapi.DoorStatus
+
+// logsMsg is a device's log history, newest first.
+type logsMsg struct {
+	deviceID string
+	entries  []ddapi.DoorStatusLog
+}
+
+// commandMsg reports how a command sent from the keyboard went.
+type commandMsg struct {
+	description string
+	err         error
+}
+
+// lostMsg reports that the connection to the hub was lost.
+type lostMsg struct{ err error }
+
+// model is the dashboard: the doors, the selected one and any position being typed in.
+type model struct {
+	// send sends a command to a device.
+	send func(ctx context.Context, deviceID string, command int) error
+	// fetchLogs returns a device's recent log entries, newest first.
+	fetchLogs func(deviceID string) ([]ddapi.DoorStatusLog, error)
+
+	devices  []ddapi.DoorStatusDevice // in the hub's order
+	logs     map[string][]ddapi.DoorStatusLog
+	selected int
+
+	entering bool   // typing a position
+	input    string // the position typed so far
+	message  string // outcome of the last command
+	failed   bool   // whether message is an error
+	err      error  // why the dashboard quit, if the connection was lost
+}
+
+func newModel(status ddapi.DoorStatus, send func(context.Context, string, int) error, fetchLogs func(string) ([]ddapi.DoorStatusLog, error)) *model {
+	m := &model{send: send, fetchLogs: fetchLogs, logs: make(map[string][]ddapi.DoorStatusLog)}
+	m.update(status)
+	m.selected = 0 // the first door in the hub's order
+	return m
+}
+
+// Init fetches each door's log history.
+func (m *model) Init() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, device := range m.devices {
+		id := device.ID
+		cmds = append(cmds, func() tea.Msg {
+			entries, err := m.fetchLogs(id)
+			if err != nil {
+				// Older hubs can't fetch history; the status still brings new entries
+				return nil
+			}
+			return logsMsg{deviceID: id, entries: entries}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case statusMsg:
+		m.update(ddapi.DoorStatus(msg))
+	case logsMsg:
+		// Keep entries the status brought in while the history was being fetched
+		m.logs[msg.deviceID] = mergeLogs(m.logs[msg.deviceID], msg.entries)
+	case commandMsg:
+		m.failed = msg.err != nil
+		m.message = msg.description
+		if msg.err != nil {
+			m.message = fmt.Sprintf("%s failed: %v", msg.description, msg.err)
+		}
+	case lostMsg:
+		m.err = msg.err
+		return m, tea.Quit
+	case tea.KeyMsg:
+		if m.entering {
+			return m, m.enterPosition(msg)
+		}
+		return m, m.key(msg)
+	}
+	return m, nil
+}
+
+// update records a status, keeping the hub's device order.
+func (m *model) update(status ddapi.DoorStatus) {
+	for _, device := range status.Devices {
+		if device.Log.ID != 0 {
+			m.logs[device.ID] = mergeLogs([]ddapi.DoorStatusLog{device.Log}, m.logs[device.ID])
+		}
+		found := false
+		for i := range m.devices {
+			if m.devices[i].ID == device.ID {
+				m.devices[i] = device
+				found = true
+			}
+		}
+		if !found {
+			m.devices = append(m.devices, device)
+		}
+	}
+	if len(status.DeviceOrder) > 0 {
+		rank := make(map[string]int, len(status.DeviceOrder))
+		for i, id := range status.DeviceOrder {
+			rank[id] = i
+		}
+		var selected string
+		if m.selected < len(m.devices) {
+			selected = m.devices[m.selected].ID
+		}
+		sort.SliceStable(m.devices, func(i, j int) bool {
+			return orderOf(rank, m.devices[i].ID) < orderOf(rank, m.devices[j].ID)
+		})
+		for i := range m.devices {
+			if m.devices[i].ID == selected {
+				m.selected = i
+			}
+		}
+	}
+}
+
+// orderOf returns where id comes in the hub's order, putting unknown IDs last.
+func orderOf(rank map[string]int, id string) int {
+	if i, ok := rank[id]; ok {
+		return i
+	}
+	return len(rank)
+}
+
+// mergeLogs returns newer followed by the entries of older it doesn't already have, up to
+// recentLogs of them.
+func mergeLogs(newer, older []ddapi.DoorStatusLog) []ddapi.DoorStatusLog {
+	out := make([]ddapi.DoorStatusLog, 0, recentLogs)
+	seen := make(map[int64]bool)
+	for _, entries := range [][]ddapi.DoorStatusLog{newer, older} {
+		for _, entry := range entries {
+			if len(out) == recentLogs {
+				return out
+			}
+			if !seen[entry.ID] {
+				seen[entry.ID] = true
+				out = append(out, entry)
+			}
+		}
+	}
+	return out
+}
+
+// key handles a key outside the position prompt.
+func (m *model) key(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "up", "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "down", "j":
+		if m.selected < len(m.devices)-1 {
+			m.selected++
+		}
+	}
+	device, ok := m.current()
+	if !ok {
+		return nil
+	}
+
+	switch msg.String() {
+	case "o":
+		return m.command(device, "Open", ddapi.AvailableCommands.Open)
+	case "c":
+		return m.command(device, "Close", ddapi.AvailableCommands.Close)
+	case "s":
+		return m.command(device, "Stop", ddapi.AvailableCommands.Stop)
+	case "l":
+		on, ok := device.LightState()
+		if !ok {
+			return m.fail("%s has no light", device.Name)
+		}
+		if on {
+			return m.command(device, "Light off", ddapi.AvailableCommands.LightOff)
+		}
+		return m.command(device, "Light on", ddapi.AvailableCommands.LightOn)
+	case "a":
+		on, ok := device.AuxState()
+		if !ok {
+			return m.fail("%s has no aux relay", device.Name)
+		}
+		if on {
+			return m.command(device, "Aux off", ddapi.AvailableCommands.AuxOff)
+		}
+		return m.command(device, "Aux on", ddapi.AvailableCommands.AuxOn)
+	case "p":
+		m.entering = true
+		m.input = ""
+	}
+	return nil
+}
+
+// enterPosition handles a key while a position is being typed in.
+func (m *model) enterPosition(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.entering = false
+	case tea.KeyBackspace:
+		if m.input != "" {
+			m.input = m.input[:len(m.input)-1]
+		}
+	case tea.KeyEnter:
+		m.entering = false
+		device, ok := m.current()
+		position, err := strconv.Atoi(m.input)
+		switch {
+		case !ok:
+		case err != nil || position < ddapi.PositionClosed || position > ddapi.PositionOpen:
+			return m.fail("position must be 0-100")
+		default:
+			return m.command(device, fmt.Sprintf("Move to %d%%", position), ddapi.GetCommandForPosition(position))
+		}
+	case tea.KeyRunes:
+		for _, r := range msg.Runes {
+			if r >= '0' && r <= '9' && len(m.input) < 3 {
+				m.input += string(r)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *model) current() (ddapi.DoorStatusDevice, bool) {
+	if m.selected >= len(m.devices) {
+		return ddapi.DoorStatusDevice{}, false
+	}
+	return m.devices[m.selected], true
+}
+
+// command sends command to device in the background, reporting back with a commandMsg.
+func (m *model) command(device ddapi.DoorStatusDevice, name string, command int) tea.Cmd {
+	description := fmt.Sprintf("%s %s", name, device.Name)
+	if ddapi.DryRun() {
+		description += " (dry run)"
+	}
+	m.message = description + "..."
+	m.failed = false
+	return func() tea.Msg {
+		return commandMsg{description: description, err: m.send(context.Background(), device.ID, command)}
+	}
+}
+
+func (m *model) fail(format string, args ...interface{}) tea.Cmd {
+	m.message = fmt.Sprintf(format, args...)
+	m.failed = true
+	return nil
+}
+
+func (m *model) View() string {
+	var b strings.Builder
+	title := "SmartDoor"
+	if ddapi.DryRun() {
+		title += " (dry run: commands aren't sent)"
+	}
+	b.WriteString(titleStyle.Render(title) + "\n\n")
+	if len(m.devices) == 0 {
+		b.WriteString(dimStyle.Render("No devices") + "\n")
+	}
+	for i, device := range m.devices {
+		row := fmt.Sprintf(" %-20s %4d%%  %-9s %-8s", device.Name, device.Device.Position,
+			onOff("light", device.LightState), onOff("aux", device.AuxState))
+		if i == m.selected {
+			row = selectedStyle.Render(row)
+		}
+		b.WriteString(row + "\n")
+	}
+
+	if device, ok := m.current(); ok {
+		b.WriteString("\n" + titleStyle.Render("Recent events: "+device.Name) + "\n")
+		entries := m.logs[device.ID]
+		if len(entries) == 0 {
+			b.WriteString(dimStyle.Render(" none") + "\n")
+		}
+		for _, entry := range entries {
+			when := time.UnixMilli(entry.Time).Format("Jan 2 15:04:05")
+			b.WriteString(fmt.Sprintf(" %s  %s\n", dimStyle.Render(when), entry.Text))
+		}
+	}
+
+	b.WriteString("\n")
+	switch {
+	case m.entering:
+		b.WriteString(fmt.Sprintf("Position (0-100): %s█\n", m.input))
+	case m.failed:
+		b.WriteString(errorStyle.Render(m.message) + "\n")
+	default:
+		b.WriteString(m.message + "\n")
+	}
+	b.WriteString(dimStyle.Render("↑/↓ select • o open • c close • s stop • p position • l light • a aux • q quit") + "\n")
+	return b.String()
+}
+
+// onOff describes a light or aux relay state, or nothing if the device has none.
+func onOff(name string, state func() (bool, bool)) string {
+	on, ok := state()
+	switch {
+	case !ok:
+		return ""
+	case on:
+		return name + " on"
+	default:
+		return name + " off"
+	}
+}
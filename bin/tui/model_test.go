@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	ddapi "github.com/gravypower/dd/api"
+)
+
+func testDevice(id, name string, position int, logID int64, logText string) ddapi.DoorStatusDevice {
+	device := ddapi.DoorStatusDevice{ID: id, Name: name}
+	device.Device.Position = position
+	device.Log = ddapi.DoorStatusLog{ID: logID, Text: logText}
+	var light ddapi.DoorStatusButton
+	light.Title = "Light"
+	light.Action.Command = ddapi.AvailableCommands.LightOn
+	device.Buttons = []ddapi.DoorStatusButton{light}
+	return device
+}
+
+func testModel(send func(context.Context, string, int) error) *model {
+	status := ddapi.DoorStatus{
+		DeviceOrder: []string{"door1", "door2"},
+		Devices:     []ddapi.DoorStatusDevice{testDevice("door2", "Side", 0, 1, "Closed"), testDevice("door1", "Garage", 100, 2, "Opened by app")},
+	}
+	fetchLogs := func(string) ([]ddapi.DoorStatusLog, error) { return nil, errors.New("unsupported") }
+	return newModel(status, send, fetchLogs)
+}
+
+func keys(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+// press sends m a key and runs the command it returns, feeding the result back.
+func press(m *model, key tea.KeyMsg) {
+	_, cmd := m.Update(key)
+	if cmd == nil {
+		return
+	}
+	if msg := cmd(); msg != nil {
+		m.Update(msg)
+	}
+}
+
+func TestModel_Commands(t *testing.T) {
+	type sent struct {
+		deviceID string
+		command  int
+	}
+	var got []sent
+	m := testModel(func(_ context.Context, deviceID string, command int) error {
+		got = append(got, sent{deviceID, command})
+		return nil
+	})
+
+	press(m, keys("o"))
+	press(m, tea.KeyMsg{Type: tea.KeyDown})
+	press(m, keys("c"))
+	press(m, keys("s"))
+	press(m, keys("l"))
+	press(m, keys("p"))
+	press(m, keys("4"))
+	press(m, keys("5"))
+	press(m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	want := []sent{
+		{"door1", ddapi.AvailableCommands.Open},
+		{"door2", ddapi.AvailableCommands.Close},
+		{"door2", ddapi.AvailableCommands.Stop},
+		{"door2", ddapi.AvailableCommands.LightOn},
+		{"door2", ddapi.AvailableCommands.OpenPercent45},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if !strings.Contains(m.message, "Move to 45% Side") || m.failed {
+		t.Errorf("message = %q, want the position move", m.message)
+	}
+}
+
+func TestModel_Errors(t *testing.T) {
+	m := testModel(func(context.Context, string, int) error { return errors.New("hub said no") })
+
+	press(m, keys("o"))
+	if !m.failed || !strings.Contains(m.message, "hub said no") {
+		t.Errorf("message after a failed command = %q, want the error", m.message)
+	}
+
+	press(m, keys("a"))
+	if !m.failed || !strings.Contains(m.message, "no aux relay") {
+		t.Errorf("message for a door without aux = %q, want an error", m.message)
+	}
+
+	press(m, keys("p"))
+	press(m, keys("200"))
+	press(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if !m.failed || !strings.Contains(m.message, "0-100") {
+		t.Errorf("message for position 200 = %q, want an error", m.message)
+	}
+}
+
+func TestModel_StatusAndView(t *testing.T) {
+	m := testModel(nil)
+
+	m.Update(logsMsg{deviceID: "door1", entries: []ddapi.DoorStatusLog{{ID: 2, Text: "Opened by app"}, {ID: 1, Text: "Closed by remote"}}})
+	m.Update(statusMsg{Devices: []ddapi.DoorStatusDevice{testDevice("door1", "Garage", 40, 3, "Closing")}})
+
+	view := m.View()
+	garage, side := strings.Index(view, "Garage"), strings.Index(view, "Side")
+	if garage < 0 || side < 0 || garage > side {
+		t.Errorf("view doesn't list Garage then Side, in the hub's order:\n%s", view)
+	}
+	for _, want := range []string{"40%", "light off", "Closing", "Opened by app", "Closed by remote"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view missing %q:\n%s", want, view)
+		}
+	}
+	if strings.Count(view, "Opened by app") != 1 {
+		t.Errorf("view repeats a log entry:\n%s", view)
+	}
+
+	if _, cmd := m.Update(lostMsg{errors.New("gone")}); cmd == nil || m.err == nil {
+		t.Errorf("Update(lostMsg) = %v, err %v, want quit with the error", cmd, m.err)
+	}
+}
@@ -2,16 +2,23 @@ package dd
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // API endpoints and versions
@@ -26,11 +33,25 @@ const (
 	DefaultVersion = "2.21.1"
 )
 
-// Timing constants for coordinating request windows with the server (milliseconds).
+// Timeouts of the default HTTP client; see Conn.HTTPClient
 const (
-	// NextAccessBumpMillis is added after signing a request to ensure increasing timestamps.
+	// DefaultDialTimeout is how long to wait for a TCP connection to the hub.
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultHTTPTimeout is how long an HTTP request may take in all, plus Conn.LongPoll.
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// DefaultMaxInFlight is how many session requests a Conn sends at once unless
+// Conn.MaxInFlight says otherwise: enough that a command isn't stuck behind a slow
+// devices/fetch.
+const DefaultMaxInFlight = 2
+
+// Timing constants for coordinating request windows with the server (milliseconds),
+// used by the default AccessLimiter.
+const (
+	// NextAccessBumpMillis is how far ahead of its send time a request is stamped.
 	NextAccessBumpMillis = 1000
-	// NextAccessResetAheadMillis resets nextAccess to a short time in the future after signing.
+	// NextAccessResetAheadMillis is the minimum spacing between signed requests.
 	NextAccessResetAheadMillis = 2000
 )
 
@@ -44,9 +65,36 @@ const (
 	RemoteTarget
 )
 
+// Polling intervals used by Subscribe
+var (
+	subscribeMinInterval      = 2 * time.Second
+	subscribeMaxIdleInterval  = 8 * time.Second
+	subscribeMaxErrorInterval = time.Minute
+	// subscribeMaxFailures consecutive failed polls end a subscription
+	subscribeMaxFailures = 5
+)
+
+// Polling intervals used while waiting for an RPC's response
+var (
+	waitForPidMinInterval = 350 * time.Millisecond
+	waitForPidMaxInterval = 3 * time.Second
+)
+
 var (
 	ErrTimeout = errors.New("RPC call timeout")
-	logger     = logrus.New()
+	// ErrSessionExpired is returned when the hub no longer accepts our session, e.g. after a reboot.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrUnreachable is returned when a request never left because the host couldn't be
+	// resolved or connected to, e.g. it's down or not on this network.
+	ErrUnreachable = errors.New("host unreachable")
+	// ErrNoResponse is returned when a request was sent, or may have been, but got no HTTP
+	// response, e.g. the connection was reset or timed out. The hub may have acted on it.
+	ErrNoResponse = errors.New("no response")
+	// ErrInvalidConnMode is returned by ParseConnMode for an unknown mode.
+	ErrInvalidConnMode = errors.New("mode must be local, cloud or auto")
+	// ErrNotConnected is returned by calls that need a session before Connect has succeeded.
+	ErrNotConnected = errors.New("not connected")
+	logger          = logrus.New()
 )
 
 func init() {
@@ -56,21 +104,38 @@ func init() {
 		ForceColors:   true,
 	})
 	logger.SetLevel(logrus.InfoLevel)
+	logger.AddHook(RedactHook{})
+}
+
+// SetLogger replaces the package logger used by connections without their own Logger.
+func SetLogger(l *logrus.Logger) {
+	if l != nil {
+		logger = l
+	}
 }
 
 // Messages decodes the list of Message instances in this genericResponse, if any.
-func (gr *genericResponse) Messages() (out []*Message, err error) {
+func (gr *genericResponse) Messages() ([]*Message, error) {
 	if len(gr.RawMessages) == 0 {
 		return nil, nil // nothing in this payload
 	}
-	err = json.Unmarshal([]byte(gr.RawMessages), &out)
-	return out, err
+	var out []*Message
+	if err := json.Unmarshal([]byte(gr.RawMessages), &out); err != nil {
+		return nil, &DecodeError{Op: DecodeJSON, Err: err}
+	}
+	for i, m := range out {
+		if m == nil {
+			return nil, &DecodeError{Op: DecodeJSON, Err: fmt.Errorf("message %d is null", i)}
+		}
+	}
+	return out, nil
 }
 
+// Decode unmarshals the decrypted message into target, returning a *DecodeError if it
+// isn't exactly one JSON value.
 func (m *Message) Decode(target interface{}) error {
-	err := json.NewDecoder(bytes.NewBuffer(m.DecodedMessage)).Decode(target)
-	if err != nil {
-		return err
+	if err := json.Unmarshal(m.DecodedMessage, target); err != nil {
+		return &DecodeError{Op: DecodeJSON, Err: err}
 	}
 
 	// Log the decrypted message
@@ -83,35 +148,53 @@ func (m *Message) Decode(target interface{}) error {
 	return nil
 }
 
+// ParseConnMode parses a mode name as given on a command line: local (or empty), cloud or auto.
+func ParseConnMode(s string) (ConnMode, error) {
+	switch s {
+	case "", "local":
+		return LocalMode, nil
+	case "cloud":
+		return CloudMode, nil
+	case "auto":
+		return AutoMode, nil
+	}
+	return LocalMode, fmt.Errorf("%w: %q", ErrInvalidConnMode, s)
+}
+
 // SimpleRequest performs a simple request to our device, without session logic.
 func (dc *Conn) SimpleRequest(arg SimpleRequest) error {
+	return dc.SimpleRequestContext(context.Background(), arg)
+}
+
+// SimpleRequestContext is SimpleRequest, cancelling the HTTP request when ctx is done.
+func (dc *Conn) SimpleRequestContext(ctx context.Context, arg SimpleRequest) error {
+	_, err := dc.simpleRequest(ctx, arg)
+	return err
+}
+
+// simpleRequest is SimpleRequestContext, also returning the HTTP status code, which is 0
+// if no response arrived.
+func (dc *Conn) simpleRequest(ctx context.Context, arg SimpleRequest) (int, error) {
 	if len(arg.Path) > 0 && arg.Path[0] != '/' {
-		return fmt.Errorf("path must start with /, got: %v", arg.Path)
+		return 0, fmt.Errorf("path must start with /, got: %v", arg.Path)
 	}
 
 	jsonBytes, err := json.Marshal(arg.Input)
 	if err != nil {
-		return fmt.Errorf("marshal input: %w", err)
+		return 0, fmt.Errorf("marshal input: %w", err)
 	}
 
-	var url string
-	switch arg.Target {
-	case RemoteTarget:
-		url = fmt.Sprintf("https://%s%s", RemoteAPIBase, arg.Path)
-	case SDKTarget:
-		url = fmt.Sprintf("https://%s:%d%s", dc.Host, SDKPort, arg.Path)
-	case DefaultTarget:
-		url = fmt.Sprintf("https://%s:%d%s", dc.Host, DefaultPort, arg.Path)
-	default:
-		return fmt.Errorf("unknown target: %v", arg.Target)
+	url, err := dc.targetURL(arg.Target, arg.Path)
+	if err != nil {
+		return 0, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, connTrace(ctx)), "POST", url, bytes.NewBuffer(jsonBytes))
 	if err != nil {
-		return fmt.Errorf("new request: %w", err)
+		return 0, fmt.Errorf("new request: %w", err)
 	}
 
-	logger.WithFields(logrus.Fields{
+	dc.log().WithFields(logrus.Fields{
 		"url":     url,
 		"payload": string(jsonBytes),
 	}).Debug("Sending request")
@@ -125,42 +208,105 @@ func (dc *Conn) SimpleRequest(arg SimpleRequest) error {
 	req.Header.Set("version", version)
 	req.Header.Set("platform", "android")
 
-	// Ensure HTTP client is initialized
-	if err := dc.ensureHTTPClient(); err != nil {
-		return err
-	}
-
-	resp, err := dc.client.Do(req)
+	resp, err := dc.httpClient().Do(req)
 	if err != nil {
-		return fmt.Errorf("do request: %w", err)
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("do request: %w", err)
+		}
+		if unreachable(err) {
+			return 0, fmt.Errorf("%w: do request: %w", ErrUnreachable, err)
+		}
+		return 0, fmt.Errorf("%w: do request: %w", ErrNoResponse, err)
 	}
 	defer func(Body io.ReadCloser) {
 		if cerr := Body.Close(); cerr != nil {
-			logger.WithError(cerr).Error("failed to close response body")
+			dc.log().WithError(cerr).Error("failed to close response body")
 		}
 	}(resp.Body)
 
 	responseBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("read body: %w", err)
+		return resp.StatusCode, fmt.Errorf("read body: %w", err)
 	}
 
-	logger.WithFields(logrus.Fields{
+	dc.log().WithFields(logrus.Fields{
 		"statusCode": resp.StatusCode,
 		"response":   string(responseBytes),
 	}).Debug("Received HTTP response")
-	logger.Debugf("Response headers: %+v", resp.Header)
+	dc.log().Debugf("Response headers: %+v", resp.Header)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("non-2xx status code for target=%v path=%v: %v (len=%d)",
+		err := fmt.Errorf("non-2xx status code for target=%v path=%v: %v (len=%d)",
 			arg.Target, arg.Path, resp.Status, len(responseBytes))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return resp.StatusCode, fmt.Errorf("%w: %w", ErrSessionExpired, err)
+		}
+		return resp.StatusCode, err
 	}
 
-	return json.Unmarshal(responseBytes, arg.Output)
+	return resp.StatusCode, json.Unmarshal(responseBytes, arg.Output)
 }
 
-func (dc *Conn) genericRequest(greq *genericRequest) (*genericResponse, error) {
-	isOnline := dc.RequestMode && greq.requestIfOnline
+// targetURL builds the URL for path on the given target, applying any host or port overrides.
+func (dc *Conn) targetURL(target SimpleRequestTarget, path string) (string, error) {
+	switch target {
+	case RemoteTarget:
+		remoteHost := dc.RemoteHost
+		if remoteHost == "" {
+			remoteHost = RemoteAPIBase
+		}
+		return fmt.Sprintf("https://%s%s", remoteHost, path), nil
+	case SDKTarget:
+		port := dc.SDKPortOverride
+		if port == 0 {
+			port = SDKPort
+		}
+		return fmt.Sprintf("https://%s:%d%s", dc.Host, port, path), nil
+	case DefaultTarget:
+		port := dc.LocalPort
+		if port == 0 {
+			port = DefaultPort
+		}
+		return fmt.Sprintf("https://%s:%d%s", dc.Host, port, path), nil
+	default:
+		return "", fmt.Errorf("unknown target: %v", target)
+	}
+}
+
+// genericRequest sends greq and processes the response, reporting both to any Hooks and
+// Recorder.
+func (dc *Conn) genericRequest(ctx context.Context, greq *genericRequest) (*genericResponse, error) {
+	hooks := dc.tracer()
+	if hooks == nil {
+		_, gresp, err := dc.sendGenericRequest(ctx, greq)
+		return gresp, err
+	}
+
+	path, processID := greq.Path, greq.ProcessID
+	hooks.OnRequest(RequestEvent{Path: path, ProcessID: processID, Payload: greq.plainData})
+	start := time.Now()
+	status, gresp, err := dc.sendGenericRequest(ctx, greq)
+	event := ResponseEvent{
+		Path:       path,
+		ProcessID:  processID,
+		Duration:   time.Since(start),
+		StatusCode: status,
+		Err:        err,
+	}
+	if gresp != nil {
+		event.Payload = gresp.inlineResponse
+	}
+	hooks.OnResponse(event)
+	return gresp, err
+}
+
+// sendGenericRequest does the work of genericRequest, also returning the HTTP status code.
+func (dc *Conn) sendGenericRequest(ctx context.Context, greq *genericRequest) (int, *genericResponse, error) {
+	target := DefaultTarget
+	if dc.cloud.Load() {
+		target = RemoteTarget
+	}
+	isOnline := (dc.RequestMode || target == RemoteTarget) && greq.requestIfOnline
 	var part string
 	if isOnline {
 		part = "/app/res/request"
@@ -170,35 +316,59 @@ func (dc *Conn) genericRequest(greq *genericRequest) (*genericResponse, error) {
 	}
 
 	gresp := genericResponse{}
-	err := dc.SimpleRequest(SimpleRequest{
+	status, err := dc.simpleRequest(ctx, SimpleRequest{
 		Path:   part,
+		Target: target,
 		Input:  greq,
 		Output: &gresp,
 	})
 	if err != nil {
-		return nil, err
+		return status, nil, err
+	}
+
+	if err := dc.verifyResponse(ctx, greq, &gresp); err != nil {
+		return status, nil, err
+	}
+	if gresp.IsBasestationOnline != nil {
+		dc.basestationOnline.Store(*gresp.IsBasestationOnline)
 	}
 
 	// fetch and append messages to queue (some are returned to us as part of this call)
 	messages, err := gresp.Messages()
 	if err != nil {
-		return nil, err
+		return status, nil, err
 	}
+	hooks := dc.tracer()
+	suite := dc.responseSuite(&gresp)
 	for _, message := range messages {
-		b, err := message.readData(dc.phoneSecret)
+		if err := dc.verifyMessage(ctx, greq, message); err != nil {
+			return status, nil, err
+		}
+		if dc.replayed(ctx, message) {
+			continue
+		}
+		b, err := message.readData(suite, greq.phoneSecret)
 		if err != nil {
-			return nil, err
+			return status, nil, err
 		}
 
-		logger.WithFields(logrus.Fields{
+		dc.log().WithFields(logrus.Fields{
 			"messageHeader": message,
 			"decoded":       string(b),
 		}).Debug("Got message from response")
 
 		message.DecodedMessage = b
+		if hooks != nil {
+			hooks.OnMessage(MessageEvent{
+				ProcessID: message.ProcessID,
+				Type:      message.Type,
+				Sequence:  message.Sequence,
+				Payload:   b,
+			})
+		}
 
 		if message.ProcessID == "" {
-			dc.pendingMessages = append(dc.pendingMessages, message)
+			dc.pending.push(message)
 			continue
 		}
 
@@ -223,110 +393,239 @@ func (dc *Conn) genericRequest(greq *genericRequest) (*genericResponse, error) {
 		}
 		dc.unresolvedMutex.Unlock()
 
-		logger.Debugf("Dropping unknown response: %+v", message)
+		dc.log().Debugf("Dropping unknown response: %+v", message)
 	}
 
 	// fail if there's a server-reported error message
 	if gresp.Message != "" {
-		return nil, fmt.Errorf("got error message: %v", gresp.Message)
+		if strings.Contains(strings.ToLower(gresp.Message), "session") {
+			return status, nil, fmt.Errorf("%w: got error message: %v", ErrSessionExpired, gresp.Message)
+		}
+		return status, nil, fmt.Errorf("got error message: %v", gresp.Message)
 	}
 
-	return &gresp, nil
+	return status, &gresp, nil
 }
 
-func (dc *Conn) signedRequest(conf requestConfig) (*genericRequest, error) {
+// signedRequest signs a request sent at stamp on the current session. The caller must hold
+// genericRequestMutex.
+func (dc *Conn) signedRequest(conf requestConfig, stamp int) (*genericRequest, error) {
 	sessionSig := newHubSignature(dc.sessionSecret)
 	phoneSig := newHubSignature(dc.phoneSecretRaw)
 
-	// Use local time or nextAccess time, whichever is greater
-	localTime := int(time.Now().UnixNano() / 1e6)
-	if localTime < dc.nextAccess {
-		waitTime := time.Duration(dc.nextAccess-localTime) * time.Millisecond
-		logger.WithField("waitTime", waitTime).Debug("Waiting until nextAccess")
-		time.Sleep(waitTime)
-	}
-
-	// Update nextAccess after waiting to ensure a monotonic-ish increasing value
-	localTime = int(time.Now().UnixNano() / 1e6)
-	if localTime > dc.nextAccess {
-		dc.nextAccess = localTime
-	}
-	dc.nextAccess += NextAccessBumpMillis
-
 	// Create an encrypted request
-	c, err := NewEncCipher(dc.phoneSecret, dc.nextAccess)
+	encrypted, err := dc.CipherSuite().Encrypt(dc.phoneSecret, stamp, conf.data)
 	if err != nil {
-		return nil, fmt.Errorf("init cipher: %w", err)
+		return nil, fmt.Errorf("encrypt request: %w", err)
 	}
-	encData := base64.StdEncoding.EncodeToString(c.Encrypt(conf.data))
+	encData := base64.StdEncoding.EncodeToString(encrypted)
 
 	dc.sequenceIDSuffix++ // Increment to track replies so process is unique
 	greq := &genericRequest{
 		ProcessID:        fmt.Sprintf("%s-%d", dc.processID, dc.sequenceIDSuffix),
 		SessionID:        dc.sessionID,
-		SessionSignature: sessionSig.Update(dc.nextAccess, encData),
-		PhoneSignature:   phoneSig.Update(dc.nextAccess, encData),
+		SessionSignature: sessionSig.Update(stamp, encData),
+		PhoneSignature:   phoneSig.Update(stamp, encData),
 		dataPayload: dataPayload{
-			Time:        dc.nextAccess,
+			Time:        stamp,
 			Data:        encData,
 			IsEncrypted: true,
 		},
 		Path:            conf.path,
 		requestIfOnline: conf.requestIfOnline,
+		plainData:       conf.data,
+		sessionSecret:   dc.sessionSecret,
+		phoneSecret:     dc.phoneSecret,
+		phoneSecretRaw:  dc.phoneSecretRaw,
 	}
 
 	// Only need the BaseStation, not the rest of the credential
 	greq.Credential.BaseStation = dc.cred.BaseStation
 
-	logger.WithFields(logrus.Fields{
-		"path":       conf.path,
-		"processID":  greq.ProcessID,
-		"nextAccess": dc.nextAccess,
+	dc.log().WithFields(logrus.Fields{
+		"path":      conf.path,
+		"processID": greq.ProcessID,
+		"time":      stamp,
 	}).Debug("Generated signed request")
 
-	dc.nextAccess = int(time.Now().UnixNano()/1e6) + NextAccessResetAheadMillis
+	return greq, nil
+}
 
-	logger.WithFields(logrus.Fields{
-		"nextAccess": dc.nextAccess,
-		"aheadMs":    NextAccessResetAheadMillis,
-	}).Debug("Next access time updated")
+// limiter returns the connection's RateLimiter, creating the default one if unset.
+func (dc *Conn) limiter() RateLimiter {
+	dc.limiterOnce.Do(func() {
+		if dc.RateLimiter == nil {
+			dc.RateLimiter = newDefaultLimiter()
+		}
+	})
+	return dc.RateLimiter
+}
 
-	return greq, nil
+// log returns the logger for this connection.
+func (dc *Conn) log() *logrus.Logger {
+	if dc.Logger != nil {
+		return dc.Logger
+	}
+	return logger
+}
+
+// IsBasestationOnline reports whether the server last said the base station was online.
+// It's false until Connect succeeds.
+func (dc *Conn) IsBasestationOnline() bool {
+	return dc.basestationOnline.Load()
+}
+
+// IsPasswordExpired reports whether the hub said at connect that the user's password has
+// expired; see api.ChangeUserPassword.
+func (dc *Conn) IsPasswordExpired() bool {
+	return dc.passwordExpired.Load()
+}
+
+// IsCloud reports whether the current session goes through the cloud API rather than the
+// LAN; see ConnMode.
+func (dc *Conn) IsCloud() bool {
+	return dc.cloud.Load()
+}
+
+// BaseStation returns the base station ID of the credential last passed to Connect.
+func (dc *Conn) BaseStation() string {
+	return dc.cred.BaseStation
 }
 
-// Close shuts down this Conn.
-// ensureHTTPClient initializes the HTTP client if it doesn't exist.
-func (dc *Conn) ensureHTTPClient() error {
+// HubVersion returns the hub version reported at connect.
+func (dc *Conn) HubVersion() int {
+	return int(dc.hubVersion.Load())
+}
+
+// CipherSuite returns the cipher suite for the hub's version; see RegisterCipherSuite.
+func (dc *Conn) CipherSuite() CipherSuite {
+	return CipherSuiteFor(dc.HubVersion())
+}
+
+// responseSuite returns the cipher suite to decrypt gresp with: that of the hub version it
+// reports, as a connect response does before the version is stored, or else the session's.
+func (dc *Conn) responseSuite(gresp *genericResponse) CipherSuite {
+	if gresp.HubVersion != 0 {
+		return CipherSuiteFor(gresp.HubVersion)
+	}
+	return dc.CipherSuite()
+}
+
+// unreachable reports whether err, from sending a request, means the request never left:
+// the host couldn't be resolved or connected to. Anything later, such as a reset or a
+// timeout, may come after the hub has read the request.
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &dnsErr)
+}
+
+// httpClient returns the HTTP client, making it on first use: HTTPClient if set, or a
+// client with default timeouts around Transport or a transport built from TLSConfig.
+func (dc *Conn) httpClient() *http.Client {
+	dc.clientMutex.Lock()
+	defer dc.clientMutex.Unlock()
 	if dc.client != nil {
-		return nil
+		return dc.client
 	}
-	customTransport := http.DefaultTransport.(*http.Transport).Clone()
-	// WARNING: For production, you should NOT use InsecureSkipVerify = true.
-	customTransport.TLSClientConfig.InsecureSkipVerify = true
-	dc.client = &http.Client{Transport: customTransport}
-	return nil
+	if dc.HTTPClient != nil {
+		dc.client = dc.HTTPClient
+		return dc.client
+	}
+	transport := dc.Transport
+	if transport == nil {
+		// Like http.DefaultTransport, honoring HTTPS_PROXY, but quicker to give up on a hub
+		// that isn't there
+		customTransport := http.DefaultTransport.(*http.Transport).Clone()
+		customTransport.DialContext = (&net.Dialer{Timeout: DefaultDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+		if dc.TLSConfig != nil {
+			customTransport.TLSClientConfig = dc.TLSConfig.Clone()
+		} else {
+			// WARNING: For production, you should NOT use InsecureSkipVerify = true.
+			// Set TLSConfig (e.g. PinnedTLSConfig) for real transport security.
+			customTransport.TLSClientConfig.InsecureSkipVerify = true
+		}
+		dc.Pool.apply(customTransport)
+		transport = customTransport
+	}
+	// A long poll is held open at the hub, so it gets that much longer
+	dc.client = &http.Client{Transport: transport, Timeout: DefaultHTTPTimeout + dc.LongPoll}
+	return dc.client
 }
 
-// Close shuts down this Conn.
+// Close shuts down this Conn, closing the idle connections of its default client.
 func (dc *Conn) Close() {
+	dc.clientMutex.Lock()
+	defer dc.clientMutex.Unlock()
 	if dc.client != nil {
-		dc.client.CloseIdleConnections()
+		if dc.client != dc.HTTPClient {
+			dc.client.CloseIdleConnections()
+		}
 		dc.client = nil
 	}
 }
 
+// generateProcessID returns a random process ID, used as the prefix for request process IDs.
+// The timestamp is taken from a single clock read as UTC epoch seconds, so it doesn't
+// depend on the local time zone. Uniqueness across processes relies on the random
+// suffix, not the timestamp.
+func generateProcessID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate process ID: %w", err)
+	}
+	now := time.Now().UTC()
+	return fmt.Sprintf("%d-E--%s", now.Unix(), hex.EncodeToString(b)), nil
+}
+
 // Connect passes credentials to the server and sets up secrets.
 func (dc *Conn) Connect(cred Credential) error {
-	// If dc.Debug == true, we allow Debug logs
-	if dc.Debug {
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
-		logger.SetLevel(logrus.InfoLevel)
+	return dc.ConnectContext(context.Background(), cred)
+}
+
+// ConnectContext is Connect, abandoning the handshake when ctx is done.
+func (dc *Conn) ConnectContext(ctx context.Context, cred Credential) error {
+	// If dc.Debug == true, we allow Debug logs. A caller-supplied Logger keeps its own level.
+	if dc.Logger == nil {
+		if dc.Debug {
+			logger.SetLevel(logrus.DebugLevel)
+		} else {
+			logger.SetLevel(logrus.InfoLevel)
+		}
 	}
 
 	dc.cred = cred
 	dc.unresolvedRPC = make(map[string]chan *Message)
 
+	return dc.handshake(ctx, cred)
+}
+
+// handshake runs the connect exchange, replacing the session. Pending RPCs are left in place
+// so that a renewed session can still deliver their responses. In AutoMode it tries the LAN
+// first, then the cloud if the hub can't be reached or doesn't answer there.
+func (dc *Conn) handshake(ctx context.Context, cred Credential) error {
+	switch dc.Mode {
+	case CloudMode:
+		dc.cloud.Store(true)
+	case AutoMode:
+		if dc.Host != "" {
+			dc.cloud.Store(false)
+			// Connecting twice is harmless, so a connect the hub may have read falls back too
+			err := dc.connectSession(ctx, cred)
+			if !errors.Is(err, ErrUnreachable) && !errors.Is(err, ErrNoResponse) {
+				return err
+			}
+			dc.log().WithError(err).Warn("Hub unreachable on the LAN; connecting through the cloud")
+		}
+		dc.cloud.Store(true)
+	default:
+		dc.cloud.Store(false)
+	}
+	return dc.connectSession(ctx, cred)
+}
+
+// connectSession runs the connect exchange on the current route.
+func (dc *Conn) connectSession(ctx context.Context, cred Credential) error {
 	greq := &genericRequest{
 		Credential:        cred,
 		CommunicationType: 3, // 1 and 3 are valid
@@ -335,15 +634,19 @@ func (dc *Conn) Connect(cred Credential) error {
 	// The phoneSecret is not sent in the JSON body
 	greq.Credential.PhoneSecret = ""
 
-	// create 'random' processID
-	now := time.Now()
-	dc.processID = fmt.Sprintf("%d-E--%d", now.Unix(), now.Unix()*1e9-now.UnixNano())
+	processID, err := generateProcessID()
+	if err != nil {
+		return err
+	}
+	dc.processID = processID
 
 	// Derive or store the phone secrets
 	dc.phoneSecret = md5hash(cred.PhoneSecret)
 	dc.phoneSecretRaw = []byte(cred.PhoneSecret)
+	greq.phoneSecret = dc.phoneSecret
+	greq.phoneSecretRaw = dc.phoneSecretRaw
 
-	gresp, err := dc.genericRequest(greq)
+	gresp, err := dc.genericRequest(ctx, greq)
 	if err != nil {
 		return err
 	}
@@ -352,88 +655,199 @@ func (dc *Conn) Connect(cred Credential) error {
 	if len(gresp.dataPayload.Data) == 0 {
 		return errors.New("no valid payload from connect")
 	}
-	err = gresp.unmarshalData(dc.phoneSecret, crd)
+	suite := dc.responseSuite(gresp)
+	err = gresp.unmarshalData(suite, dc.phoneSecret, crd)
 	if err != nil {
 		return err
 	}
 
 	dc.sessionID = gresp.SessionID
 	dc.sessionSecret = []byte(gresp.SessionSecret)
-	dc.nextAccess = crd.UserAccess.NextAccess
+	dc.replay.reset(gresp.ServerTime, time.Now())
+	dc.limiter().Reset(crd.UserAccess)
+	dc.passwordExpired.Store(crd.IsPasswordExpired)
+	dc.hubVersion.Store(int64(gresp.HubVersion))
+	dc.responded()
 
 	// Example of structured logging with a single field "basicInfo"
 	basicInfo := map[string]interface{}{
 		"sessionID": dc.sessionID,
 		"secret":    gresp.SessionSecret,
 		"next":      crd.UserAccess.NextAccess,
+		"cipher":    suite.Name(),
 	}
-	logger.WithField("basicInfo", basicInfo).
+	dc.log().WithField("basicInfo", basicInfo).
 		Debug("Fetched basic information about the connection")
 
 	return nil
 }
 
 // internalMessages does a messages poll, adding to any pending messages and resolving pending RPCs.
-func (dc *Conn) internalMessages() error {
-	dc.genericRequestMutex.Lock()
-	defer dc.genericRequestMutex.Unlock()
+// It's traced as a dd.messages.poll span and counted on the dd.messages instruments.
+func (dc *Conn) internalMessages(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "dd.messages.poll", attribute.Bool("dd.long_poll", dc.LongPoll > 0))
+	start := time.Now()
+	count, err := dc.pollMessages(ctx)
+	recordPoll(ctx, time.Since(start), count, err)
+	span.SetAttributes(attribute.Int("dd.messages.count", count))
+	endSpan(span, err)
+	return err
+}
 
-	greq, err := dc.signedRequest(requestConfig{path: "app/res/messages"})
-	if err != nil {
-		return err
+// pollMessages does the work of internalMessages, returning how many messages came back.
+func (dc *Conn) pollMessages(ctx context.Context) (int, error) {
+	// Polls give way to RPCs waiting for the same access slot
+	ctx = WithPriority(ctx, priorityFrom(ctx, PriorityBackground))
+	if dc.LongPoll > 0 {
+		return dc.longPollMessages(ctx)
 	}
-	gresp, err := dc.genericRequest(greq)
+
+	gresp, _, err := dc.sessionRequest(ctx, requestConfig{path: "app/res/messages"})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	// genericRequest has already queued status messages and resolved pending RPCs
 	messages, err := gresp.Messages()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	logger.WithField("messageCount", len(messages)).Debug("Fetched messages")
+	dc.log().WithField("messageCount", len(messages)).Debug("Fetched messages")
 
-	for _, message := range messages {
-		logger.WithField("processID", message.ProcessID).Info("Processing message")
+	return len(messages), nil
+}
 
-		b, err := message.readData(dc.phoneSecret)
-		if err != nil {
-			logger.WithError(err).Error("Failed to decode message")
-			continue
-		}
-		message.DecodedMessage = b
+// longPollMessages does a messages poll that the hub may hold open for up to LongPoll.
+// It doesn't take one of the MaxInFlight slots, so RPCs can go out while the poll waits.
+// If the session has expired, it reconnects and falls back to an ordinary poll.
+func (dc *Conn) longPollMessages(ctx context.Context) (int, error) {
+	data, err := json.Marshal(longPollRequest{AppTimeout: int(dc.LongPoll.Milliseconds())})
+	if err != nil {
+		return 0, err
+	}
 
-		if message.ProcessID != "" {
-			dc.unresolvedMutex.Lock()
-			if ch, exists := dc.unresolvedRPC[message.ProcessID]; exists {
-				ch <- message
-				delete(dc.unresolvedRPC, message.ProcessID)
-			}
-			dc.unresolvedMutex.Unlock()
-		} else {
-			dc.pendingMessages = append(dc.pendingMessages, message)
-		}
+	greq, err := dc.sign(ctx, requestConfig{path: "app/res/messages", data: data})
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	gresp, err := dc.genericRequest(ctx, greq)
+	if errors.Is(err, ErrSessionExpired) {
+		gresp, _, err = dc.sessionRequest(ctx, requestConfig{path: "app/res/messages"})
+	}
+	if err != nil {
+		return 0, err
+	}
+	messages, err := gresp.Messages()
+	return len(messages), err
 }
 
 // Messages gets any pending status messages from the server.
 func (dc *Conn) Messages() ([]*Message, error) {
-	if len(dc.pendingMessages) == 0 {
-		if err := dc.internalMessages(); err != nil {
-			return nil, err
-		}
+	return dc.MessagesContext(context.Background())
+}
+
+// MessagesContext is Messages, cancelling the poll when ctx is done.
+func (dc *Conn) MessagesContext(ctx context.Context) ([]*Message, error) {
+	if out := dc.pending.take(); len(out) > 0 {
+		return out, nil
 	}
+	if err := dc.internalMessages(ctx); err != nil {
+		return nil, err
+	}
+	return dc.pending.take(), nil
+}
 
-	out := dc.pendingMessages
-	dc.pendingMessages = nil
-	return out, nil
+// AllMessages always polls the server, then returns the newly fetched messages
+// along with any that were already pending (e.g. delivered inline with an RPC).
+func (dc *Conn) AllMessages() ([]*Message, error) {
+	if err := dc.internalMessages(context.Background()); err != nil {
+		return nil, err
+	}
+	return dc.pending.take(), nil
 }
 
-// Request makes a signed generic RPC and waits until its response is available.
+// Subscribe polls for messages in the background and delivers them on the returned channel,
+// which is closed once ctx is done. Polling speeds up while messages are arriving, slows down
+// while the hub is idle, and backs off further on errors, which are logged rather than returned.
+// With LongPoll set, each poll waits at the hub instead, and the next starts as soon as it
+// returns. If several polls in a row fail the connection is considered lost and the channel
+// is closed early; the caller should reconnect and subscribe again.
+func (dc *Conn) Subscribe(ctx context.Context) (<-chan *Message, error) {
+	if dc.sessionID == "" {
+		return nil, ErrNotConnected
+	}
+
+	ch := make(chan *Message)
+	go func() {
+		defer close(ch)
+
+		interval := subscribeMinInterval
+		retry := Backoff{Base: 2 * subscribeMinInterval, Max: subscribeMaxErrorInterval, Jitter: DefaultBackoffJitter}
+		failures := 0
+		for {
+			messages, err := dc.MessagesContext(ctx)
+			if err == nil {
+				failures = 0
+				retry.Reset()
+			}
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				failures++
+				if failures >= subscribeMaxFailures {
+					dc.log().WithError(err).WithField("failures", failures).Error("Giving up polling messages")
+					return
+				}
+				interval = retry.Next()
+				dc.log().WithError(err).WithField("retryIn", interval).Warn("Failed to poll messages")
+			case dc.LongPoll > 0:
+				// The hub already waited for messages; poll again straight away
+				interval = 0
+			case len(messages) > 0:
+				interval = subscribeMinInterval
+			default:
+				interval = min(interval*3/2, subscribeMaxIdleInterval)
+			}
+
+			for _, m := range messages {
+				select {
+				case ch <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// RPC makes a signed generic RPC and waits until its response is available.
 func (dc *Conn) RPC(rpc RPC) error {
+	return dc.RPCContext(context.Background(), rpc)
+}
+
+// RPCContext is RPC, giving up on the request or on waiting for its response when ctx is done.
+// It's traced as a dd.rpc span; see SetTracerProvider.
+func (dc *Conn) RPCContext(ctx context.Context, rpc RPC) error {
+	ctx, span := startSpan(ctx, "dd.rpc", attribute.String("dd.rpc.path", rpc.Path))
+	err := dc.rpc(ctx, rpc)
+	endSpan(span, err)
+	return err
+}
+
+// rpc does the work of RPCContext.
+func (dc *Conn) rpc(ctx context.Context, rpc RPC) error {
 	var err error
 	var b []byte
 
@@ -452,29 +866,20 @@ func (dc *Conn) RPC(rpc RPC) error {
 		path = rpc.Path[1:]
 	}
 
-	// Wrap sign/send in inner fn so we can lock while it occurs.
-	resp, pid, err := func() (*genericResponse, string, error) {
-		dc.genericRequestMutex.Lock()
-		defer dc.genericRequestMutex.Unlock()
-
-		greq, err := dc.signedRequest(requestConfig{data: b, path: path, requestIfOnline: true})
-		if err != nil {
-			return nil, "", err
-		}
-
-		resp, err := dc.genericRequest(greq)
-		return resp, greq.ProcessID, err
-	}()
+	// Registered before sending, as a concurrent poll may pick up the response first
+	response := make(chan *Message, 1) // must have a buffer
+	resp, pid, err := dc.sessionRequest(ctx, requestConfig{data: b, path: path, requestIfOnline: true, response: response})
 	if err != nil {
 		return err
 	}
+	defer dc.forget(pid)
 
-	logger.WithField("resp", resp).Debug("RPC resp")
+	dc.log().WithField("resp", resp).Debug("RPC resp")
 	var responseBytes []byte
 	if resp.inlineResponse != nil {
 		responseBytes = resp.inlineResponse
 	} else {
-		responseBytes, err = dc.waitForPid(pid)
+		responseBytes, err = dc.waitForPid(ctx, pid, response)
 		if err != nil {
 			return err
 		}
@@ -487,7 +892,7 @@ func (dc *Conn) RPC(rpc RPC) error {
 	}
 	err = json.Unmarshal(responseBytes, &output)
 	if err != nil {
-		logger.WithFields(logrus.Fields{
+		dc.log().WithFields(logrus.Fields{
 			"rawInlineResponse": string(responseBytes),
 			"error":             err,
 		}).Error("Could not decode non-JSON response")
@@ -495,8 +900,7 @@ func (dc *Conn) RPC(rpc RPC) error {
 		return err
 	}
 	if output.Code != 0 {
-		return fmt.Errorf("got unhandled error calling path=%v code=%v note=%v",
-			rpc.Path, output.Code, output.Description)
+		return &RPCError{Path: rpc.Path, Code: output.Code, Description: output.Description}
 	}
 
 	if rpc.Output != nil {
@@ -505,44 +909,149 @@ func (dc *Conn) RPC(rpc RPC) error {
 	return nil
 }
 
-// waitForPid waits for the server to respond with a matching processID.
-func (dc *Conn) waitForPid(pid string) ([]byte, error) {
-	ch := make(chan *Message, 1) // must have a buffer
+// sessionRequest signs and sends a request on the current session. Up to MaxInFlight are
+// sent at once; only signing them holds genericRequestMutex. If the hub reports the session
+// as expired, it reconnects with the cached credential, unless another request already
+// has, and replays the request once.
+func (dc *Conn) sessionRequest(ctx context.Context, conf requestConfig) (*genericResponse, string, error) {
+	release, err := dc.acquire(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+
+	greq, err := dc.sign(ctx, conf)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := dc.send(ctx, greq, conf.response)
+	// Only a request that never left is replayed through the cloud; one the hub may have
+	// read on the LAN could otherwise run twice
+	lostLAN := dc.Mode == AutoMode && !dc.cloud.Load() && errors.Is(err, ErrUnreachable)
+	if !(errors.Is(err, ErrSessionExpired) || lostLAN) || greq.SessionID == "" {
+		if err == nil {
+			dc.responded()
+		}
+		return resp, greq.ProcessID, err
+	}
+
+	if err := dc.renew(ctx, greq.SessionID, err, lostLAN); err != nil {
+		return nil, "", err
+	}
+
+	greq, err = dc.sign(ctx, conf)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err = dc.send(ctx, greq, conf.response)
+	if err == nil {
+		dc.responded()
+	}
+	return resp, greq.ProcessID, err
+}
+
+// send sends greq, first registering response, if set, to receive its response wherever it
+// arrives. If sending fails, response is unregistered again.
+func (dc *Conn) send(ctx context.Context, greq *genericRequest, response chan *Message) (*genericResponse, error) {
+	if response == nil {
+		return dc.genericRequest(ctx, greq)
+	}
 	dc.unresolvedMutex.Lock()
-	dc.unresolvedRPC[pid] = ch
+	dc.unresolvedRPC[greq.ProcessID] = response
 	dc.unresolvedMutex.Unlock()
+	resp, err := dc.genericRequest(ctx, greq)
+	if err != nil {
+		dc.forget(greq.ProcessID)
+	}
+	return resp, err
+}
 
-	logger.WithField("pid", pid).Debug("Delaying for process")
+// forget stops waiting for the response to pid.
+func (dc *Conn) forget(pid string) {
+	dc.unresolvedMutex.Lock()
+	delete(dc.unresolvedRPC, pid)
+	dc.unresolvedMutex.Unlock()
+}
 
-	var calls int
-	ticks := 1
+// sign waits for the RateLimiter to allow a request, then signs it on the current session.
+// The wait comes before taking genericRequestMutex, so concurrent requests queue in the
+// RateLimiter, where a command can go ahead of a poll, rather than on the mutex.
+func (dc *Conn) sign(ctx context.Context, conf requestConfig) (*genericRequest, error) {
+	stamp, err := dc.limiter().Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dc.genericRequestMutex.Lock()
+	defer dc.genericRequestMutex.Unlock()
+	return dc.signedRequest(conf, stamp)
+}
+
+// renew reconnects after a request on session failed with err, unless a concurrent request
+// has already replaced the session.
+func (dc *Conn) renew(ctx context.Context, session string, err error, lostLAN bool) error {
+	dc.genericRequestMutex.Lock()
+	defer dc.genericRequestMutex.Unlock()
+	if dc.sessionID != session {
+		return nil
+	}
+
+	if lostLAN {
+		dc.log().WithError(err).Warn("Hub unreachable on the LAN; reconnecting")
+	} else {
+		dc.log().WithError(err).Warn("Session expired; reconnecting")
+	}
+	if cerr := dc.handshake(ctx, dc.cred); cerr != nil {
+		return fmt.Errorf("reconnect after session expiry: %w", cerr)
+	}
+	return nil
+}
+
+// acquire waits for one of the MaxInFlight slots for a session request, returning the
+// func to release it.
+func (dc *Conn) acquire(ctx context.Context) (func(), error) {
+	dc.inFlightOnce.Do(func() {
+		n := dc.MaxInFlight
+		if n <= 0 {
+			n = DefaultMaxInFlight
+		}
+		dc.inFlight = make(chan struct{}, n)
+	})
+	select {
+	case dc.inFlight <- struct{}{}:
+		return func() { <-dc.inFlight }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
+// waitForPid waits for the server to respond with a matching processID on ch, which send
+// registered for pid.
+func (dc *Conn) waitForPid(ctx context.Context, pid string, ch chan *Message) ([]byte, error) {
+	dc.log().WithField("pid", pid).Debug("Delaying for process")
+
+	poll := Backoff{Base: waitForPidMinInterval, Max: waitForPidMaxInterval, Jitter: DefaultBackoffJitter}
 	timeout := time.NewTimer(time.Second * 20)
-	tick := time.NewTicker(time.Millisecond * 350)
+	tick := time.NewTimer(poll.Next())
 	defer timeout.Stop()
 	defer tick.Stop()
 
 	for {
 		select {
 		case m := <-ch:
-			logger.WithField("pid", pid).Debug("Received process response")
+			dc.log().WithField("pid", pid).Debug("Received process response")
 			return m.DecodedMessage, nil
 		case <-tick.C:
-			ticks--
-			if ticks > 0 {
-				continue
-			}
-
-			err := dc.internalMessages()
+			err := dc.internalMessages(ctx)
 			if err != nil {
 				return nil, err
 			}
-
-			calls++
-			ticks = calls
+			tick.Reset(poll.Next())
 
 		case <-timeout.C:
 			return nil, ErrTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
 }
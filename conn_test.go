@@ -1,7 +1,23 @@
 package dd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestSimpleRequestTarget_Constants(t *testing.T) {
@@ -83,7 +99,7 @@ func TestDataPayload_readData_Unencrypted(t *testing.T) {
 	}
 
 	key := []byte("dummy_key")
-	result, err := dp.readData(key)
+	result, err := dp.readData(AESCBC, key)
 
 	if err != nil {
 		t.Errorf("readData() with unencrypted data should not error: %v", err)
@@ -104,7 +120,7 @@ func TestDataPayload_readData_InvalidBase64(t *testing.T) {
 	// Use a valid 16-byte key for AES
 	key := make([]byte, 16)
 
-	_, err := dp.readData(key)
+	_, err := dp.readData(AESCBC, key)
 
 	if err == nil {
 		t.Errorf("readData() with invalid base64 should return error")
@@ -179,6 +195,52 @@ func TestPKCS5Trimming_InvalidPadding(t *testing.T) {
 	}
 }
 
+func TestTrimPKCS5Padding(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		wantLen int
+	}{
+		{"Valid padding of 1", []byte{1, 2, 3, 4, 5, 1}, 5},
+		{"Valid padding of 2", []byte{1, 2, 3, 4, 2, 2}, 4},
+		{"Valid padding of 6", []byte{6, 6, 6, 6, 6, 6}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := TrimPKCS5Padding(tt.input, 6)
+			if err != nil {
+				t.Fatalf("TrimPKCS5Padding() error = %v", err)
+			}
+			if len(result) != tt.wantLen {
+				t.Errorf("TrimPKCS5Padding() length = %d, want %d", len(result), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestTrimPKCS5Padding_InvalidPadding(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"Empty", nil},
+		{"Padding exceeds block", []byte{1, 2, 3, 100}},
+		{"Zero padding", []byte{1, 2, 3, 0}},
+		{"Inconsistent padding", []byte{1, 2, 3, 2}},
+		{"Partial block", []byte{1, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := TrimPKCS5Padding(tt.input, 4)
+			if !errors.Is(err, ErrBadPadding) {
+				t.Errorf("TrimPKCS5Padding(%v) = %v, %v, want ErrBadPadding", tt.input, result, err)
+			}
+		})
+	}
+}
+
 func TestNewEncCipher_InvalidKeyLength(t *testing.T) {
 	// AES requires key length of 16, 24, or 32 bytes
 	invalidKey := []byte("short")
@@ -229,4 +291,707 @@ func TestEncryptDecrypt_RoundTrip(t *testing.T) {
 	if string(decrypted) != string(plaintext) {
 		t.Errorf("Decrypt(Encrypt(%q)) = %q, want original plaintext", plaintext, decrypted)
 	}
+
+	// A cipher's IV chains on, so the checked variant needs one of its own
+	decCipher, err = NewDecCipher(key, timestamp)
+	if err != nil {
+		t.Fatalf("NewDecCipher() error = %v", err)
+	}
+	decrypted, err = decCipher.DecryptChecked(ciphertext)
+	if err != nil || string(decrypted) != string(plaintext) {
+		t.Errorf("DecryptChecked(Encrypt(%q)) = %q, %v, want original plaintext", plaintext, decrypted, err)
+	}
+	if _, err := decCipher.DecryptChecked(ciphertext[:5]); !errors.Is(err, ErrBadCiphertext) {
+		t.Errorf("DecryptChecked() of a partial block error = %v, want ErrBadCiphertext", err)
+	}
+}
+
+func TestSimpleRequest_RemoteHost(t *testing.T) {
+	var gotPath string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewEncoder(w).Encode(map[string]string{"name": "remote"})
+	}))
+	defer server.Close()
+
+	conn := Conn{RemoteHost: strings.TrimPrefix(server.URL, "https://")}
+	defer conn.Close()
+
+	var out struct {
+		Name string `json:"name"`
+	}
+	err := conn.SimpleRequest(SimpleRequest{
+		Path:   "/app/remoteregister",
+		Target: RemoteTarget,
+		Output: &out,
+	})
+	if err != nil {
+		t.Fatalf("SimpleRequest() error = %v", err)
+	}
+	if gotPath != "/app/remoteregister" {
+		t.Errorf("request path = %q, want %q", gotPath, "/app/remoteregister")
+	}
+	if out.Name != "remote" {
+		t.Errorf("response name = %q, want %q", out.Name, "remote")
+	}
+}
+
+func TestTargetURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		conn   *Conn
+		target SimpleRequestTarget
+		want   string
+	}{
+		{"Default port", &Conn{Host: "hub"}, DefaultTarget, "https://hub:8989/app/connect"},
+		{"Local port override", &Conn{Host: "hub", LocalPort: 9090}, DefaultTarget, "https://hub:9090/app/connect"},
+		{"SDK port", &Conn{Host: "hub"}, SDKTarget, "https://hub:8991/app/connect"},
+		{"SDK port override", &Conn{Host: "hub", SDKPortOverride: 9091}, SDKTarget, "https://hub:9091/app/connect"},
+		{"Remote default", &Conn{}, RemoteTarget, "https://" + RemoteAPIBase + "/app/connect"},
+		{"Remote override", &Conn{RemoteHost: "cloud.example.com"}, RemoteTarget, "https://cloud.example.com/app/connect"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.conn.targetURL(tt.target, "/app/connect")
+			if err != nil {
+				t.Fatalf("targetURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("targetURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (&Conn{}).targetURL(SimpleRequestTarget(99), "/"); err == nil {
+		t.Errorf("targetURL() with unknown target should return error")
+	}
+}
+
+func TestGenerateProcessID_Unique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := generateProcessID()
+		if err != nil {
+			t.Fatalf("generateProcessID() error = %v", err)
+		}
+		if seen[id] {
+			t.Fatalf("generateProcessID() returned duplicate %q after %d calls", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+// newTestConn returns a Conn with session state set up, talking to a TLS test
+// server that answers every request with handler.
+func newTestConn(t *testing.T, handler http.HandlerFunc) *Conn {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	conn := &Conn{
+		Host:           addr.IP.String(),
+		LocalPort:      addr.Port,
+		sessionID:      "session",
+		sessionSecret:  []byte("session_secret"),
+		phoneSecret:    md5hash("phone_secret"),
+		phoneSecretRaw: []byte("phone_secret"),
+		unresolvedRPC:  make(map[string]chan *Message),
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+// messagesResponse encodes msgs as the unencrypted "messages" field of a genericResponse.
+func messagesResponse(t *testing.T, msgs ...Message) map[string]interface{} {
+	t.Helper()
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatalf("encode messages: %v", err)
+	}
+	return map[string]interface{}{"messages": string(b)}
+}
+
+func TestAllMessages_IncludesPending(t *testing.T) {
+	polled := Message{Sequence: 2, dataPayload: dataPayload{Data: `{"polled":true}`}}
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/res/messages" {
+			t.Errorf("request path = %q, want /app/res/messages", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(messagesResponse(t, polled))
+	})
+	conn.pending.push(&Message{Sequence: 1, DecodedMessage: []byte(`{"pending":true}`)})
+
+	messages, err := conn.AllMessages()
+	if err != nil {
+		t.Fatalf("AllMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("AllMessages() returned %d messages, want 2", len(messages))
+	}
+	if messages[0].Sequence != 1 || messages[1].Sequence != 2 {
+		t.Errorf("AllMessages() sequences = %d,%d, want 1,2", messages[0].Sequence, messages[1].Sequence)
+	}
+	if string(messages[1].DecodedMessage) != `{"polled":true}` {
+		t.Errorf("polled message decoded = %q", messages[1].DecodedMessage)
+	}
+	if n := conn.pending.len(); n != 0 {
+		t.Errorf("AllMessages() left %d pending messages", n)
+	}
+}
+
+func TestMessages_Concurrent(t *testing.T) {
+	var sequence atomic.Int32
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		n := int(sequence.Add(2))
+		json.NewEncoder(w).Encode(messagesResponse(t,
+			Message{Sequence: n - 1, dataPayload: dataPayload{Data: `{}`}},
+			Message{Sequence: n, dataPayload: dataPayload{Data: `{}`}},
+		))
+	})
+	conn.RateLimiter = NewAccessLimiter(0, 0)
+
+	const readers, polls = 4, 5
+	got := make(chan int, readers*polls*2)
+	var wg sync.WaitGroup
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range polls {
+				messages, err := conn.Messages()
+				if err != nil {
+					t.Errorf("Messages() error = %v", err)
+					return
+				}
+				for _, m := range messages {
+					got <- m.Sequence
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(got)
+
+	// Every message polled is returned to exactly one reader
+	seen := make(map[int]bool)
+	for seq := range got {
+		if seen[seq] {
+			t.Errorf("message %d returned twice", seq)
+		}
+		seen[seq] = true
+	}
+	if want := int(sequence.Load()) - conn.pending.len(); len(seen) != want {
+		t.Errorf("readers got %d messages, want %d", len(seen), want)
+	}
+}
+
+func TestIsBasestationOnline(t *testing.T) {
+	var online *bool
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"isBasestationOnline": online})
+	})
+
+	isOnline, isOffline := true, false
+	tests := []struct {
+		name     string
+		reported *bool
+		want     bool
+	}{
+		{"Reported online", &isOnline, true},
+		{"Not reported keeps last value", nil, true},
+		{"Reported offline", &isOffline, false},
+	}
+
+	for _, tt := range tests {
+		online = tt.reported
+		if err := conn.internalMessages(context.Background()); err != nil {
+			t.Fatalf("%s: internalMessages() error = %v", tt.name, err)
+		}
+		if got := conn.IsBasestationOnline(); got != tt.want {
+			t.Errorf("%s: IsBasestationOnline() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRPCContext_CancelWhileWaiting(t *testing.T) {
+	// The hub accepts the request but never delivers a response
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := conn.RPCContext(ctx, RPC{Path: "/app/res/devices/fetch"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RPCContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RPCContext() returned after %v, should stop promptly on cancel", elapsed)
+	}
+
+	conn.unresolvedMutex.Lock()
+	defer conn.unresolvedMutex.Unlock()
+	if len(conn.unresolvedRPC) != 0 {
+		t.Errorf("cancelled RPC left %d unresolved entries", len(conn.unresolvedRPC))
+	}
+}
+
+func TestSimpleRequestContext_Cancelled(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out struct{}
+	err := conn.SimpleRequestContext(ctx, SimpleRequest{Path: "/sdk/info", Output: &out})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SimpleRequestContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestConn_Logger(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetLevel(logrus.DebugLevel)
+	conn.Logger = l
+
+	var out struct{}
+	if err := conn.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out}); err != nil {
+		t.Fatalf("SimpleRequest() error = %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("connection Logger received no output")
+	}
+}
+
+// countingTransport counts the requests it passes to an insecure transport.
+type countingTransport struct {
+	requests atomic.Int32
+	next     http.RoundTripper
+}
+
+func newCountingTransport() *countingTransport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	return &countingTransport{next: transport}
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return c.next.RoundTrip(r)
+}
+
+func TestConn_HTTPClient(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	transport := newCountingTransport()
+	client := &http.Client{Transport: transport, Timeout: time.Second}
+	conn.HTTPClient = client
+
+	var out struct{}
+	if err := conn.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out}); err != nil {
+		t.Fatalf("SimpleRequest() error = %v", err)
+	}
+	if transport.requests.Load() != 1 {
+		t.Errorf("HTTPClient requests = %d, want 1", transport.requests.Load())
+	}
+	conn.Close()
+	if conn.HTTPClient != client || client.Timeout != time.Second {
+		t.Error("Close() changed the HTTPClient")
+	}
+}
+
+func TestConn_Transport(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	transport := newCountingTransport()
+	conn.Transport = transport
+	conn.LongPoll = 30 * time.Second
+
+	var out struct{}
+	if err := conn.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out}); err != nil {
+		t.Fatalf("SimpleRequest() error = %v", err)
+	}
+	if transport.requests.Load() != 1 {
+		t.Errorf("Transport requests = %d, want 1", transport.requests.Load())
+	}
+	if want := DefaultHTTPTimeout + conn.LongPoll; conn.client.Timeout != want {
+		t.Errorf("default client timeout = %v, want %v", conn.client.Timeout, want)
+	}
+}
+
+func TestMessages_ReconnectsOnExpiredSession(t *testing.T) {
+	polled := Message{Sequence: 1, dataPayload: dataPayload{Data: `{"polled":true}`}}
+	var connects, polls int
+	var replaySession string
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app/connect":
+			connects++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"sessionId":     "renewed",
+				"sessionSecret": "renewed_secret",
+				"data":          `{"userAccess":{"nextAccess":0}}`,
+			})
+		case "/app/res/messages":
+			polls++
+			if polls == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var body struct {
+				SessionID string `json:"sessionId"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			replaySession = body.SessionID
+			json.NewEncoder(w).Encode(messagesResponse(t, polled))
+		default:
+			t.Errorf("unexpected request path %q", r.URL.Path)
+		}
+	})
+	conn.cred = Credential{PhoneSecret: "phone_secret"}
+
+	messages, err := conn.Messages()
+	if err != nil {
+		t.Fatalf("Messages() error = %v", err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("Messages() returned %d messages, want 1", len(messages))
+	}
+	if connects != 1 {
+		t.Errorf("connect requests = %d, want 1", connects)
+	}
+	if replaySession != "renewed" {
+		t.Errorf("replayed request sessionId = %q, want %q", replaySession, "renewed")
+	}
+}
+
+func TestMessages_ExpiredSessionReconnectFails(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := conn.Messages()
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Messages() error = %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestSubscribe_NotConnected(t *testing.T) {
+	conn := &Conn{}
+	if _, err := conn.Subscribe(context.Background()); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Subscribe() error = %v, want ErrNotConnected", err)
+	}
+}
+
+func TestSubscribe_DeliversMessages(t *testing.T) {
+	prevMin, prevIdle := subscribeMinInterval, subscribeMaxIdleInterval
+	subscribeMinInterval, subscribeMaxIdleInterval = time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { subscribeMinInterval, subscribeMaxIdleInterval = prevMin, prevIdle })
+
+	var polls atomic.Int32
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		n := polls.Add(1)
+		switch n {
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2:
+			json.NewEncoder(w).Encode(messagesResponse(t, Message{Sequence: 1, dataPayload: dataPayload{Data: `{}`}}))
+		default:
+			json.NewEncoder(w).Encode(messagesResponse(t, Message{Sequence: int(n), dataPayload: dataPayload{Data: `{}`}}))
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := conn.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	// The failed first poll is retried rather than ending the subscription
+	for want := 1; want <= 2; want++ {
+		select {
+		case m := <-ch:
+			if m.Sequence < want {
+				t.Errorf("message sequence = %d, want >= %d", m.Sequence, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", want)
+		}
+	}
+
+	cancel()
+	for range ch {
+		// drain until closed
+	}
+}
+
+func TestRPC_ReturnsRPCError(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		var req genericRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(messagesResponse(t, Message{
+			ProcessID:   req.ProcessID,
+			dataPayload: dataPayload{Data: `{"code":5,"description":"Device is offline"}`},
+		}))
+	})
+
+	err := conn.RPC(RPC{Path: "/app/res/action"})
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("RPC() error = %v, want *RPCError", err)
+	}
+	if rpcErr.Code != 5 || rpcErr.Path != "/app/res/action" {
+		t.Errorf("RPCError = %+v, want code 5 on /app/res/action", rpcErr)
+	}
+	if !errors.Is(err, ErrDeviceOffline) {
+		t.Errorf("errors.Is(RPC(), ErrDeviceOffline) = false, want true")
+	}
+}
+
+func TestRPC_ResponseViaConcurrentPoll(t *testing.T) {
+	sent := make(chan string, 1)
+	polled := make(chan struct{})
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		var req genericRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/app/res/action":
+			// The response is picked up by a poll before the action itself returns
+			sent <- req.ProcessID
+			<-polled
+			w.Write([]byte(`{}`))
+		case "/app/res/messages":
+			select {
+			case pid := <-sent:
+				json.NewEncoder(w).Encode(messagesResponse(t, Message{
+					ProcessID:   pid,
+					dataPayload: dataPayload{Data: `{"code":0}`},
+				}))
+			default:
+				json.NewEncoder(w).Encode(messagesResponse(t))
+			}
+		}
+	})
+	conn.RateLimiter = NewAccessLimiter(0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- conn.RPCContext(ctx, RPC{Path: "/app/res/action"}) }()
+
+	for len(sent) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := conn.pollMessages(ctx); err != nil {
+		t.Fatalf("pollMessages() error = %v", err)
+	}
+	close(polled)
+
+	if err := <-done; err != nil {
+		t.Errorf("RPC() with its response polled early error = %v, want nil", err)
+	}
+}
+
+func TestRPC_Concurrent(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		maxInFlight int
+		wantBlocked bool
+	}{
+		{"default", 0, false},
+		{"serialized", 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			entered, release := make(chan struct{}), make(chan struct{})
+			conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+				var req genericRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				if r.URL.Path == "/app/res/devices/fetch" {
+					close(entered)
+					<-release
+				}
+				json.NewEncoder(w).Encode(messagesResponse(t, Message{
+					ProcessID:   req.ProcessID,
+					dataPayload: dataPayload{Data: `{"code":0}`},
+				}))
+			})
+			conn.MaxInFlight = tt.maxInFlight
+			conn.RateLimiter = NewAccessLimiter(0, 0)
+
+			// A slow devices/fetch is waiting on the hub
+			fetched := make(chan error, 1)
+			go func() { fetched <- conn.RPC(RPC{Path: "/app/res/devices/fetch"}) }()
+			<-entered
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			err := conn.RPCContext(ctx, RPC{Path: "/app/res/action"})
+			if blocked := errors.Is(err, context.DeadlineExceeded); blocked != tt.wantBlocked || (!blocked && err != nil) {
+				t.Errorf("RPC() behind a slow one error = %v, want blocked %v", err, tt.wantBlocked)
+			}
+
+			close(release)
+			if err := <-fetched; err != nil {
+				t.Errorf("slow RPC() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionRequest_CommandPreemptsPoll(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		var req genericRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/app/res/messages" {
+			json.NewEncoder(w).Encode(messagesResponse(t))
+			return
+		}
+		json.NewEncoder(w).Encode(messagesResponse(t, Message{
+			ProcessID:   req.ProcessID,
+			dataPayload: dataPayload{Data: `{"code":0}`},
+		}))
+	})
+	limiter := NewAccessLimiter(100*time.Millisecond, 0)
+	conn.RateLimiter = limiter
+	limiter.Reset(UserAccess{NextAccess: int(time.Now().Add(200 * time.Millisecond).UnixMilli())})
+
+	// A poll is waiting for the next access slot when a command comes in
+	ctx := context.Background()
+	polled := make(chan error, 1)
+	go func() {
+		_, err := conn.pollMessages(ctx)
+		polled <- err
+	}()
+	waitQueued(t, limiter, 1)
+	commanded := make(chan error, 1)
+	go func() { commanded <- conn.RPCContext(WithPriority(ctx, PriorityCommand), RPC{Path: "/app/res/action"}) }()
+	waitQueued(t, limiter, 2)
+
+	if err := <-commanded; err != nil {
+		t.Errorf("RPC() error = %v", err)
+	}
+	if err := <-polled; err != nil {
+		t.Errorf("pollMessages() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 2 || paths[0] != "/app/res/action" {
+		t.Errorf("hub got %v, want the command before the poll", paths)
+	}
+}
+
+func TestSessionRequest_ConcurrentExpiryReconnectsOnce(t *testing.T) {
+	var mu sync.Mutex
+	var connects int
+	expired := make(chan struct{})
+	var expiredPolls int
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			SessionID string `json:"sessionId"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		switch {
+		case r.URL.Path == "/app/connect":
+			connects++
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"sessionId":     "renewed",
+				"sessionSecret": "renewed_secret",
+				"data":          `{"userAccess":{"nextAccess":0}}`,
+			})
+		case body.SessionID == "session":
+			// Both polls are on the old session when it expires
+			expiredPolls++
+			if expiredPolls == 2 {
+				close(expired)
+			}
+			mu.Unlock()
+			<-expired
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+		}
+	})
+	conn.cred = Credential{PhoneSecret: "phone_secret"}
+	conn.RateLimiter = NewAccessLimiter(0, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.Messages(); err != nil {
+				t.Errorf("Messages() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if connects != 1 {
+		t.Errorf("connect requests = %d, want 1", connects)
+	}
+}
+
+func TestParseConnMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ConnMode
+		wantErr error
+	}{
+		{"", LocalMode, nil},
+		{"local", LocalMode, nil},
+		{"cloud", CloudMode, nil},
+		{"auto", AutoMode, nil},
+		{"satellite", LocalMode, ErrInvalidConnMode},
+	}
+	for _, tt := range tests {
+		got, err := ParseConnMode(tt.in)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("ParseConnMode(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseConnMode(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial", &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{"DNS", &net.DNSError{Err: "no such host", Name: "hub.invalid"}, true},
+		{"read", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, false},
+		{"EOF", &url.Error{Op: "Post", Err: io.EOF}, false},
+		{"timeout", &url.Error{Op: "Post", Err: context.DeadlineExceeded}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unreachable(tt.err); got != tt.want {
+				t.Errorf("unreachable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
 }
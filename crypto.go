@@ -6,13 +6,15 @@ import (
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"strconv"
+	"sync"
 )
 
 type cbcCipher struct {
@@ -67,27 +69,190 @@ func NewDecCipher(key []byte, t int) (*cbcDecCipher, error) {
 	return out, nil
 }
 
+// Decrypt decrypts src and removes its padding as PKCS5Trimming does.
+//
+// Deprecated: Decrypt panics unless src is a whole number of blocks, and returns garbage
+// for malformed padding rather than an error. Use DecryptChecked.
 func (c *cbcDecCipher) Decrypt(src []byte) []byte {
 	decrypted := make([]byte, len(src))
 	c.cb.CryptBlocks(decrypted, src)
 	return PKCS5Trimming(decrypted)
 }
 
+// DecryptChecked decrypts src and removes its padding. It fails with ErrBadCiphertext
+// unless src is a whole number of blocks, or ErrBadPadding if the padding is malformed, as
+// it is when the key or timestamp is wrong.
+func (c *cbcDecCipher) DecryptChecked(src []byte) ([]byte, error) {
+	size := c.block.BlockSize()
+	if len(src) == 0 || len(src)%size != 0 {
+		return nil, fmt.Errorf("%w: %d bytes isn't a whole number of %d byte blocks", ErrBadCiphertext, len(src), size)
+	}
+	decrypted := make([]byte, len(src))
+	c.cb.CryptBlocks(decrypted, src)
+	return TrimPKCS5Padding(decrypted, size)
+}
+
 func PKCS5Padding(ciphertext []byte, blockSize int) []byte {
 	padding := blockSize - len(ciphertext)%blockSize
 	padtext := bytes.Repeat([]byte{byte(padding)}, padding) // pad with length of padding
 	return append(ciphertext, padtext...)
 }
 
+// PKCS5Trimming removes the padding length held in the last byte of encrypt, returning
+// encrypt as is if that length is out of range.
+//
+// Deprecated: PKCS5Trimming doesn't check the block size or the padding bytes, so
+// tampered ciphertext can decrypt to a truncated payload. Use TrimPKCS5Padding.
 func PKCS5Trimming(encrypt []byte) []byte {
+	if len(encrypt) == 0 {
+		return encrypt
+	}
 	padding := encrypt[len(encrypt)-1]
 	if int(padding) > len(encrypt) || int(padding) <= 0 {
-		log.Printf("badly encoded CBC padding: %v (enc=%+v)", padding, encrypt)
 		return encrypt
 	}
 	return encrypt[:len(encrypt)-int(padding)]
 }
 
+// TrimPKCS5Padding removes the padding PKCS5Padding added for blockSize. It fails with
+// ErrBadPadding unless b is a whole number of blocks ending in 1 to blockSize bytes that
+// each hold the padding length.
+func TrimPKCS5Padding(b []byte, blockSize int) ([]byte, error) {
+	if len(b) == 0 || blockSize <= 0 || len(b)%blockSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes isn't a whole number of %d byte blocks", ErrBadPadding, len(b), blockSize)
+	}
+	padding := int(b[len(b)-1])
+	if padding == 0 || padding > blockSize {
+		return nil, fmt.Errorf("%w: length %d", ErrBadPadding, padding)
+	}
+	for _, p := range b[len(b)-padding:] {
+		if int(p) != padding {
+			return nil, fmt.Errorf("%w: length %d with byte %d", ErrBadPadding, padding, p)
+		}
+	}
+	return b[:len(b)-padding], nil
+}
+
+// CipherSuite encrypts payloads the way one generation of hub firmware does. The suite
+// is picked by the hubVersion the hub reports at connect (see RegisterCipherSuite), so
+// ciphers for new firmware are added without changes to Conn.
+type CipherSuite interface {
+	// Name identifies the suite, e.g. "aes-cbc".
+	Name() string
+	// Encrypt encrypts plain with key for a payload sent at time t (epoch millis).
+	Encrypt(key []byte, t int, plain []byte) ([]byte, error)
+	// Decrypt reverses Encrypt. Malformed or tampered ciphertext fails with an error
+	// wrapping ErrBadCiphertext or ErrBadPadding.
+	Decrypt(key []byte, t int, ciphertext []byte) ([]byte, error)
+}
+
+var (
+	// AESCBC is the cipher every known hub uses: AES-CBC with the MD5 of the payload's
+	// time as the IV and PKCS#5 padding.
+	AESCBC CipherSuite = cbcSuite{}
+	// AESGCM is AES-GCM with a random 12 byte nonce before the ciphertext and the
+	// payload's time as additional data. No firmware is known to use it yet; register it
+	// for the hub versions that do.
+	AESGCM CipherSuite = gcmSuite{}
+)
+
+// cipherSuites holds the registered suites by the first hub version using them.
+var cipherSuites = struct {
+	sync.RWMutex
+	byVersion map[int]CipherSuite
+}{byVersion: map[int]CipherSuite{0: AESCBC}}
+
+// RegisterCipherSuite makes hubs reporting minHubVersion or later, up to the next
+// registered version, use suite. A nil suite removes the registration. AESCBC is
+// registered for version 0, covering hubs that don't report a version.
+func RegisterCipherSuite(minHubVersion int, suite CipherSuite) {
+	cipherSuites.Lock()
+	defer cipherSuites.Unlock()
+	if suite == nil {
+		delete(cipherSuites.byVersion, minHubVersion)
+		return
+	}
+	cipherSuites.byVersion[minHubVersion] = suite
+}
+
+// CipherSuiteFor returns the suite registered for hubVersion, or AESCBC if there's none.
+func CipherSuiteFor(hubVersion int) CipherSuite {
+	cipherSuites.RLock()
+	defer cipherSuites.RUnlock()
+	suite, best := AESCBC, -1
+	for version, s := range cipherSuites.byVersion {
+		if version <= hubVersion && version > best {
+			suite, best = s, version
+		}
+	}
+	return suite
+}
+
+type cbcSuite struct{}
+
+func (cbcSuite) Name() string {
+	return "aes-cbc"
+}
+
+func (cbcSuite) Encrypt(key []byte, t int, plain []byte) ([]byte, error) {
+	c, err := NewEncCipher(key, t)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encrypt(plain), nil
+}
+
+func (cbcSuite) Decrypt(key []byte, t int, ciphertext []byte) ([]byte, error) {
+	c, err := NewDecCipher(key, t)
+	if err != nil {
+		return nil, err
+	}
+	return c.DecryptChecked(ciphertext)
+}
+
+type gcmSuite struct{}
+
+func (gcmSuite) Name() string {
+	return "aes-gcm"
+}
+
+func (gcmSuite) Encrypt(key []byte, t int, plain []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plain, []byte(strconv.Itoa(t))), nil
+}
+
+func (gcmSuite) Decrypt(key []byte, t int, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("%w: %d bytes is too short for a nonce and tag", ErrBadCiphertext, len(ciphertext))
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(strconv.Itoa(t)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCiphertext, err)
+	}
+	return plain, nil
+}
+
+// newGCM returns AES-GCM with key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher (key length %d bytes): %w", len(key), err)
+	}
+	return cipher.NewGCM(block)
+}
+
 func md5hash(s string) []byte {
 	h := md5.New()
 	io.WriteString(h, s)
@@ -125,36 +290,38 @@ type dataPayload struct {
 	Data        string `json:"data,omitempty"`
 }
 
-// readData reads this dataPayload, transparently decrypting if required.
-// Returns the decrypted data or an error with context about what failed.
-func (dp *dataPayload) readData(key []byte) ([]byte, error) {
+// readData reads this dataPayload, transparently decrypting with suite if required.
+// Returns the decrypted data, or a *DecodeError saying what about it was malformed.
+func (dp *dataPayload) readData(suite CipherSuite, key []byte) ([]byte, error) {
 	if !dp.IsEncrypted {
 		return []byte(dp.Data), nil
 	}
 
-	c, err := NewDecCipher(key, dp.Time)
+	cipherBytes, err := base64.StdEncoding.Strict().DecodeString(dp.Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize decryption cipher (check phone secret): %w", err)
+		return nil, &DecodeError{Op: DecodeBase64, Err: err}
+	}
+	b, err := suite.Decrypt(key, dp.Time, cipherBytes)
+	if errors.Is(err, ErrBadCiphertext) || errors.Is(err, ErrBadPadding) {
+		return nil, &DecodeError{Op: DecodeCiphertext, Err: err}
 	}
-
-	cipherBytes, err := base64.StdEncoding.DecodeString(dp.Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 encrypted data: %w", err)
+		return nil, fmt.Errorf("failed to decrypt with %s (check phone secret): %w", suite.Name(), err)
 	}
-	return c.Decrypt(cipherBytes), nil
+	return b, nil
 }
 
 // unmarshalData is a convenience over readData, which unmarshals the payload via JSON.
 // Provides context about whether decryption or JSON parsing failed.
-func (dp *dataPayload) unmarshalData(key []byte, target interface{}) error {
-	b, err := dp.readData(key)
+func (dp *dataPayload) unmarshalData(suite CipherSuite, key []byte, target interface{}) error {
+	b, err := dp.readData(suite, key)
 	if err != nil {
 		return fmt.Errorf("failed to decrypt payload data: %w", err)
 	} else if len(b) == 0 {
 		return errors.New("no data to unmarshal from payload (empty decrypted content)")
 	}
 	if err := json.Unmarshal(b, target); err != nil {
-		return fmt.Errorf("failed to parse JSON from decrypted payload: %w", err)
+		return &DecodeError{Op: DecodeJSON, Err: err}
 	}
 	return nil
 }
@@ -1,6 +1,10 @@
 package dd
 
 import (
+	"bytes"
+	"crypto/aes"
+	"encoding/base64"
+	"errors"
 	"testing"
 )
 
@@ -30,3 +34,198 @@ func TestCrypto(t *testing.T) {
 		t.Errorf("got \"%s\", expected \"%s\" (replay should match)", s, expected)
 	}
 }
+
+// testKey is a 16 byte AES key for the decoding tests.
+var testKey = []byte("0123456789abcdef")
+
+// encryptedPayload returns plain encrypted with testKey for time t.
+func encryptedPayload(t testing.TB, time int, plain []byte) dataPayload {
+	t.Helper()
+	c, err := NewEncCipher(testKey, time)
+	if err != nil {
+		t.Fatalf("NewEncCipher() error = %v", err)
+	}
+	return dataPayload{IsEncrypted: true, Time: time, Data: base64.StdEncoding.EncodeToString(c.Encrypt(plain))}
+}
+
+func TestReadData_DecodeErrors(t *testing.T) {
+	valid := encryptedPayload(t, 1000, []byte(`{"ok":true}`))
+	tests := []struct {
+		name    string
+		payload dataPayload
+		op      string
+		is      error
+	}{
+		{"bad base64", dataPayload{IsEncrypted: true, Time: 1000, Data: "!!!"}, DecodeBase64, nil},
+		{"partial block", dataPayload{IsEncrypted: true, Time: 1000, Data: base64.StdEncoding.EncodeToString([]byte("short"))}, DecodeCiphertext, ErrBadCiphertext},
+		{"empty ciphertext", dataPayload{IsEncrypted: true, Time: 1000, Data: ""}, DecodeCiphertext, ErrBadCiphertext},
+		{"wrong time", dataPayload{IsEncrypted: true, Time: 1001, Data: valid.Data}, DecodeCiphertext, ErrBadPadding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.payload.readData(AESCBC, testKey)
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Op != tt.op {
+				t.Fatalf("readData() = %q, %v, want a %s DecodeError", b, err, tt.op)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("readData() error = %v, want %v", err, tt.is)
+			}
+		})
+	}
+
+	var out map[string]bool
+	if err := valid.unmarshalData(AESCBC, testKey, &out); err != nil || !out["ok"] {
+		t.Errorf("unmarshalData() = %v, %v, want ok", out, err)
+	}
+}
+
+func TestMessage_Decode(t *testing.T) {
+	var out map[string]int
+	for _, bad := range []string{``, `{"position":1} trailing`, `{"position":`, `[1]`} {
+		m := &Message{DecodedMessage: []byte(bad)}
+		var decodeErr *DecodeError
+		if err := m.Decode(&out); !errors.As(err, &decodeErr) || decodeErr.Op != DecodeJSON {
+			t.Errorf("Decode(%q) error = %v, want a json DecodeError", bad, err)
+		}
+	}
+}
+
+func TestGenericResponse_Messages(t *testing.T) {
+	for _, bad := range []string{`[null]`, `{}`, `[{"sequence":"one"}]`} {
+		gr := genericResponse{RawMessages: bad}
+		var decodeErr *DecodeError
+		if out, err := gr.Messages(); !errors.As(err, &decodeErr) {
+			t.Errorf("Messages() of %s = %v, %v, want a DecodeError", bad, out, err)
+		}
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	plain := []byte(`{"deviceId":"door1","action":1}`)
+	for _, suite := range []CipherSuite{AESCBC, AESGCM} {
+		t.Run(suite.Name(), func(t *testing.T) {
+			ciphertext, err := suite.Encrypt(testKey, 1000, plain)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			got, err := suite.Decrypt(testKey, 1000, ciphertext)
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Fatalf("Decrypt(Encrypt()) = %q, %v, want %q", got, err, plain)
+			}
+
+			tampered := bytes.Clone(ciphertext)
+			tampered[len(tampered)-1] ^= 0xff
+			if got, err := suite.Decrypt(testKey, 1000, tampered); err == nil && bytes.Equal(got, plain) {
+				t.Error("Decrypt() of tampered ciphertext returned the plaintext")
+			}
+			if _, err := suite.Decrypt(testKey, 1000, ciphertext[:5]); !errors.Is(err, ErrBadCiphertext) {
+				t.Errorf("Decrypt() of truncated ciphertext error = %v, want ErrBadCiphertext", err)
+			}
+		})
+	}
+
+	// GCM binds the payload's time
+	ciphertext, _ := AESGCM.Encrypt(testKey, 1000, plain)
+	if _, err := AESGCM.Decrypt(testKey, 1001, ciphertext); !errors.Is(err, ErrBadCiphertext) {
+		t.Errorf("AESGCM.Decrypt() at another time error = %v, want ErrBadCiphertext", err)
+	}
+}
+
+func TestCipherSuiteFor(t *testing.T) {
+	RegisterCipherSuite(9000, AESGCM)
+	defer RegisterCipherSuite(9000, nil)
+
+	tests := []struct {
+		version int
+		want    CipherSuite
+	}{
+		{-1, AESCBC},
+		{0, AESCBC},
+		{8999, AESCBC},
+		{9000, AESGCM},
+		{12000, AESGCM},
+	}
+	for _, tt := range tests {
+		if got := CipherSuiteFor(tt.version); got != tt.want {
+			t.Errorf("CipherSuiteFor(%d) = %s, want %s", tt.version, got.Name(), tt.want.Name())
+		}
+	}
+
+	RegisterCipherSuite(9000, nil)
+	if got := CipherSuiteFor(9000); got != AESCBC {
+		t.Errorf("CipherSuiteFor(9000) after removing it = %s, want aes-cbc", got.Name())
+	}
+}
+
+func FuzzTrimPKCS5Padding(f *testing.F) {
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 1})
+	f.Add(bytes.Repeat([]byte{16}, 16))
+	f.Add([]byte{1, 2, 3, 0})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		trimmed, err := TrimPKCS5Padding(b, aes.BlockSize)
+		if err != nil {
+			if !errors.Is(err, ErrBadPadding) {
+				t.Fatalf("TrimPKCS5Padding() error = %v, want ErrBadPadding", err)
+			}
+			return
+		}
+		// Only exactly what PKCS5Padding produces is accepted
+		if padded := PKCS5Padding(bytes.Clone(trimmed), aes.BlockSize); !bytes.Equal(padded, b) {
+			t.Fatalf("TrimPKCS5Padding(%v) = %v, which pads to %v", b, trimmed, padded)
+		}
+	})
+}
+
+func FuzzReadData(f *testing.F) {
+	f.Add(1000, encryptedPayload(f, 1000, []byte(`{"ok":true}`)).Data)
+	f.Add(1000, "")
+	f.Add(-1, "AAAAAAAAAAAAAAAAAAAAAA==")
+	f.Add(1000, "not base64")
+	f.Fuzz(func(t *testing.T, time int, data string) {
+		dp := dataPayload{IsEncrypted: true, Time: time, Data: data}
+		b, err := dp.readData(AESCBC, testKey)
+		var decodeErr *DecodeError
+		if err != nil && !errors.As(err, &decodeErr) {
+			t.Fatalf("readData() error = %v, want a DecodeError", err)
+		}
+		if err == nil {
+			// Whatever decrypts cleanly encrypts back to the same ciphertext
+			if again := encryptedPayload(t, time, b); again.Data != base64.StdEncoding.EncodeToString(mustBase64(t, data)) {
+				t.Fatalf("readData(%q) = %q, which encrypts to %q", data, b, again.Data)
+			}
+		}
+	})
+}
+
+func FuzzMessages(f *testing.F) {
+	f.Add(`[{"processId":"p-1","sequence":1,"type":2,"data":"{}"}]`)
+	f.Add(`[null]`)
+	f.Add(`{"sequence":1}`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		messages, err := (&genericResponse{RawMessages: raw}).Messages()
+		var decodeErr *DecodeError
+		if err != nil && !errors.As(err, &decodeErr) {
+			t.Fatalf("Messages() error = %v, want a DecodeError", err)
+		}
+		for _, m := range messages {
+			if b, err := m.readData(AESCBC, testKey); err == nil {
+				m.DecodedMessage = b
+				var v interface{}
+				if err := m.Decode(&v); err != nil && !errors.As(err, &decodeErr) {
+					t.Fatalf("Decode() error = %v, want a DecodeError", err)
+				}
+			}
+		}
+	})
+}
+
+// mustBase64 decodes s, which readData has already accepted.
+func mustBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.Strict().DecodeString(s)
+	if err != nil {
+		t.Fatalf("DecodeString(%q) error = %v", s, err)
+	}
+	return b
+}
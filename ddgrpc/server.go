@@ -0,0 +1,232 @@
+// Package ddgrpc serves a SmartDoor hub over gRPC, so clients in other languages (Node,
+// Python and so on) can use it without reimplementing its crypto. The service is defined
+// in dd.proto; after changing it, regenerate the Go code from the repository root with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative ddgrpc/dd.proto
+package ddgrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var logger = logrus.StandardLogger()
+
+// streamBuffer is how many updates a slow StreamStatus client may fall behind before
+// updates to it are dropped.
+const streamBuffer = 16
+
+// Server implements DoorService for one hub's Conn. StreamStatus follows the updates
+// Run receives; the other methods call the hub directly.
+type Server struct {
+	UnimplementedDoorServiceServer
+
+	conn *dd.Conn
+
+	mu          sync.Mutex
+	devices     map[string]*Device // latest status of each device, sent to new streams
+	order       []string           // device IDs, in the order first seen
+	subscribers map[chan *Device]struct{}
+}
+
+// NewServer returns a Server for an already connected Conn.
+func NewServer(conn *dd.Conn) *Server {
+	return &Server{
+		conn:        conn,
+		devices:     make(map[string]*Device),
+		subscribers: make(map[chan *Device]struct{}),
+	}
+}
+
+// Run fetches the current status, then follows the hub's status updates for StreamStatus
+// until ctx is done or the connection is lost, returning the reason as dd.Runner does.
+func (s *Server) Run(ctx context.Context) error {
+	if hubStatus, err := ddapi.SafeFetchStatusContext(ctx, s.conn); err == nil {
+		s.update(*hubStatus)
+	}
+
+	runner := dd.NewRunner(s.conn, 0)
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	for m := range runner.Status() {
+		var hubStatus ddapi.DoorStatus
+		if err := m.Decode(&hubStatus); err != nil {
+			logger.WithError(err).Debug("Ignoring message that is not a door status")
+			continue
+		}
+		s.update(hubStatus)
+	}
+	return <-done
+}
+
+// update records a status and sends its changed devices to streams.
+func (s *Server) update(hubStatus ddapi.DoorStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range hubStatus.Devices {
+		device := deviceFromStatus(d)
+		prev, seen := s.devices[d.ID]
+		s.devices[d.ID] = device
+		if !seen {
+			s.order = append(s.order, d.ID)
+		} else if equalDevices(prev, device) {
+			continue
+		}
+		for ch := range s.subscribers {
+			select {
+			case ch <- device:
+			default:
+				logger.WithField("deviceID", d.ID).Warn("gRPC stream is behind; dropping update")
+			}
+		}
+	}
+}
+
+// GetInfo returns the hub's basic info.
+func (s *Server) GetInfo(ctx context.Context, _ *GetInfoRequest) (*Info, error) {
+	info, err := ddapi.FetchBasicInfo(s.conn)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	out := &Info{
+		BaseStation: info.BaseStation,
+		Name:        info.Name,
+		Version:     int32(info.Version),
+		Clock:       info.Clock,
+	}
+	if info.WiFiRSSI != nil {
+		rssi := int32(*info.WiFiRSSI)
+		out.WifiRssi = &rssi
+	}
+	return out, nil
+}
+
+// ListDevices fetches the hub's devices.
+func (s *Server) ListDevices(ctx context.Context, _ *ListDevicesRequest) (*ListDevicesResponse, error) {
+	devices, err := ddapi.ListDevices(s.conn)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	s.update(ddapi.DoorStatus{Devices: devices})
+	out := &ListDevicesResponse{}
+	for _, d := range devices {
+		out.Devices = append(out.Devices, deviceFromStatus(d))
+	}
+	return out, nil
+}
+
+// SendCommand resolves the request's command or position for the device and sends it.
+func (s *Server) SendCommand(ctx context.Context, req *SendCommandRequest) (*SendCommandResponse, error) {
+	hubStatus, err := ddapi.SafeFetchStatusContext(ctx, s.conn)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if hubStatus.Get(req.GetDeviceId()) == nil {
+		return nil, status.Errorf(codes.NotFound, "%v: %s", ddapi.ErrDeviceNotFound, req.GetDeviceId())
+	}
+
+	var command int
+	switch action := req.GetAction().(type) {
+	case *SendCommandRequest_Position:
+		if action.Position < ddapi.PositionClosed || action.Position > ddapi.PositionOpen {
+			return nil, status.Error(codes.InvalidArgument, "position must be 0-100")
+		}
+		command = ddapi.GetCommandForPosition(int(action.Position))
+	case *SendCommandRequest_Command:
+		registry := ddapi.ParseCommandsFromButtons(hubStatus)
+		command, err = ddapi.GetCommandForDevice(req.GetDeviceId(), action.Command, registry)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "command or position is required")
+	}
+
+	if err := ddapi.SafeCommandContext(ctx, s.conn, req.GetDeviceId(), command); err != nil {
+		var rejected *ddapi.CommandRejectedError
+		if errors.As(err, &rejected) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &SendCommandResponse{}, nil
+}
+
+// StreamStatus sends every device's latest status, then each device whose status changes,
+// until the client goes away.
+func (s *Server) StreamStatus(_ *StreamStatusRequest, stream DoorService_StreamStatusServer) error {
+	ch := make(chan *Device, streamBuffer)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	snapshot := make([]*Device, 0, len(s.order))
+	for _, id := range s.order {
+		snapshot = append(snapshot, s.devices[id])
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}()
+
+	for _, device := range snapshot {
+		if err := stream.Send(device); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case device := <-ch:
+			if err := stream.Send(device); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// deviceFromStatus converts a device's status to its protobuf message.
+func deviceFromStatus(d ddapi.DoorStatusDevice) *Device {
+	out := &Device{
+		Id:       d.ID,
+		Name:     d.Name,
+		Position: int32(d.Device.Position),
+		Log: &LogEntry{
+			Id:    d.Log.ID,
+			Alert: int32(d.Log.Alert),
+			Text:  d.Log.Text,
+			Time:  d.Log.Time,
+		},
+		Battery:  optionalInt32(d.Device.Battery),
+		RfSignal: optionalInt32(d.Device.RFSignal),
+	}
+	for _, b := range d.Buttons {
+		out.Buttons = append(out.Buttons, &Button{Title: b.Title, Command: int32(b.Action.Command)})
+	}
+	return out
+}
+
+func optionalInt32(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	i := int32(*v)
+	return &i
+}
+
+// equalDevices reports whether a and b have the same status, as far as streams care.
+func equalDevices(a, b *Device) bool {
+	return a.GetPosition() == b.GetPosition() && a.GetName() == b.GetName() &&
+		a.GetLog().GetId() == b.GetLog().GetId() &&
+		a.GetBattery() == b.GetBattery() && a.GetRfSignal() == b.GetRfSignal()
+}
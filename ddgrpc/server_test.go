@@ -0,0 +1,180 @@
+package ddgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/gravypower/dd/ddtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testStatus(position int) ddapi.DoorStatus {
+	device := ddapi.DoorStatusDevice{ID: "door1", Name: "Garage"}
+	device.Device.Position = position
+	device.Log = ddapi.DoorStatusLog{ID: 7, Text: "Opened by app"}
+	var pet ddapi.DoorStatusButton
+	pet.Title = "Pet Open"
+	pet.Action.Command = ddapi.CMD_PET_OPEN
+	device.Buttons = []ddapi.DoorStatusButton{pet}
+	return ddapi.DoorStatus{DeviceOrder: []string{"door1"}, Devices: []ddapi.DoorStatusDevice{device}}
+}
+
+// startServer serves a Server for a new ddtest hub over an in-memory listener, returning
+// the hub, the Server and a client for it.
+func startServer(t *testing.T) (*ddtest.Server, *Server, DoorServiceClient) {
+	t.Helper()
+	hub := ddtest.NewServer()
+	t.Cleanup(hub.Close)
+	conn := hub.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(hub.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	hub.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return testStatus(0), nil
+	})
+
+	server := NewServer(conn)
+	grpcServer := grpc.NewServer()
+	RegisterDoorServiceServer(grpcServer, server)
+	ln := bufconn.Listen(1 << 20)
+	go grpcServer.Serve(ln)
+	t.Cleanup(grpcServer.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return ln.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return hub, server, NewDoorServiceClient(cc)
+}
+
+func TestServer_GetInfoAndListDevices(t *testing.T) {
+	hub, _, client := startServer(t)
+	hub.SetInfo(ddapi.BasicInfo{BaseStation: "bs1", Name: "Home", Version: 3})
+	ctx := context.Background()
+
+	info, err := client.GetInfo(ctx, &GetInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetInfo() error = %v", err)
+	}
+	if info.GetBaseStation() != "bs1" || info.GetName() != "Home" || info.GetVersion() != 3 || info.WifiRssi != nil {
+		t.Errorf("GetInfo() = %v, want bs1 Home v3 without Wi-Fi", info)
+	}
+
+	resp, err := client.ListDevices(ctx, &ListDevicesRequest{})
+	if err != nil {
+		t.Fatalf("ListDevices() error = %v", err)
+	}
+	if len(resp.GetDevices()) != 1 {
+		t.Fatalf("ListDevices() = %v, want door1", resp)
+	}
+	d := resp.GetDevices()[0]
+	if d.GetId() != "door1" || d.GetName() != "Garage" || d.GetLog().GetText() != "Opened by app" ||
+		len(d.GetButtons()) != 1 || d.GetButtons()[0].GetCommand() != ddapi.CMD_PET_OPEN {
+		t.Errorf("device = %v, want door1 with its log and Pet Open button", d)
+	}
+}
+
+func TestServer_SendCommand(t *testing.T) {
+	hub, _, client := startServer(t)
+	var mu sync.Mutex
+	var sent []ddapi.CommandInput
+	hub.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		var in ddapi.CommandInput
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		sent = append(sent, in)
+		mu.Unlock()
+		return ddapi.CommandOutput{}, nil
+	})
+	ctx := context.Background()
+
+	requests := []*SendCommandRequest{
+		{DeviceId: "door1", Action: &SendCommandRequest_Command{Command: "open"}},
+		{DeviceId: "door1", Action: &SendCommandRequest_Command{Command: "Pet Open"}},
+		{DeviceId: "door1", Action: &SendCommandRequest_Position{Position: 100}},
+	}
+	for _, req := range requests {
+		if _, err := client.SendCommand(ctx, req); err != nil {
+			t.Fatalf("SendCommand(%v) error = %v", req, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int{ddapi.AvailableCommands.Open, ddapi.CMD_PET_OPEN, ddapi.GetCommandForPosition(100)}
+	if len(sent) != len(want) {
+		t.Fatalf("hub got %d commands, want %d", len(sent), len(want))
+	}
+	for i, in := range sent {
+		if in.DeviceId != "door1" || in.Action.Command != want[i] {
+			t.Errorf("command %d = %+v, want %d for door1", i, in, want[i])
+		}
+	}
+
+	errorTests := []struct {
+		req  *SendCommandRequest
+		code codes.Code
+	}{
+		{&SendCommandRequest{DeviceId: "door9", Action: &SendCommandRequest_Command{Command: "open"}}, codes.NotFound},
+		{&SendCommandRequest{DeviceId: "door1", Action: &SendCommandRequest_Command{Command: "fly"}}, codes.InvalidArgument},
+		{&SendCommandRequest{DeviceId: "door1", Action: &SendCommandRequest_Position{Position: 101}}, codes.InvalidArgument},
+		{&SendCommandRequest{DeviceId: "door1"}, codes.InvalidArgument},
+	}
+	for _, tt := range errorTests {
+		if _, err := client.SendCommand(ctx, tt.req); status.Code(err) != tt.code {
+			t.Errorf("SendCommand(%v) error = %v, want %v", tt.req, err, tt.code)
+		}
+	}
+}
+
+func TestServer_StreamStatus(t *testing.T) {
+	hub, server, client := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go server.Run(ctx)
+
+	// Wait for Run's initial fetch, so the stream starts with it
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		server.mu.Lock()
+		seen := len(server.order)
+		server.mu.Unlock()
+		if seen > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run() didn't fetch the status")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stream, err := client.StreamStatus(ctx, &StreamStatusRequest{})
+	if err != nil {
+		t.Fatalf("StreamStatus() error = %v", err)
+	}
+	first, err := stream.Recv()
+	if err != nil || first.GetPosition() != 0 {
+		t.Fatalf("first Recv() = %v, %v, want door1 closed", first, err)
+	}
+
+	if err := hub.Push(testStatus(100)); err != nil {
+		t.Fatal(err)
+	}
+	update, err := stream.Recv()
+	if err != nil || update.GetId() != "door1" || update.GetPosition() != 100 {
+		t.Errorf("Recv() after a push = %v, %v, want door1 open", update, err)
+	}
+}
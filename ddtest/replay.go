@@ -0,0 +1,154 @@
+package ddtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gravypower/dd"
+)
+
+// Replay is a Server answering from a recording made by a dd.Recorder, so a real hub's
+// responses, quirks included, can be fed back to a Conn in tests. Each RPC gets the next
+// response recorded for its path, and status messages are delivered in their recorded
+// order: those recorded before any RPC are queued from the start, and those recorded after
+// an RPC are queued with its response. An RPC without recorded responses left fails.
+//
+// A Replay is an http.RoundTripper serving requests in process, so its Conn needs no
+// network. Close it when done.
+type Replay struct {
+	*Server
+
+	mu        sync.Mutex
+	responses map[string][]*recordedRPC // by path, in order
+}
+
+// recordedRPC is an RPC's response and the status messages recorded after it.
+type recordedRPC struct {
+	path     string
+	response json.RawMessage
+	status   []json.RawMessage
+}
+
+// LoadReplay returns a Replay of the recording in the named file.
+func LoadReplay(name string) (*Replay, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	events, err := dd.ReadRecording(f)
+	if err != nil {
+		return nil, fmt.Errorf("read recording %s: %w", name, err)
+	}
+	return NewReplay(events), nil
+}
+
+// NewReplay returns a Replay of the recorded events.
+func NewReplay(events []dd.RecordedEvent) *Replay {
+	r := &Replay{
+		Server:    NewUnstartedServer(),
+		responses: make(map[string][]*recordedRPC),
+	}
+
+	// An RPC's response comes inline with it or, when deferred, in a later message
+	first := &recordedRPC{} // holds the status messages recorded before any RPC
+	rpcs := []*recordedRPC{first}
+	byProcess := make(map[string]*recordedRPC)
+	for _, e := range events {
+		path := strings.TrimPrefix(e.Path, "/")
+		switch {
+		case e.Kind == dd.RecordedRequest && isRPC(path):
+			call := &recordedRPC{path: path}
+			rpcs = append(rpcs, call)
+			byProcess[e.ProcessID] = call
+		case e.Kind == dd.RecordedResponse && e.Payload != nil:
+			if call, ok := byProcess[e.ProcessID]; ok {
+				call.response = e.Payload
+			}
+		case e.Kind == dd.RecordedMessage && e.ProcessID == "":
+			last := rpcs[len(rpcs)-1]
+			last.status = append(last.status, e.Payload)
+		case e.Kind == dd.RecordedMessage && e.Payload != nil:
+			if call, ok := byProcess[e.ProcessID]; ok {
+				call.response = e.Payload
+			}
+		}
+	}
+
+	// RPCs that failed without a response, e.g. on an expired session, were retried and
+	// aren't replayed; their status messages go with the RPC before
+	prev := first
+	for _, call := range rpcs[1:] {
+		if call.response == nil {
+			prev.status = append(prev.status, call.status...)
+			continue
+		}
+		if _, ok := r.responses[call.path]; !ok {
+			path := call.path
+			r.Handle(path, func([]byte) (interface{}, error) { return r.answer(path) })
+		}
+		r.responses[call.path] = append(r.responses[call.path], call)
+		prev = call
+	}
+	for _, payload := range first.status {
+		r.Push(payload)
+	}
+	return r
+}
+
+// Conn returns a Conn whose requests are served by r. It still needs to Connect with
+// r.Credential.
+func (r *Replay) Conn() *dd.Conn {
+	conn := r.Server.Conn()
+	conn.Transport = r
+	return conn
+}
+
+// RoundTrip serves req without a network.
+func (r *Replay) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	r.serveHTTP(w, req)
+	resp := w.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// Remaining returns how many recorded responses are yet to be replayed.
+func (r *Replay) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, responses := range r.responses {
+		n += len(responses)
+	}
+	return n
+}
+
+// answer returns the next response recorded for path and queues the status messages
+// recorded after it.
+func (r *Replay) answer(path string) (interface{}, error) {
+	r.mu.Lock()
+	responses := r.responses[path]
+	if len(responses) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("no more recorded responses for /%s", path)
+	}
+	r.responses[path] = responses[1:]
+	r.mu.Unlock()
+
+	call := responses[0]
+	for _, payload := range call.status {
+		r.Push(payload)
+	}
+	return call.response, nil
+}
+
+// isRPC reports whether path is an RPC rather than a connect or messages poll.
+func isRPC(path string) bool {
+	return path != "app/connect" && path != "app/res/messages"
+}
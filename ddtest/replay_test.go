@@ -0,0 +1,114 @@
+package ddtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/gravypower/dd"
+)
+
+// record returns what conn's traffic with s recorded while run ran.
+func record(t *testing.T, s *Server, run func(conn *dd.Conn)) []dd.RecordedEvent {
+	t.Helper()
+	var out bytes.Buffer
+	conn := s.Conn()
+	defer conn.Close()
+	conn.Recorder = dd.NewRecorder(&out)
+	if err := conn.Connect(s.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	run(conn)
+
+	events, err := dd.ReadRecording(&out)
+	if err != nil {
+		t.Fatalf("ReadRecording() error = %v", err)
+	}
+	return events
+}
+
+func TestReplay(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		name := "inline"
+		if deferred {
+			name = "deferred"
+		}
+		t.Run(name, func(t *testing.T) {
+			// A hub with a quirk: its second answer has an extra field
+			s := NewServer()
+			defer s.Close()
+			s.DeferResponses = deferred
+			answers := 0
+			s.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+				answers++
+				if answers == 2 {
+					s.Push(map[string]int{"position": 42})
+					return map[string]interface{}{"result": "ok", "firmware": "quirk"}, nil
+				}
+				return map[string]string{"result": "ok"}, nil
+			})
+			s.Push(map[string]int{"position": 0})
+
+			type result struct {
+				Result   string `json:"result"`
+				Firmware string `json:"firmware"`
+			}
+			events := record(t, s, func(conn *dd.Conn) {
+				for i := 0; i < 2; i++ {
+					if err := conn.RPC(dd.RPC{Path: "/app/res/action", Input: map[string]int{"action": i}, Output: &result{}}); err != nil {
+						t.Fatalf("RPC() error = %v", err)
+					}
+				}
+			})
+
+			r := NewReplay(events)
+			defer r.Close()
+			conn := r.Conn()
+			defer conn.Close()
+			if err := conn.Connect(r.Credential); err != nil {
+				t.Fatalf("Connect() to replay error = %v", err)
+			}
+
+			var positions []int
+			collect := func() {
+				t.Helper()
+				messages, err := conn.Messages()
+				if err != nil {
+					t.Fatalf("Messages() error = %v", err)
+				}
+				for _, m := range messages {
+					var status struct {
+						Position int `json:"position"`
+					}
+					if err := json.Unmarshal(m.DecodedMessage, &status); err != nil {
+						t.Fatalf("status message %s: %v", m.DecodedMessage, err)
+					}
+					positions = append(positions, status.Position)
+				}
+			}
+
+			var first, second result
+			if err := conn.RPC(dd.RPC{Path: "/app/res/action", Output: &first}); err != nil {
+				t.Fatalf("replayed RPC() error = %v", err)
+			}
+			collect()
+			if err := conn.RPC(dd.RPC{Path: "/app/res/action", Output: &second}); err != nil {
+				t.Fatalf("second replayed RPC() error = %v", err)
+			}
+			collect()
+			if first != (result{Result: "ok"}) || second != (result{Result: "ok", Firmware: "quirk"}) {
+				t.Errorf("replayed results = %+v, %+v, want the recorded ones", first, second)
+			}
+			if len(positions) != 2 || positions[0] != 0 || positions[1] != 42 {
+				t.Errorf("replayed positions = %v, want [0 42]", positions)
+			}
+			if got := r.Remaining(); got != 0 {
+				t.Errorf("Remaining() = %d, want 0", got)
+			}
+
+			if err := conn.RPC(dd.RPC{Path: "/app/res/action"}); err == nil {
+				t.Error("RPC() past the recording error = nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+// Package ddtest provides an in-memory SmartDoor hub for end-to-end tests of dd.Conn
+// and the code built on it, without real hardware.
+package ddtest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravypower/dd"
+)
+
+// CodeUnknownPath is the RPC error code returned for paths without a handler.
+const CodeUnknownPath = 404
+
+// Handler answers an RPC. It receives the decrypted request body and returns the value
+// to send back, which must encode as a JSON object. A *dd.RPCError is returned to the
+// caller as is; any other error is returned with code 1 and its message as the description.
+type Handler func(body []byte) (interface{}, error)
+
+// Server is a hub speaking the encrypted protocol over TLS: the connect handshake,
+// signed requests and the message queue, plus the unencrypted /sdk/info endpoint.
+// Both the encrypted API and the SDK endpoint are served on the same port.
+type Server struct {
+	*httptest.Server
+
+	// Credential is what clients must connect with.
+	Credential dd.Credential
+
+	// DeferResponses queues RPC responses to be collected by the next messages poll,
+	// instead of returning them inline with the request.
+	DeferResponses bool
+
+	// HubVersion is reported at connect and, as with a real hub, picks the cipher suite
+	// (see dd.RegisterCipherSuite).
+	HubVersion int
+
+	mu                sync.Mutex
+	sessionID         string
+	sessionSecret     string
+	basestationOnline bool
+	passwordExpired   bool
+	info              interface{}
+	handlers          map[string]Handler
+	queue             []message
+	queued            chan struct{} // closed and replaced whenever a message is queued
+	sequence          int
+	requests          map[string]int
+}
+
+// message is a queued message; it's encrypted when delivered.
+type message struct {
+	ProcessID    string `json:"processId,omitempty"`
+	ProcessState *int   `json:"processState,omitempty"`
+	Sequence     int    `json:"sequence"`
+	Type         int    `json:"type"`
+	IsEncrypted  bool   `json:"isEncrypted,omitempty"`
+	Time         int    `json:"time,omitempty"`
+	Data         string `json:"data,omitempty"`
+	// PhoneSignature signs Time and Data with the phone secret
+	PhoneSignature string `json:"phoneSig,omitempty"`
+}
+
+// request mirrors the JSON clients send for every request.
+type request struct {
+	BaseStation       string `json:"bsid"`
+	Phone             string `json:"phoneId"`
+	SessionID         string `json:"sessionId"`
+	ProcessID         string `json:"processId"`
+	SessionSignature  string `json:"sessionSig"`
+	PhoneSignature    string `json:"phoneSig"`
+	Path              string `json:"path"`
+	CommunicationType int    `json:"communicationType"`
+	IsEncrypted       bool   `json:"isEncrypted"`
+	Time              int    `json:"time"`
+	Data              string `json:"data"`
+}
+
+// NewServer starts a hub with a random credential. The caller should Close it when done.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.StartTLS()
+	return s
+}
+
+// NewUnstartedServer returns a hub that isn't listening yet, so the caller can change its
+// Listener or Credential before calling StartTLS.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		Credential: dd.Credential{
+			BaseStation: "bs-" + randomHex(4),
+			Phone:       "phone-" + randomHex(4),
+			PhoneSecret: randomHex(16),
+		},
+		basestationOnline: true,
+		info:              map[string]interface{}{"name": "ddtest hub"},
+		handlers:          make(map[string]Handler),
+		requests:          make(map[string]int),
+		queued:            make(chan struct{}),
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Conn returns a Conn for this server. It still needs to Connect with s.Credential.
+// The server doesn't enforce an access window, so the Conn isn't rate limited.
+func (s *Server) Conn() *dd.Conn {
+	addr := s.Listener.Addr().(*net.TCPAddr)
+	return &dd.Conn{
+		Host:            addr.IP.String(),
+		LocalPort:       addr.Port,
+		SDKPortOverride: addr.Port,
+		RateLimiter:     dd.NewAccessLimiter(0, 0),
+	}
+}
+
+// CloudConn returns a Conn in dd.CloudMode with the server standing in for the cloud API.
+// It still needs to Connect with s.Credential.
+func (s *Server) CloudConn() *dd.Conn {
+	return &dd.Conn{
+		Mode:        dd.CloudMode,
+		RemoteHost:  s.Listener.Addr().String(),
+		RateLimiter: dd.NewAccessLimiter(0, 0),
+	}
+}
+
+// Handle registers h for RPCs on path, e.g. "/app/res/action", replacing any previous handler.
+func (s *Server) Handle(path string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[strings.TrimPrefix(path, "/")] = h
+}
+
+// SetInfo sets the value served by /sdk/info.
+func (s *Server) SetInfo(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = v
+}
+
+// SetBasestationOnline sets the isBasestationOnline flag reported with every response.
+func (s *Server) SetBasestationOnline(online bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.basestationOnline = online
+}
+
+// SetPasswordExpired sets the isPasswordExpired flag sent in later connect responses.
+func (s *Server) SetPasswordExpired(expired bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwordExpired = expired
+}
+
+// Push queues v as a status message for the next messages poll.
+func (s *Server) Push(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enqueue("", b)
+	return nil
+}
+
+// ExpireSession drops the current session, as a hub reboot would. Requests on it are
+// rejected with 401 until the client connects again.
+func (s *Server) ExpireSession() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionID = ""
+}
+
+// Requests returns how many requests have been made to path, e.g. "/app/connect".
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[strings.TrimPrefix(path, "/")]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[path]++
+
+	if path == "sdk/info" {
+		writeJSON(w, s.info)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if path == "app/connect" {
+		s.connect(w, req)
+		return
+	}
+
+	body, status, err := s.verify(req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// In request mode the RPC path travels in the body
+	if path == "app/res/request" {
+		path = strings.TrimPrefix(req.Path, "/")
+		s.requests[path]++
+	}
+	if path != "app/res/messages" {
+		s.rpc(path, req.ProcessID, body)
+	} else {
+		s.hold(r.Context(), body)
+	}
+
+	// Responses are signed with the session secret, as the hub does
+	now := nowMillis()
+	messages := s.drain(req.ProcessID)
+	resp := s.response()
+	resp["messages"] = messages
+	resp["time"] = now
+	resp["sessionSig"] = sign([]byte(s.sessionSecret), now, messages)
+	writeJSON(w, resp)
+}
+
+// connect checks the credential and starts a new session.
+func (s *Server) connect(w http.ResponseWriter, req request) {
+	if req.BaseStation != s.Credential.BaseStation || req.Phone != s.Credential.Phone {
+		writeJSON(w, map[string]interface{}{"message": "invalid credentials"})
+		return
+	}
+
+	s.sessionID = randomHex(8)
+	s.sessionSecret = randomHex(16)
+
+	now := nowMillis()
+	data, err := s.encrypt(now, map[string]interface{}{
+		"userAccess": map[string]interface{}{
+			"isAccessReady": true,
+			"nextAccess":    now,
+		},
+		"isPasswordExpired": s.passwordExpired,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := s.response()
+	resp["sessionId"] = s.sessionID
+	resp["sessionSecret"] = s.sessionSecret
+	resp["communicationType"] = req.CommunicationType
+	resp["serverTime"] = now
+	resp["isEncrypted"] = true
+	resp["time"] = now
+	resp["data"] = data
+	resp["hubVersion"] = s.HubVersion
+	writeJSON(w, resp)
+}
+
+// verify checks a signed request's session and signatures and returns its decrypted body,
+// or the HTTP status to reject it with.
+func (s *Server) verify(req request) ([]byte, int, error) {
+	if s.sessionID == "" || req.SessionID != s.sessionID {
+		return nil, http.StatusUnauthorized, errors.New("unknown session")
+	}
+	if req.SessionSignature != sign([]byte(s.sessionSecret), req.Time, req.Data) ||
+		req.PhoneSignature != sign([]byte(s.Credential.PhoneSecret), req.Time, req.Data) {
+		return nil, http.StatusForbidden, errors.New("bad signature")
+	}
+	if !req.IsEncrypted || req.Data == "" {
+		return nil, http.StatusOK, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	plain, err := dd.CipherSuiteFor(s.HubVersion).Decrypt(s.key(), req.Time, b)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return plain, http.StatusOK, nil
+}
+
+// rpc runs the handler for path and queues its response under processID.
+func (s *Server) rpc(path, processID string, body []byte) {
+	out := map[string]interface{}{"code": 0}
+	h, ok := s.handlers[path]
+	if !ok {
+		out = map[string]interface{}{"code": CodeUnknownPath, "description": "unknown path /" + path}
+	} else {
+		// Handlers may use the Server, so don't hold the lock while they run
+		s.mu.Unlock()
+		v, err := h(body)
+		s.mu.Lock()
+
+		var rpcErr *dd.RPCError
+		switch {
+		case errors.As(err, &rpcErr):
+			out = map[string]interface{}{"code": rpcErr.Code, "description": rpcErr.Description}
+		case err != nil:
+			out = map[string]interface{}{"code": 1, "description": err.Error()}
+		case v != nil:
+			b, err := json.Marshal(v)
+			if err != nil {
+				out = map[string]interface{}{"code": 1, "description": err.Error()}
+				break
+			}
+			// The response is the handler's value, with a zero code unless it set one
+			if err := json.Unmarshal(b, &out); err != nil {
+				out = map[string]interface{}{"code": 1, "description": "response is not a JSON object"}
+				break
+			}
+			if _, ok := out["code"]; !ok {
+				out["code"] = 0
+			}
+		}
+	}
+
+	b, _ := json.Marshal(out)
+	s.enqueue(processID, b)
+}
+
+// enqueue adds a message with the given process ID (empty for status messages).
+// The caller must hold s.mu.
+func (s *Server) enqueue(processID string, data []byte) {
+	s.sequence++
+	m := message{ProcessID: processID, Sequence: s.sequence, Data: string(data)}
+	if processID != "" {
+		done := 0
+		m.ProcessState = &done
+	}
+	s.queue = append(s.queue, m)
+	close(s.queued)
+	s.queued = make(chan struct{})
+}
+
+// hold keeps a messages poll that asked for it (with an appTimeout) waiting until a message
+// is queued or the timeout passes. The caller must hold s.mu.
+func (s *Server) hold(ctx context.Context, body []byte) {
+	var req struct {
+		AppTimeout int `json:"appTimeout"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.AppTimeout <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(time.Duration(req.AppTimeout) * time.Millisecond)
+	defer timer.Stop()
+	for len(s.queue) == 0 {
+		queued := s.queued
+		s.mu.Unlock()
+		select {
+		case <-queued:
+			s.mu.Lock()
+		case <-timer.C:
+			s.mu.Lock()
+			return
+		case <-ctx.Done():
+			s.mu.Lock()
+			return
+		}
+	}
+}
+
+// drain returns the queued messages to deliver with a response as an encrypted JSON list.
+// Responses to processID stay queued if responses are deferred.
+func (s *Server) drain(processID string) string {
+	var out, keep []message
+	for _, m := range s.queue {
+		if s.DeferResponses && processID != "" && m.ProcessID == processID {
+			keep = append(keep, m)
+			continue
+		}
+		m.Time = nowMillis()
+		encrypted, err := dd.CipherSuiteFor(s.HubVersion).Encrypt(s.key(), m.Time, []byte(m.Data))
+		if err != nil {
+			keep = append(keep, m)
+			continue
+		}
+		m.IsEncrypted = true
+		m.Data = base64.StdEncoding.EncodeToString(encrypted)
+		m.PhoneSignature = sign([]byte(s.Credential.PhoneSecret), m.Time, m.Data)
+		out = append(out, m)
+	}
+	s.queue = keep
+
+	if len(out) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(out)
+	return string(b)
+}
+
+// response returns the fields common to every response.
+func (s *Server) response() map[string]interface{} {
+	return map[string]interface{}{
+		"bsid":                s.Credential.BaseStation,
+		"isBasestationOnline": s.basestationOnline,
+	}
+}
+
+// key is the AES key derived from the phone secret.
+func (s *Server) key() []byte {
+	h := md5.Sum([]byte(s.Credential.PhoneSecret))
+	return h[:]
+}
+
+// encrypt returns v as JSON, encrypted for time t and base64 encoded.
+func (s *Server) encrypt(t int, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := dd.CipherSuiteFor(s.HubVersion).Encrypt(s.key(), t, b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// sign returns the HMAC-SHA256 request signature the hub expects for data sent at time t.
+func sign(key []byte, t int, data string) string {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%d:%s", t, data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func nowMillis() int {
+	return int(time.Now().UnixNano() / 1e6)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
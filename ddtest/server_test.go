@@ -0,0 +1,407 @@
+package ddtest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gravypower/dd"
+)
+
+// connect returns a Conn connected to a new Server.
+func connect(t *testing.T) (*Server, *dd.Conn) {
+	t.Helper()
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	conn := s.Conn()
+	t.Cleanup(conn.Close)
+	if err := conn.Connect(s.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	return s, conn
+}
+
+func TestServer_Connect(t *testing.T) {
+	s, conn := connect(t)
+
+	if got := s.Requests("/app/connect"); got != 1 {
+		t.Errorf("Requests(/app/connect) = %d, want 1", got)
+	}
+	if !conn.IsBasestationOnline() {
+		t.Errorf("IsBasestationOnline() = false, want true")
+	}
+}
+
+func TestServer_ConnectBadCredential(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	cred := s.Credential
+	cred.Phone = "someone-else"
+	if err := s.Conn().Connect(cred); err == nil {
+		t.Errorf("Connect() with wrong phone ID should return error")
+	}
+}
+
+func TestServer_Info(t *testing.T) {
+	s, conn := connect(t)
+	s.SetInfo(map[string]string{"name": "Garage"})
+
+	var info struct {
+		Name string `json:"name"`
+	}
+	if err := conn.SimpleRequest(dd.SimpleRequest{Path: "/sdk/info", Target: dd.SDKTarget, Output: &info}); err != nil {
+		t.Fatalf("SimpleRequest(/sdk/info) error = %v", err)
+	}
+	if info.Name != "Garage" {
+		t.Errorf("info name = %q, want %q", info.Name, "Garage")
+	}
+}
+
+func TestServer_RPC(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		name := "inline"
+		if deferred {
+			name = "deferred"
+		}
+		t.Run(name, func(t *testing.T) {
+			s, conn := connect(t)
+			s.DeferResponses = deferred
+
+			var got struct {
+				Action int `json:"action"`
+			}
+			s.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+				if err := json.Unmarshal(body, &got); err != nil {
+					return nil, err
+				}
+				return map[string]string{"result": "ok"}, nil
+			})
+
+			var out struct {
+				Result string `json:"result"`
+			}
+			err := conn.RPC(dd.RPC{Path: "/app/res/action", Input: map[string]int{"action": 7}, Output: &out})
+			if err != nil {
+				t.Fatalf("RPC() error = %v", err)
+			}
+			if got.Action != 7 {
+				t.Errorf("handler got action %d, want 7", got.Action)
+			}
+			if out.Result != "ok" {
+				t.Errorf("RPC() result = %q, want %q", out.Result, "ok")
+			}
+		})
+	}
+}
+
+func TestServer_RPCErrors(t *testing.T) {
+	s, conn := connect(t)
+	s.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		return nil, &dd.RPCError{Code: 3, Description: "device offline"}
+	})
+
+	err := conn.RPC(dd.RPC{Path: "/app/res/action"})
+	if !errors.Is(err, dd.ErrDeviceOffline) {
+		t.Errorf("RPC() error = %v, want ErrDeviceOffline", err)
+	}
+
+	var rpcErr *dd.RPCError
+	err = conn.RPC(dd.RPC{Path: "/app/res/nothing"})
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeUnknownPath {
+		t.Errorf("RPC() to unknown path error = %v, want code %d", err, CodeUnknownPath)
+	}
+}
+
+func TestServer_Push(t *testing.T) {
+	s, conn := connect(t)
+	if err := s.Push(map[string]int{"position": 42}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	messages, err := conn.Messages()
+	if err != nil {
+		t.Fatalf("Messages() error = %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("Messages() returned %d messages, want 1", len(messages))
+	}
+	var status struct {
+		Position int `json:"position"`
+	}
+	if err := messages[0].Decode(&status); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if status.Position != 42 {
+		t.Errorf("position = %d, want 42", status.Position)
+	}
+}
+
+func TestServer_ExpireSession(t *testing.T) {
+	s, conn := connect(t)
+	s.ExpireSession()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := conn.MessagesContext(ctx); err != nil {
+		t.Fatalf("MessagesContext() after expiry error = %v, want reconnect", err)
+	}
+	if got := s.Requests("/app/connect"); got != 2 {
+		t.Errorf("Requests(/app/connect) = %d, want 2", got)
+	}
+}
+
+func TestServer_LongPoll(t *testing.T) {
+	s, conn := connect(t)
+	conn.LongPoll = 10 * time.Second
+	s.Handle("/app/res/echo", func(body []byte) (interface{}, error) {
+		return map[string]string{"echo": "ok"}, nil
+	})
+
+	type result struct {
+		messages []*dd.Message
+		err      error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		messages, err := conn.Messages()
+		done <- result{messages, err}
+	}()
+
+	// The poll is held, but RPCs still go through
+	time.Sleep(100 * time.Millisecond)
+	if err := conn.RPC(dd.RPC{Path: "/app/res/echo"}); err != nil {
+		t.Fatalf("RPC() during long poll error = %v", err)
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("Messages() returned %d messages, %v before anything was pushed", len(r.messages), r.err)
+	default:
+	}
+
+	if err := s.Push(map[string]int{"position": 42}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Messages() error = %v", r.err)
+	}
+	if len(r.messages) != 1 {
+		t.Errorf("Messages() returned %d messages, want 1", len(r.messages))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Messages() took %v, want it to return once a message was pushed", elapsed)
+	}
+}
+
+func TestServer_LongPollTimeout(t *testing.T) {
+	_, conn := connect(t)
+	conn.LongPoll = 200 * time.Millisecond
+
+	start := time.Now()
+	messages, err := conn.Messages()
+	if err != nil || len(messages) != 0 {
+		t.Fatalf("Messages() = %d messages, %v, want none", len(messages), err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Messages() returned after %v, want it held for the 200ms timeout", elapsed)
+	}
+}
+
+func TestServer_CloudMode(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return map[string]string{"name": "Garage"}, nil
+	})
+
+	conn := s.CloudConn()
+	defer conn.Close()
+	if err := conn.Connect(s.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if !conn.IsCloud() {
+		t.Errorf("IsCloud() = false, want true")
+	}
+	if err := conn.RPC(dd.RPC{Path: "/app/res/devices/fetch"}); err != nil {
+		t.Fatalf("RPC() error = %v", err)
+	}
+	if got := s.Requests("/app/res/request"); got != 1 {
+		t.Errorf("Requests(/app/res/request) = %d, want 1", got)
+	}
+	if _, err := conn.Messages(); err != nil {
+		t.Errorf("Messages() error = %v", err)
+	}
+}
+
+// closedPort returns a port nothing listens on, standing in for a hub that's off the LAN.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestServer_AutoMode(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		lan       bool
+		wantCloud bool
+	}{
+		{"LAN reachable", true, false},
+		{"LAN unreachable", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+
+			conn := s.Conn()
+			defer conn.Close()
+			conn.Mode = dd.AutoMode
+			conn.RemoteHost = s.Listener.Addr().String()
+			if !tt.lan {
+				conn.LocalPort = closedPort(t)
+			}
+			if err := conn.Connect(s.Credential); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			if got := conn.IsCloud(); got != tt.wantCloud {
+				t.Errorf("IsCloud() = %v, want %v", got, tt.wantCloud)
+			}
+		})
+	}
+}
+
+func TestServer_AutoModeLosesLAN(t *testing.T) {
+	s, conn := connect(t)
+	conn.Mode = dd.AutoMode
+	conn.RemoteHost = s.Listener.Addr().String()
+	s.Handle("/app/res/devices/fetch", func([]byte) (interface{}, error) {
+		return nil, nil
+	})
+
+	conn.LocalPort = closedPort(t)
+	if err := conn.RPC(dd.RPC{Path: "/app/res/devices/fetch"}); err != nil {
+		t.Fatalf("RPC() after losing the LAN error = %v", err)
+	}
+	if !conn.IsCloud() {
+		t.Errorf("IsCloud() = false, want true")
+	}
+	if got := s.Requests("/app/connect"); got != 2 {
+		t.Errorf("Requests(/app/connect) = %d, want 2", got)
+	}
+}
+
+func TestServer_AutoModeLANTimesOut(t *testing.T) {
+	s := NewUnstartedServer()
+	// The hub reads the command, then never answers
+	var delivered atomic.Int32
+	handler := s.Config.Handler
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/app/res/action") {
+			io.ReadAll(r.Body)
+			delivered.Add(1)
+			<-r.Context().Done()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	s.StartTLS()
+	defer s.Close()
+
+	conn := s.Conn()
+	defer conn.Close()
+	conn.Mode = dd.AutoMode
+	conn.RemoteHost = s.Listener.Addr().String()
+	conn.HTTPClient = &http.Client{
+		Timeout:   200 * time.Millisecond,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	if err := conn.Connect(s.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	// The hub may have acted on it, so it isn't sent again through the cloud
+	if err := conn.RPC(dd.RPC{Path: "/app/res/action"}); !errors.Is(err, dd.ErrNoResponse) {
+		t.Fatalf("RPC() error = %v, want ErrNoResponse", err)
+	}
+	if conn.IsCloud() {
+		t.Errorf("IsCloud() = true, want false")
+	}
+	if n := delivered.Load(); n != 1 {
+		t.Errorf("hub got %d commands, want 1", n)
+	}
+	if got := s.Requests("/app/connect"); got != 1 {
+		t.Errorf("Requests(/app/connect) = %d, want 1", got)
+	}
+}
+
+func TestServer_CipherSuite(t *testing.T) {
+	dd.RegisterCipherSuite(9000, dd.AESGCM)
+	defer dd.RegisterCipherSuite(9000, nil)
+
+	s := NewServer()
+	defer s.Close()
+	s.HubVersion = 9000
+	s.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		return map[string]string{"result": "ok"}, nil
+	})
+	s.Push(map[string]int{"position": 42})
+
+	conn := s.Conn()
+	defer conn.Close()
+	if err := conn.Connect(s.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if conn.HubVersion() != 9000 || conn.CipherSuite() != dd.AESGCM {
+		t.Fatalf("HubVersion() = %d with %s, want 9000 with aes-gcm", conn.HubVersion(), conn.CipherSuite().Name())
+	}
+
+	var out struct {
+		Result string `json:"result"`
+	}
+	if err := conn.RPC(dd.RPC{Path: "/app/res/action", Output: &out}); err != nil || out.Result != "ok" {
+		t.Errorf("RPC() = %+v, %v, want ok", out, err)
+	}
+	messages, err := conn.Messages()
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Messages() = %v, %v, want the status message", messages, err)
+	}
+}
+
+func TestServer_Signatures(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Handle("/app/res/action", func(body []byte) (interface{}, error) {
+		return map[string]string{"result": "ok"}, nil
+	})
+	s.Push(map[string]int{"position": 42})
+
+	conn := s.Conn()
+	defer conn.Close()
+	conn.Signatures = dd.SignaturesStrict
+	if err := conn.Connect(s.Credential); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := conn.RPC(dd.RPC{Path: "/app/res/action"}); err != nil {
+		t.Errorf("RPC() with strict signatures error = %v", err)
+	}
+	if messages, err := conn.Messages(); err != nil || len(messages) != 1 {
+		t.Errorf("Messages() with strict signatures = %v, %v, want the status message", messages, err)
+	}
+}
@@ -0,0 +1,177 @@
+package dd
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Discovery probing limits
+const (
+	// DiscoverProbeTimeout is how long each host gets to answer /sdk/info.
+	DiscoverProbeTimeout = 2 * time.Second
+	// discoverConcurrency is how many hosts are probed at once.
+	discoverConcurrency = 64
+	// discoverMinPrefix caps a scan at a /24; larger subnets are scanned only around our address.
+	discoverMinPrefix = 24
+)
+
+// discardLogger quietens the request logging of the many probes that fail.
+var discardLogger = func() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}()
+
+// DiscoveredHub is a base station found on the LAN.
+type DiscoveredHub struct {
+	Host        string // IP address
+	BaseStation string // base station ID, matching Credential.BaseStation
+	Name        string
+}
+
+// sdkInfo is the part of /sdk/info Discover needs.
+type sdkInfo struct {
+	BaseStation string `json:"bsid"`
+	Name        string `json:"name"`
+}
+
+// Discover finds base stations on the local network by probing the SDK info endpoint
+// (port 8991) of every address on this machine's IPv4 subnets, scanning at most a /24
+// per interface. The hub's mDNS and SSDP advertisements aren't documented, so this
+// doesn't rely on them. Hubs are returned sorted by host; ctx bounds the whole scan.
+func Discover(ctx context.Context) ([]DiscoveredHub, error) {
+	hosts, err := localHosts()
+	if err != nil {
+		return nil, err
+	}
+	return discover(ctx, hosts, SDKPort), nil
+}
+
+// discover probes port on each of hosts, returning those that answer as a hub.
+func discover(ctx context.Context, hosts []string, port int) []DiscoveredHub {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig.InsecureSkipVerify = true // hubs use self-signed certificates
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+
+	var (
+		mu    sync.Mutex
+		found []DiscoveredHub
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, discoverConcurrency)
+	for _, host := range hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			hub, ok := probe(ctx, client, host, port)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			found = append(found, hub)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(found, func(a, b DiscoveredHub) int { return strings.Compare(a.Host, b.Host) })
+	return found
+}
+
+// probe asks host for its SDK info, reporting whether it answered as a hub.
+func probe(ctx context.Context, client *http.Client, host string, port int) (DiscoveredHub, bool) {
+	ctx, cancel := context.WithTimeout(ctx, DiscoverProbeTimeout)
+	defer cancel()
+
+	conn := &Conn{Host: host, SDKPortOverride: port, client: client, Logger: discardLogger}
+	var info sdkInfo
+	if err := conn.SimpleRequestContext(ctx, SimpleRequest{Path: "/sdk/info", Target: SDKTarget, Output: &info}); err != nil {
+		return DiscoveredHub{}, false
+	}
+	// Anything else listening on the port won't send a base station ID
+	if info.BaseStation == "" {
+		return DiscoveredHub{}, false
+	}
+	logger.WithField("host", host).WithField("bsid", info.BaseStation).Debug("Discovered hub")
+	return DiscoveredHub{Host: host, BaseStation: info.BaseStation, Name: info.Name}, true
+}
+
+// localHosts returns the other addresses on this machine's IPv4 subnets.
+func localHosts() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			for _, host := range subnetHosts(ipnet) {
+				if !seen[host] {
+					seen[host] = true
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// subnetHosts returns the host addresses of ipnet other than its own IP, narrowing the
+// subnet to a /24 around the IP if it's larger. IPv6 subnets are skipped.
+func subnetHosts(ipnet *net.IPNet) []string {
+	ip := ipnet.IP.To4()
+	if ip == nil {
+		return nil
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits != 32 || ones > 30 {
+		return nil
+	}
+	ones = max(ones, discoverMinPrefix)
+	mask := net.CIDRMask(ones, 32)
+
+	self := binary.BigEndian.Uint32(ip)
+	network := self & binary.BigEndian.Uint32(mask)
+	broadcast := network | ^binary.BigEndian.Uint32(mask)
+
+	var hosts []string
+	for n := network + 1; n < broadcast; n++ {
+		if n == self {
+			continue
+		}
+		host := make(net.IP, 4)
+		binary.BigEndian.PutUint32(host, n)
+		hosts = append(hosts, host.String())
+	}
+	return hosts
+}
@@ -0,0 +1,85 @@
+package dd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newInfoServer returns the port of a TLS server answering /sdk/info with body.
+func newInfoServer(t *testing.T, body string) int {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sdk/info" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []DiscoveredHub
+	}{
+		{"hub", `{"bsid":"bs1","name":"Garage"}`, []DiscoveredHub{{Host: "127.0.0.1", BaseStation: "bs1", Name: "Garage"}}},
+		{"not a hub", `{"name":"printer"}`, nil},
+		{"not JSON", `<html></html>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := newInfoServer(t, tt.body)
+			got := discover(context.Background(), []string{"127.0.0.1"}, port)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("discover() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscover_Cancelled(t *testing.T) {
+	port := newInfoServer(t, `{"bsid":"bs1"}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := discover(ctx, []string{"127.0.0.1"}, port); len(got) != 0 {
+		t.Errorf("discover() with cancelled context = %+v, want none", got)
+	}
+}
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		cidr      string
+		wantCount int
+		wantFirst string
+		wantLast  string
+	}{
+		{"192.168.1.20/24", 253, "192.168.1.1", "192.168.1.254"},
+		{"10.1.2.3/16", 253, "10.1.2.1", "10.1.2.254"}, // narrowed to 10.1.2.0/24
+		{"192.168.1.5/30", 1, "192.168.1.6", "192.168.1.6"},
+		{"192.168.1.5/32", 0, "", ""},
+		{"fe80::1/64", 0, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			ip, ipnet, err := net.ParseCIDR(tt.cidr)
+			if err != nil {
+				t.Fatalf("ParseCIDR() error = %v", err)
+			}
+			ipnet.IP = ip
+			got := subnetHosts(ipnet)
+			if len(got) != tt.wantCount {
+				t.Fatalf("subnetHosts(%s) returned %d hosts, want %d", tt.cidr, len(got), tt.wantCount)
+			}
+			if len(got) > 0 && (got[0] != tt.wantFirst || got[len(got)-1] != tt.wantLast) {
+				t.Errorf("subnetHosts(%s) = %s..%s, want %s..%s", tt.cidr, got[0], got[len(got)-1], tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
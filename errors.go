@@ -0,0 +1,68 @@
+package dd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors for common RPC failures, matched with errors.Is against an *RPCError.
+var (
+	ErrAuthFailed       = errors.New("authentication failed")
+	ErrDeviceOffline    = errors.New("device offline")
+	ErrAccessRestricted = errors.New("access restricted")
+)
+
+// RPCError is returned by RPC when the hub answers with a non-zero code.
+type RPCError struct {
+	Path        string // RPC path that failed
+	Code        int    // hub error code
+	Description string // hub-provided description, may be empty
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("got unhandled error calling path=%v code=%v note=%v", e.Path, e.Code, e.Description)
+}
+
+// Unwrap returns the sentinel error matching this failure, if any. The hub's numeric
+// codes aren't documented, so this is based on the description it sends.
+func (e *RPCError) Unwrap() error {
+	desc := strings.ToLower(e.Description)
+	switch {
+	case strings.Contains(desc, "offline"):
+		return ErrDeviceOffline
+	case strings.Contains(desc, "restrict"):
+		return ErrAccessRestricted
+	case strings.Contains(desc, "auth"), strings.Contains(desc, "permission"), strings.Contains(desc, "credential"):
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// Sentinel errors for malformed ciphertext, matched with errors.Is against a *DecodeError.
+var (
+	ErrBadCiphertext = errors.New("bad ciphertext length")
+	ErrBadPadding    = errors.New("bad padding")
+)
+
+// What a DecodeError failed to decode
+const (
+	DecodeBase64     = "base64"     // the encrypted data's base64
+	DecodeCiphertext = "ciphertext" // decrypting, including the padding
+	DecodeJSON       = "json"       // the decrypted payload or message list
+)
+
+// DecodeError is returned when a payload from the hub is malformed, so a misbehaving hub
+// (or the wrong phone secret) fails loudly rather than yielding garbage.
+type DecodeError struct {
+	Op  string // DecodeBase64, DecodeCiphertext or DecodeJSON
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s: %v", e.Op, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
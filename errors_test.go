@@ -0,0 +1,30 @@
+package dd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRPCError_Is(t *testing.T) {
+	tests := []struct {
+		description string
+		want        error
+	}{
+		{"Device is offline", ErrDeviceOffline},
+		{"Access restricted by schedule", ErrAccessRestricted},
+		{"Not authorised", ErrAuthFailed},
+		{"Invalid credential", ErrAuthFailed},
+		{"", nil},
+		{"Something else", nil},
+	}
+
+	sentinels := []error{ErrDeviceOffline, ErrAccessRestricted, ErrAuthFailed}
+	for _, tt := range tests {
+		var err error = &RPCError{Path: "/app/res/action", Code: 1, Description: tt.description}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+				t.Errorf("errors.Is(%q, %v) = %v, want %v", tt.description, sentinel, got, !got)
+			}
+		}
+	}
+}
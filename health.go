@@ -0,0 +1,88 @@
+package dd
+
+import (
+	"context"
+	"time"
+)
+
+// HealthFailureThreshold is how many pings in a row must fail before a Conn is unhealthy.
+const HealthFailureThreshold = 3
+
+// PingResult is the outcome of a successful Ping.
+type PingResult struct {
+	Latency time.Duration // round trip, including any wait for the RateLimiter in cloud mode
+	// ClockSkew is the hub's clock minus ours at the middle of the round trip. It's 0
+	// when the hub doesn't report its clock, as through the cloud.
+	ClockSkew time.Duration
+}
+
+// pingInfo is the part of /sdk/info Ping reads.
+type pingInfo struct {
+	Clock int64 `json:"clock"` // epoch millis
+}
+
+// Ping checks the hub is answering. On the LAN it fetches the unencrypted /sdk/info, which
+// also gives the hub's clock; through the cloud it makes a signed messages poll, queueing
+// any status messages that come back as Messages would. The result feeds IsHealthy.
+func (dc *Conn) Ping(ctx context.Context) (PingResult, error) {
+	start := time.Now()
+	var (
+		result PingResult
+		info   pingInfo
+		err    error
+	)
+	if dc.cloud.Load() {
+		err = dc.pingSession(ctx)
+	} else {
+		err = dc.SimpleRequestContext(ctx, SimpleRequest{Path: "/sdk/info", Target: SDKTarget, Output: &info})
+	}
+	end := time.Now()
+
+	if err != nil {
+		dc.pingFailures.Add(1)
+		return result, err
+	}
+	dc.pingFailures.Store(0)
+	dc.pinged.Store(true)
+
+	result.Latency = end.Sub(start)
+	if info.Clock > 0 {
+		result.ClockSkew = time.UnixMilli(info.Clock).Sub(start.Add(result.Latency / 2))
+	}
+	return result, nil
+}
+
+// LastResponse returns when the hub last answered a connect or a request on the session,
+// including messages polls, or the zero time if it never has. A Runner polls continuously,
+// so an old LastResponse means the Conn is stuck.
+func (dc *Conn) LastResponse() time.Time {
+	nanos := dc.lastResponse.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// responded records that the hub answered, for LastResponse.
+func (dc *Conn) responded() {
+	dc.lastResponse.Store(time.Now().UnixNano())
+}
+
+// pingSession makes a messages poll on the current session.
+func (dc *Conn) pingSession(ctx context.Context) error {
+	dc.genericRequestMutex.Lock()
+	connected := dc.sessionID != ""
+	dc.genericRequestMutex.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+	ctx = WithPriority(ctx, priorityFrom(ctx, PriorityBackground))
+	_, _, err := dc.sessionRequest(ctx, requestConfig{path: "app/res/messages"})
+	return err
+}
+
+// IsHealthy reports whether a Ping has succeeded and fewer than HealthFailureThreshold
+// have failed since.
+func (dc *Conn) IsHealthy() bool {
+	return dc.pinged.Load() && dc.pingFailures.Load() < HealthFailureThreshold
+}
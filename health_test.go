@@ -0,0 +1,103 @@
+package dd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	skew := 90 * time.Second
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sdk/info" {
+			t.Errorf("request path = %q, want /sdk/info", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"clock": time.Now().Add(skew).UnixMilli()})
+	})
+	conn.SDKPortOverride = conn.LocalPort
+
+	if conn.IsHealthy() {
+		t.Errorf("IsHealthy() before any Ping = true, want false")
+	}
+	result, err := conn.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if result.Latency <= 0 {
+		t.Errorf("Ping() latency = %v, want > 0", result.Latency)
+	}
+	if diff := result.ClockSkew - skew; diff < -time.Second || diff > time.Second {
+		t.Errorf("Ping() clock skew = %v, want about %v", result.ClockSkew, skew)
+	}
+	if !conn.IsHealthy() {
+		t.Errorf("IsHealthy() after Ping = false, want true")
+	}
+}
+
+func TestPing_Cloud(t *testing.T) {
+	var polls int
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/res/messages" {
+			t.Errorf("request path = %q, want /app/res/messages", r.URL.Path)
+		}
+		polls++
+		w.Write([]byte(`{}`))
+	})
+	conn.RemoteHost = net.JoinHostPort(conn.Host, strconv.Itoa(conn.LocalPort))
+	conn.cloud.Store(true)
+
+	if !conn.LastResponse().IsZero() {
+		t.Errorf("LastResponse() before any request = %v, want zero", conn.LastResponse())
+	}
+	before := time.Now()
+	result, err := conn.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if conn.LastResponse().Before(before) {
+		t.Errorf("LastResponse() after Ping = %v, want after %v", conn.LastResponse(), before)
+	}
+	if polls != 1 {
+		t.Errorf("messages polls = %d, want 1", polls)
+	}
+	if result.ClockSkew != 0 {
+		t.Errorf("Ping() clock skew through the cloud = %v, want 0", result.ClockSkew)
+	}
+}
+
+func TestIsHealthy_FailureThreshold(t *testing.T) {
+	fail := false
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	conn.SDKPortOverride = conn.LocalPort
+
+	if _, err := conn.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	fail = true
+	for i := 1; i <= HealthFailureThreshold; i++ {
+		if _, err := conn.Ping(context.Background()); err == nil {
+			t.Fatalf("Ping() against a failing hub error = nil")
+		}
+		if want := i < HealthFailureThreshold; conn.IsHealthy() != want {
+			t.Errorf("IsHealthy() after %d failures = %v, want %v", i, conn.IsHealthy(), want)
+		}
+	}
+
+	fail = false
+	if _, err := conn.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if !conn.IsHealthy() {
+		t.Errorf("IsHealthy() after recovering = false, want true")
+	}
+}
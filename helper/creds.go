@@ -1,21 +1,15 @@
 package helper
 
 import (
-	"encoding/json"
-	"os"
-
 	ddapi "github.com/gravypower/dd/api"
 )
 
-// LoadCreds loads a RegisterResponse from disk.
+// LoadCreds loads a RegisterResponse from a file, or from any store OpenStore accepts. An
+// encrypted file is decrypted with the passphrase from PassphraseFromEnv.
 func LoadCreds(p string) (*ddapi.RegisterResponse, error) {
-	f, err := os.Open(p)
+	store, err := OpenStore(p)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
-	var creds ddapi.RegisterResponse
-	err = json.NewDecoder(f).Decode(&creds)
-	return &creds, err
+	return store.Load()
 }
@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Environment variables an encrypted credentials file's passphrase is read from, when
+// it isn't given directly. The key file takes precedence.
+const (
+	PassphraseEnv = "DD_CREDENTIALS_PASSPHRASE"
+	KeyFileEnv    = "DD_CREDENTIALS_KEY_FILE"
+)
+
+// encryptedFormat identifies an encrypted credentials file.
+const encryptedFormat = "dd-credentials-aes-gcm"
+
+// scrypt parameters for deriving the AES-256 key from a passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+var (
+	// ErrPassphraseRequired is returned when reading an encrypted credentials file
+	// without a passphrase.
+	ErrPassphraseRequired = errors.New("credentials file is encrypted; set " + PassphraseEnv + " or " + KeyFileEnv)
+	// ErrDecrypt is returned when an encrypted credentials file can't be decrypted, most
+	// likely because the passphrase is wrong.
+	ErrDecrypt = errors.New("can't decrypt credentials; wrong passphrase?")
+)
+
+// encryptedFile is the on-disk form of an encrypted credentials file.
+type encryptedFile struct {
+	Format     string `json:"format"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ReadKeyFile reads a passphrase from a key file, ignoring surrounding whitespace.
+func ReadKeyFile(p string) ([]byte, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", p)
+	}
+	return key, nil
+}
+
+// PassphraseFromEnv returns the passphrase given by KeyFileEnv or PassphraseEnv, or
+// ErrPassphraseRequired if neither is set.
+func PassphraseFromEnv() ([]byte, error) {
+	if p := os.Getenv(KeyFileEnv); p != "" {
+		return ReadKeyFile(p)
+	}
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return nil, ErrPassphraseRequired
+}
+
+// isEncrypted reports whether b is an encrypted credentials file.
+func isEncrypted(b []byte) bool {
+	var f encryptedFile
+	return json.Unmarshal(b, &f) == nil && f.Format == encryptedFormat
+}
+
+// encrypt seals plaintext with AES-256-GCM under a key derived from passphrase.
+func encrypt(plaintext, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedFile{
+		Format:     encryptedFormat,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(encryptedFormat)),
+	})
+}
+
+// decrypt opens an encrypted credentials file.
+func decrypt(b, passphrase []byte) ([]byte, error) {
+	var f encryptedFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("decode encrypted credentials: %w", err)
+	}
+	gcm, err := newGCM(passphrase, f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := gcm.Open(nil, f.Nonce, f.Ciphertext, []byte(encryptedFormat))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore_Encrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.json")
+	if err := SaveEncryptedCreds(path, testCreds(), []byte("hunter2")); err != nil {
+		t.Fatalf("SaveEncryptedCreds() error = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !isEncrypted(b) {
+		t.Fatalf("saved file is not encrypted: %s", b)
+	}
+
+	creds, err := FileStore{Path: path, Passphrase: []byte("hunter2")}.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if creds.Credential != testCreds().Credential {
+		t.Errorf("Load() = %+v, want %+v", creds, testCreds())
+	}
+
+	if _, err := (FileStore{Path: path, Passphrase: []byte("wrong")}).Load(); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Load() with wrong passphrase error = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestLoadCreds_EncryptedFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "creds.json")
+	if err := SaveEncryptedCreds(path, testCreds(), []byte("hunter2")); err != nil {
+		t.Fatalf("SaveEncryptedCreds() error = %v", err)
+	}
+
+	t.Setenv(KeyFileEnv, "")
+	t.Setenv(PassphraseEnv, "")
+	if _, err := LoadCreds(path); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("LoadCreds() without passphrase error = %v, want %v", err, ErrPassphraseRequired)
+	}
+
+	t.Setenv(PassphraseEnv, "hunter2")
+	if _, err := LoadCreds(path); err != nil {
+		t.Errorf("LoadCreds() with %s error = %v", PassphraseEnv, err)
+	}
+
+	// The key file wins over the passphrase variable
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	t.Setenv(PassphraseEnv, "wrong")
+	t.Setenv(KeyFileEnv, keyFile)
+	if _, err := LoadCreds(path); err != nil {
+		t.Errorf("LoadCreds() with %s error = %v", KeyFileEnv, err)
+	}
+}
+
+func TestSaveEncryptedCreds_NotAFile(t *testing.T) {
+	if err := SaveEncryptedCreds("env://DD_CREDENTIALS", testCreds(), []byte("hunter2")); !errors.Is(err, ErrUnsupportedStore) {
+		t.Errorf("SaveEncryptedCreds(env://) error = %v, want %v", err, ErrUnsupportedStore)
+	}
+	path := filepath.Join(t.TempDir(), "creds.json")
+	if err := SaveEncryptedCreds(path, testCreds(), nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("SaveEncryptedCreds() without passphrase error = %v, want %v", err, ErrPassphraseRequired)
+	}
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix begins the environment variable for each flag; see EnvName.
+const EnvPrefix = "DD_"
+
+// fileSuffix marks the variant of a flag's variable that names a file holding its value,
+// e.g. a Docker or Kubernetes secret.
+const fileSuffix = "_FILE"
+
+// credentialsFlag names the credentials store in every binary. Its variable may instead
+// hold the credentials themselves; see SetFlagsFromEnv.
+const credentialsFlag = "credentials"
+
+// ErrEnvConflict is returned when both a flag's variable and its _FILE variant are set.
+var ErrEnvConflict = errors.New("set only one of")
+
+// ParseFlags parses the command line like flag.Parse, then fills in the flags it didn't
+// set from the environment; see SetFlagsFromEnv. An invalid variable exits like an
+// invalid flag.
+func ParseFlags() {
+	flag.Parse()
+	if err := SetFlagsFromEnv(flag.CommandLine); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// SetFlagsFromEnv sets each flag in fs that hasn't been set from its environment
+// variable (see EnvName), or from the file named by the same variable with a _FILE
+// suffix, less any trailing newline. The command line takes precedence over the
+// environment, which flag.Visit then reports as set like the command line, so it also
+// overrides config files.
+//
+// DD_CREDENTIALS may hold credentials JSON rather than a store URI, as read by
+// env://DD_CREDENTIALS, and DD_CREDENTIALS_FILE may name a credentials file.
+func SetFlagsFromEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		name := EnvName(f.Name)
+		value, fromFile, ok, lookupErr := lookupEnv(name)
+		if lookupErr != nil {
+			err = lookupErr
+			return
+		}
+		if !ok {
+			return
+		}
+		if f.Name == credentialsFlag && strings.HasPrefix(strings.TrimSpace(value), "{") {
+			if fromFile != "" {
+				value = fromFile
+			} else {
+				value = "env://" + name
+			}
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// lookupEnv returns the value of the variable name, or the contents of the file named by
+// name_FILE along with its path.
+func lookupEnv(name string) (value, path string, ok bool, err error) {
+	value, ok = os.LookupEnv(name)
+	path, fromFile := os.LookupEnv(name + fileSuffix)
+	if !fromFile {
+		return value, "", ok, nil
+	}
+	if ok {
+		return "", "", false, fmt.Errorf("%w %s and %s", ErrEnvConflict, name, name+fileSuffix)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false, fmt.Errorf("%s: %w", name+fileSuffix, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), path, true, nil
+}
+
+// EnvName returns the environment variable for a flag: EnvPrefix and the flag name in
+// upper snake case, e.g. DD_MQTT_PASSWORD for -mqttPassword and DD_SDK_PORT for -sdk-port.
+func EnvName(flagName string) string {
+	// QoS is one word
+	flagName = strings.ReplaceAll(flagName, "QoS", "Qos")
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	runes := []rune(flagName)
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == '.':
+			b.WriteByte('_')
+			continue
+		case i > 0 && unicode.IsUpper(r):
+			// A word starts at an upper case letter after a lower case one, or at the last
+			// letter of an acronym followed by lower case, as in ClientID or TLSKey
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
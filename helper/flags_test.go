@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		flag, want string
+	}{
+		{"host", "DD_HOST"},
+		{"mqtt", "DD_MQTT"},
+		{"mqttPassword", "DD_MQTT_PASSWORD"},
+		{"mqttClientID", "DD_MQTT_CLIENT_ID"},
+		{"mqttCA", "DD_MQTT_CA"},
+		{"mqttStateQoS", "DD_MQTT_STATE_QOS"},
+		{"sdk-port", "DD_SDK_PORT"},
+		{"otel-metrics-endpoint", "DD_OTEL_METRICS_ENDPOINT"},
+		{"tlsFingerprint", "DD_TLS_FINGERPRINT"},
+		{"httpAddr", "DD_HTTP_ADDR"},
+	}
+	for _, tt := range tests {
+		if got := EnvName(tt.flag); got != tt.want {
+			t.Errorf("EnvName(%q) = %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+}
+
+// testFlags returns a flag set with a few flags of each kind.
+func testFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("host", "", "")
+	fs.Int("mqttPort", 1883, "")
+	fs.String("mqttPassword", "", "")
+	fs.Bool("readOnly", false, "")
+	fs.Duration("longPoll", 0, "")
+	fs.String("credentials", "dd-credentials.json", "")
+	return fs
+}
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "mqtt_password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	t.Setenv("DD_HOST", "192.168.1.20")
+	t.Setenv("DD_MQTT_PORT", "8883")
+	t.Setenv("DD_MQTT_PASSWORD_FILE", secret)
+	t.Setenv("DD_READ_ONLY", "true")
+	t.Setenv("DD_LONG_POLL", "30s")
+
+	fs := testFlags()
+	if err := fs.Parse([]string{"-host", "10.0.0.5"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := SetFlagsFromEnv(fs); err != nil {
+		t.Fatalf("SetFlagsFromEnv() error = %v", err)
+	}
+
+	want := map[string]string{
+		"host":         "10.0.0.5", // the command line wins
+		"mqttPort":     "8883",
+		"mqttPassword": "s3cret",
+		"readOnly":     "true",
+		"longPoll":     (30 * time.Second).String(),
+		"credentials":  "dd-credentials.json",
+	}
+	for name, value := range want {
+		if got := fs.Lookup(name).Value.String(); got != value {
+			t.Errorf("-%s = %q, want %q", name, got, value)
+		}
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["mqttPort"] || set["credentials"] {
+		t.Errorf("flags set = %v, want those from the environment but not defaults", set)
+	}
+}
+
+func TestSetFlagsFromEnv_Credentials(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) {
+		t.Setenv("DD_CREDENTIALS", `{"phoneSecret": "x"}`)
+		fs := testFlags()
+		if err := SetFlagsFromEnv(fs); err != nil {
+			t.Fatalf("SetFlagsFromEnv() error = %v", err)
+		}
+		if got := fs.Lookup("credentials").Value.String(); got != "env://DD_CREDENTIALS" {
+			t.Errorf("-credentials with JSON in DD_CREDENTIALS = %q, want env://DD_CREDENTIALS", got)
+		}
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "creds.json")
+		if err := os.WriteFile(path, []byte(`{"phoneSecret": "x"}`), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		t.Setenv("DD_CREDENTIALS_FILE", path)
+		fs := testFlags()
+		if err := SetFlagsFromEnv(fs); err != nil {
+			t.Fatalf("SetFlagsFromEnv() error = %v", err)
+		}
+		if got := fs.Lookup("credentials").Value.String(); got != path {
+			t.Errorf("-credentials with DD_CREDENTIALS_FILE = %q, want %q", got, path)
+		}
+	})
+}
+
+func TestSetFlagsFromEnv_Invalid(t *testing.T) {
+	t.Run("bad value", func(t *testing.T) {
+		t.Setenv("DD_MQTT_PORT", "eighty")
+		if err := SetFlagsFromEnv(testFlags()); err == nil {
+			t.Error("SetFlagsFromEnv() error = nil, want an invalid value")
+		}
+	})
+	t.Run("both set", func(t *testing.T) {
+		t.Setenv("DD_MQTT_PASSWORD", "a")
+		t.Setenv("DD_MQTT_PASSWORD_FILE", "/run/secrets/mqtt")
+		if err := SetFlagsFromEnv(testFlags()); !errors.Is(err, ErrEnvConflict) {
+			t.Errorf("SetFlagsFromEnv() error = %v, want %v", err, ErrEnvConflict)
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("DD_MQTT_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+		if err := SetFlagsFromEnv(testFlags()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("SetFlagsFromEnv() error = %v, want %v", err, os.ErrNotExist)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/zalando/go-keyring"
+)
+
+// DefaultKeyringAccount is the keyring account used when a keyring URI names only a service.
+const DefaultKeyringAccount = "default"
+
+var (
+	// ErrUnsupportedStore is returned for a credentials URI with an unknown scheme.
+	ErrUnsupportedStore = errors.New("unsupported credentials store")
+	// ErrReadOnlyStore is returned when saving to a store that can only be read.
+	ErrReadOnlyStore = errors.New("credentials store is read-only")
+)
+
+// CredentialStore loads and saves a hub's credentials.
+type CredentialStore interface {
+	Load() (*ddapi.RegisterResponse, error)
+	Save(creds *ddapi.RegisterResponse) error
+}
+
+// OpenStore returns the store a credentials URI names:
+//
+//	dd-credentials.json, file:///etc/dd.json  a JSON file, optionally encrypted
+//	env://DD_CREDENTIALS                      JSON in an environment variable (read-only)
+//	keyring://dd/default                      the OS keyring (Keychain, Secret Service or
+//	                                          Windows Credential Manager), as service/account
+//
+// Anything without a scheme is a file path.
+func OpenStore(uri string) (CredentialStore, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return FileStore{Path: uri}, nil
+	}
+	switch scheme {
+	case "file":
+		return FileStore{Path: rest}, nil
+	case "env":
+		if rest == "" {
+			return nil, fmt.Errorf("%w: %s: no variable named", ErrUnsupportedStore, uri)
+		}
+		return EnvStore{Variable: rest}, nil
+	case "keyring":
+		service, account, _ := strings.Cut(rest, "/")
+		if service == "" {
+			return nil, fmt.Errorf("%w: %s: no service named", ErrUnsupportedStore, uri)
+		}
+		if account == "" {
+			account = DefaultKeyringAccount
+		}
+		return KeyringStore{Service: service, Account: account}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedStore, uri)
+}
+
+// SaveCreds saves creds to the store uri names; see OpenStore.
+func SaveCreds(uri string, creds *ddapi.RegisterResponse) error {
+	store, err := OpenStore(uri)
+	if err != nil {
+		return err
+	}
+	return store.Save(creds)
+}
+
+// SaveEncryptedCreds saves creds to a file encrypted with passphrase. uri must name a file.
+func SaveEncryptedCreds(uri string, creds *ddapi.RegisterResponse, passphrase []byte) error {
+	store, err := OpenStore(uri)
+	if err != nil {
+		return err
+	}
+	file, ok := store.(FileStore)
+	if !ok {
+		return fmt.Errorf("%w: only files can be encrypted: %s", ErrUnsupportedStore, uri)
+	}
+	if len(passphrase) == 0 {
+		return ErrPassphraseRequired
+	}
+	file.Passphrase = passphrase
+	return file.Save(creds)
+}
+
+// UpdateCreds loads the credentials uri names, applies update and saves them back. A
+// file encrypted when loaded is saved encrypted under the same passphrase: passphrase if
+// given, otherwise the one from PassphraseFromEnv.
+func UpdateCreds(uri string, passphrase []byte, update func(*ddapi.RegisterResponse) error) error {
+	store, err := OpenStore(uri)
+	if err != nil {
+		return err
+	}
+	if file, ok := store.(FileStore); ok {
+		b, err := os.ReadFile(file.Path)
+		if err != nil {
+			return err
+		}
+		if isEncrypted(b) {
+			if passphrase == nil {
+				if passphrase, err = PassphraseFromEnv(); err != nil {
+					return err
+				}
+			}
+			file.Passphrase = passphrase
+		}
+		store = file
+	}
+
+	creds, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if err := update(creds); err != nil {
+		return err
+	}
+	return store.Save(creds)
+}
+
+// FileStore keeps credentials as JSON in a file. With Passphrase set, Save encrypts the
+// file with AES-GCM. Load decrypts an encrypted file with Passphrase, or if that's unset
+// the passphrase from PassphraseFromEnv.
+type FileStore struct {
+	Path       string
+	Passphrase []byte
+}
+
+func (s FileStore) Load() (*ddapi.RegisterResponse, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(b) {
+		passphrase := s.Passphrase
+		if passphrase == nil {
+			if passphrase, err = PassphraseFromEnv(); err != nil {
+				return nil, err
+			}
+		}
+		if b, err = decrypt(b, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	var creds ddapi.RegisterResponse
+	err = json.Unmarshal(b, &creds)
+	return &creds, err
+}
+
+// Save writes creds readable only by the current user, encrypted if Passphrase is set.
+// The file is replaced atomically, so a failed save leaves the old credentials in place.
+func (s FileStore) Save(creds *ddapi.RegisterResponse) error {
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if s.Passphrase != nil {
+		if b, err = encrypt(b, s.Passphrase); err != nil {
+			return fmt.Errorf("encrypt credentials: %w", err)
+		}
+	}
+	return writeFileAtomic(s.Path, b)
+}
+
+// writeFileAtomic writes b to a temporary file, mode 0600, beside p and renames it over p.
+func writeFileAtomic(p string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // fails harmlessly once renamed
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// EnvStore reads credentials JSON from an environment variable, e.g. one set from a
+// container secret.
+type EnvStore struct {
+	Variable string
+}
+
+func (s EnvStore) Load() (*ddapi.RegisterResponse, error) {
+	value, ok := os.LookupEnv(s.Variable)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", s.Variable)
+	}
+	return decodeCreds(value)
+}
+
+func (s EnvStore) Save(*ddapi.RegisterResponse) error {
+	return fmt.Errorf("%w: env://%s", ErrReadOnlyStore, s.Variable)
+}
+
+// KeyringStore keeps credentials JSON in the OS keyring.
+type KeyringStore struct {
+	Service string
+	Account string
+}
+
+func (s KeyringStore) Load() (*ddapi.RegisterResponse, error) {
+	value, err := keyring.Get(s.Service, s.Account)
+	if err != nil {
+		return nil, fmt.Errorf("keyring %s/%s: %w", s.Service, s.Account, err)
+	}
+	return decodeCreds(value)
+}
+
+func (s KeyringStore) Save(creds *ddapi.RegisterResponse) error {
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(s.Service, s.Account, string(b)); err != nil {
+		return fmt.Errorf("keyring %s/%s: %w", s.Service, s.Account, err)
+	}
+	return nil
+}
+
+func decodeCreds(value string) (*ddapi.RegisterResponse, error) {
+	var creds ddapi.RegisterResponse
+	if err := json.Unmarshal([]byte(value), &creds); err != nil {
+		return nil, fmt.Errorf("decode credentials: %w", err)
+	}
+	return &creds, nil
+}
@@ -0,0 +1,164 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gravypower/dd"
+	ddapi "github.com/gravypower/dd/api"
+	"github.com/zalando/go-keyring"
+)
+
+func testCreds() *ddapi.RegisterResponse {
+	return &ddapi.RegisterResponse{
+		Credential: dd.Credential{PhoneSecret: "test_secret", BaseStation: "test_basestation", Phone: "test_phone"},
+		Name:       "Test",
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    CredentialStore
+		wantErr error
+	}{
+		{"dd-credentials.json", FileStore{Path: "dd-credentials.json"}, nil},
+		{"/etc/dd/creds.json", FileStore{Path: "/etc/dd/creds.json"}, nil},
+		{"file:///etc/dd/creds.json", FileStore{Path: "/etc/dd/creds.json"}, nil},
+		{"env://DD_CREDENTIALS", EnvStore{Variable: "DD_CREDENTIALS"}, nil},
+		{"keyring://dd/garage", KeyringStore{Service: "dd", Account: "garage"}, nil},
+		{"keyring://dd", KeyringStore{Service: "dd", Account: DefaultKeyringAccount}, nil},
+		{"keyring://", nil, ErrUnsupportedStore},
+		{"env://", nil, ErrUnsupportedStore},
+		{"vault://secret/dd", nil, ErrUnsupportedStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, err := OpenStore(tt.uri)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenStore(%q) error = %v, want %v", tt.uri, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OpenStore(%q) = %#v, want %#v", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStore_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.json")
+	if err := SaveCreds(path, testCreds()); err != nil {
+		t.Fatalf("SaveCreds() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("credentials file mode = %v, want 0600", perm)
+	}
+
+	creds, err := LoadCreds("file://" + path)
+	if err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if creds.Credential != testCreds().Credential || creds.Name != "Test" {
+		t.Errorf("LoadCreds() = %+v, want %+v", creds, testCreds())
+	}
+}
+
+func TestEnvStore(t *testing.T) {
+	t.Setenv("DD_TEST_CREDENTIALS", `{"phoneSecret": "test_secret", "name": "Test"}`)
+	creds, err := LoadCreds("env://DD_TEST_CREDENTIALS")
+	if err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if creds.PhoneSecret != "test_secret" || creds.Name != "Test" {
+		t.Errorf("LoadCreds() = %+v, want credentials from environment", creds)
+	}
+
+	if _, err := LoadCreds("env://DD_TEST_UNSET"); err == nil {
+		t.Errorf("LoadCreds() with unset variable error = nil, want error")
+	}
+	if err := SaveCreds("env://DD_TEST_CREDENTIALS", testCreds()); !errors.Is(err, ErrReadOnlyStore) {
+		t.Errorf("SaveCreds() error = %v, want %v", err, ErrReadOnlyStore)
+	}
+}
+
+func TestKeyringStore(t *testing.T) {
+	keyring.MockInit()
+
+	if _, err := LoadCreds("keyring://dd/garage"); !errors.Is(err, keyring.ErrNotFound) {
+		t.Errorf("LoadCreds() before save error = %v, want %v", err, keyring.ErrNotFound)
+	}
+	if err := SaveCreds("keyring://dd/garage", testCreds()); err != nil {
+		t.Fatalf("SaveCreds() error = %v", err)
+	}
+	creds, err := LoadCreds("keyring://dd/garage")
+	if err != nil {
+		t.Fatalf("LoadCreds() error = %v", err)
+	}
+	if creds.Credential != testCreds().Credential {
+		t.Errorf("LoadCreds() = %+v, want %+v", creds, testCreds())
+	}
+}
+
+func TestUpdateCreds(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.json")
+	encrypted := filepath.Join(dir, "encrypted.json")
+	if err := SaveCreds(plain, testCreds()); err != nil {
+		t.Fatalf("SaveCreds() error = %v", err)
+	}
+	if err := SaveEncryptedCreds(encrypted, testCreds(), []byte("hunter2")); err != nil {
+		t.Fatalf("SaveEncryptedCreds() error = %v", err)
+	}
+	t.Setenv(KeyFileEnv, "")
+	t.Setenv(PassphraseEnv, "hunter2")
+
+	rotate := func(creds *ddapi.RegisterResponse) error {
+		creds.PhoneSecret = "rotated"
+		return nil
+	}
+	for _, path := range []string{plain, encrypted} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if err := UpdateCreds(path, nil, rotate); err != nil {
+				t.Fatalf("UpdateCreds() error = %v", err)
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if isEncrypted(after) != isEncrypted(before) {
+				t.Errorf("UpdateCreds() changed encryption from %v to %v", isEncrypted(before), isEncrypted(after))
+			}
+			creds, err := LoadCreds(path)
+			if err != nil {
+				t.Fatalf("LoadCreds() error = %v", err)
+			}
+			if creds.PhoneSecret != "rotated" || creds.Name != "Test" {
+				t.Errorf("LoadCreds() after update = %+v, want rotated secret", creds)
+			}
+		})
+	}
+
+	// A failed update leaves the file alone, with no temporary files behind
+	failed := errors.New("failed")
+	if err := UpdateCreds(plain, nil, func(*ddapi.RegisterResponse) error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("UpdateCreds() error = %v, want %v", err, failed)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("directory has %d entries after updates, want 2", len(entries))
+	}
+}
@@ -0,0 +1,113 @@
+package dd
+
+import "time"
+
+// Hooks receives a Conn's traffic with the hub, for tracing (e.g. OpenTelemetry spans) or
+// debugging dumps. Set Conn.Hooks to use it. The methods are called synchronously, on the
+// goroutine making the request, and may be called concurrently; they must not block or
+// call back into the Conn. Embed NopHooks to implement only some of them.
+type Hooks interface {
+	// OnRequest is called before a session request (connect, RPC or messages poll) is sent.
+	OnRequest(RequestEvent)
+	// OnResponse is called once the hub has answered the request, or it has failed.
+	OnResponse(ResponseEvent)
+	// OnMessage is called for each message that comes back with a response, before it is
+	// queued or handed to the RPC waiting for it.
+	OnMessage(MessageEvent)
+}
+
+// RequestEvent describes a request about to be sent.
+type RequestEvent struct {
+	Path      string // hub path, e.g. "app/res/action"
+	ProcessID string // empty for connect
+	Payload   []byte // decrypted request data; nil when there's none or Conn.RedactPayloads is set
+}
+
+// ResponseEvent describes the outcome of a request.
+type ResponseEvent struct {
+	Path       string
+	ProcessID  string
+	Duration   time.Duration // from sending the request to decoding the response
+	StatusCode int           // HTTP status, or 0 if the hub couldn't be reached
+	Err        error         // nil on success
+	// Payload is the decrypted inline RPC response, if the hub sent one; nil when
+	// Conn.RedactPayloads is set.
+	Payload []byte
+}
+
+// MessageEvent describes a message received from the hub.
+type MessageEvent struct {
+	ProcessID string // the RPC the message answers; empty for a status message
+	Type      int
+	Sequence  int
+	Payload   []byte // decrypted message; nil when Conn.RedactPayloads is set
+}
+
+// NopHooks implements Hooks by doing nothing.
+type NopHooks struct{}
+
+func (NopHooks) OnRequest(RequestEvent)   {}
+func (NopHooks) OnResponse(ResponseEvent) {}
+func (NopHooks) OnMessage(MessageEvent)   {}
+
+// tracer returns where to report the Conn's traffic: its Hooks, without payloads if
+// RedactPayloads is set, and its Recorder. It's nil if there's neither.
+func (dc *Conn) tracer() Hooks {
+	var hooks multiHooks
+	if dc.Hooks != nil {
+		h := dc.Hooks
+		if dc.RedactPayloads {
+			h = redactedHooks{h}
+		}
+		hooks = append(hooks, h)
+	}
+	if dc.Recorder != nil {
+		hooks = append(hooks, dc.Recorder)
+	}
+	switch len(hooks) {
+	case 0:
+		return nil
+	case 1:
+		return hooks[0]
+	}
+	return hooks
+}
+
+// multiHooks passes each event to all of its Hooks in turn.
+type multiHooks []Hooks
+
+func (m multiHooks) OnRequest(e RequestEvent) {
+	for _, h := range m {
+		h.OnRequest(e)
+	}
+}
+
+func (m multiHooks) OnResponse(e ResponseEvent) {
+	for _, h := range m {
+		h.OnResponse(e)
+	}
+}
+
+func (m multiHooks) OnMessage(e MessageEvent) {
+	for _, h := range m {
+		h.OnMessage(e)
+	}
+}
+
+// redactedHooks passes events on without their payloads.
+type redactedHooks struct{ Hooks }
+
+func (r redactedHooks) OnRequest(e RequestEvent) {
+	e.Payload = nil
+	r.Hooks.OnRequest(e)
+}
+
+func (r redactedHooks) OnResponse(e ResponseEvent) {
+	e.Payload = nil
+	r.Hooks.OnResponse(e)
+}
+
+func (r redactedHooks) OnMessage(e MessageEvent) {
+	e.Payload = nil
+	r.Hooks.OnMessage(e)
+}
@@ -0,0 +1,130 @@
+package dd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingHooks keeps every event it's given.
+type recordingHooks struct {
+	mu        sync.Mutex
+	requests  []RequestEvent
+	responses []ResponseEvent
+	messages  []MessageEvent
+}
+
+func (h *recordingHooks) OnRequest(e RequestEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, e)
+}
+
+func (h *recordingHooks) OnResponse(e ResponseEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responses = append(h.responses, e)
+}
+
+func (h *recordingHooks) OnMessage(e MessageEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, e)
+}
+
+// responseHooks only keeps responses, using NopHooks for the rest.
+type responseHooks struct {
+	NopHooks
+	responses []ResponseEvent
+}
+
+func (h *responseHooks) OnResponse(e ResponseEvent) {
+	h.responses = append(h.responses, e)
+}
+
+// newHooksTestConn returns a Conn whose hub answers every RPC inline with {"ok":true}.
+func newHooksTestConn(t *testing.T) *Conn {
+	t.Helper()
+	return newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		var req genericRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(messagesResponse(t, Message{
+			ProcessID:   req.ProcessID,
+			Type:        2,
+			dataPayload: dataPayload{Data: `{"ok":true}`},
+		}))
+	})
+}
+
+func TestHooks(t *testing.T) {
+	conn := newHooksTestConn(t)
+	hooks := &recordingHooks{}
+	conn.Hooks = hooks
+
+	if err := conn.RPC(RPC{Path: "/app/res/action", Input: map[string]int{"command": 1}}); err != nil {
+		t.Fatalf("RPC() error = %v", err)
+	}
+
+	if len(hooks.requests) != 1 || len(hooks.responses) != 1 || len(hooks.messages) != 1 {
+		t.Fatalf("hooks got %d requests, %d responses, %d messages, want 1 of each",
+			len(hooks.requests), len(hooks.responses), len(hooks.messages))
+	}
+	req, resp, msg := hooks.requests[0], hooks.responses[0], hooks.messages[0]
+	if !strings.HasSuffix(req.Path, "app/res/action") || req.ProcessID == "" {
+		t.Errorf("OnRequest() event = %+v, want path app/res/action with a process ID", req)
+	}
+	if string(req.Payload) != `{"command":1}` {
+		t.Errorf("OnRequest() payload = %q, want the decrypted input", req.Payload)
+	}
+	if resp.Path != req.Path || resp.ProcessID != req.ProcessID {
+		t.Errorf("OnResponse() event = %+v, want the request's path and process ID", resp)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Err != nil || resp.Duration <= 0 {
+		t.Errorf("OnResponse() event = %+v, want status 200, no error and a duration", resp)
+	}
+	if string(resp.Payload) != `{"ok":true}` {
+		t.Errorf("OnResponse() payload = %q, want the inline response", resp.Payload)
+	}
+	if msg.ProcessID != req.ProcessID || msg.Type != 2 || string(msg.Payload) != `{"ok":true}` {
+		t.Errorf("OnMessage() event = %+v, want the inline response message", msg)
+	}
+}
+
+func TestHooks_RedactPayloads(t *testing.T) {
+	conn := newHooksTestConn(t)
+	hooks := &recordingHooks{}
+	conn.Hooks = hooks
+	conn.RedactPayloads = true
+
+	if err := conn.RPC(RPC{Path: "/app/res/action", Input: map[string]int{"command": 1}}); err != nil {
+		t.Fatalf("RPC() error = %v", err)
+	}
+	if len(hooks.requests) != 1 || len(hooks.responses) != 1 || len(hooks.messages) != 1 {
+		t.Fatalf("hooks got %d requests, %d responses, %d messages, want 1 of each",
+			len(hooks.requests), len(hooks.responses), len(hooks.messages))
+	}
+	if hooks.requests[0].Payload != nil || hooks.responses[0].Payload != nil || hooks.messages[0].Payload != nil {
+		t.Errorf("payloads with RedactPayloads = %q, %q, %q, want nil",
+			hooks.requests[0].Payload, hooks.responses[0].Payload, hooks.messages[0].Payload)
+	}
+}
+
+func TestHooks_Error(t *testing.T) {
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	hooks := &responseHooks{}
+	conn.Hooks = hooks
+
+	if err := conn.RPC(RPC{Path: "/app/res/action"}); err == nil {
+		t.Fatalf("RPC() against a failing hub error = nil")
+	}
+	if len(hooks.responses) == 0 {
+		t.Fatalf("OnResponse() not called")
+	}
+	if resp := hooks.responses[0]; resp.StatusCode != http.StatusInternalServerError || resp.Err == nil {
+		t.Errorf("OnResponse() event = %+v, want status 500 and an error", resp)
+	}
+}
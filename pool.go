@@ -0,0 +1,85 @@
+package dd
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// Connection reuse defaults for the default transport; see PoolConfig
+const (
+	// DefaultMaxIdleConnsPerHost leaves room for a long poll, an RPC and a health ping to
+	// the hub at once, where net/http keeps only 2.
+	DefaultMaxIdleConnsPerHost = 4
+	// DefaultIdleConnTimeout is how long an idle connection to the hub is kept.
+	DefaultIdleConnTimeout = 90 * time.Second
+	// DefaultTLSSessionCacheSize is how many TLS sessions are kept for resumption.
+	DefaultTLSSessionCacheSize = 16
+)
+
+// How a request got its connection, recorded on dd.http.connections
+const (
+	connReused  = "reused"  // an idle connection from the pool
+	connResumed = "resumed" // a new connection resuming an earlier TLS session
+	connNew     = "new"     // a new connection with a full TLS handshake
+)
+
+// PoolConfig tunes how the default transport reuses connections to the hub. Messages are
+// polled every few hundred milliseconds while waiting for an RPC, and each new connection
+// costs the hub a TLS handshake, so connections are kept open and TLS sessions resumed
+// when one has to be replaced. Zero values use the defaults. It doesn't apply with
+// Conn.Transport or Conn.HTTPClient.
+type PoolConfig struct {
+	MaxIdleConnsPerHost int           // defaults to DefaultMaxIdleConnsPerHost
+	IdleConnTimeout     time.Duration // defaults to DefaultIdleConnTimeout
+	// TLSSessionCacheSize defaults to DefaultTLSSessionCacheSize; negative turns off
+	// resumption. It's ignored if Conn.TLSConfig has its own ClientSessionCache.
+	TLSSessionCacheSize int
+}
+
+// apply sets p on t.
+func (p PoolConfig) apply(t *http.Transport) {
+	t.MaxIdleConnsPerHost = p.MaxIdleConnsPerHost
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = p.IdleConnTimeout
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	size := p.TLSSessionCacheSize
+	if size == 0 {
+		size = DefaultTLSSessionCacheSize
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	if size > 0 && t.TLSClientConfig.ClientSessionCache == nil {
+		t.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	}
+}
+
+// connTrace returns a trace recording on dd.http.connections whether a request reused a
+// pooled connection, resumed a TLS session or needed a full handshake.
+func connTrace(ctx context.Context) *httptrace.ClientTrace {
+	// The handshake may run on the transport's dialing goroutine
+	var resumed atomic.Bool
+	return &httptrace.ClientTrace{
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			resumed.Store(err == nil && state.DidResume)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			switch {
+			case info.Reused:
+				recordConn(ctx, connReused)
+			case resumed.Load():
+				recordConn(ctx, connResumed)
+			default:
+				recordConn(ctx, connNew)
+			}
+		},
+	}
+}
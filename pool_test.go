@@ -0,0 +1,88 @@
+package dd
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// connCounts returns the dd.http.connections counts collected by reader, by how.
+func connCounts(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	counts := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok || m.Name != "dd.http.connections" {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				how, _ := dp.Attributes.Value("conn")
+				counts[how.AsString()] += dp.Value
+			}
+		}
+	}
+	return counts
+}
+
+func TestConn_ReusesConnections(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	if err := SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatalf("SetMeterProvider() error = %v", err)
+	}
+	t.Cleanup(func() { SetMeterProvider(otel.GetMeterProvider()) })
+
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	request := func() {
+		t.Helper()
+		var out struct{}
+		if err := conn.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out}); err != nil {
+			t.Fatalf("SimpleRequest() error = %v", err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		request()
+	}
+	if got := connCounts(t, reader); got[connNew] != 1 || got[connReused] != 2 {
+		t.Errorf("connections after 3 requests = %v, want 1 new and 2 reused", got)
+	}
+
+	// A replacement connection resumes the TLS session
+	conn.client.CloseIdleConnections()
+	request()
+	if got := connCounts(t, reader); got[connResumed] != 1 {
+		t.Errorf("connections after reconnecting = %v, want 1 resumed", got)
+	}
+}
+
+func TestPoolConfig_Apply(t *testing.T) {
+	transport := &http.Transport{}
+	PoolConfig{}.apply(transport)
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("defaults = %d, %v, want %d, %v", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, DefaultMaxIdleConnsPerHost, DefaultIdleConnTimeout)
+	}
+	if transport.TLSClientConfig.ClientSessionCache == nil {
+		t.Error("default ClientSessionCache = nil, want TLS sessions cached")
+	}
+
+	transport = &http.Transport{}
+	PoolConfig{MaxIdleConnsPerHost: 1, IdleConnTimeout: time.Second, TLSSessionCacheSize: -1}.apply(transport)
+	if transport.MaxIdleConnsPerHost != 1 || transport.IdleConnTimeout != time.Second {
+		t.Errorf("settings = %d, %v, want 1, 1s", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+	if transport.TLSClientConfig.ClientSessionCache != nil {
+		t.Error("ClientSessionCache with a negative size is set, want resumption off")
+	}
+}
@@ -0,0 +1,34 @@
+package dd
+
+import "sync"
+
+// messageQueue buffers status messages between the request that received them and the
+// caller that takes them. It has its own lock, so taking messages never waits behind a
+// request in flight, and each message is taken exactly once.
+type messageQueue struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// push appends messages to the queue.
+func (q *messageQueue) push(messages ...*Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, messages...)
+}
+
+// take removes and returns all queued messages, oldest first.
+func (q *messageQueue) take() []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := q.messages
+	q.messages = nil
+	return out
+}
+
+// len returns the number of queued messages.
+func (q *messageQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
@@ -0,0 +1,179 @@
+package dd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter paces signed requests to the hub's access window. A Conn calls Reset with the
+// access data from each connect handshake, then Wait before signing every request.
+type RateLimiter interface {
+	// Wait blocks until a request may be sent and returns the timestamp (epoch millis) to
+	// sign it with. Timestamps must increase from one request to the next.
+	Wait(ctx context.Context) (int, error)
+	// Reset starts a new access window from a connect response.
+	Reset(access UserAccess)
+}
+
+// Priority orders requests waiting for the hub's access window, so a door command from a
+// user isn't kept waiting by background polling. Set it on a request's context with
+// WithPriority.
+type Priority int
+
+const (
+	// PriorityBackground is for messages polls and pings, which can wait.
+	PriorityBackground Priority = iota - 1
+	// PriorityNormal is for RPCs without a priority of their own.
+	PriorityNormal
+	// PriorityCommand is for user-initiated commands, e.g. opening a door from MQTT.
+	PriorityCommand
+)
+
+type priorityKey struct{}
+
+// WithPriority returns ctx carrying p for requests made with it.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the priority set on ctx, or def if there's none.
+func priorityFrom(ctx context.Context, def Priority) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return def
+}
+
+// AccessLimiter is the default RateLimiter. It hands out request slots at least Interval
+// apart, starting no earlier than the hub's NextAccess, and stamps each request Lead ahead
+// of its slot. When a slot comes up it goes to the waiting request with the highest
+// Priority, in the order callers arrived within a priority, so a command preempts a poll
+// that was waiting for the same slot. While the hub reports the user as restricted, Wait
+// fails with ErrAccessRestricted until NextAccess instead of blocking.
+type AccessLimiter struct {
+	interval time.Duration
+	lead     time.Duration
+
+	mu          sync.Mutex
+	next        time.Time // earliest time the next slot may start
+	last        int       // last timestamp handed out
+	restricted  bool
+	restriction string // hub's description of the current restriction
+	waiting     []*slotWaiter
+	timer       *time.Timer // fires when the next slot is due, while anyone is waiting
+}
+
+// slotWaiter is a Wait call waiting for a slot.
+type slotWaiter struct {
+	priority Priority
+	granted  chan int  // receives the slot's timestamp
+	slot     time.Time // when the slot was granted
+}
+
+// NewAccessLimiter returns an AccessLimiter spacing requests interval apart and stamping
+// them lead ahead. A Conn without a RateLimiter uses NextAccessResetAheadMillis and
+// NextAccessBumpMillis; tests against a fake hub can use zero for both.
+func NewAccessLimiter(interval, lead time.Duration) *AccessLimiter {
+	return &AccessLimiter{interval: interval, lead: lead}
+}
+
+func newDefaultLimiter() *AccessLimiter {
+	return NewAccessLimiter(NextAccessResetAheadMillis*time.Millisecond, NextAccessBumpMillis*time.Millisecond)
+}
+
+// Reset implements RateLimiter.
+func (l *AccessLimiter) Reset(access UserAccess) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next = time.UnixMilli(int64(access.NextAccess))
+	l.restricted = access.IsCurrentlyRestricted
+	l.restriction = access.DescriptionRestrictionDetails
+	l.dispatch(time.Now())
+}
+
+// Wait implements RateLimiter, taking the request's Priority from ctx (PriorityNormal if
+// it has none).
+func (l *AccessLimiter) Wait(ctx context.Context) (int, error) {
+	l.mu.Lock()
+	if l.restricted {
+		if time.Now().Before(l.next) {
+			defer l.mu.Unlock()
+			return 0, fmt.Errorf("%w until %v: %s", ErrAccessRestricted, l.next.Format(time.RFC3339), l.restriction)
+		}
+		l.restricted = false
+	}
+
+	w := &slotWaiter{priority: priorityFrom(ctx, PriorityNormal), granted: make(chan int, 1)}
+	l.waiting = append(l.waiting, w)
+	l.dispatch(time.Now())
+	l.mu.Unlock()
+
+	select {
+	case stamp := <-w.granted:
+		return stamp, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.abandon(w)
+		return 0, ctx.Err()
+	}
+}
+
+// abandon takes w out of the queue. If it was granted a slot as its caller gave up, the
+// slot goes to the next waiter, unless a later one has already gone out. The caller must
+// hold l.mu.
+func (l *AccessLimiter) abandon(w *slotWaiter) {
+	for i, other := range l.waiting {
+		if other == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			return
+		}
+	}
+	select {
+	case stamp := <-w.granted:
+		if stamp == l.last {
+			l.next = w.slot
+			l.dispatch(time.Now())
+		}
+	default:
+	}
+}
+
+// dispatch grants slots that are due to the waiting requests with the highest priority,
+// and sets the timer for the next one. The caller must hold l.mu.
+func (l *AccessLimiter) dispatch(now time.Time) {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	for len(l.waiting) > 0 {
+		if wait := l.next.Sub(now); wait > 0 {
+			l.timer = time.AfterFunc(wait, func() {
+				l.mu.Lock()
+				defer l.mu.Unlock()
+				l.dispatch(time.Now())
+			})
+			return
+		}
+
+		best := 0
+		for i, w := range l.waiting {
+			if w.priority > l.waiting[best].priority {
+				best = i
+			}
+		}
+		w := l.waiting[best]
+		l.waiting = append(l.waiting[:best], l.waiting[best+1:]...)
+
+		l.next = now.Add(l.interval)
+		stamp := int(now.Add(l.lead).UnixMilli())
+		if stamp <= l.last {
+			stamp = l.last + 1
+		}
+		l.last = stamp
+		w.slot = now
+		w.granted <- stamp
+	}
+}
@@ -0,0 +1,157 @@
+package dd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccessLimiter_Spacing(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	l := NewAccessLimiter(interval, time.Second)
+	start := time.Now()
+	l.Reset(UserAccess{NextAccess: int(start.UnixMilli())})
+
+	// Concurrent callers each get their own slot
+	const callers = 4
+	stamps := make(chan int, callers)
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stamp, err := l.Wait(context.Background())
+			if err != nil {
+				t.Errorf("Wait() error = %v", err)
+			}
+			stamps <- stamp
+		}()
+	}
+	wg.Wait()
+	close(stamps)
+
+	if elapsed := time.Since(start); elapsed < (callers-1)*interval {
+		t.Errorf("%d requests took %v, want at least %v", callers, elapsed, (callers-1)*interval)
+	}
+	seen := make(map[int]bool)
+	for stamp := range stamps {
+		if seen[stamp] {
+			t.Errorf("timestamp %d handed out twice", stamp)
+		}
+		seen[stamp] = true
+		if earliest := int(start.Add(time.Second).UnixMilli()); stamp < earliest {
+			t.Errorf("timestamp %d is before %d, want at least the 1s lead", stamp, earliest)
+		}
+	}
+}
+
+func TestAccessLimiter_Increasing(t *testing.T) {
+	l := NewAccessLimiter(0, 0)
+	prev := 0
+	for range 100 {
+		stamp, err := l.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+		if stamp <= prev {
+			t.Fatalf("Wait() = %d after %d, want increasing timestamps", stamp, prev)
+		}
+		prev = stamp
+	}
+}
+
+func TestAccessLimiter_NextAccess(t *testing.T) {
+	l := NewAccessLimiter(0, 0)
+	next := time.Now().Add(time.Hour)
+	l.Reset(UserAccess{NextAccess: int(next.UnixMilli())})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() before NextAccess error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestAccessLimiter_Restricted(t *testing.T) {
+	l := NewAccessLimiter(0, 0)
+	l.Reset(UserAccess{
+		NextAccess:                    int(time.Now().Add(time.Hour).UnixMilli()),
+		IsCurrentlyRestricted:         true,
+		DescriptionRestrictionDetails: "weekdays only",
+	})
+	if _, err := l.Wait(context.Background()); !errors.Is(err, ErrAccessRestricted) {
+		t.Errorf("Wait() while restricted error = %v, want ErrAccessRestricted", err)
+	}
+
+	// Once the restriction has passed, requests go through
+	l.Reset(UserAccess{NextAccess: int(time.Now().Add(-time.Minute).UnixMilli()), IsCurrentlyRestricted: true})
+	if _, err := l.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() after restriction error = %v, want nil", err)
+	}
+}
+
+func TestAccessLimiter_Priority(t *testing.T) {
+	l := NewAccessLimiter(100*time.Millisecond, 0)
+	if _, err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	// A poll queues for the next slot, then a command arrives and takes it
+	order := make(chan Priority, 2)
+	wait := func(p Priority) {
+		if _, err := l.Wait(WithPriority(context.Background(), p)); err != nil {
+			t.Errorf("Wait(%d) error = %v", p, err)
+		}
+		order <- p
+	}
+	go wait(PriorityBackground)
+	waitQueued(t, l, 1)
+	go wait(PriorityCommand)
+	waitQueued(t, l, 2)
+
+	if first, second := <-order, <-order; first != PriorityCommand || second != PriorityBackground {
+		t.Errorf("slots went to priorities %d, %d, want the command first", first, second)
+	}
+
+	// A caller giving up leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() with a short deadline error = %v, want DeadlineExceeded", err)
+	}
+	waitQueued(t, l, 0)
+}
+
+func TestAccessLimiter_AbandonedSlot(t *testing.T) {
+	l := NewAccessLimiter(time.Hour, 0)
+
+	// A slot is granted just as its caller gives up
+	w := &slotWaiter{granted: make(chan int, 1)}
+	l.mu.Lock()
+	l.waiting = append(l.waiting, w)
+	l.dispatch(time.Now())
+	l.abandon(w)
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := l.Wait(ctx); err != nil {
+		t.Errorf("Wait() after an abandoned slot error = %v, want the slot", err)
+	}
+}
+
+// waitQueued waits for n callers to be waiting in l.
+func waitQueued(t *testing.T, l *AccessLimiter, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		waiting := len(l.waiting)
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+	}
+	t.Fatalf("never had %d waiting", n)
+}
@@ -0,0 +1,157 @@
+package dd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of RecordedEvent
+const (
+	RecordedRequest  = "request"
+	RecordedResponse = "response"
+	RecordedMessage  = "message"
+)
+
+// RecordedEvent is a line written by a Recorder: one of the events Hooks receive, with its
+// decrypted payload.
+type RecordedEvent struct {
+	Time       time.Time       `json:"time"`
+	Kind       string          `json:"kind"` // RecordedRequest, RecordedResponse or RecordedMessage
+	Path       string          `json:"path,omitempty"`
+	ProcessID  string          `json:"processId,omitempty"`
+	DurationMS int64           `json:"durationMs,omitempty"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Type       int             `json:"type,omitempty"`
+	Sequence   int             `json:"sequence,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"` // as is if it's JSON, or as a JSON string
+}
+
+// Recorder captures a Conn's exchanges with the hub, with their decrypted payloads, as
+// JSON lines of RecordedEvent, for ddtest.Replay to feed back in tests. Set Conn.Recorder
+// to use it; payloads are recorded even with Conn.RedactPayloads. Secrets in payloads are
+// masked unless SetUnsafeLogSecrets is on. It's safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// OpenRecorder returns a Recorder appending to the named file, creating it if needed.
+// Close it when done.
+func OpenRecorder(name string) (*Recorder, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+func (r *Recorder) OnRequest(e RequestEvent) {
+	r.write(RecordedEvent{
+		Kind:      RecordedRequest,
+		Path:      e.Path,
+		ProcessID: e.ProcessID,
+		Payload:   recordedPayload(e.Payload),
+	})
+}
+
+func (r *Recorder) OnResponse(e ResponseEvent) {
+	event := RecordedEvent{
+		Kind:       RecordedResponse,
+		Path:       e.Path,
+		ProcessID:  e.ProcessID,
+		DurationMS: e.Duration.Milliseconds(),
+		StatusCode: e.StatusCode,
+		Payload:    recordedPayload(e.Payload),
+	}
+	if e.Err != nil {
+		event.Error = Redact(e.Err.Error())
+	}
+	r.write(event)
+}
+
+func (r *Recorder) OnMessage(e MessageEvent) {
+	r.write(RecordedEvent{
+		Kind:      RecordedMessage,
+		ProcessID: e.ProcessID,
+		Type:      e.Type,
+		Sequence:  e.Sequence,
+		Payload:   recordedPayload(e.Payload),
+	})
+}
+
+// Err returns the first error writing the recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close closes the file opened by OpenRecorder, returning any error writing to it.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closer != nil {
+		if err := r.closer.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
+		r.closer = nil
+	}
+	return r.err
+}
+
+func (r *Recorder) write(event RecordedEvent) {
+	event.Time = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Once a write fails the recording can't be replayed in order, so it stops there
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(event)
+}
+
+// recordedPayload returns b as it's recorded: masked, and as a JSON string unless it's JSON.
+func recordedPayload(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	s := string(b)
+	if !unsafeLogSecrets.Load() {
+		s = Redact(s)
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	quoted, _ := json.Marshal(s)
+	return quoted
+}
+
+// ReadRecording returns the events a Recorder wrote to r.
+func ReadRecording(r io.Reader) ([]RecordedEvent, error) {
+	var events []RecordedEvent
+	dec := json.NewDecoder(r)
+	for {
+		var event RecordedEvent
+		err := dec.Decode(&event)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,65 @@
+package dd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	conn := newHooksTestConn(t)
+	var out bytes.Buffer
+	conn.Recorder = NewRecorder(&out)
+	hooks := &recordingHooks{}
+	conn.Hooks = hooks
+	conn.RedactPayloads = true
+
+	if err := conn.RPC(RPC{Path: "/app/res/action", Input: map[string]int{"command": 1}}); err != nil {
+		t.Fatalf("RPC() error = %v", err)
+	}
+	if len(hooks.requests) != 1 || hooks.requests[0].Payload != nil {
+		t.Errorf("Hooks got requests %+v, want one without its payload", hooks.requests)
+	}
+
+	events, err := ReadRecording(&out)
+	if err != nil {
+		t.Fatalf("ReadRecording() error = %v", err)
+	}
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	if len(events) != 3 {
+		t.Fatalf("recorded %v, want a request, message and response", kinds)
+	}
+	req, msg, resp := events[0], events[1], events[2]
+	if req.Kind != RecordedRequest || req.ProcessID == "" || string(req.Payload) != `{"command":1}` {
+		t.Errorf("recorded request = %+v, want the decrypted input even with RedactPayloads", req)
+	}
+	if msg.Kind != RecordedMessage || msg.ProcessID != req.ProcessID || msg.Type != 2 || string(msg.Payload) != `{"ok":true}` {
+		t.Errorf("recorded message = %+v, want the inline response message", msg)
+	}
+	if resp.Kind != RecordedResponse || resp.StatusCode != 200 || resp.Error != "" || string(resp.Payload) != `{"ok":true}` {
+		t.Errorf("recorded response = %+v, want status 200 and the inline response", resp)
+	}
+	if err := conn.Recorder.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestRecordedPayload(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"JSON", `{"position":40}`, `{"position":40}`},
+		{"secret", `{"userPassword":"hunter2"}`, `{"userPassword":"[REDACTED]"}`},
+		{"text", `not json`, `"not json"`},
+		{"empty", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recordedPayload([]byte(tt.in)); string(got) != tt.want {
+				t.Errorf("recordedPayload(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package dd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secrets in log output.
+const Redacted = "[REDACTED]"
+
+var (
+	// secretKey matches the names of fields holding secrets: phoneSecret, sessionSecret,
+	// userPassword, phoneSig, sessionSig, tokens and the like.
+	secretKey = regexp.MustCompile(`(?i)^\w*(secret|password|passphrase|sig|signature|token|authorization)$`)
+	// secretValue matches a secret and its value in text: JSON ("sessionSig":"abc", also
+	// escaped inside a JSON string), Go's %+v (SessionSecret:abc, Authorization:[Bearer x])
+	// and key=value pairs.
+	secretValue = regexp.MustCompile(`(?i)(\b\w*(?:secret|password|passphrase|sig|signature|token|authorization)\\?["']?\s*[:=]\s*)(\\?"[^"\\]*\\?"|\[[^\]]*\]|[^\s,;&}\]"]+)`)
+
+	unsafeLogSecrets atomic.Bool
+)
+
+// SetUnsafeLogSecrets turns off redaction by RedactHook, so secrets are logged in full.
+// It's for debugging the protocol only.
+func SetUnsafeLogSecrets(enabled bool) {
+	unsafeLogSecrets.Store(enabled)
+}
+
+// Redact masks the values of secrets in s, such as a request's phoneSecret and
+// signatures or a connect response's sessionSecret.
+func Redact(s string) string {
+	return secretValue.ReplaceAllStringFunc(s, func(match string) string {
+		parts := secretValue.FindStringSubmatch(match)
+		key, value := parts[1], parts[2]
+		switch {
+		case strings.HasPrefix(value, `\"`):
+			if value == `\"\"` {
+				return match
+			}
+			return key + `\"` + Redacted + `\"`
+		case strings.HasPrefix(value, `"`):
+			if value == `""` {
+				return match
+			}
+			return key + `"` + Redacted + `"`
+		case value == "[]":
+			return match
+		}
+		return key + Redacted
+	})
+}
+
+// RedactHook is a logrus hook masking secrets in each entry's message and fields, unless
+// SetUnsafeLogSecrets is on. The package logger has one; add it to a Conn's own Logger or
+// one passed to SetLogger to keep secrets out of that too.
+type RedactHook struct{}
+
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	if unsafeLogSecrets.Load() {
+		return nil
+	}
+	entry.Message = Redact(entry.Message)
+	// Fields may be shared with the entry they were added to, so they're copied
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		data[key] = redactField(key, value)
+	}
+	entry.Data = data
+	return nil
+}
+
+// redactField returns value, masked if key names a secret or with any secrets in it masked.
+func redactField(key string, value interface{}) interface{} {
+	if secretKey.MatchString(key) && value != nil && value != "" {
+		return Redacted
+	}
+	switch v := value.(type) {
+	case string:
+		return Redact(v)
+	case []byte:
+		return Redact(string(v))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, inner := range v {
+			m[k] = redactField(k, inner)
+		}
+		return m
+	case logrus.Fields:
+		m := make(logrus.Fields, len(v))
+		for k, inner := range v {
+			m[k] = redactField(k, inner)
+		}
+		return m
+	}
+	// Anything else, e.g. a response struct, is only replaced by its text if that held
+	// secrets
+	s := fmt.Sprintf("%+v", value)
+	if r := Redact(s); r != s {
+		return r
+	}
+	return value
+}
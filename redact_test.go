@@ -0,0 +1,65 @@
+package dd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"JSON", `{"phoneSecret":"abc123","bsid":"bs1","phoneSig":"f00d"}`, `{"phoneSecret":"[REDACTED]","bsid":"bs1","phoneSig":"[REDACTED]"}`},
+		{"JSON spaces", `{"userPassword": "hunter2"}`, `{"userPassword": "[REDACTED]"}`},
+		{"escaped JSON", `"{\"sessionSecret\":\"s3cret\"}"`, `"{\"sessionSecret\":\"[REDACTED]\"}"`},
+		{"empty", `{"sessionSig":""}`, `{"sessionSig":""}`},
+		{"struct", `&{SessionSignature:abc SessionID:42 SessionSecret:xyz}`, `&{SessionSignature:[REDACTED] SessionID:42 SessionSecret:[REDACTED]}`},
+		{"headers", `map[Authorization:[Bearer t0ken] Content-Type:[application/json]]`, `map[Authorization:[REDACTED] Content-Type:[application/json]]`},
+		{"key=value", `user=bob password=hunter2`, `user=bob password=[REDACTED]`},
+		{"no secrets", `{"deviceId":"door1","position":40}`, `{"deviceId":"door1","position":40}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactHook(t *testing.T) {
+	var out bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&out)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	l.AddHook(RedactHook{})
+
+	fields := l.WithField("basicInfo", map[string]interface{}{"sessionID": "42", "secret": "s3cret"})
+	fields.WithFields(logrus.Fields{
+		"payload":     `{"phoneSecret":"abc123"}`,
+		"phoneSecret": "abc123",
+		"resp":        &genericResponse{SessionSecret: "xyz"},
+	}).Info(`connect with {"userPassword":"hunter2"}`)
+	for _, secret := range []string{"s3cret", "abc123", "xyz", "hunter2"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("log output %s contains %q", out.String(), secret)
+		}
+	}
+	if !strings.Contains(out.String(), `"sessionID":"42"`) {
+		t.Errorf("log output %s lost other fields", out.String())
+	}
+	if fields.Data["basicInfo"].(map[string]interface{})["secret"] != "s3cret" {
+		t.Error("RedactHook changed the fields of the entry it was derived from")
+	}
+
+	SetUnsafeLogSecrets(true)
+	defer SetUnsafeLogSecrets(false)
+	out.Reset()
+	l.WithField("phoneSecret", "abc123").Info("connect")
+	if !strings.Contains(out.String(), "abc123") {
+		t.Errorf("log output with SetUnsafeLogSecrets = %s, want the secret", out.String())
+	}
+}
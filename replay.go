@@ -0,0 +1,107 @@
+package dd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultReplayWindow is how far a message's time may be from the hub's clock before the
+// message is rejected as stale; see Conn.ReplayWindow.
+const DefaultReplayWindow = 5 * time.Minute
+
+// replaySequences is how many sequence numbers below the highest seen are remembered;
+// older ones are rejected as stale.
+const replaySequences = 1024
+
+// Why a message was rejected as a replay, recorded on dd.messages.rejected
+const (
+	rejectDuplicate = "duplicate"
+	rejectStale     = "stale"
+)
+
+// replayGuard rejects messages already received in the session, by sequence number, and
+// those sent too long ago, by time. The zero value is ready to use.
+type replayGuard struct {
+	mu      sync.Mutex
+	skew    time.Duration // the hub's clock less ours, from the connect response
+	highest int
+	seen    map[int]bool
+}
+
+// reset starts a new session, whose connect response gave the hub's time as serverTime
+// (epoch millis, 0 if it didn't).
+func (g *replayGuard) reset(serverTime int, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.skew = 0
+	if serverTime > 0 {
+		g.skew = time.UnixMilli(int64(serverTime)).Sub(now)
+	}
+	g.highest = 0
+	g.seen = nil
+}
+
+// check returns why m is a replay, rejectDuplicate or rejectStale, or "" if it isn't, in
+// which case its sequence is remembered. Messages without a time or sequence number can't
+// be checked by it. A negative window turns off the time check.
+func (g *replayGuard) check(m *Message, window time.Duration, now time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if m.Time != 0 && window >= 0 {
+		age := now.Add(g.skew).Sub(time.UnixMilli(int64(m.Time)))
+		if age > window || age < -window {
+			return rejectStale
+		}
+	}
+
+	seq := m.Sequence
+	switch {
+	case seq == 0:
+		return ""
+	case seq <= g.highest-replaySequences:
+		return rejectStale
+	case g.seen[seq]:
+		return rejectDuplicate
+	}
+	if g.seen == nil {
+		g.seen = make(map[int]bool)
+	}
+	g.seen[seq] = true
+	if seq > g.highest {
+		g.highest = seq
+	}
+	// Forget sequences that are now rejected as stale anyway
+	if len(g.seen) > 2*replaySequences {
+		for s := range g.seen {
+			if s <= g.highest-replaySequences {
+				delete(g.seen, s)
+			}
+		}
+	}
+	return ""
+}
+
+// replayed reports whether m was already received this session or was sent outside the
+// replay window, logging and counting it if so.
+func (dc *Conn) replayed(ctx context.Context, m *Message) bool {
+	window := dc.ReplayWindow
+	if window == 0 {
+		window = DefaultReplayWindow
+	}
+	reason := dc.replay.check(m, window, time.Now())
+	if reason == "" {
+		return false
+	}
+	recordRejected(ctx, reason)
+	dc.log().WithFields(logrus.Fields{
+		"sequence":  m.Sequence,
+		"time":      m.Time,
+		"processID": m.ProcessID,
+		"reason":    reason,
+	}).Warn("Dropped replayed message")
+	return true
+}
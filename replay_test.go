@@ -0,0 +1,98 @@
+package dd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestReplayGuard(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	at := func(d time.Duration) int { return int(now.Add(d).UnixMilli()) }
+
+	var g replayGuard
+	g.reset(at(time.Hour), now) // the hub's clock is an hour ahead
+	tests := []struct {
+		name     string
+		sequence int
+		time     int
+		want     string
+	}{
+		{"first", 10, at(time.Hour), ""},
+		{"duplicate", 10, at(time.Hour), rejectDuplicate},
+		{"out of order", 8, at(time.Hour), ""},
+		{"old time", 11, at(time.Hour - 2*DefaultReplayWindow), rejectStale},
+		{"future time", 12, at(time.Hour + 2*DefaultReplayWindow), rejectStale},
+		{"no time", 13, 0, ""},
+		{"no sequence", 0, at(time.Hour), ""},
+		{"no sequence again", 0, at(time.Hour), ""},
+		{"far ahead", 2000, at(time.Hour), ""},
+		{"below the window", 900, at(time.Hour), rejectStale},
+	}
+	for _, tt := range tests {
+		m := &Message{Sequence: tt.sequence, dataPayload: dataPayload{Time: tt.time}}
+		if got := g.check(m, DefaultReplayWindow, now); got != tt.want {
+			t.Errorf("%s: check(sequence %d) = %q, want %q", tt.name, tt.sequence, got, tt.want)
+		}
+	}
+
+	if got := g.check(&Message{Sequence: 2001, dataPayload: dataPayload{Time: 1}}, -1, now); got != "" {
+		t.Errorf("check() with the time check off = %q, want accepted", got)
+	}
+
+	// A new session starts over
+	g.reset(0, now)
+	if got := g.check(&Message{Sequence: 10, dataPayload: dataPayload{Time: at(0)}}, DefaultReplayWindow, now); got != "" {
+		t.Errorf("check() after reset = %q, want accepted", got)
+	}
+}
+
+func TestConn_DropsReplayedMessages(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	if err := SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatalf("SetMeterProvider() error = %v", err)
+	}
+	t.Cleanup(func() { SetMeterProvider(otel.GetMeterProvider()) })
+
+	// The hub, or someone between it and us, sends the same message on every poll
+	captured := Message{Sequence: 5, dataPayload: dataPayload{Time: int(time.Now().UnixMilli()), Data: `{"position":40}`}}
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(messagesResponse(t, captured))
+	})
+
+	for i, want := range []int{1, 0} {
+		messages, err := conn.Messages()
+		if err != nil {
+			t.Fatalf("Messages() error = %v", err)
+		}
+		if len(messages) != want {
+			t.Errorf("poll %d returned %d messages, want %d", i+1, len(messages), want)
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	var duplicates int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok && m.Name == "dd.messages.rejected" {
+				for _, dp := range sum.DataPoints {
+					if reason, _ := dp.Attributes.Value("reason"); reason.AsString() == rejectDuplicate {
+						duplicates += dp.Value
+					}
+				}
+			}
+		}
+	}
+	if duplicates != 1 {
+		t.Errorf("dd.messages.rejected duplicates = %d, want 1", duplicates)
+	}
+}
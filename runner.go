@@ -0,0 +1,86 @@
+package dd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultRequestTimeout bounds a Runner request when no timeout is configured.
+const DefaultRequestTimeout = 20 * time.Second
+
+var (
+	// ErrRunnerStarted is returned when Run is called on a Runner that has already been started.
+	ErrRunnerStarted = errors.New("runner already started")
+	// ErrConnectionLost is returned by Run when the hub stops answering polls; reconnect
+	// the Conn and start a new Runner.
+	ErrConnectionLost = errors.New("connection to hub lost")
+)
+
+// Runner owns the background work for a connected Conn: it polls for messages and
+// publishes them on a status channel, and runs requests with a timeout. It is safe
+// for concurrent use, but may only be Run once.
+type Runner struct {
+	conn           *Conn
+	requestTimeout time.Duration
+	status         chan *Message
+
+	mu      sync.Mutex
+	started bool
+}
+
+// NewRunner creates a Runner for an already connected Conn. A requestTimeout of zero
+// uses DefaultRequestTimeout.
+func NewRunner(conn *Conn, requestTimeout time.Duration) *Runner {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	return &Runner{
+		conn:           conn,
+		requestTimeout: requestTimeout,
+		status:         make(chan *Message),
+	}
+}
+
+// Status returns the channel messages are delivered on. It is closed when Run returns.
+func (r *Runner) Status() <-chan *Message {
+	return r.status
+}
+
+// Run polls for messages until ctx is done, backing off while the hub is idle or failing,
+// and then returns ctx.Err(). It returns ErrConnectionLost early if polling keeps failing.
+func (r *Runner) Run(ctx context.Context) error {
+	r.mu.Lock()
+	if r.started {
+		r.mu.Unlock()
+		return ErrRunnerStarted
+	}
+	r.started = true
+	r.mu.Unlock()
+
+	defer close(r.status)
+
+	messages, err := r.conn.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	for m := range messages {
+		select {
+		case r.status <- m:
+		case <-ctx.Done():
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrConnectionLost
+}
+
+// Request performs rpc, failing with context.DeadlineExceeded if no response arrives
+// within the request timeout.
+func (r *Runner) Request(ctx context.Context, rpc RPC) error {
+	ctx, cancel := context.WithTimeout(ctx, r.requestTimeout)
+	defer cancel()
+	return r.conn.RPCContext(ctx, rpc)
+}
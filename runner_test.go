@@ -0,0 +1,89 @@
+package dd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunner_Status(t *testing.T) {
+	prevMin := subscribeMinInterval
+	subscribeMinInterval = time.Millisecond
+	t.Cleanup(func() { subscribeMinInterval = prevMin })
+
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(messagesResponse(t, Message{Sequence: 7, dataPayload: dataPayload{Data: `{}`}}))
+	})
+	runner := NewRunner(conn, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+
+	select {
+	case m := <-runner.Status():
+		if m.Sequence != 7 {
+			t.Errorf("status message sequence = %d, want 7", m.Sequence)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for status message")
+	}
+
+	if err := runner.Run(ctx); !errors.Is(err, ErrRunnerStarted) {
+		t.Errorf("second Run() error = %v, want ErrRunnerStarted", err)
+	}
+
+	cancel()
+	for range runner.Status() {
+		// drain until closed
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRunner_ConnectionLost(t *testing.T) {
+	prevMin, prevErr, prevFailures := subscribeMinInterval, subscribeMaxErrorInterval, subscribeMaxFailures
+	subscribeMinInterval, subscribeMaxErrorInterval, subscribeMaxFailures = time.Millisecond, time.Millisecond, 2
+	t.Cleanup(func() {
+		subscribeMinInterval, subscribeMaxErrorInterval, subscribeMaxFailures = prevMin, prevErr, prevFailures
+	})
+
+	var polls atomic.Int32
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- NewRunner(conn, 0).Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConnectionLost) {
+			t.Errorf("Run() error = %v, want ErrConnectionLost", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for Run to give up")
+	}
+	if got := polls.Load(); got != int32(subscribeMaxFailures) {
+		t.Errorf("polls = %d, want %d", got, subscribeMaxFailures)
+	}
+}
+
+func TestRunner_RequestTimeout(t *testing.T) {
+	// The hub accepts the request but never delivers a response
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	runner := NewRunner(conn, 50*time.Millisecond)
+
+	err := runner.Request(context.Background(), RPC{Path: "/app/res/devices/fetch"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request() error = %v, want context.DeadlineExceeded", err)
+	}
+}
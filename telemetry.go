@@ -0,0 +1,151 @@
+package dd
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the OpenTelemetry instrumentation scope of this package's spans
+// and metrics.
+const InstrumentationName = "github.com/gravypower/dd"
+
+// Results recorded on the dd.messages.polls counter.
+const (
+	pollResultSuccess = "success"
+	pollResultError   = "error"
+)
+
+// connMetrics holds the OpenTelemetry instruments for Conn traffic.
+type connMetrics struct {
+	polls        metric.Int64Counter
+	pollDuration metric.Float64Histogram
+	messages     metric.Int64Counter
+	connections  metric.Int64Counter
+	badSigs      metric.Int64Counter
+	rejected     metric.Int64Counter
+}
+
+var (
+	tracer  atomic.Pointer[trace.Tracer]
+	metrics atomic.Pointer[connMetrics]
+)
+
+func init() {
+	SetTracerProvider(otel.GetTracerProvider())
+	if err := SetMeterProvider(otel.GetMeterProvider()); err != nil {
+		logger.WithError(err).Error("Failed to create connection metrics")
+	}
+}
+
+// SetTracerProvider routes the spans for RPCs and message polls to tp. Until it's called
+// the global otel TracerProvider is used, which is a no-op unless the application sets one.
+func SetTracerProvider(tp trace.TracerProvider) {
+	t := tp.Tracer(InstrumentationName)
+	tracer.Store(&t)
+}
+
+// SetMeterProvider (re)creates the connection instruments from mp. Until it's called the
+// global otel MeterProvider is used, which is a no-op unless the application sets one.
+func SetMeterProvider(mp metric.MeterProvider) error {
+	meter := mp.Meter(InstrumentationName)
+
+	polls, err := meter.Int64Counter("dd.messages.polls",
+		metric.WithDescription("Messages polls made to the hub"),
+		metric.WithUnit("{poll}"))
+	if err != nil {
+		return err
+	}
+	pollDuration, err := meter.Float64Histogram("dd.messages.poll.duration",
+		metric.WithDescription("Time taken by messages polls, including any long poll wait"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	messages, err := meter.Int64Counter("dd.messages.received",
+		metric.WithDescription("Messages received in polls"),
+		metric.WithUnit("{message}"))
+	if err != nil {
+		return err
+	}
+
+	connections, err := meter.Int64Counter("dd.http.connections",
+		metric.WithDescription("Requests to the hub by how they got a connection: reused from the pool, resuming a TLS session or new"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		return err
+	}
+
+	badSigs, err := meter.Int64Counter("dd.signatures.invalid",
+		metric.WithDescription("Responses and messages from the hub with a missing or mismatched signature"),
+		metric.WithUnit("{signature}"))
+	if err != nil {
+		return err
+	}
+
+	rejected, err := meter.Int64Counter("dd.messages.rejected",
+		metric.WithDescription("Messages from the hub dropped as replays: a duplicate sequence number or a time outside the replay window"),
+		metric.WithUnit("{message}"))
+	if err != nil {
+		return err
+	}
+
+	metrics.Store(&connMetrics{
+		polls:        polls,
+		pollDuration: pollDuration,
+		messages:     messages,
+		connections:  connections,
+		badSigs:      badSigs,
+		rejected:     rejected,
+	})
+	return nil
+}
+
+// startSpan starts a client span for a request to the hub.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return (*tracer.Load()).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// recordPoll records a messages poll that returned count messages.
+func recordPoll(ctx context.Context, duration time.Duration, count int, err error) {
+	m := metrics.Load()
+	result := pollResultSuccess
+	if err != nil {
+		result = pollResultError
+	}
+	m.polls.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+	m.pollDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.String("result", result)))
+	if count > 0 {
+		m.messages.Add(ctx, int64(count))
+	}
+}
+
+// recordConn records how a request got its connection: connReused, connResumed or connNew.
+func recordConn(ctx context.Context, how string) {
+	metrics.Load().connections.Add(ctx, 1, metric.WithAttributes(attribute.String("conn", how)))
+}
+
+// recordBadSignature records a missing or mismatched signature on a response or message.
+func recordBadSignature(ctx context.Context, kind string) {
+	metrics.Load().badSigs.Add(ctx, 1, metric.WithAttributes(attribute.String("kind", kind)))
+}
+
+// recordRejected records a message dropped as a replay, for rejectDuplicate or rejectStale.
+func recordRejected(ctx context.Context, reason string) {
+	metrics.Load().rejected.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
@@ -0,0 +1,115 @@
+package dd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans routes this package's spans to a recorder for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { SetTracerProvider(otel.GetTracerProvider()) })
+	return recorder
+}
+
+// spanAttr returns the value of key on span, or nil.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) *attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return &kv.Value
+		}
+	}
+	return nil
+}
+
+func TestRPC_Span(t *testing.T) {
+	recorder := recordSpans(t)
+	conn := newHooksTestConn(t)
+
+	if err := conn.RPC(RPC{Path: "/app/res/action"}); err != nil {
+		t.Fatalf("RPC() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "dd.rpc" {
+		t.Fatalf("ended spans = %v, want one dd.rpc", spans)
+	}
+	if v := spanAttr(spans[0], "dd.rpc.path"); v == nil || v.AsString() != "/app/res/action" {
+		t.Errorf("dd.rpc.path = %v, want /app/res/action", v)
+	}
+	if spans[0].Status().Code == codes.Error {
+		t.Errorf("dd.rpc status = %v, want not an error", spans[0].Status())
+	}
+}
+
+func TestRPC_SpanError(t *testing.T) {
+	recorder := recordSpans(t)
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if err := conn.RPC(RPC{Path: "/app/res/action"}); err == nil {
+		t.Fatalf("RPC() against a failing hub error = nil")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error {
+		t.Errorf("ended spans = %v, want one with an error status", spans)
+	}
+}
+
+func TestMessages_Telemetry(t *testing.T) {
+	recorder := recordSpans(t)
+	reader := sdkmetric.NewManualReader()
+	if err := SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatalf("SetMeterProvider() error = %v", err)
+	}
+	t.Cleanup(func() { SetMeterProvider(otel.GetMeterProvider()) })
+
+	conn := newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(messagesResponse(t,
+			Message{Sequence: 1, dataPayload: dataPayload{Data: `{}`}},
+			Message{Sequence: 2, dataPayload: dataPayload{Data: `{}`}},
+		))
+	})
+	if _, err := conn.Messages(); err != nil {
+		t.Fatalf("Messages() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "dd.messages.poll" {
+		t.Fatalf("ended spans = %v, want one dd.messages.poll", spans)
+	}
+	if v := spanAttr(spans[0], "dd.messages.count"); v == nil || v.AsInt64() != 2 {
+		t.Errorf("dd.messages.count = %v, want 2", v)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	sums := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+	if sums["dd.messages.polls"] != 1 || sums["dd.messages.received"] != 2 {
+		t.Errorf("polls = %d, received = %d, want 1 and 2", sums["dd.messages.polls"], sums["dd.messages.received"])
+	}
+}
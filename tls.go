@@ -0,0 +1,62 @@
+package dd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrCertificateMismatch is returned when the hub presents a certificate that doesn't match the pin.
+var ErrCertificateMismatch = errors.New("certificate fingerprint mismatch")
+
+// CertificateFingerprint returns the hex SHA-256 fingerprint of a DER-encoded certificate.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts fingerprints with or without colons, in either case.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// PinnedTLSConfig returns a TLS config for Conn.TLSConfig that only accepts a hub whose leaf
+// certificate matches fingerprint. The hub's certificate is self-signed, so the usual chain
+// verification is replaced by the pin check.
+//
+// If fingerprint is empty, the first certificate seen is trusted and onPin (if set) is called
+// with its fingerprint so the caller can persist it for next time (trust on first use).
+func PinnedTLSConfig(fingerprint string, onPin func(fingerprint string)) *tls.Config {
+	var mu sync.Mutex
+	pinned := normalizeFingerprint(fingerprint)
+
+	return &tls.Config{
+		InsecureSkipVerify: true, // replaced by VerifyPeerCertificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("%w: no certificate presented", ErrCertificateMismatch)
+			}
+			got := CertificateFingerprint(rawCerts[0])
+
+			mu.Lock()
+			defer mu.Unlock()
+			if pinned == "" {
+				pinned = got
+				logger.WithField("fingerprint", got).Info("Pinned hub certificate on first use")
+				if onPin != nil {
+					onPin(got)
+				}
+				return nil
+			}
+			if got != pinned {
+				return fmt.Errorf("%w: got %s, want %s", ErrCertificateMismatch, got, pinned)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package dd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPinnedTLSConfig(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}
+	var out struct{}
+
+	// Trust on first use records the server's fingerprint
+	var pinned string
+	conn := newTestConn(t, handler)
+	conn.TLSConfig = PinnedTLSConfig("", func(fingerprint string) { pinned = fingerprint })
+	if err := conn.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out}); err != nil {
+		t.Fatalf("SimpleRequest() with TOFU error = %v", err)
+	}
+	if len(pinned) != 64 {
+		t.Fatalf("onPin fingerprint = %q, want 64 hex chars", pinned)
+	}
+
+	// The recorded pin is accepted on a fresh connection
+	again := newTestConn(t, handler)
+	again.TLSConfig = PinnedTLSConfig(pinned, nil)
+	if err := again.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out}); err != nil {
+		t.Errorf("SimpleRequest() with matching pin error = %v", err)
+	}
+
+	// Any other certificate is rejected
+	other := newTestConn(t, handler)
+	other.TLSConfig = PinnedTLSConfig(strings.Repeat("00", 32), nil)
+	err := other.SimpleRequest(SimpleRequest{Path: "/sdk/info", Output: &out})
+	if !errors.Is(err, ErrCertificateMismatch) {
+		t.Errorf("SimpleRequest() with wrong pin error = %v, want ErrCertificateMismatch", err)
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	if got, want := normalizeFingerprint("AB:cd:01"), "abcd01"; got != want {
+		t.Errorf("normalizeFingerprint() = %q, want %q", got, want)
+	}
+}
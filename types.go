@@ -1,8 +1,13 @@
 package dd
 
 import (
+	"crypto/tls"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type SimpleRequestTarget int
@@ -15,30 +20,106 @@ type SimpleRequest struct {
 }
 
 // Conn is a connection to the service.
+//
+// Once Connect has returned, a Conn is safe for concurrent use. Requests are signed one at a
+// time as the RateLimiter allows, and up to MaxInFlight of them wait on the hub at once. Each
+// RPC response goes to the call that made it, wherever it arrives. Status messages are queued
+// until taken, and each is returned by exactly one Messages, AllMessages or Subscribe, so
+// concurrent readers split the stream rather than each seeing all of it. Connect and Close
+// must not run concurrently with other calls.
 type Conn struct {
-	Version     string // version number to send
-	Host        string // hostname
-	RequestMode bool   // whether to "request" changes, used for talking to an online server
-	Debug       bool   // whether to log debug
-
-	cred   Credential   // cached creds
-	client *http.Client // cached optional client
+	Version         string   // version number to send
+	Host            string   // hostname
+	RemoteHost      string   // cloud API host[:port], defaults to RemoteAPIBase
+	LocalPort       int      // encrypted API port, defaults to DefaultPort
+	SDKPortOverride int      // SDK info port, defaults to SDKPort
+	RequestMode     bool     // whether to "request" changes, used for talking to an online server
+	Mode            ConnMode // where the hub is reached, defaults to LocalMode
+	Debug           bool     // whether to log debug (only applies to the package logger)
+
+	Logger      *logrus.Logger // optional logger for this connection, defaults to the package logger; see RedactHook
+	TLSConfig   *tls.Config    // optional TLS settings unless Transport is set, defaults to skipping verification
+	RateLimiter RateLimiter    // optional request pacing, defaults to an AccessLimiter
+	Hooks       Hooks          // optional tracing of requests, responses and messages
+
+	// RedactPayloads leaves decrypted payloads out of the events passed to Hooks.
+	RedactPayloads bool
+	// Recorder, if set, captures every exchange with the hub, decrypted payloads included,
+	// for replaying in tests with ddtest.Replay.
+	Recorder *Recorder
+	// Signatures says how the signatures on the hub's responses and messages are checked,
+	// defaulting to SignaturesLogged.
+	Signatures SignatureMode
+
+	// HTTPClient, if set, makes every request, e.g. with its own timeouts or a dialer that
+	// goes through a VPN. It's used as is: TLSConfig, Transport and the default timeouts
+	// don't apply.
+	HTTPClient *http.Client
+	// Transport, if set, carries the requests of the default client, e.g. through a proxy
+	// or a custom dialer, in place of one built from TLSConfig. The default timeouts still
+	// apply.
+	Transport http.RoundTripper
+	// Pool tunes how the default transport keeps connections to the hub open and resumes
+	// TLS sessions; see PoolConfig.
+	Pool PoolConfig
+
+	// LongPoll, if set, asks the hub to hold each messages poll open for up to this long
+	// until a message arrives, instead of answering straight away.
+	LongPoll time.Duration
+	// ReplayWindow is how far a message's time may be from the hub's clock before it's
+	// dropped as a replay, defaulting to DefaultReplayWindow; negative turns the check off.
+	// Messages repeating a sequence number already received in the session are always
+	// dropped.
+	ReplayWindow time.Duration
+	// MaxInFlight is how many RPCs and polls may be waiting on the hub at once, defaulting
+	// to DefaultMaxInFlight; 1 sends them one at a time. They're still signed in turn and
+	// paced by the RateLimiter. A long poll doesn't count.
+	MaxInFlight int
+
+	cred        Credential   // cached creds
+	client      *http.Client // cached optional client
+	clientMutex sync.Mutex   // guards client, made on first use
 
 	processID      string // random process ID to use in requests
 	sessionID      string // session ID returned from server
-	nextAccess     int    // the next timestamp to use (millis)
 	sessionSecret  []byte // to calculate sessionSignature (from server)
 	phoneSecret    []byte // to calculate phoneSignature, derived from cred.PhoneSecret
 	phoneSecretRaw []byte // raw secret, UTF-8 bytes of string
 
-	sequenceIDSuffix int // incremented suffix (to track replies)
-	pendingMessages  []*Message
-
-	genericRequestMutex sync.Mutex
+	sequenceIDSuffix int          // incremented suffix (to track replies)
+	pending          messageQueue // status messages waiting to be taken by Messages
+
+	basestationOnline atomic.Bool  // last isBasestationOnline reported by the server
+	passwordExpired   atomic.Bool  // isPasswordExpired from the last connect
+	cloud             atomic.Bool  // the current session goes through the cloud API
+	hubVersion        atomic.Int64 // hubVersion from the last connect
+	pinged            atomic.Bool  // a Ping has succeeded
+	pingFailures      atomic.Int32 // Pings failed in a row
+	lastResponse      atomic.Int64 // unix nanos of the last connect or session request answered
+	replay            replayGuard  // messages received this session
+
+	genericRequestMutex sync.Mutex // held to sign a request or replace the session
+	inFlight            chan struct{}
+	inFlightOnce        sync.Once
+	limiterOnce         sync.Once // defaults RateLimiter on first use
 	unresolvedMutex     sync.Mutex
 	unresolvedRPC       map[string]chan *Message
 }
 
+// ConnMode says how a Conn reaches the hub.
+type ConnMode int
+
+const (
+	// LocalMode talks to the hub on the LAN at Host.
+	LocalMode ConnMode = iota
+	// CloudMode talks to the hub through the cloud API at RemoteHost, relaying RPCs with
+	// /app/res/request. The SDK info endpoint isn't available.
+	CloudMode
+	// AutoMode talks to the hub on the LAN, connecting through the cloud instead whenever
+	// Host can't be reached (or isn't set). Each reconnect tries the LAN first again.
+	AutoMode
+)
+
 // Credential holds login/connect credentials.
 type Credential struct {
 	PhoneSecret   string `json:"phoneSecret,omitempty"` // phone secret
@@ -48,15 +129,25 @@ type Credential struct {
 	UserPassword  string `json:"userPassword,omitempty"`
 }
 
+// longPollRequest is the body of a held-open messages poll.
+type longPollRequest struct {
+	AppTimeout int `json:"appTimeout"` // millis the hub may hold the request
+}
+
 type requestConfig struct {
 	data            []byte
 	path            string
-	requestIfOnline bool // does this need to be "requested" via /app/res/request
+	requestIfOnline bool          // does this need to be "requested" via /app/res/request
+	response        chan *Message // receives the response if it comes in a later message
 }
 
 // genericRequest is what we actually marshal as JSON for any request.
 type genericRequest struct {
-	requestIfOnline bool // does this need to be "requested" via /app/res/request
+	requestIfOnline bool   // does this need to be "requested" via /app/res/request
+	plainData       []byte // data before encryption, for Hooks
+	sessionSecret   []byte // the signing session's secret, to verify the response
+	phoneSecret     []byte // the key to decrypt the response's messages
+	phoneSecretRaw  []byte // the key to verify the response's messages
 	dataPayload
 
 	Credential
@@ -78,7 +169,7 @@ type genericResponse struct {
 
 	// Fields from a connect response
 	SessionID           string `json:"sessionId"`
-	IsBasestationOnline bool   `json:"isBasestationOnline"`
+	IsBasestationOnline *bool  `json:"isBasestationOnline"` // nil when not reported
 	HubVersion          int    `json:"hubVersion"`
 	CommunicationType   int    `json:"communicationType"`
 	SessionSecret       string `json:"sessionSecret"`
@@ -99,22 +190,25 @@ type Message struct {
 	DecodedMessage []byte `json:"-"` // actual decoded message
 }
 
+// UserAccess is the hub's access window for the connecting user, sent in the connect response.
+type UserAccess struct {
+	IsAccessReady                 bool   `json:"isAccessReady"`
+	NextAccess                    int    `json:"nextAccess"` // epoch millis
+	IsExpired                     bool   `json:"isExpired"`
+	IsCurrentlyRestricted         bool   `json:"isCurrentlyRestricted"`
+	DescriptionRestrictionDetails string `json:"descriptionRestrictionDetails"`
+	HashCode                      int    `json:"hashCode"`
+	NextRestricted                int    `json:"nextRestricted"`
+	IsHubClockAccurate            bool   `json:"isHubClockAccurate"`
+	DescriptionNextEvent          string `json:"descriptionNextEvent"`
+	OneTimeLimit                  int    `json:"oneTimeLimit"`
+	HasRestrictions               bool   `json:"hasRestrictions"`
+}
+
 type connectResponseData struct {
-	UserAccess struct {
-		IsAccessReady                 bool   `json:"isAccessReady"`
-		NextAccess                    int    `json:"nextAccess"`
-		IsExpired                     bool   `json:"isExpired"`
-		IsCurrentlyRestricted         bool   `json:"isCurrentlyRestricted"`
-		DescriptionRestrictionDetails string `json:"descriptionRestrictionDetails"`
-		HashCode                      int    `json:"hashCode"`
-		NextRestricted                int    `json:"nextRestricted"`
-		IsHubClockAccurate            bool   `json:"isHubClockAccurate"`
-		DescriptionNextEvent          string `json:"descriptionNextEvent"`
-		OneTimeLimit                  int    `json:"oneTimeLimit"`
-		HasRestrictions               bool   `json:"hasRestrictions"`
-	} `json:"userAccess"`
-	IsPasswordExpired bool `json:"isPasswordExpired"`
-	IsAdmin           bool `json:"isAdmin"`
+	UserAccess        UserAccess `json:"userAccess"`
+	IsPasswordExpired bool       `json:"isPasswordExpired"`
+	IsAdmin           bool       `json:"isAdmin"`
 }
 
 type RPC struct {
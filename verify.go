@@ -0,0 +1,71 @@
+package dd
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+)
+
+// ErrBadSignature is returned in SignaturesStrict mode for a response or message whose
+// signature is missing or doesn't match.
+var ErrBadSignature = errors.New("bad signature")
+
+// SignatureMode says how a Conn checks the signatures the hub puts on what it sends: a
+// response's sessionSig, over its time and messages with the session secret, and each
+// message's phoneSig, over its time and data with the phone secret. The connect response
+// is checked instead by decrypting it with the phone secret.
+type SignatureMode int
+
+const (
+	// SignaturesLogged checks the signatures the hub sends, logging any that don't match
+	// and counting them on dd.signatures.invalid, but still accepts them. It's the default.
+	SignaturesLogged SignatureMode = iota
+	// SignaturesStrict also rejects responses and messages that are unsigned or don't
+	// match, with ErrBadSignature, for hubs on untrusted networks.
+	SignaturesStrict
+	// SignaturesIgnored doesn't check signatures.
+	SignaturesIgnored
+)
+
+// What a signature was checked on, recorded on dd.signatures.invalid
+const (
+	signedResponse = "response"
+	signedMessage  = "message"
+)
+
+// verifyResponse checks the session signature on gresp, the response to greq.
+func (dc *Conn) verifyResponse(ctx context.Context, greq *genericRequest, gresp *genericResponse) error {
+	// Connect responses carry the session secret itself, so signing with it proves nothing
+	if greq.SessionID == "" {
+		return nil
+	}
+	return dc.verify(ctx, signedResponse, greq.sessionSecret, gresp.SessionSignature, gresp.Time, gresp.RawMessages)
+}
+
+// verifyMessage checks the phone signature on m, which came with the response to greq.
+func (dc *Conn) verifyMessage(ctx context.Context, greq *genericRequest, m *Message) error {
+	return dc.verify(ctx, signedMessage, greq.phoneSecretRaw, m.PhoneSignature, m.Time, m.Data)
+}
+
+// verify checks that sig signs data sent at time t with key, as SignatureMode says.
+func (dc *Conn) verify(ctx context.Context, kind string, key []byte, sig string, t int, data string) error {
+	if dc.Signatures == SignaturesIgnored || (sig == "" && dc.Signatures != SignaturesStrict) {
+		return nil
+	}
+	want := newHubSignature(key).Update(t, data)
+	if sig != "" && hmac.Equal([]byte(sig), []byte(want)) {
+		return nil
+	}
+
+	recordBadSignature(ctx, kind)
+	reason := "doesn't match"
+	if sig == "" {
+		reason = "is missing"
+	}
+	if dc.Signatures == SignaturesStrict {
+		return fmt.Errorf("%w: %s signature %s", ErrBadSignature, kind, reason)
+	}
+	dc.log().WithField("kind", kind).Warnf("Hub %s signature %s; set Conn.Signatures to SignaturesStrict to reject these", kind, reason)
+	return nil
+}
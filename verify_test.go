@@ -0,0 +1,82 @@
+package dd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newSigningTestConn returns a Conn whose hub answers every request with a status message,
+// signing the response and message unless told not to.
+func newSigningTestConn(t *testing.T, sign func(resp map[string]interface{}, msg *Message)) *Conn {
+	t.Helper()
+	return newTestConn(t, func(w http.ResponseWriter, r *http.Request) {
+		msg := Message{Sequence: 1, dataPayload: dataPayload{Time: int(time.Now().UnixMilli()), Data: `{"position":40}`}}
+		sign(nil, &msg)
+		resp := messagesResponse(t, msg)
+		resp["time"] = 2000
+		sign(resp, nil)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// signed signs responses with the session secret and messages with the phone secret.
+func signed(resp map[string]interface{}, msg *Message) {
+	if msg != nil {
+		msg.PhoneSignature = newHubSignature([]byte("phone_secret")).Update(msg.Time, msg.Data)
+	}
+	if resp != nil {
+		resp["sessionSig"] = newHubSignature([]byte("session_secret")).Update(2000, resp["messages"].(string))
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	tests := []struct {
+		name string
+		sign func(map[string]interface{}, *Message)
+		mode SignatureMode
+		want error
+	}{
+		{"signed", signed, SignaturesStrict, nil},
+		{"unsigned", func(map[string]interface{}, *Message) {}, SignaturesLogged, nil},
+		{"unsigned strict", func(map[string]interface{}, *Message) {}, SignaturesStrict, ErrBadSignature},
+		{"tampered message", func(resp map[string]interface{}, msg *Message) {
+			signed(resp, msg)
+			if msg != nil {
+				msg.PhoneSignature = newHubSignature([]byte("attacker")).Update(msg.Time, msg.Data)
+			}
+		}, SignaturesStrict, ErrBadSignature},
+		{"tampered response", func(resp map[string]interface{}, msg *Message) {
+			signed(resp, msg)
+			if resp != nil {
+				resp["time"] = 2001
+			}
+		}, SignaturesStrict, ErrBadSignature},
+		{"tampered logged", func(resp map[string]interface{}, msg *Message) {
+			if resp != nil {
+				resp["sessionSig"] = "forged"
+			}
+		}, SignaturesLogged, nil},
+		{"tampered ignored", func(resp map[string]interface{}, msg *Message) {
+			if resp != nil {
+				resp["sessionSig"] = "forged"
+			}
+		}, SignaturesIgnored, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newSigningTestConn(t, tt.sign)
+			conn.Signatures = tt.mode
+
+			messages, err := conn.Messages()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Messages() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && len(messages) != 1 {
+				t.Errorf("Messages() returned %d messages, want 1", len(messages))
+			}
+		})
+	}
+}